@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 答案引用标注的展示风格
+// inline保持LLM输出的圆圈数字标注（①②③）；footnote把标注改写为[1][2]并在答案末尾
+// 追加参考来源列表；none去掉所有标注。作为答案的展示层变换，不影响检索和生成本身。
+const (
+	citationStyleInline   = "inline"
+	citationStyleFootnote = "footnote"
+	citationStyleNone     = "none"
+)
+
+// citationStyleMarkers 圆圈数字标注列表，与提示词中的文档编号一一对应（①=文档片段1）
+var citationStyleMarkers = []string{"①", "②", "③", "④", "⑤", "⑥", "⑦", "⑧", "⑨", "⑩"}
+
+// validCitationStyle 校验引用风格取值（空表示使用配置默认值）
+func validCitationStyle(style string) bool {
+	switch style {
+	case "", citationStyleInline, citationStyleFootnote, citationStyleNone:
+		return true
+	}
+	return false
+}
+
+// applyCitationStyle 按引用风格转换答案中的标注
+// footnote风格按标注在答案中首次出现的顺序编号，保证脚注编号与阅读顺序一致
+func applyCitationStyle(answer, style string, results []schema.Document) string {
+	switch style {
+	case citationStyleNone:
+		for _, marker := range citationStyleMarkers {
+			answer = strings.ReplaceAll(answer, marker, "")
+		}
+		return answer
+	case citationStyleFootnote:
+		return footnoteCitations(answer, results)
+	default:
+		// inline或空：保持原样
+		return answer
+	}
+}
+
+// footnoteCitations 把圆圈数字标注改写为[1][2]脚注，并在答案末尾追加参考来源列表
+func footnoteCitations(answer string, results []schema.Document) string {
+	// 收集答案中实际出现的标注及其首次出现位置
+	type markerHit struct {
+		index int // 标注对应的results下标（①=0）
+		pos   int // 答案中首次出现的字节位置
+	}
+	var hits []markerHit
+	for i, marker := range citationStyleMarkers {
+		if pos := strings.Index(answer, marker); pos >= 0 {
+			hits = append(hits, markerHit{index: i, pos: pos})
+		}
+	}
+	if len(hits) == 0 {
+		return answer
+	}
+	sort.Slice(hits, func(a, b int) bool { return hits[a].pos < hits[b].pos })
+
+	refs := make([]string, 0, len(hits))
+	for n, hit := range hits {
+		answer = strings.ReplaceAll(answer, citationStyleMarkers[hit.index], fmt.Sprintf("[%d]", n+1))
+		refs = append(refs, fmt.Sprintf("[%d] %s", n+1, citationRefTitle(results, hit.index)))
+	}
+	return answer + "\n\n参考来源：\n" + strings.Join(refs, "\n")
+}
+
+// citationRefTitle 获取标注对应检索结果的文档标题，用于脚注参考列表
+func citationRefTitle(results []schema.Document, index int) string {
+	if index < 0 || index >= len(results) {
+		return "未知来源"
+	}
+	metadata := results[index].Metadata
+	if fileName, ok := metadata["file_name"].(string); ok && fileName != "" {
+		return extractOriginalFilename(fileName)
+	}
+	if source, ok := metadata["source"].(string); ok && source != "" {
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			return source
+		}
+		return extractOriginalFilename(filepath.Base(source))
+	}
+	if title, ok := metadata["title"].(string); ok && title != "" {
+		return title
+	}
+	return "未命名文档"
+}
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/Codeyangyi/personal-ai-kb/rag"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 查询结果的文档分组（DOC_GROUP_PARALLEL_THRESHOLD）
+// handleQuery要把被标注使用的chunk组装成平铺results和按来源分组的docGroups。
+// 组装本身是纯内存的元数据处理，每chunk一个goroutine加锁收集对常见的小结果集
+// （topK通常是个位数）纯属开销：小结果集顺序处理，只有被使用的chunk数量超过
+// 阈值时才走并发路径。
+
+// defaultDocGroupParallelThreshold 被使用的chunk数量超过该值才并发组装
+const defaultDocGroupParallelThreshold = 32
+
+// docProcessResult 单个chunk的组装结果（平铺result + 所属文档组）
+type docProcessResult struct {
+	index    int
+	result   map[string]interface{}
+	groupKey string
+	group    *DocGroup
+}
+
+// buildDocGroups 把被标注使用的检索结果组装成平铺results和按来源分组的docGroups
+// 并发与否由被使用的chunk数量和阈值（DOC_GROUP_PARALLEL_THRESHOLD）决定
+func (s *Server) buildDocGroups(results []schema.Document, usedIndices map[int]bool) ([]map[string]interface{}, map[string]*DocGroup) {
+	usedCount := 0
+	for i := range results {
+		if usedIndices[i+1] {
+			usedCount++
+		}
+	}
+	threshold := s.config.DocGroupParallelThreshold
+	if threshold <= 0 {
+		threshold = defaultDocGroupParallelThreshold
+	}
+	return s.buildDocGroupsWith(results, usedIndices, usedCount > threshold)
+}
+
+// buildDocGroupsWith 按指定的并发策略组装结果（拆出来供基准测试对比两条路径）
+func (s *Server) buildDocGroupsWith(results []schema.Document, usedIndices map[int]bool, parallel bool) ([]map[string]interface{}, map[string]*DocGroup) {
+	docGroupsMap := make(map[string]*DocGroup)
+	var searchResults []map[string]interface{} // 保留平铺格式以兼容旧前端
+
+	// 合并单个chunk的组装结果：追加平铺结果，按来源归组（组已存在时补全缺失的文档级字段）
+	merge := func(res docProcessResult) {
+		searchResults = append(searchResults, res.result)
+		if existingGroup, exists := docGroupsMap[res.groupKey]; exists {
+			if res.group.FileType != "" && existingGroup.FileType == "" {
+				existingGroup.FileType = res.group.FileType
+			}
+			if res.group.FileID != "" && existingGroup.FileID == "" {
+				existingGroup.FileID = res.group.FileID
+			}
+			if res.group.Author != "" && existingGroup.Author == "" {
+				existingGroup.Author = res.group.Author
+			}
+			if res.group.Date != "" && existingGroup.Date == "" {
+				existingGroup.Date = res.group.Date
+			}
+			existingGroup.Chunks = append(existingGroup.Chunks, res.result)
+		} else {
+			docGroupsMap[res.groupKey] = res.group
+		}
+	}
+
+	if !parallel {
+		// 顺序路径（常见情况）：没有goroutine和channel开销，结果天然有序
+		for i, doc := range results {
+			if !usedIndices[i+1] {
+				continue
+			}
+			merge(s.buildDocProcessResult(i+1, doc))
+		}
+		return searchResults, docGroupsMap
+	}
+
+	// 并发路径（大结果集）：每chunk一个goroutine组装，channel收集后单协程合并
+	resultChan := make(chan docProcessResult, len(results))
+	var wg sync.WaitGroup
+	for i, doc := range results {
+		if !usedIndices[i+1] {
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, d schema.Document) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("⚠️ 处理文档片段时发生panic: %v, 索引: %d", r, idx)
+				}
+			}()
+			resultChan <- s.buildDocProcessResult(idx+1, d)
+		}(i, doc)
+	}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+	for res := range resultChan {
+		merge(res)
+	}
+
+	// 并发收集后顺序被打乱，按index排回标注顺序
+	sort.Slice(searchResults, func(i, j int) bool {
+		idxI, _ := searchResults[i]["index"].(int)
+		idxJ, _ := searchResults[j]["index"].(int)
+		return idxI < idxJ
+	})
+	return searchResults, docGroupsMap
+}
+
+// attachSupportingEvidence 为答案引用到的chunk附上最能支撑答案的原文句子（includeEvidence）
+// chunk["index"]本身就是1起始的标注索引（与答案中的①②③一致），直接对照usedIndices
+func attachSupportingEvidence(docGroups []DocGroup, usedIndices map[int]bool, answer string) {
+	for i := range docGroups {
+		for _, chunk := range docGroups[i].Chunks {
+			idx, ok := chunk["index"].(int)
+			if !ok || !usedIndices[idx] {
+				continue
+			}
+			if content, ok := chunk["pageContent"].(string); ok {
+				if sentences := rag.SupportingSentences(answer, content); len(sentences) > 0 {
+					chunk["supportingSentences"] = sentences
+				}
+			}
+		}
+	}
+}
+
+// buildDocProcessResult 组装单个chunk：提取来源/标题/类型等元数据，生成平铺result和文档组
+// originalIndex为标注索引（从1开始），与AI答案中的①②③标注保持一致
+func (s *Server) buildDocProcessResult(originalIndex int, d schema.Document) docProcessResult {
+	// 获取文档来源信息
+	var docTitle, docSource, sourceType, fileType, fileID string
+	if source, ok := d.Metadata["source"].(string); ok {
+		docSource = source
+		// 判断是文件还是URL
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			sourceType = "url"
+			docTitle = source // URL直接使用完整URL作为标题
+		} else {
+			sourceType = "file"
+			// 从文件路径中提取原始文件名（去除UUID前缀）
+			docTitle = extractOriginalFilename(filepath.Base(source))
+			// 从文件路径中提取fileID（格式：{fileID}_{原文件名}）
+			baseName := filepath.Base(source)
+			if idx := strings.Index(baseName, "_"); idx > 0 {
+				fileID = baseName[:idx]
+			}
+			// 判断文件类型
+			ext := strings.ToLower(filepath.Ext(docTitle))
+			if ext != "" {
+				fileType = ext[1:] // 去掉点号
+			}
+		}
+	}
+	// 优先使用file_name元数据（如果存在且不包含UUID）
+	if fileName, ok := d.Metadata["file_name"].(string); ok && fileName != "" {
+		// 从file_name中提取原始文件名（去除UUID前缀）
+		originalFileName := extractOriginalFilename(fileName)
+		if originalFileName != "" {
+			docTitle = originalFileName
+		}
+		// 从file_name中提取fileID
+		if idx := strings.Index(fileName, "_"); idx > 0 {
+			fileID = fileName[:idx]
+		}
+		// 判断文件类型
+		ext := strings.ToLower(filepath.Ext(originalFileName))
+		if ext != "" {
+			fileType = ext[1:] // 去掉点号
+		}
+	}
+	if docTitle == "" {
+		docTitle = "未命名文档"
+	}
+
+	// 生成预览（前200字符）
+	preview := d.PageContent
+	if len(preview) > 200 {
+		preview = preview[:200] + "..."
+	}
+
+	// 创建文档片段结果
+	// score为Qdrant返回的相似度分数（余弦相似度），前端可按置信度展示/排序；
+	// 响应大小限制只截断content字段，score不受影响
+	result := map[string]interface{}{
+		"content":     d.PageContent,
+		"pageContent": d.PageContent,
+		"index":       originalIndex, // 使用原始索引，与AI答案中的标注保持一致
+		"source":      docSource,
+		"title":       docTitle,
+		"preview":     preview,
+		"score":       roundScore(d.Score),
+	}
+
+	// 引用元数据透传（CITATION_METADATA_FIELDS）：把白名单内的元数据带进chunk结果，
+	// 前端可以展示更丰富的引用信息（页码、小节标题、标签、作者等）
+	for _, field := range s.config.CitationMetadataFields {
+		if _, reserved := result[field]; reserved {
+			continue
+		}
+		if value, ok := d.Metadata[field]; ok && value != nil {
+			result[field] = value
+		}
+	}
+
+	// 按文档来源分组
+	groupKey := docSource
+	if groupKey == "" {
+		groupKey = docTitle // 如果没有source，使用title作为分组key
+	}
+
+	// 文档结构化元数据（作者、日期，加载时从docx核心属性或PDF Info字典提取）
+	var docAuthor, docDate string
+	if author, ok := d.Metadata["author"].(string); ok {
+		docAuthor = author
+	}
+	if date, ok := d.Metadata["date"].(string); ok {
+		docDate = date
+	}
+
+	return docProcessResult{
+		index:    originalIndex,
+		result:   result,
+		groupKey: groupKey,
+		group: &DocGroup{
+			DocTitle:   docTitle,
+			DocSource:  docSource,
+			SourceType: sourceType,
+			FileType:   fileType,
+			FileID:     fileID,
+			Author:     docAuthor,
+			Date:       docDate,
+			Chunks:     []map[string]interface{}{result},
+		},
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Codeyangyi/personal-ai-kb/config"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 构造基准测试用的检索结果：n个chunk分布在10个来源文档上，全部被标注使用
+func benchmarkDocs(n int) ([]schema.Document, map[int]bool) {
+	docs := make([]schema.Document, n)
+	usedIndices := make(map[int]bool, n)
+	for i := 0; i < n; i++ {
+		fileID := fmt.Sprintf("file-%d", i%10)
+		docs[i] = schema.Document{
+			PageContent: fmt.Sprintf("文档 %d 的内容片段，用于文档分组的基准测试。", i),
+			Metadata: map[string]interface{}{
+				"source":    fmt.Sprintf("/data/uploads/%s_报告%d.pdf", fileID, i%10),
+				"file_name": fmt.Sprintf("%s_报告%d.pdf", fileID, i%10),
+				"author":    "测试作者",
+				"date":      "2026-08-28",
+			},
+			Score: float32(1) - float32(i)*0.01,
+		}
+		usedIndices[i+1] = true
+	}
+	return docs, usedIndices
+}
+
+func benchmarkServer() *Server {
+	return &Server{config: &config.Config{}}
+}
+
+// 两条组装路径的结果必须一致，基准对比才有意义
+func TestBuildDocGroupsPathsAgree(t *testing.T) {
+	s := benchmarkServer()
+	docs, usedIndices := benchmarkDocs(20)
+
+	seqResults, seqGroups := s.buildDocGroupsWith(docs, usedIndices, false)
+	parResults, parGroups := s.buildDocGroupsWith(docs, usedIndices, true)
+
+	if len(seqResults) != len(parResults) {
+		t.Fatalf("平铺结果数量不一致: 顺序 %d, 并发 %d", len(seqResults), len(parResults))
+	}
+	if len(seqGroups) != len(parGroups) {
+		t.Fatalf("文档组数量不一致: 顺序 %d, 并发 %d", len(seqGroups), len(parGroups))
+	}
+	for i := range seqResults {
+		if seqResults[i]["index"] != parResults[i]["index"] {
+			t.Errorf("第%d个结果的index不一致: 顺序 %v, 并发 %v", i, seqResults[i]["index"], parResults[i]["index"])
+		}
+	}
+	for key, seqGroup := range seqGroups {
+		parGroup, ok := parGroups[key]
+		if !ok {
+			t.Errorf("并发路径缺少文档组 %s", key)
+			continue
+		}
+		if len(seqGroup.Chunks) != len(parGroup.Chunks) {
+			t.Errorf("文档组 %s 的chunk数量不一致: 顺序 %d, 并发 %d", key, len(seqGroup.Chunks), len(parGroup.Chunks))
+		}
+	}
+}
+
+// 顺序与并发组装的性能对比：小结果集（topK量级）顺序路径应明显更快
+func BenchmarkBuildDocGroups(b *testing.B) {
+	s := benchmarkServer()
+	for _, n := range []int{5, 50, 200} {
+		docs, usedIndices := benchmarkDocs(n)
+		b.Run(fmt.Sprintf("sequential-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.buildDocGroupsWith(docs, usedIndices, false)
+			}
+		})
+		b.Run(fmt.Sprintf("parallel-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.buildDocGroupsWith(docs, usedIndices, true)
+			}
+		})
+	}
+}
+
+// 证据句定位的回归测试：只有①一个引用时也必须附上supportingSentences
+// （chunk["index"]本身就是1起始的标注索引，曾因再+1错位导致单引用场景永远匹配不上）
+func TestAttachSupportingEvidenceSingleCitation(t *testing.T) {
+	s := benchmarkServer()
+	docs := []schema.Document{
+		{
+			PageContent: "年假规定：员工每年享有十五天带薪年假。年假需要提前一周向主管申请。未休完的年假可以结转到下一年度。",
+			Metadata: map[string]interface{}{
+				"source":    "/data/uploads/file-1_员工手册.pdf",
+				"file_name": "file-1_员工手册.pdf",
+			},
+		},
+	}
+	usedIndices := map[int]bool{1: true}
+	_, docGroupsMap := s.buildDocGroupsWith(docs, usedIndices, false)
+
+	docGroups := make([]DocGroup, 0, len(docGroupsMap))
+	for _, group := range docGroupsMap {
+		docGroups = append(docGroups, *group)
+	}
+
+	answer := "根据员工手册，员工每年享有十五天带薪年假，年假需要提前一周向主管申请①。"
+	attachSupportingEvidence(docGroups, usedIndices, answer)
+
+	if len(docGroups) != 1 || len(docGroups[0].Chunks) != 1 {
+		t.Fatalf("期望1个文档组1个chunk，实际 %d 个组", len(docGroups))
+	}
+	sentences, ok := docGroups[0].Chunks[0]["supportingSentences"].([]string)
+	if !ok || len(sentences) == 0 {
+		t.Fatalf("单引用场景下被引用的chunk没有附上supportingSentences: %v", docGroups[0].Chunks[0]["supportingSentences"])
+	}
+	for _, sentence := range sentences {
+		if !strings.Contains(docs[0].PageContent, sentence) {
+			t.Errorf("证据句 %q 不是chunk原文中的句子", sentence)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 文件摄取状态（FileInfo.Status的取值）
+// 上传成功到向量入库之间存在一个不可查询的窗口，状态让文件列表能区分这段时间
+const (
+	fileStatusPending = "pending" // 文件已保存到磁盘，向量化尚未完成，此时还查询不到内容
+	fileStatusIndexed = "indexed" // 向量化完成，文件内容可被检索
+	fileStatusFailed  = "failed"  // 摄取失败，文件已被移动到失败目录
+)
+
+// fileStatusFilename 摄取状态的持久化文件（位于filesDir下，每次状态变更整体重写）
+// 状态表很小（每个文件一条fileID->status记录），整体重写比增量追加简单可靠
+const fileStatusFilename = ".ingest_status.json"
+
+// loadFileStatuses 从磁盘读取摄取状态表，文件不存在或损坏时返回空表
+// 空表不致命：无记录的文件按indexed兜底（见fileStatusFor）
+func (s *Server) loadFileStatuses() map[string]string {
+	statuses := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(s.filesDir, fileStatusFilename))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("读取文件摄取状态表失败: %v", err)
+		}
+		return statuses
+	}
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		logger.Warn("解析文件摄取状态表失败，按空表处理: %v", err)
+		return make(map[string]string)
+	}
+	return statuses
+}
+
+// setFileStatus 更新文件的摄取状态并持久化，同时写回内存中的FileInfo（如果已注册）
+// 持久化失败只记录日志：状态表丢失时重启后按indexed兜底，不影响查询功能
+func (s *Server) setFileStatus(fileID, status string) {
+	s.fileStatusMu.Lock()
+	defer s.fileStatusMu.Unlock()
+
+	s.fileStatuses[fileID] = status
+	s.filesMu.RLock()
+	fileInfo, exists := s.files[fileID]
+	s.filesMu.RUnlock()
+	if exists {
+		fileInfo.Status = status
+	}
+
+	data, err := json.Marshal(s.fileStatuses)
+	if err != nil {
+		logger.Error("序列化文件摄取状态表失败: %v", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(s.filesDir, fileStatusFilename), data, 0644); err != nil {
+		logger.Error("写入文件摄取状态表失败: %v", err)
+	}
+}
+
+// clearFileStatus 删除文件的摄取状态记录并持久化（文件被删除时调用，避免状态表越积越大）
+func (s *Server) clearFileStatus(fileID string) {
+	s.fileStatusMu.Lock()
+	defer s.fileStatusMu.Unlock()
+
+	if _, exists := s.fileStatuses[fileID]; !exists {
+		return
+	}
+	delete(s.fileStatuses, fileID)
+
+	data, err := json.Marshal(s.fileStatuses)
+	if err != nil {
+		logger.Error("序列化文件摄取状态表失败: %v", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(s.filesDir, fileStatusFilename), data, 0644); err != nil {
+		logger.Error("写入文件摄取状态表失败: %v", err)
+	}
+}
+
+// fileStatusFor 返回文件记录的摄取状态
+// 无记录时视为indexed：filesDir里的存量文件都是状态跟踪功能之前摄取成功的
+func (s *Server) fileStatusFor(fileID string) string {
+	s.fileStatusMu.Lock()
+	defer s.fileStatusMu.Unlock()
+	if status, ok := s.fileStatuses[fileID]; ok {
+		return status
+	}
+	return fileStatusIndexed
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/embedding"
+	"github.com/Codeyangyi/personal-ai-kb/loader"
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/Codeyangyi/personal-ai-kb/rag"
+	"github.com/Codeyangyi/personal-ai-kb/splitter"
+	"github.com/Codeyangyi/personal-ai-kb/store"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 嵌入模型在线迁移（/api/admin/migrate）
+// 维度迁移（ON_DIM_MISMATCH=migrate）需要重启且重建索引期间无法服务。在线迁移把
+// 重建放到后台任务：服务继续用旧集合回答查询，任务按文件把磁盘上的文档用新模型
+// 重新嵌入到新集合，全部完成后原子切换到新集合。旧集合保留为备份。
+
+// 迁移任务状态
+const (
+	migrationRunning   = "running"
+	migrationCompleted = "completed"
+	migrationFailed    = "failed"
+)
+
+// migrationJob 一次在线迁移任务的进度信息
+type migrationJob struct {
+	Status           string `json:"status"`
+	TargetCollection string `json:"targetCollection"`
+	TargetProvider   string `json:"targetProvider"`
+	TargetModel      string `json:"targetModel"`
+	TotalFiles       int    `json:"totalFiles"`
+	ProcessedFiles   int    `json:"processedFiles"`
+	FailedFiles      int    `json:"failedFiles"`
+	TotalChunks      int    `json:"totalChunks"`
+	StartedAt        string `json:"startedAt"`
+	FinishedAt       string `json:"finishedAt,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// 迁移任务的全局状态（同一时间只允许一个迁移任务）
+var (
+	migrationMu  sync.Mutex
+	migrationCur *migrationJob
+)
+
+// handleAdminMigrate 处理嵌入模型在线迁移
+// POST启动后台迁移任务（body: {"model": "...", "provider": "...", "collection": "..."}），
+// GET返回当前/最近一次任务的进度
+func (s *Server) handleAdminMigrate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		migrationMu.Lock()
+		job := migrationCur
+		migrationMu.Unlock()
+		if job == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "No migration job",
+				"message": "当前没有进行过迁移任务",
+			})
+			return
+		}
+		migrationMu.Lock()
+		snapshot := *job
+		migrationMu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"job":     snapshot,
+		})
+		return
+
+	case http.MethodPost:
+		// 继续走下面的启动逻辑
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Provider   string `json:"provider"`   // 新嵌入provider（空表示沿用当前provider）
+		Model      string `json:"model"`      // 新嵌入模型（必填）
+		Collection string `json:"collection"` // 新集合名称（空表示"当前集合名_migrate"）
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid request",
+			"message": "无法解析请求体",
+		})
+		return
+	}
+	if req.Model == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Model is required",
+			"message": "必须指定新的嵌入模型",
+		})
+		return
+	}
+	if req.Provider == "" {
+		req.Provider = s.config.EmbeddingProvider
+	}
+	if req.Collection == "" {
+		req.Collection = s.config.CollectionName + "_migrate"
+	}
+	if req.Collection == s.config.CollectionName {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid collection",
+			"message": "新集合名称不能与当前集合相同",
+		})
+		return
+	}
+
+	// 仅Qdrant后端支持在线迁移（切换依赖集合名，Milvus路径未覆盖）
+	if _, ok := s.store.(*store.QdrantStore); !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Unsupported vector store",
+			"message": "在线迁移仅支持Qdrant后端",
+		})
+		return
+	}
+
+	migrationMu.Lock()
+	if migrationCur != nil && migrationCur.Status == migrationRunning {
+		migrationMu.Unlock()
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Migration in progress",
+			"message": "已有迁移任务在进行中，请等待其完成",
+		})
+		return
+	}
+	s.filesMu.RLock()
+	totalFiles := len(s.files)
+	s.filesMu.RUnlock()
+	job := &migrationJob{
+		Status:           migrationRunning,
+		TargetCollection: req.Collection,
+		TargetProvider:   req.Provider,
+		TargetModel:      req.Model,
+		TotalFiles:       totalFiles,
+		StartedAt:        time.Now().Format(time.RFC3339),
+	}
+	migrationCur = job
+	migrationMu.Unlock()
+
+	go s.runMigration(job)
+
+	logger.Info("✅ 嵌入模型在线迁移已启动: %s/%s -> 集合 %s", req.Provider, req.Model, req.Collection)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "迁移任务已启动，可通过 GET /api/admin/migrate 查看进度",
+		"job":     *job,
+	})
+}
+
+// runMigration 后台执行迁移：逐文件重新嵌入到新集合，完成后原子切换
+func (s *Server) runMigration(job *migrationJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("⚠️ 迁移任务发生panic: %v, 堆栈: %s", r, getStackTrace())
+			s.finishMigration(job, migrationFailed, "迁移任务发生内部错误")
+		}
+	}()
+
+	// 1. 用新provider/模型创建嵌入器和新集合的向量存储
+	newEmbedder, err := embedding.NewEmbedderWithTimeout(
+		job.TargetProvider,
+		s.config.OllamaBaseURL,
+		job.TargetModel,
+		s.config.SiliconFlowAPIKey,
+		time.Duration(s.config.EmbedTimeoutSec)*time.Second,
+	)
+	if err != nil {
+		s.finishMigration(job, migrationFailed, "创建新嵌入器失败: "+err.Error())
+		return
+	}
+	newStore, err := store.NewQdrantStoreWithMode(
+		s.config.QdrantURL, s.config.QdrantAPIKey, job.TargetCollection,
+		newEmbedder.GetEmbedder(), newEmbedder, "recreate",
+	)
+	if err != nil {
+		s.finishMigration(job, migrationFailed, "创建新集合失败: "+err.Error())
+		return
+	}
+	newStore.SetWaitOnWrite(s.config.QdrantWaitWrites)
+	newStore.SetRetryOptions(s.config.QdrantMaxRetries, s.config.QdrantBreakerThreshold, s.config.QdrantBreakerCooldown)
+
+	// topK对入库路径没有影响，传默认值即可
+	migrationRAG := rag.NewRAG(newEmbedder, newStore, s.llm, 3)
+	migrationRAG.SetEmbedBatchOptions(s.config.EmbedBatchSize, s.config.EmbedBatchDelayMS)
+	migrationRAG.SetRetryBackoff(s.config.EmbedRetryBaseMS, s.config.EmbedRetryMaxMS)
+
+	// 2. 逐文件重新加载、切分、嵌入到新集合（服务继续用旧集合响应查询）
+	var fileLoader *loader.FileLoader
+	if s.ocrProcessor != nil {
+		fileLoader = loader.NewFileLoaderWithOCR(s.ocrProcessor)
+	} else {
+		fileLoader = loader.NewFileLoader()
+	}
+	textSplitter := splitter.NewTextSplitter(s.config.ChunkSize, s.config.ChunkOverlap)
+	ctx := context.Background()
+
+	// 在锁下快照文件列表再遍历：迁移耗时可达数分钟，期间handler仍在并发增删文件，
+	// 直接遍历共享map会触发运行时崩溃（concurrent map iteration and map write）
+	for fileID, fileInfo := range s.snapshotFiles() {
+		var filePath string
+		newFormatPath := filepath.Join(s.filesDir, fileID+"_"+fileInfo.Filename)
+		oldFormatPath := filepath.Join(s.filesDir, fileID+filepath.Ext(fileInfo.Filename))
+		if _, err := os.Stat(newFormatPath); err == nil {
+			filePath = newFormatPath
+		} else if _, err := os.Stat(oldFormatPath); err == nil {
+			filePath = oldFormatPath
+		} else {
+			// URL摄取等没有磁盘文件的条目无法重新嵌入，计入失败并继续
+			s.stepMigration(job, false)
+			logger.Warn("在线迁移：文件 %s 没有磁盘副本，跳过", fileInfo.Filename)
+			continue
+		}
+
+		docs, err := fileLoader.Load(filePath)
+		if err != nil {
+			logger.Error("在线迁移：加载文件 %s 失败: %v", fileInfo.Filename, err)
+			s.stepMigration(job, false)
+			continue
+		}
+		chunks, err := textSplitter.SplitDocuments(docs)
+		if err != nil {
+			logger.Error("在线迁移：切分文件 %s 失败: %v", fileInfo.Filename, err)
+			s.stepMigration(job, false)
+			continue
+		}
+		validChunks := make([]schema.Document, 0, len(chunks))
+		for _, chunk := range chunks {
+			if len(strings.TrimSpace(chunk.PageContent)) > 0 {
+				validChunks = append(validChunks, chunk)
+			}
+		}
+		if len(validChunks) == 0 {
+			logger.Warn("在线迁移：文件 %s 没有有效文本内容，跳过", fileInfo.Filename)
+			s.stepMigration(job, false)
+			continue
+		}
+		annotateChunks(validChunks, fileID)
+
+		if err := migrationRAG.AddDocuments(ctx, validChunks); err != nil {
+			logger.Error("在线迁移：向量化文件 %s 失败: %v", fileInfo.Filename, err)
+			s.stepMigration(job, false)
+			continue
+		}
+		migrationMu.Lock()
+		job.TotalChunks += len(validChunks)
+		migrationMu.Unlock()
+		s.stepMigration(job, true)
+	}
+
+	// 3. 原子切换到新集合：之后的查询全部走新模型/新集合，旧集合保留为备份
+	// 整个swap持backendMu写锁：embedder/store/集合名必须一起换，正在进来的查询
+	// 通过currentBackend在同一把锁下取值，不会看到新store+旧集合名的中间状态
+	oldCollection := s.config.CollectionName
+	s.backendMu.Lock()
+	s.ragSystem.SwapBackend(newEmbedder, newStore)
+	s.ragSystem.SetStoreConnection(s.config.QdrantURL, s.config.QdrantAPIKey, job.TargetCollection)
+	if s.config.NeighborWindow > 0 {
+		s.ragSystem.EnableNeighborExpansion(s.config.QdrantURL, s.config.QdrantAPIKey, job.TargetCollection, s.config.NeighborWindow)
+	}
+	s.embedder = newEmbedder
+	s.store = newStore
+	s.config.CollectionName = job.TargetCollection
+	s.backendMu.Unlock()
+	rag.InvalidateRetrievalCache()
+
+	s.finishMigration(job, migrationCompleted, "")
+	logger.Info("✅ 嵌入模型在线迁移完成: 集合 %s -> %s, 文件 %d/%d, 旧集合保留为备份",
+		oldCollection, job.TargetCollection, job.ProcessedFiles-job.FailedFiles, job.TotalFiles)
+}
+
+// snapshotFiles 在filesMu下复制文件列表（遍历耗时长的调用方用快照，不长时间持锁）
+func (s *Server) snapshotFiles() map[string]*FileInfo {
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+	snapshot := make(map[string]*FileInfo, len(s.files))
+	for fileID, fileInfo := range s.files {
+		snapshot[fileID] = fileInfo
+	}
+	return snapshot
+}
+
+// currentBackend 在backendMu下返回当前生效的查询后端三元组（嵌入器、主存储、集合名）
+// 在线迁移的cutover会整体替换这三者，读方必须一次性取到一致的组合
+func (s *Server) currentBackend() (*embedding.Embedder, store.VectorStore, string) {
+	s.backendMu.RLock()
+	defer s.backendMu.RUnlock()
+	return s.embedder, s.store, s.config.CollectionName
+}
+
+// stepMigration 累加迁移进度（success为false时同时累加失败数）
+func (s *Server) stepMigration(job *migrationJob, success bool) {
+	migrationMu.Lock()
+	defer migrationMu.Unlock()
+	job.ProcessedFiles++
+	if !success {
+		job.FailedFiles++
+	}
+}
+
+// finishMigration 标记迁移任务结束
+func (s *Server) finishMigration(job *migrationJob, status, errMsg string) {
+	migrationMu.Lock()
+	defer migrationMu.Unlock()
+	job.Status = status
+	job.Error = errMsg
+	job.FinishedAt = time.Now().Format(time.RFC3339)
+}
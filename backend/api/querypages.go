@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/google/uuid"
+)
+
+// 查询结果的服务端分页
+// 响应大小限制会截断超过50个的文档组和每组超过20个的chunks，命中大量相关内容的
+// 查询会静默丢数据。分页机制在截断发生时把完整结果集深拷贝进短期缓存，响应附带
+// queryId，调用方通过 GET /api/query/{queryId}/page/{n} 逐页取回全部文档组
+// （分页响应不做chunk数量和内容长度截断）。
+
+const (
+	// queryPageSize 每页的文档组数量（与handleQuery内联响应的maxDocGroups一致）
+	queryPageSize = 50
+	// queryPageTTL 缓存的完整结果集的存活时间，过期后翻页返回404
+	queryPageTTL = 5 * time.Minute
+	// maxCachedQueries 同时缓存的查询数量上限，超出时淘汰最旧的
+	maxCachedQueries = 50
+)
+
+// cachedQueryPages 一次查询的完整文档组结果集
+type cachedQueryPages struct {
+	docGroups []DocGroup
+	createdAt time.Time
+}
+
+// storeQueryPages 深拷贝完整结果集存入缓存，返回分配的queryId
+// 深拷贝是必要的：handleQuery随后会就地截断chunks切片和chunk内容
+func (s *Server) storeQueryPages(docGroups []DocGroup) string {
+	groups := make([]DocGroup, len(docGroups))
+	for i, group := range docGroups {
+		groups[i] = group
+		groups[i].Chunks = make([]map[string]interface{}, len(group.Chunks))
+		for j, chunk := range group.Chunks {
+			chunkCopy := make(map[string]interface{}, len(chunk))
+			for k, v := range chunk {
+				chunkCopy[k] = v
+			}
+			groups[i].Chunks[j] = chunkCopy
+		}
+	}
+
+	queryID := uuid.New().String()
+
+	s.queryPagesMu.Lock()
+	defer s.queryPagesMu.Unlock()
+	if s.queryPages == nil {
+		s.queryPages = make(map[string]*cachedQueryPages)
+	}
+	// 清理过期条目；仍超上限时淘汰最旧的
+	now := time.Now()
+	for id, cached := range s.queryPages {
+		if now.Sub(cached.createdAt) > queryPageTTL {
+			delete(s.queryPages, id)
+		}
+	}
+	for len(s.queryPages) >= maxCachedQueries {
+		oldestID := ""
+		var oldestAt time.Time
+		for id, cached := range s.queryPages {
+			if oldestID == "" || cached.createdAt.Before(oldestAt) {
+				oldestID = id
+				oldestAt = cached.createdAt
+			}
+		}
+		delete(s.queryPages, oldestID)
+	}
+	s.queryPages[queryID] = &cachedQueryPages{docGroups: groups, createdAt: now}
+	logger.Info("📎 查询结果已缓存用于分页: queryId=%s, 文档组数=%d, 总页数=%d", queryID, len(groups), queryPageCount(len(groups)))
+	return queryID
+}
+
+// queryPageCount 计算文档组总数对应的页数
+func queryPageCount(totalGroups int) int {
+	pages := (totalGroups + queryPageSize - 1) / queryPageSize
+	if pages < 1 {
+		pages = 1
+	}
+	return pages
+}
+
+// anyGroupOverChunkCap 判断是否有文档组的chunks数量超过内联响应的上限
+func anyGroupOverChunkCap(docGroups []DocGroup, maxChunks int) bool {
+	for _, group := range docGroups {
+		if len(group.Chunks) > maxChunks {
+			return true
+		}
+	}
+	return false
+}
+
+// handleQueryPage 处理 GET /api/query/{queryId}/page/{n}，返回缓存结果集的第n页
+func (s *Server) handleQueryPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Method not allowed",
+			"message": "只支持GET请求",
+		})
+		return
+	}
+
+	// 路径格式：/api/query/{queryId}/page/{n}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/query/"), "/")
+	if len(parts) != 3 || parts[1] != "page" || parts[0] == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid path",
+			"message": "路径格式应为 /api/query/{queryId}/page/{n}",
+		})
+		return
+	}
+	queryID := parts[0]
+	page, err := strconv.Atoi(parts[2])
+	if err != nil || page < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid page",
+			"message": "页码必须是从1开始的整数",
+		})
+		return
+	}
+
+	s.queryPagesMu.Lock()
+	cached, exists := s.queryPages[queryID]
+	if exists && time.Since(cached.createdAt) > queryPageTTL {
+		delete(s.queryPages, queryID)
+		exists = false
+	}
+	s.queryPagesMu.Unlock()
+
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Query not found",
+			"message": "查询结果已过期或不存在，请重新发起查询",
+		})
+		return
+	}
+
+	totalPages := queryPageCount(len(cached.docGroups))
+	if page > totalPages {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Page out of range",
+			"message": "页码超出范围，总页数: " + strconv.Itoa(totalPages),
+		})
+		return
+	}
+
+	start := (page - 1) * queryPageSize
+	end := start + queryPageSize
+	if end > len(cached.docGroups) {
+		end = len(cached.docGroups)
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"queryId":        queryID,
+		"page":           page,
+		"totalPages":     totalPages,
+		"totalDocGroups": len(cached.docGroups),
+		"docGroups":      cached.docGroups[start:end],
+	}); err != nil {
+		logger.Error("编码分页响应失败: %v", err)
+	}
+}
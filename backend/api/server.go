@@ -1,17 +1,27 @@
 package api
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha1"
 	"database/sql"
 	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +36,7 @@ import (
 	"github.com/Codeyangyi/personal-ai-kb/rag"
 	"github.com/Codeyangyi/personal-ai-kb/splitter"
 	"github.com/Codeyangyi/personal-ai-kb/store"
+	"github.com/Codeyangyi/personal-ai-kb/version"
 	"github.com/google/uuid"
 	"github.com/tmc/langchaingo/schema"
 	"golang.org/x/crypto/bcrypt"
@@ -44,6 +55,33 @@ type FileInfo struct {
 	Size       int64     `json:"size"`
 	UploadedAt time.Time `json:"uploadedAt"`
 	Chunks     int       `json:"chunks"`
+	Status     string    `json:"status,omitempty"` // 摄取状态：pending（向量化中）/ indexed（可检索）/ failed（摄取失败）
+	Tags       []string  `json:"tags,omitempty"`   // 文件标签（可通过PATCH /api/files/{id}编辑）
+	Pinned     bool      `json:"pinned,omitempty"` // 置顶标记：检索重排序时加分，让权威文档优先出现（POST /api/files/{id}/pin）
+
+	// URL来源信息（通过 /api/upload-url 摄取的网页，ID为URL哈希生成的合成ID）
+	SourceType string `json:"sourceType,omitempty"` // "url"表示网页来源，空表示上传的文件
+	SourceURL  string `json:"sourceURL,omitempty"`  // 网页来源的原始URL
+
+	// 结构化元数据（加载时从docx核心属性或PDF Info字典提取）
+	Author string `json:"author,omitempty"` // 文档作者
+	Date   string `json:"date,omitempty"`   // 文档日期（创建日期，格式YYYY-MM-DD）
+
+	// 下载限制状态（上传时计算，查询时刷新）
+	DownloadBlocked bool   `json:"downloadBlocked"`         // 检查结果：是否禁止下载
+	BlockReason     string `json:"blockReason,omitempty"`   // 禁止下载的原因
+	BlockOverride   string `json:"blockOverride,omitempty"` // 管理员覆盖: "allow"强制允许, "block"强制禁止, 空表示按检查结果
+}
+
+// isDownloadBlocked 判断文件当前是否禁止下载（管理员覆盖优先于检查结果）
+func (f *FileInfo) isDownloadBlocked() bool {
+	switch f.BlockOverride {
+	case "allow":
+		return false
+	case "block":
+		return true
+	}
+	return f.DownloadBlocked
 }
 
 // DocGroup 文档分组信息（用于查询结果和异步检查）
@@ -54,6 +92,8 @@ type DocGroup struct {
 	FileType      string                   `json:"fileType,omitempty"`      // 文件类型，如 "pdf", "docx", "txt" 等
 	HasPublicForm bool                     `json:"hasPublicForm,omitempty"` // 是否包含"公开形式"字眼
 	FileID        string                   `json:"fileId,omitempty"`        // 文件ID，用于下载
+	Author        string                   `json:"author,omitempty"`        // 文档作者（来自docx核心属性或PDF Info字典）
+	Date          string                   `json:"date,omitempty"`          // 文档日期（创建日期，格式YYYY-MM-DD）
 	Chunks        []map[string]interface{} `json:"chunks"`
 }
 
@@ -68,7 +108,7 @@ type Server struct {
 	ragSystem      *rag.RAG
 	config         *config.Config
 	embedder       *embedding.Embedder
-	store          *store.QdrantStore
+	store          store.VectorStore
 	llm            llm.LLM
 	adminToken     string
 	filesDir       string
@@ -81,25 +121,148 @@ type Server struct {
 	// 异步检查相关
 	checkQueue   chan *checkTaskWithResult // 检查任务队列（包含结果channel）
 	checkWorkers int                       // 检查工作协程数量
+
+	// LLM并发限制（LLM_MAX_CONCURRENCY，nil表示不限制）
+	llmSlots   chan struct{} // 执行槽位：容量=最大并发数
+	llmWaiters chan struct{} // 等待队列：容量=最大并发数*4，满了直接返回503
+
+	// 上传并发限制（MAX_CONCURRENT_UPLOADS，nil表示不限制）：防止上传风暴耗尽内存和磁盘IO
+	uploadSlots chan struct{}
+
+	// 上传幂等性（Idempotency-Key头）：防止前端网络重试导致同一文件并发重复上传
+	idempotencyMu sync.Mutex
+	idempotency   map[string]*idempotencyEntry
+
+	// filesMu 保护files map的全部读写：在线迁移会在后台长时间遍历文件列表，
+	// 与处理上传/删除的handler并发写同一个map会触发运行时崩溃（concurrent map iteration and map write）；
+	// 单key查找走RLock，整体遍历走RLock或snapshotFiles快照
+	filesMu sync.RWMutex
+
+	// backendMu 保护查询后端三元组（embedder/store/CollectionName）：在线迁移的cutover
+	// 要整体替换这三者，读方必须在同一把锁下取值，避免拿到新store+旧集合名的组合
+	backendMu sync.RWMutex
+
+	// 维护模式（POST /api/admin/maintenance运行时切换）：暂停入库或查询而不用停整个服务
+	// Qdrant维护窗口期间暂停上传可避免产生半失败的入库，查询照常服务（反之亦然）
+	maintenanceMu sync.Mutex
+	uploadsPaused bool
+	queriesPaused bool
+
+	// 文件摄取状态表（fileID -> pending/indexed/failed），持久化在filesDir下的状态文件中
+	fileStatusMu sync.Mutex
+	fileStatuses map[string]string
+
+	// 查询结果分页缓存：结果被截断时保存完整结果集，供 /api/query/{queryId}/page/{n} 翻页
+	queryPagesMu sync.Mutex
+	queryPages   map[string]*cachedQueryPages
+
+	// 按文件类型分片（SHARD_BY_TYPE）：类型 -> 分片RAG/store，按需懒创建
+	shardMu     sync.Mutex
+	shardRAGs   map[string]*rag.RAG
+	shardStores map[string]*store.QdrantStore
+
+	// 多集合扇出检索的额外集合（SEARCH_COLLECTIONS，空表示只搜主集合）
+	extraStores []store.VectorStore
+
+	startedAt time.Time // 服务启动时间（用于/api/version的uptime）
 }
 
+// idempotencyEntry 幂等键记录：处理中的键拒绝重复请求，已完成的键在TTL内重放之前的响应
+type idempotencyEntry struct {
+	done        bool
+	statusCode  int
+	contentType string
+	body        []byte
+	createdAt   time.Time
+}
+
+// idempotencyTTL 幂等键的有效期，过期后允许重新上传
+const idempotencyTTL = 10 * time.Minute
+
 // NewServer 创建新的API服务器
 func NewServer(cfg *config.Config) (*Server, error) {
+	// 设置PDF文本提取后端（PDF_BACKEND，外部工具不可用时自动回退到builtin）
+	loader.SetDefaultPDFBackend(cfg.PDFBackend)
+	loader.SetAllowedDomains(cfg.URLAllowedDomains)
+	loader.SetOCRNormalization(cfg.OCRNormalize, cfg.OCRSubstitutions)
+	loader.SetMinDocChars(cfg.MinDocChars)
+	loader.SetCleanMode(cfg.CleanMode)
+
+	// 设置单个文档的chunk数量上限（MAX_CHUNKS_PER_DOC / CHUNK_CAP_MODE）
+	splitter.SetDefaultChunkCap(cfg.MaxChunksPerDoc, cfg.ChunkCapMode == "reject")
+
+	// 设置检索结果缓存TTL（RETRIEVAL_CACHE_TTL，0表示禁用）
+	rag.SetRetrievalCacheTTL(cfg.RetrievalCacheTTL)
+
+	// 设置入库PII脱敏规则（REDACT_PII / REDACT_PATTERNS）
+	rag.SetRedaction(cfg.RedactPII, cfg.RedactPatterns)
+	rag.SetTraceOptions(cfg.TraceDir, cfg.TraceSampleRate)
+	store.SetPayloadKeys(cfg.QdrantContentKey, cfg.QdrantSourceKey)
+	store.SetCleanMode(cfg.CleanMode)
+	embedding.SetDiskCache(cfg.EmbedCacheDir, cfg.EmbedCacheMaxMB)
+
 	// 创建嵌入向量生成器
-	embedder, err := embedding.NewEmbedder(
+	embedder, err := embedding.NewEmbedderWithTimeout(
 		cfg.EmbeddingProvider,
 		cfg.OllamaBaseURL,
 		cfg.EmbeddingModelName,
 		cfg.SiliconFlowAPIKey,
+		time.Duration(cfg.EmbedTimeoutSec)*time.Second,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("创建嵌入向量生成器失败: %v", err)
 	}
 
-	// 创建向量存储
-	vectorStore, err := store.NewQdrantStore(cfg.QdrantURL, cfg.QdrantAPIKey, cfg.CollectionName, embedder.GetEmbedder(), embedder)
-	if err != nil {
-		return nil, fmt.Errorf("创建向量存储失败: %v", err)
+	// 查询/存储嵌入分离（QUERY_EMBEDDING_PROVIDER / QUERY_EMBEDDING_MODEL）：
+	// 查询路径可使用独立的provider和模型（延迟敏感部署），启动时校验向量空间兼容
+	queryProvider := cfg.QueryEmbeddingProvider
+	if queryProvider == "" {
+		queryProvider = cfg.EmbeddingProvider
+	}
+	queryModel := cfg.QueryEmbeddingModel
+	if queryModel == "" {
+		queryModel = cfg.EmbeddingModelName
+	}
+	if queryProvider != cfg.EmbeddingProvider || queryModel != cfg.EmbeddingModelName {
+		queryEmbedder, err := embedding.NewEmbedderWithTimeout(
+			queryProvider,
+			cfg.OllamaBaseURL,
+			queryModel,
+			cfg.SiliconFlowAPIKey,
+			time.Duration(cfg.EmbedTimeoutSec)*time.Second,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("创建查询嵌入向量生成器失败: %v", err)
+		}
+		if err := embedder.UseQueryModel(queryEmbedder); err != nil {
+			return nil, err
+		}
+	}
+
+	// 创建向量存储（VECTOR_STORE选择后端，默认Qdrant；维度不匹配时按ON_DIM_MISMATCH处理）
+	var vectorStore store.VectorStore
+	if cfg.VectorStore == "milvus" {
+		vectorStore, err = store.NewMilvusStore(cfg.MilvusAddr, cfg.MilvusUsername, cfg.MilvusPassword, cfg.CollectionName, embedder)
+		if err != nil {
+			return nil, fmt.Errorf("创建Milvus向量存储失败: %v", err)
+		}
+		logger.Info("使用Milvus向量存储: %s", cfg.MilvusAddr)
+	} else {
+		vectorStore, err = store.NewQdrantStoreWithMode(cfg.QdrantURL, cfg.QdrantAPIKey, cfg.CollectionName, embedder.GetEmbedder(), embedder, cfg.OnDimMismatch)
+		if err != nil {
+			return nil, fmt.Errorf("创建向量存储失败: %v", err)
+		}
+		// 写入等待（QDRANT_WAIT_WRITES）：AddDocuments返回时新增的点已可被检索
+		vectorStore.(*store.QdrantStore).SetWaitOnWrite(cfg.QdrantWaitWrites)
+		// 瞬时故障重试与熔断（QDRANT_MAX_RETRIES / QDRANT_BREAKER_THRESHOLD / QDRANT_BREAKER_COOLDOWN）
+		vectorStore.(*store.QdrantStore).SetRetryOptions(cfg.QdrantMaxRetries, cfg.QdrantBreakerThreshold, cfg.QdrantBreakerCooldown)
+	}
+
+	// 维度迁移：集合被切换到新集合时，后续所有操作都使用新集合，原集合保留为备份
+	migrated := vectorStore.CollectionName() != cfg.CollectionName
+	if migrated {
+		logger.Warn("检测到维度迁移：当前集合为 %s，原集合 %s 保留为备份", vectorStore.CollectionName(), cfg.CollectionName)
+		cfg.CollectionName = vectorStore.CollectionName()
 	}
 
 	// 创建LLM客户端（根据配置选择Ollama、通义千问或Kimi2）
@@ -110,6 +273,7 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		if err != nil {
 			return nil, fmt.Errorf("创建通义千问客户端失败: %v", err)
 		}
+		llmClient.(*llm.DashScopeLLM).SetResultFormat(cfg.DashScopeResultFormat)
 	} else if cfg.LLMProvider == "kimi" {
 		// 使用Kimi2
 		llmClient, err = llm.NewKimiLLM(cfg.MoonshotAPIKey, cfg.MoonshotModel)
@@ -126,9 +290,93 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		logger.Info("使用Ollama模型: %s", cfg.OllamaModel)
 	}
 
+	// STRICT_STARTUP：启动时对LLM发一次最小生成请求，模型名或凭证有问题立即失败
+	if cfg.StrictStartup {
+		if err := llm.ValidateStartup(context.Background(), llmClient); err != nil {
+			return nil, err
+		}
+	}
+
+	// 设置系统提示词（persona），与RAG检索指令分离
+	if setter, ok := llmClient.(llm.SystemPromptSetter); ok && cfg.SystemPrompt != "" {
+		setter.SetSystemPrompt(cfg.SystemPrompt)
+	}
+
 	// 创建RAG系统
 	ragSystem := rag.NewRAG(embedder, vectorStore, llmClient, 3)
 
+	// 设置向量存储连接信息（关键词降级检索的scroll接口需要）
+	ragSystem.SetStoreConnection(cfg.QdrantURL, cfg.QdrantAPIKey, cfg.CollectionName)
+
+	// 启用邻近块扩展（NEIGHBOR_WINDOW=0 时禁用）
+	if cfg.NeighborWindow > 0 {
+		ragSystem.EnableNeighborExpansion(cfg.QdrantURL, cfg.QdrantAPIKey, cfg.CollectionName, cfg.NeighborWindow)
+	}
+
+	// 应用嵌入批次配置（EMBED_BATCH_SIZE / EMBED_BATCH_DELAY_MS，0表示自动）
+	ragSystem.SetEmbedBatchOptions(cfg.EmbedBatchSize, cfg.EmbedBatchDelayMS)
+	ragSystem.SetQueryRewritePrompt(cfg.QueryRewritePrompt)
+	ragSystem.SetRetryBackoff(cfg.EmbedRetryBaseMS, cfg.EmbedRetryMaxMS)
+
+	// 应用重排序关键词长度范围配置
+	ragSystem.SetKeywordGramRange(cfg.KeywordMinGram, cfg.KeywordMaxGram)
+	ragSystem.SetFuzzyMatch(cfg.FuzzyMatch)
+	ragSystem.SetRelevanceFallbackCount(cfg.RelevanceFallbackCount)
+	ragSystem.SetPinnedBoost(cfg.PinnedBoost)
+	ragSystem.SetPromptOrder(cfg.PromptOrder)
+	ragSystem.SetQueryBudget(cfg.QueryBudgetMS)
+	ragSystem.SetAnswerLanguage(cfg.AnswerLanguage)
+	ragSystem.SetAnswerFrame(cfg.AnswerHeader, cfg.AnswerFooter)
+	ragSystem.SetAllowGeneralKnowledge(cfg.AllowGeneralKnowledge)
+
+	// 多集合扇出检索（SEARCH_COLLECTIONS，仅Qdrant）：额外集合初始化失败只跳过
+	var extraStores []store.VectorStore
+	if len(cfg.SearchCollections) > 0 {
+		if _, ok := vectorStore.(*store.QdrantStore); ok {
+			for _, name := range cfg.SearchCollections {
+				if name == "" || name == cfg.CollectionName {
+					continue
+				}
+				extraStore, err := store.NewQdrantStore(cfg.QdrantURL, cfg.QdrantAPIKey, name, embedder.GetEmbedder(), embedder)
+				if err != nil {
+					logger.Warn("⚠️ 额外检索集合 %s 初始化失败，已跳过: %v", name, err)
+					continue
+				}
+				extraStores = append(extraStores, extraStore)
+			}
+			if len(extraStores) > 0 {
+				ragSystem.SetSearchFanOut(extraStores, cfg.FanOutTimeoutMS, cfg.FanOutConcurrency)
+				logger.Info("✅ 多集合扇出检索已开启: %d 个额外集合", len(extraStores))
+			}
+		} else {
+			logger.Warn("⚠️ SEARCH_COLLECTIONS 仅支持Qdrant后端，已忽略")
+		}
+	}
+
+	// 应用引用标注校验配置（ENFORCE_CITATIONS）
+	ragSystem.SetEnforceCitations(cfg.EnforceCitations)
+
+	// 应用"未找到相关信息"提示语配置（NO_ANSWER_MESSAGE，空表示按问题语言自动选择）
+	ragSystem.SetNoAnswerMessage(cfg.NoAnswerMessage)
+
+	// 应用每个来源文档的chunk数量上限配置（MAX_CHUNKS_PER_SOURCE）
+	ragSystem.SetMaxChunksPerSource(cfg.MaxChunksPerSource)
+
+	// 应用检索多样性配置（MIN_DISTINCT_SOURCES）
+	ragSystem.SetMinDistinctSources(cfg.MinDistinctSources)
+
+	// 应用上下文预算配置（MAX_CONTEXT_CHARS / CONTEXT_STRATEGY）
+	ragSystem.SetContextBudget(cfg.MaxContextChars, cfg.ContextStrategy)
+
+	// 应用答案生成模式配置（GENERATION_MODE / REFINE_MAX_ITERATIONS）
+	ragSystem.SetGenerationMode(cfg.GenerationMode, cfg.RefineMaxIterations)
+
+	// 应用答案后处理配置（ANSWER_CLEANUP / ANSWER_STRIP_PATTERNS）
+	ragSystem.SetAnswerCleanup(cfg.AnswerCleanup, cfg.AnswerStripPatterns)
+
+	// 应用答案长度上限配置（MAX_ANSWER_CHARS）
+	ragSystem.SetMaxAnswerChars(cfg.MaxAnswerChars)
+
 	// 初始化 MySQL（可选）
 	var db *sql.DB
 	var gormDB *gorm.DB
@@ -214,27 +462,115 @@ func NewServer(cfg *config.Config) (*Server, error) {
 		config:         cfg,
 		embedder:       embedder,
 		store:          vectorStore,
+		extraStores:    extraStores,
 		llm:            llmClient,
 		adminToken:     adminToken,
 		filesDir:       filesDir,
 		failedFilesDir: failedFilesDir,
 		files:          make(map[string]*FileInfo),
+		idempotency:    make(map[string]*idempotencyEntry),
 		db:             db,
 		gormDB:         gormDB,
 		ocrProcessor:   ocrProcessor,
 		checkQueue:     make(chan *checkTaskWithResult, 100), // 检查任务队列，缓冲区100
 		checkWorkers:   3,                                    // 3个工作协程处理检查任务
+		startedAt:      time.Now(),
+	}
+
+	// LLM并发限制（LLM_MAX_CONCURRENCY=0时不限制，保持历史行为）
+	if cfg.LLMMaxConcurrency > 0 {
+		server.llmSlots = make(chan struct{}, cfg.LLMMaxConcurrency)
+		server.llmWaiters = make(chan struct{}, cfg.LLMMaxConcurrency*4)
+		logger.Info("LLM并发限制已启用：最大并发 %d，等待队列 %d", cfg.LLMMaxConcurrency, cfg.LLMMaxConcurrency*4)
 	}
 
-	// 从磁盘恢复文件列表
+	// 上传并发限制（MAX_CONCURRENT_UPLOADS=0时不限制，保持历史行为）
+	if cfg.MaxConcurrentUploads > 0 {
+		server.uploadSlots = make(chan struct{}, cfg.MaxConcurrentUploads)
+		logger.Info("上传并发限制已启用：最大并发 %d", cfg.MaxConcurrentUploads)
+	}
+
+	// 从磁盘恢复文件列表（先读摄取状态表，恢复时据此填充Status）
+	server.fileStatuses = server.loadFileStatuses()
 	server.loadFilesFromDisk()
 
+	// 维度迁移后把磁盘上的文件重新嵌入到新集合
+	if migrated {
+		logger.Info("维度迁移：开始将磁盘上的文件重新嵌入到集合 %s ...", cfg.CollectionName)
+		server.reindexAllFiles()
+	}
+
 	// 启动异步检查工作协程
 	server.startAsyncCheckWorkers()
 
 	return server, nil
 }
 
+// reindexAllFiles 将磁盘上的所有已上传文件重新切分并嵌入到当前集合
+// 用于维度迁移（ON_DIM_MISMATCH=migrate）后重建索引
+func (s *Server) reindexAllFiles() {
+	var fileLoader *loader.FileLoader
+	if s.ocrProcessor != nil {
+		fileLoader = loader.NewFileLoaderWithOCR(s.ocrProcessor)
+	} else {
+		fileLoader = loader.NewFileLoader()
+	}
+	textSplitter := splitter.NewTextSplitter(s.config.ChunkSize, s.config.ChunkOverlap)
+	ctx := context.Background()
+
+	successCount, failCount := 0, 0
+	for fileID, fileInfo := range s.snapshotFiles() {
+		// 构建文件路径（新旧两种命名格式）
+		var filePath string
+		newFormatPath := filepath.Join(s.filesDir, fileID+"_"+fileInfo.Filename)
+		oldFormatPath := filepath.Join(s.filesDir, fileID+filepath.Ext(fileInfo.Filename))
+		if _, err := os.Stat(newFormatPath); err == nil {
+			filePath = newFormatPath
+		} else if _, err := os.Stat(oldFormatPath); err == nil {
+			filePath = oldFormatPath
+		} else {
+			continue
+		}
+
+		docs, err := fileLoader.Load(filePath)
+		if err != nil {
+			logger.Error("重建索引：加载文件 %s 失败: %v", fileInfo.Filename, err)
+			failCount++
+			continue
+		}
+
+		chunks, err := textSplitter.SplitDocuments(docs)
+		if err != nil {
+			logger.Error("重建索引：切分文件 %s 失败: %v", fileInfo.Filename, err)
+			failCount++
+			continue
+		}
+
+		// 过滤掉内容为空的chunks
+		validChunks := make([]schema.Document, 0, len(chunks))
+		for _, chunk := range chunks {
+			if len(strings.TrimSpace(chunk.PageContent)) > 0 {
+				validChunks = append(validChunks, chunk)
+			}
+		}
+		if len(validChunks) == 0 {
+			logger.Warn("重建索引：文件 %s 没有有效文本内容，跳过", fileInfo.Filename)
+			failCount++
+			continue
+		}
+		annotateChunks(validChunks, fileID)
+
+		if err := s.ragSystem.AddDocuments(ctx, validChunks); err != nil {
+			logger.Error("重建索引：向量化文件 %s 失败: %v", fileInfo.Filename, err)
+			failCount++
+			continue
+		}
+		fileInfo.Chunks = len(validChunks)
+		successCount++
+	}
+	logger.Info("重建索引完成: 成功 %d 个文件, 失败 %d 个", successCount, failCount)
+}
+
 // Start 启动HTTP服务器
 func (s *Server) Start(port string) error {
 	mux := http.NewServeMux()
@@ -275,21 +611,53 @@ func (s *Server) Start(port string) error {
 	}
 
 	// API路由
-	mux.HandleFunc("/api/health", s.handleHealth)
+	// 健康检查路径可配置（HEALTH_PATH），适配负载均衡器的探活约定
+	healthPath := s.config.HealthPath
+	if healthPath == "" {
+		healthPath = "/api/health"
+	}
+	mux.HandleFunc(healthPath, s.handleHealth)
+	mux.HandleFunc("/api/version", s.handleVersion)
+	mux.HandleFunc("/api/supported-types", s.handleSupportedTypes)
+	mux.HandleFunc("/api/admin/optimize", s.handleAdminOptimize)
+	mux.HandleFunc("/api/admin/migrate", s.handleAdminMigrate)
+	mux.HandleFunc("/api/admin/logs", s.handleAdminLogs)
+	mux.HandleFunc("/api/import", s.withMaintenanceGate("uploads", s.withUploadLimit(s.handleImport)))
+	mux.HandleFunc("/api/failed/report", s.handleFailedReport)
+	mux.HandleFunc("/api/admin/maintenance", s.handleAdminMaintenance)
+	mux.HandleFunc("/api/admin/cache-stats", s.handleAdminCacheStats)
+	mux.HandleFunc("/api/embed", s.handleEmbed)
 	mux.HandleFunc("/api/login", s.handleLogin)
 	mux.HandleFunc("/api/test-password", s.handleTestPassword) // 测试密码验证接口（仅用于调试）
-	mux.HandleFunc("/api/upload", s.handleUpload)
-	mux.HandleFunc("/api/upload-batch", s.handleBatchUpload)
-	mux.HandleFunc("/api/query", s.handleQuery)
+	mux.HandleFunc("/api/upload", s.withMaintenanceGate("uploads", s.withUploadLimit(s.withIdempotency(s.handleUpload))))
+	mux.HandleFunc("/api/upload-batch", s.withMaintenanceGate("uploads", s.withUploadLimit(s.withIdempotency(s.handleBatchUpload))))
+	mux.HandleFunc("/api/upload-archive", s.withMaintenanceGate("uploads", s.withUploadLimit(s.withIdempotency(s.handleUploadArchive))))
+	mux.HandleFunc("/api/upload-url", s.withMaintenanceGate("uploads", s.withUploadLimit(s.withIdempotency(s.handleUploadURL))))
+	mux.HandleFunc("/api/query", s.withMaintenanceGate("queries", s.handleQuery))
+	mux.HandleFunc("/api/query/multi", s.withMaintenanceGate("queries", s.handleQueryMulti))
+	mux.HandleFunc("/api/query/", s.handleQueryPage) // /api/query/{queryId}/page/{n} 查询结果翻页
+	mux.HandleFunc("/api/answer", s.withMaintenanceGate("queries", s.handleAnswer))
 	mux.HandleFunc("/api/feedback", s.handleFeedback)
 	mux.HandleFunc("/api/check-admin", s.handleCheckAdmin)
 	mux.HandleFunc("/api/files/count", s.handleFileCount)
+	mux.HandleFunc("/api/recheck-all", s.handleRecheckAll)
 	mux.HandleFunc("/api/files", s.handleFileList)
+	mux.HandleFunc("/api/chunks/", s.handleChunkDelete)
 	mux.HandleFunc("/api/files/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == "GET" {
+		if strings.HasSuffix(r.URL.Path, "/block-override") {
+			s.handleFileBlockOverride(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/recheck") {
+			s.handleFileRecheck(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/pin") {
+			s.handleFilePin(w, r)
+		} else if strings.HasSuffix(r.URL.Path, "/similar") {
+			s.handleFileSimilar(w, r)
+		} else if r.Method == "GET" {
 			s.handleFileDownload(w, r)
 		} else if r.Method == "DELETE" {
 			s.handleFileDelete(w, r)
+		} else if r.Method == "PATCH" {
+			s.handleFileUpdate(w, r)
 		} else {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
@@ -338,8 +706,8 @@ func (s *Server) Start(port string) error {
 		})
 	}
 
-	// 先应用panic恢复，再应用CORS
-	handler := recoveryMiddleware(corsMiddleware(mux))
+	// 先应用panic恢复，再应用CORS，最后限制JSON请求体大小
+	handler := recoveryMiddleware(corsMiddleware(s.withJSONBodyLimit(mux)))
 
 	// 创建HTTP服务器并设置超时时间
 	// 优化：增加超时时间以支持大文件上传和长时间向量化
@@ -351,12 +719,142 @@ func (s *Server) Start(port string) error {
 		IdleTimeout:  120 * time.Second, // 空闲连接超时：2分钟
 	}
 
+	// 种子数据引导（BOOTSTRAP_DIR）：集合为空时自动导入种子文档，在后台进行以免阻塞服务启动
+	if s.config.BootstrapDir != "" {
+		go s.bootstrap()
+	}
+
+	// 启动预热（WARMUP=true时），在后台进行以免阻塞服务启动
+	if s.config.Warmup {
+		go s.warmup()
+	}
+
+	// 定期触发Qdrant优化器（OPTIMIZE_INTERVAL_MIN），清理频繁删除累积的软删除点
+	if s.config.OptimizeIntervalMin > 0 {
+		if qdrantStore, ok := s.store.(*store.QdrantStore); ok {
+			go func() {
+				ticker := time.NewTicker(time.Duration(s.config.OptimizeIntervalMin) * time.Minute)
+				defer ticker.Stop()
+				for range ticker.C {
+					ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+					if err := qdrantStore.Optimize(ctx); err != nil {
+						logger.Warn("⚠️ 定期优化触发失败: %v", err)
+					}
+					cancel()
+				}
+			}()
+			logger.Info("已启用定期优化: 每 %d 分钟触发一次Qdrant优化器", s.config.OptimizeIntervalMin)
+		}
+	}
+
 	logger.Info("服务器启动在 http://localhost%s (超时设置: 读取/写入30分钟)", server.Addr)
 	return server.ListenAndServe()
 }
 
+// warmup 启动预热：做一次丢弃结果的嵌入和一次小规模检索
+// 提前建立到嵌入API和向量存储的连接池，避免重启后第一个真实查询承担冷启动开销
+func (s *Server) warmup() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	startTime := time.Now()
+	logger.Info("🔥 开始启动预热（WARMUP=true）...")
+
+	if _, err := s.embedder.EmbedQuery(ctx, "warmup"); err != nil {
+		logger.Warn("⚠️ 预热嵌入请求失败（不影响服务启动）: %v", err)
+		return
+	}
+
+	if _, err := s.store.Search(ctx, "warmup", s.embedder.GetEmbedder(), 1); err != nil {
+		logger.Warn("⚠️ 预热检索请求失败（不影响服务启动）: %v", err)
+		return
+	}
+
+	logger.Info("✅ 启动预热完成 (耗时: %v)", time.Since(startTime).Round(time.Millisecond))
+}
+
+// bootstrap 种子数据引导：新部署集合为空时，自动导入BOOTSTRAP_DIR目录下的种子文档
+// 让新环境开箱即可演示查询效果，也为部署提供一条冒烟测试路径；集合已有数据时跳过
+func (s *Server) bootstrap() {
+	ctx := context.Background()
+
+	count, err := s.store.Count(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName)
+	if err != nil {
+		logger.Warn("⚠️ 种子数据引导：查询集合数量失败，跳过引导: %v", err)
+		return
+	}
+	if count > 0 {
+		logger.Info("种子数据引导：集合已有 %d 个向量，跳过 BOOTSTRAP_DIR 导入", count)
+		return
+	}
+
+	logger.Info("种子数据引导：集合为空，开始导入种子目录 %s ...", s.config.BootstrapDir)
+
+	var fileLoader *loader.FileLoader
+	if s.ocrProcessor != nil {
+		fileLoader = loader.NewFileLoaderWithOCR(s.ocrProcessor)
+	} else {
+		fileLoader = loader.NewFileLoader()
+	}
+	textSplitter := splitter.NewTextSplitter(s.config.ChunkSize, s.config.ChunkOverlap)
+
+	fileCount, chunkCount := 0, 0
+	err = filepath.Walk(s.config.BootstrapDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if !archiveSupportedExts[ext] {
+			return nil
+		}
+
+		docs, err := fileLoader.Load(path)
+		if err != nil {
+			logger.Warn("⚠️ 种子数据引导：加载 %s 失败，跳过: %v", path, err)
+			return nil
+		}
+		chunks, err := textSplitter.SplitDocuments(docs)
+		if err != nil {
+			logger.Warn("⚠️ 种子数据引导：切分 %s 失败，跳过: %v", path, err)
+			return nil
+		}
+		validChunks := make([]schema.Document, 0, len(chunks))
+		for _, chunk := range chunks {
+			if len(strings.TrimSpace(chunk.PageContent)) > 0 {
+				validChunks = append(validChunks, chunk)
+			}
+		}
+		if len(validChunks) == 0 {
+			return nil
+		}
+		if err := s.ragSystem.AddDocuments(ctx, validChunks); err != nil {
+			logger.Warn("⚠️ 种子数据引导：向量化 %s 失败，跳过: %v", path, err)
+			return nil
+		}
+		logger.Info("种子数据引导：已导入 %s (%d 个文本块)", path, len(validChunks))
+		fileCount++
+		chunkCount += len(validChunks)
+		return nil
+	})
+	if err != nil {
+		logger.Warn("⚠️ 种子数据引导：遍历目录 %s 失败: %v", s.config.BootstrapDir, err)
+		return
+	}
+
+	logger.Info("✅ 种子数据引导完成: 导入 %d 个文件, 共 %d 个文本块", fileCount, chunkCount)
+}
+
 // handleHealth 健康检查
+// 配置了HEALTH_TOKEN时要求请求携带匹配的X-Health-Token头，防止探活端点被任意探测
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if s.config.HealthToken != "" && r.Header.Get("X-Health-Token") != s.config.HealthToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status": "ok",
@@ -364,72 +862,431 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleLogin 处理用户登录
-func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+// handleVersion 返回构建信息（版本、git提交、构建时间通过 -ldflags 注入）
+// 用于排查线上运行的是哪个构建，无需认证
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version":   version.Version,
+		"gitCommit": version.GitCommit,
+		"buildTime": version.BuildTime,
+		"goVersion": runtime.Version(),
+		"uptime":    time.Since(s.startedAt).Round(time.Second).String(),
+	})
+}
+
+// handleSupportedTypes 返回后端支持的文件类型清单（从loader注册表派生）
+// 前端的accept过滤和帮助文案从这里获取，避免硬编码列表与后端能力漂移，无需认证
+func (s *Server) handleSupportedTypes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"types":      loader.SupportedTypes(),
+		"ocrEnabled": s.ocrProcessor != nil, // 扫描件PDF只有在OCR可用时才能提取文本
+	})
+}
+
+// handleAdminOptimize 管理端手动触发Qdrant优化器运行
+// 频繁重传和删除会累积软删除点拖慢检索，触发优化器合并段并清理；返回触发前后的点数量
+func (s *Server) handleAdminOptimize(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	qdrantStore, ok := s.store.(*store.QdrantStore)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Unsupported vector store",
+			"message": "当前向量存储后端不支持手动优化，仅Qdrant支持",
+		})
 		return
 	}
 
-	// 检查数据库连接
-	if s.gormDB == nil {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	before, err := s.store.Count(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName)
+	if err != nil {
+		logger.Warn("⚠️ 优化前查询点数量失败: %v", err)
+		before = -1
+	}
+
+	if err := qdrantStore.Optimize(ctx); err != nil {
+		logger.Error("触发Qdrant优化器失败: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "数据库未配置",
+			"error":   "Optimize failed",
+			"message": err.Error(),
 		})
 		return
 	}
 
-	// 从数据库查询用户
-	var user models.User
-	logger.Info("尝试登录，用户名: %s, 输入密码长度: %d", req.Username, len(req.Password))
-	result := s.gormDB.Where("username = ?", req.Username).First(&user)
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			logger.Info("用户不存在: %s", req.Username)
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": false,
-				"message": "用户名或密码错误",
-			})
-		} else {
-			logger.Error("查询用户失败: %v", result.Error)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"success": false,
-				"message": "登录失败，请稍后重试",
-			})
-		}
-		return
+	// 优化在Qdrant后台异步执行，这里的after计数反映触发时刻的状态
+	after, err := s.store.Count(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName)
+	if err != nil {
+		logger.Warn("⚠️ 优化后查询点数量失败: %v", err)
+		after = -1
 	}
 
-	logger.Info("找到用户: %s (ID: %d), 创建时间: %v", user.Username, user.ID, user.CreatedAt)
+	logger.Info("管理员触发集合优化: 触发前 %d 个点, 触发后 %d 个点（清理在后台继续）", before, after)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"message":      "已触发优化器运行，软删除点的清理在后台异步进行",
+		"pointsBefore": before,
+		"pointsAfter":  after,
+	})
+}
 
-	// 清理密码hash（去除前后空格和换行符）
-	passwordHash := strings.TrimSpace(user.Password)
-	passwordHash = strings.Trim(passwordHash, "\n\r")
+// 日志tail接口单次返回的行数默认值和上限
+const (
+	defaultLogTailLines = 200
+	maxLogTailLines     = 1000
+)
 
-	// 清理用户输入的密码（去除前后空格，但保留中间空格）
-	inputPassword := strings.TrimSpace(req.Password)
+// handleAdminLogs 返回当天日志文件的末尾若干行（GET /api/admin/logs?source=&level=&lines=）
+// 给没有服务器shell权限的运维远程排障用：source为app或error（默认app），
+// level非空时按级别过滤，lines默认200行、封顶1000行
+func (s *Server) handleAdminLogs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	// 调试：检查密码hash格式（只显示前20个字符，避免泄露完整hash）
-	if len(passwordHash) > 20 {
-		logger.Info("密码hash前缀: %s... (长度: %d)", passwordHash[:20], len(passwordHash))
-	} else {
-		logger.Info("密码hash: %s (长度: %d)", passwordHash, len(passwordHash))
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
-	logger.Info("输入密码长度: %d, 原始长度: %d", len(inputPassword), len(req.Password))
+
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = "app"
+	}
+	level := r.URL.Query().Get("level")
+
+	lines := defaultLogTailLines
+	if linesStr := r.URL.Query().Get("lines"); linesStr != "" {
+		parsed, err := strconv.Atoi(linesStr)
+		if err != nil || parsed <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Invalid lines",
+				"message": fmt.Sprintf("lines参数必须是正整数: %s", linesStr),
+			})
+			return
+		}
+		lines = parsed
+	}
+	if lines > maxLogTailLines {
+		lines = maxLogTailLines
+	}
+
+	logLines, err := logger.TailLogs(source, level, lines)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Failed to read logs",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"source":  source,
+		"level":   level,
+		"lines":   logLines,
+		"count":   len(logLines),
+	})
+}
+
+// handleImport 从导出的逐行JSON恢复向量（灾备路径，不需要原始文件）
+// 请求体每行一条 {"content": "...", "metadata": {...}}，重新嵌入后写入向量库；
+// metadata（含file_id、chunk_index）原样保留，point ID确定性派生，重复导入不会产生副本
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	qdrantStore, ok := s.store.(*store.QdrantStore)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Unsupported vector store",
+			"message": "当前向量存储后端不支持导入恢复，仅Qdrant支持",
+		})
+		return
+	}
+
+	// 逐行解析导出记录（单行过长时放宽scanner缓冲上限）
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var docs []schema.Document
+	lineNo := 0
+	skipped := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record struct {
+			Content  string                 `json:"content"`
+			Metadata map[string]interface{} `json:"metadata"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Invalid import record",
+				"message": fmt.Sprintf("第 %d 行JSON解析失败: %v", lineNo, err),
+			})
+			return
+		}
+		if strings.TrimSpace(record.Content) == "" {
+			skipped++
+			continue
+		}
+		if record.Metadata == nil {
+			record.Metadata = map[string]interface{}{}
+		}
+		docs = append(docs, schema.Document{
+			PageContent: record.Content,
+			Metadata:    record.Metadata,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Failed to read request body",
+			"message": fmt.Sprintf("读取请求体失败: %v", err),
+		})
+		return
+	}
+	if len(docs) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Empty import",
+			"message": "请求体中没有可导入的记录",
+		})
+		return
+	}
+
+	// 按嵌入批次分批导入，避免一次请求嵌入过多文本
+	batchSize := s.config.EmbedBatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+	if maxBatch := s.embedder.MaxBatch(); maxBatch > 0 && batchSize > maxBatch {
+		batchSize = maxBatch
+	}
+
+	logger.Info("开始导入恢复: %d 条记录, 批次大小 %d", len(docs), batchSize)
+	startTime := time.Now()
+	imported := 0
+	for i := 0; i < len(docs); i += batchSize {
+		end := i + batchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		if err := qdrantStore.ImportDocuments(r.Context(), docs[i:end], s.embedder.GetEmbedder()); err != nil {
+			logger.Error("导入恢复在第 %d-%d 条之间失败: %v", i+1, end, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":    "Import failed",
+				"message":  fmt.Sprintf("导入失败: %v（已成功导入 %d 条，重跑请求会覆盖同一批点，不会重复）", err, imported),
+				"imported": imported,
+			})
+			return
+		}
+		imported = end
+	}
+
+	// 文档发生变化，清空检索结果缓存
+	rag.InvalidateRetrievalCache()
+
+	logger.Info("✅ 导入恢复完成: %d 条记录, 跳过空内容 %d 条 (耗时: %v)", imported, skipped, time.Since(startTime).Round(time.Millisecond))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"message":  fmt.Sprintf("导入完成: %d 条记录", imported),
+		"imported": imported,
+		"skipped":  skipped,
+	})
+}
+
+// 单次嵌入请求允许的最大文本数量，防止外部服务一次性打爆嵌入配额
+const maxEmbedTexts = 256
+
+// handleEmbed 批量嵌入接口（仅管理员）
+// POST {"texts":[...]}返回每段文本的向量，供内部辅助工具（聚类、去重分析）复用
+// 已配置的嵌入后端，不用各自持有API Key；分批与速率控制和入库流程一致
+func (s *Server) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Texts []string `json:"texts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid request",
+			"message": "无法解析请求体",
+		})
+		return
+	}
+	if len(req.Texts) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Texts is required",
+			"message": "texts不能为空",
+		})
+		return
+	}
+	if len(req.Texts) > maxEmbedTexts {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Too many texts",
+			"message": fmt.Sprintf("单次请求最多嵌入 %d 段文本，收到 %d 段", maxEmbedTexts, len(req.Texts)),
+		})
+		return
+	}
+
+	// 与入库流程相同的分批策略：EMBED_BATCH_SIZE优先，受provider单次请求上限约束
+	batchSize := s.config.EmbedBatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+	if maxBatch := s.embedder.MaxBatch(); maxBatch > 0 && batchSize > maxBatch {
+		batchSize = maxBatch
+	}
+
+	logger.Info("收到批量嵌入请求: %d 段文本, 批次大小 %d, 客户端: %s", len(req.Texts), batchSize, r.RemoteAddr)
+	vectors := make([][]float32, 0, len(req.Texts))
+	for i := 0; i < len(req.Texts); i += batchSize {
+		end := i + batchSize
+		if end > len(req.Texts) {
+			end = len(req.Texts)
+		}
+		batchVectors, err := s.embedder.EmbedDocuments(r.Context(), req.Texts[i:end])
+		if err != nil {
+			logger.Error("批量嵌入在第 %d-%d 段之间失败: %v", i+1, end, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Embed failed",
+				"message": fmt.Sprintf("嵌入失败: %v", err),
+			})
+			return
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":    true,
+		"count":      len(vectors),
+		"dimensions": s.embedder.GetDimensions(),
+		"vectors":    vectors,
+	})
+}
+
+// handleLogin 处理用户登录
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// 检查数据库连接
+	if s.gormDB == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "数据库未配置",
+		})
+		return
+	}
+
+	// 从数据库查询用户
+	var user models.User
+	logger.Info("尝试登录，用户名: %s, 输入密码长度: %d", req.Username, len(req.Password))
+	result := s.gormDB.Where("username = ?", req.Username).First(&user)
+	if result.Error != nil {
+		if result.Error == gorm.ErrRecordNotFound {
+			logger.Info("用户不存在: %s", req.Username)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "用户名或密码错误",
+			})
+		} else {
+			logger.Error("查询用户失败: %v", result.Error)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "登录失败，请稍后重试",
+			})
+		}
+		return
+	}
+
+	logger.Info("找到用户: %s (ID: %d), 创建时间: %v", user.Username, user.ID, user.CreatedAt)
+
+	// 清理密码hash（去除前后空格和换行符）
+	passwordHash := strings.TrimSpace(user.Password)
+	passwordHash = strings.Trim(passwordHash, "\n\r")
+
+	// 清理用户输入的密码（去除前后空格，但保留中间空格）
+	inputPassword := strings.TrimSpace(req.Password)
+
+	// 调试：检查密码hash格式（只显示前20个字符，避免泄露完整hash）
+	if len(passwordHash) > 20 {
+		logger.Info("密码hash前缀: %s... (长度: %d)", passwordHash[:20], len(passwordHash))
+	} else {
+		logger.Info("密码hash: %s (长度: %d)", passwordHash, len(passwordHash))
+	}
+	logger.Info("输入密码长度: %d, 原始长度: %d", len(inputPassword), len(req.Password))
 
 	// 检查输入密码是否为空
 	if len(inputPassword) == 0 {
@@ -596,6 +1453,10 @@ func (s *Server) handleCheckAdmin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if isBodyTooLarge(err) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
@@ -657,12 +1518,120 @@ func (s *Server) handleCheckAdmin(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// handleUpload 处理单个文件上传
-func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// capturedResponse 捕获handler写出的响应，用于幂等键重放
+type capturedResponse struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newCapturedResponse() *capturedResponse {
+	return &capturedResponse{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (c *capturedResponse) Header() http.Header {
+	return c.header
+}
+
+func (c *capturedResponse) WriteHeader(statusCode int) {
+	c.statusCode = statusCode
+}
+
+func (c *capturedResponse) Write(data []byte) (int, error) {
+	return c.body.Write(data)
+}
+
+// withMaintenanceGate 维护模式守卫：对应开关被暂停时返回503，不影响其他接口
+// kind为"uploads"（入库类接口）或"queries"（查询类接口）
+func (s *Server) withMaintenanceGate(kind string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.maintenanceMu.Lock()
+		var paused bool
+		var message string
+		if kind == "uploads" {
+			paused = s.uploadsPaused
+			message = "系统维护中，暂停接收文档上传，请稍后重试（查询不受影响）"
+		} else {
+			paused = s.queriesPaused
+			message = "系统维护中，暂停查询服务，请稍后重试"
+		}
+		s.maintenanceMu.Unlock()
+
+		if paused {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Service in maintenance",
+				"message": message,
+			})
+			return
+		}
+		handler(w, r)
 	}
+}
+
+// withJSONBodyLimit 限制非上传接口的请求体大小（MAX_JSON_BODY_KB）
+// 上传接口走multipart且有独立的大小限制，这里跳过；其余接口的请求体都是小JSON，
+// 超限读取会得到http.MaxBytesError，处理器据此返回413
+func (s *Server) withJSONBodyLimit(next http.Handler) http.Handler {
+	limit := int64(s.config.MaxJSONBodyKB) * 1024
+	if limit <= 0 {
+		return next
+	}
+	uploadPaths := map[string]bool{
+		"/api/upload":         true,
+		"/api/upload-batch":   true,
+		"/api/upload-archive": true,
+		"/api/import":         true,
+		"/api/feedback":       true, // 反馈可携带截图附件
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && !uploadPaths[r.URL.Path] {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isBodyTooLarge 判断解码错误是否由请求体超过MaxBytesReader限制导致
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// withUploadLimit 上传并发限制中间件（MAX_CONCURRENT_UPLOADS）
+// 多路大文件并发上传会耗尽multipart解析缓冲和磁盘IO；超出并发上限时直接返回503，
+// 不排队（上传体可能很大，挂起等待反而占住连接和内存）
+func (s *Server) withUploadLimit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.uploadSlots == nil {
+			handler(w, r)
+			return
+		}
+		select {
+		case s.uploadSlots <- struct{}{}:
+			defer func() { <-s.uploadSlots }()
+		default:
+			logger.Warn("上传并发已达上限，拒绝上传请求: %s, 客户端: %s", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "10")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Too many concurrent uploads",
+				"message": "同时进行的上传过多，请稍后重试",
+			})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// handleAdminMaintenance 维护模式开关（仅管理员）
+// POST {"uploads":false}暂停上传、{"queries":false}暂停查询，省略的字段保持当前值；
+// GET返回当前开关状态。开关只存在内存中，重启后恢复为全部开放
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
 	// 检查管理员权限
 	if !s.checkAdminAuth(r) {
@@ -670,16 +1639,254 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 解析multipart form
-	// 优化：统一文件大小限制为500MB，与批量上传保持一致
-	err := r.ParseMultipartForm(500 << 20) // 500MB（从32MB增加到500MB，与批量上传保持一致）
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to parse form: %v (文件可能过大，最大支持500MB)", err), http.StatusBadRequest)
+	switch r.Method {
+	case "GET":
+		// 只查询当前状态
+	case "POST":
+		var req struct {
+			Uploads *bool `json:"uploads"`
+			Queries *bool `json:"queries"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Invalid request",
+				"message": "无法解析请求体",
+			})
+			return
+		}
+		s.maintenanceMu.Lock()
+		if req.Uploads != nil {
+			s.uploadsPaused = !*req.Uploads
+		}
+		if req.Queries != nil {
+			s.queriesPaused = !*req.Queries
+		}
+		s.maintenanceMu.Unlock()
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
-	if err != nil {
+	s.maintenanceMu.Lock()
+	uploadsOpen := !s.uploadsPaused
+	queriesOpen := !s.queriesPaused
+	s.maintenanceMu.Unlock()
+
+	if r.Method == "POST" {
+		logger.Info("维护模式已更新: uploads=%v, queries=%v", uploadsOpen, queriesOpen)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"uploads": uploadsOpen,
+		"queries": queriesOpen,
+	})
+}
+
+// handleAdminCacheStats 检索结果缓存统计（仅管理员）
+// GET返回缓存开关、条目数和命中/未命中计数，用于评估RETRIEVAL_CACHE_TTL的收益
+func (s *Server) handleAdminCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":        true,
+		"retrievalCache": rag.RetrievalCacheStats(),
+	})
+}
+
+// withIdempotency 为上传类接口添加幂等性支持（Idempotency-Key头）
+// 同一个键的请求：处理中时返回409拒绝并发重复；TTL内已完成时直接重放之前的响应
+// 与内容哈希去重互补，覆盖哈希检查尚未提交时的并发重试竞态
+func (s *Server) withIdempotency(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			handler(w, r)
+			return
+		}
+
+		s.idempotencyMu.Lock()
+		// 顺带清理过期的键，避免map无限增长
+		now := time.Now()
+		for k, entry := range s.idempotency {
+			if entry.done && now.Sub(entry.createdAt) > idempotencyTTL {
+				delete(s.idempotency, k)
+			}
+		}
+
+		if entry, exists := s.idempotency[key]; exists {
+			if !entry.done {
+				s.idempotencyMu.Unlock()
+				logger.Warn("幂等键 %q 的请求正在处理中，拒绝并发重复请求", key)
+				http.Error(w, "相同Idempotency-Key的请求正在处理中，请勿重复提交", http.StatusConflict)
+				return
+			}
+			// TTL内已完成：重放之前的响应
+			s.idempotencyMu.Unlock()
+			logger.Info("幂等键 %q 在TTL内重复请求，重放之前的响应 (status=%d)", key, entry.statusCode)
+			if entry.contentType != "" {
+				w.Header().Set("Content-Type", entry.contentType)
+			}
+			w.WriteHeader(entry.statusCode)
+			w.Write(entry.body)
+			return
+		}
+
+		entry := &idempotencyEntry{createdAt: now}
+		s.idempotency[key] = entry
+		s.idempotencyMu.Unlock()
+
+		// 捕获响应，完成后记录到幂等键并回写给客户端。
+		// handler panic时（由外层recoveryMiddleware接住）删除幂等键：
+		// 未完成的条目不会被TTL清理，否则该键永远停留在"处理中"、重试一直被409拒绝
+		captured := newCapturedResponse()
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			s.idempotencyMu.Lock()
+			delete(s.idempotency, key)
+			s.idempotencyMu.Unlock()
+		}()
+		handler(captured, r)
+
+		s.idempotencyMu.Lock()
+		entry.done = true
+		entry.statusCode = captured.statusCode
+		entry.contentType = captured.header.Get("Content-Type")
+		entry.body = captured.body.Bytes()
+		s.idempotencyMu.Unlock()
+		completed = true
+
+		for k, values := range captured.header {
+			for _, v := range values {
+				w.Header().Add(k, v)
+			}
+		}
+		w.WriteHeader(captured.statusCode)
+		w.Write(captured.body.Bytes())
+	}
+}
+
+// maxUploadBytes 上传请求的大小限制（multipart form整体大小）
+const maxUploadBytes = 500 << 20 // 500MB
+
+// checkUploadSize 在解析multipart form之前检查Content-Length是否超过限制
+// 超限时返回413及明确的提示（限制大小和实际大小），避免笼统的400让用户误以为文件损坏
+// 返回false表示请求已被拒绝，调用方应直接返回
+func (s *Server) checkUploadSize(w http.ResponseWriter, r *http.Request) bool {
+	if r.ContentLength > maxUploadBytes {
+		logger.Warn("上传请求过大被拒绝：%d 字节（限制 %d 字节），客户端: %s", r.ContentLength, int64(maxUploadBytes), r.RemoteAddr)
+		http.Error(w, fmt.Sprintf("上传内容过大：%.1f MB，超过 %d MB 的限制，请压缩或拆分后重试", float64(r.ContentLength)/(1<<20), maxUploadBytes>>20), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	return true
+}
+
+// parseIngestedBound 解析入库时间范围参数（"2006-01-02"或RFC3339），空表示不限制
+// 仅日期格式的上限（before）按当天结束计算，使"before":"2026-08-28"包含当天入库的文档
+func parseIngestedBound(value string, isUpperBound bool) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("%q 不是有效的时间（支持2006-01-02或RFC3339格式）", value)
+	}
+	if isUpperBound {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t.Unix(), nil
+}
+
+// annotateChunks 为每个chunk写入file_id、chunk_index和入库时间元数据
+// file_id/chunk_index供检索时做邻近块扩展，ingested_at（Unix秒）供按入库时间范围过滤检索
+func annotateChunks(chunks []schema.Document, fileID string) {
+	ingestedAt := time.Now().Unix()
+	for i := range chunks {
+		if chunks[i].Metadata == nil {
+			chunks[i].Metadata = make(map[string]interface{})
+		}
+		chunks[i].Metadata["file_id"] = fileID
+		chunks[i].Metadata["chunk_index"] = i
+		chunks[i].Metadata["ingested_at"] = ingestedAt
+	}
+}
+
+// maybeAppendSummaryChunk 按GENERATE_SUMMARIES配置为文档追加LLM摘要chunk
+// 摘要生成失败或文档过短时原样返回，不影响正常摄取（失败只记录警告）
+func (s *Server) maybeAppendSummaryChunk(chunks, docs []schema.Document, title string) []schema.Document {
+	if !s.config.GenerateSummaries {
+		return chunks
+	}
+	summaryChunk, err := s.ragSystem.GenerateSummaryChunk(context.Background(), docs, title)
+	if err != nil {
+		logger.Warn("⚠️ 文档 %s: %v（跳过摘要，正常摄取）", title, err)
+		return chunks
+	}
+	if summaryChunk != nil {
+		chunks = append(chunks, *summaryChunk)
+	}
+	return chunks
+}
+
+// docAuthorDate 从加载结果中提取结构化元数据（作者、日期），供文件列表展示
+func docAuthorDate(docs []schema.Document) (author, date string) {
+	if len(docs) == 0 {
+		return "", ""
+	}
+	if v, ok := docs[0].Metadata["author"].(string); ok {
+		author = v
+	}
+	if v, ok := docs[0].Metadata["date"].(string); ok {
+		date = v
+	}
+	return author, date
+}
+
+// handleUpload 处理单个文件上传
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// 在解析前检查请求大小，超限时返回413及明确的提示
+	if !s.checkUploadSize(w, r) {
+		return
+	}
+
+	// 解析multipart form
+	// 优化：统一文件大小限制为500MB，与批量上传保持一致
+	err := r.ParseMultipartForm(maxUploadBytes) // 500MB（从32MB增加到500MB，与批量上传保持一致）
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse form: %v (文件可能过大，最大支持500MB)", err), http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to get file: %v", err), http.StatusBadRequest)
 		return
 	}
@@ -720,6 +1927,9 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 标记为pending：向量化完成前文件在列表中可见，但还查询不到内容
+	s.setFileStatus(fileID, fileStatusPending)
+
 	// 加载文档（使用带OCR支持的文件加载器，纯Go实现）
 	var fileLoader *loader.FileLoader
 	if s.ocrProcessor != nil {
@@ -727,7 +1937,8 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	} else {
 		fileLoader = loader.NewFileLoader()
 	}
-	docs, err := fileLoader.Load(savedPath)
+	// 带上上传方声明的Content-Type：扩展名缺失或错误时按内容推断实际类型
+	docs, err := fileLoader.LoadWithContentType(savedPath, header.Header.Get("Content-Type"))
 	if err != nil {
 		// 优化：提供更友好的错误信息（与批量上传保持一致）
 		errMsg := err.Error()
@@ -752,6 +1963,25 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 			logger.Error("保存失败文件时出错: %v", saveErr)
 			os.Remove(savedPath) // 如果保存失败，删除原文件
 		}
+		s.setFileStatus(fileID, fileStatusFailed)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  false,
+			"message":  failureReason,
+			"filename": header.Filename,
+		})
+		return
+	}
+
+	// 最小文档长度（MIN_DOC_CHARS）：过短的文档只会产出噪声向量，拒绝入库
+	if total, tooShort := loader.DocBelowMinChars(docs); tooShort {
+		failureReason := fmt.Sprintf("文档内容过短: 仅 %d 字符（最低要求 %d），未建立索引", total, loader.MinDocChars())
+		if saveErr := s.saveFailedFile(savedPath, header.Filename, failureReason); saveErr != nil {
+			logger.Error("保存失败文件时出错: %v", saveErr)
+			os.Remove(savedPath)
+		}
+		s.setFileStatus(fileID, fileStatusFailed)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -786,6 +2016,7 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 			logger.Error("保存失败文件时出错: %v", saveErr)
 			os.Remove(savedPath) // 如果保存失败，删除原文件
 		}
+		s.setFileStatus(fileID, fileStatusFailed)
 		http.Error(w, fmt.Sprintf("Failed to split document: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -807,6 +2038,7 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 			logger.Error("保存失败文件时出错: %v", saveErr)
 			os.Remove(savedPath)
 		}
+		s.setFileStatus(fileID, fileStatusFailed)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success":  false,
@@ -821,15 +2053,22 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		logger.Warn("文件 %s 过滤掉 %d 个空chunks，保留 %d 个有效chunks", header.Filename, len(chunks)-len(validChunks), len(validChunks))
 	}
 
-	// 添加到知识库
+	// 为每个chunk添加file_id和chunk_index元数据（用于邻近块扩展）
+	validChunks = s.maybeAppendSummaryChunk(validChunks, docs, title)
+	annotateChunks(validChunks, fileID)
+
+	// 添加到知识库（每批完成后记录进度，方便在日志中跟踪大文件上传）
 	ctx := context.Background()
-	if err := s.ragSystem.AddDocuments(ctx, validChunks); err != nil {
+	if err := s.ragSystem.AddDocumentsWithProgress(ctx, validChunks, func(processed, total int) {
+		logger.Info("文件 %s 向量化进度: %d/%d", header.Filename, processed, total)
+	}); err != nil {
 		// 向量化失败：保存失败文件到失败目录
 		failureReason := fmt.Sprintf("向量化失败: %v", err)
 		if saveErr := s.saveFailedFile(savedPath, header.Filename, failureReason); saveErr != nil {
 			logger.Error("保存失败文件时出错: %v", saveErr)
 			os.Remove(savedPath) // 如果保存失败，删除原文件
 		}
+		s.setFileStatus(fileID, fileStatusFailed)
 		logger.Error("向量化失败，已保存失败文件: %s, 错误: %v", savedPath, err)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -840,6 +2079,9 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 按类型镜像到分片集合（SHARD_BY_TYPE，异步执行，失败不影响主集合）
+	go s.mirrorToShards(validChunks)
+
 	// 保存文件信息
 	fileInfo := &FileInfo{
 		ID:         fileID,
@@ -849,8 +2091,16 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		Size:       fileSize,
 		UploadedAt: time.Now(),
 		Chunks:     len(validChunks),
+		Status:     fileStatusIndexed,
 	}
+	fileInfo.Author, fileInfo.Date = docAuthorDate(docs)
+	s.filesMu.Lock()
 	s.files[fileID] = fileInfo
+	s.filesMu.Unlock()
+	s.setFileStatus(fileID, fileStatusIndexed)
+
+	// 上传时计算下载限制状态（是否包含"公开形式"字样）
+	s.refreshDownloadBlock(fileInfo)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -862,6 +2112,173 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// urlFileID 为URL来源的文档生成合成文件ID（URL哈希，保证同一URL重复摄取时ID稳定）
+func urlFileID(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return "url_" + hex.EncodeToString(sum[:])[:12]
+}
+
+// handleUploadURL 从URL摄取网页内容到知识库
+// URL来源的文档以合成ID记录在文件列表中（SourceType=url），删除时按source URL过滤向量数据
+func (s *Server) handleUploadURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.URL = strings.TrimSpace(req.URL)
+	if req.URL == "" {
+		http.Error(w, "URL required", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+		http.Error(w, "仅支持http/https URL", http.StatusBadRequest)
+		return
+	}
+
+	// 域名白名单检查（URL_ALLOWED_DOMAINS），白名单外的域名直接拒绝
+	if err := loader.CheckURLAllowed(req.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// 合成ID按URL哈希生成，同一URL重复摄取时直接拒绝
+	fileID := urlFileID(req.URL)
+	s.filesMu.RLock()
+	_, ingested := s.files[fileID]
+	s.filesMu.RUnlock()
+	if ingested {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("URL %s 已摄取过，请先删除后再重新摄取", req.URL),
+			"fileId":  fileID,
+		})
+		return
+	}
+
+	// 加载网页内容（LoadFromURL会在metadata中写入source=URL和source_type=url）
+	docs, err := loader.LoadFromURL(req.URL)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("加载URL失败: %v", err),
+		})
+		return
+	}
+
+	// 最小文档长度（MIN_DOC_CHARS）：过短的网页内容拒绝入库
+	if total, tooShort := loader.DocBelowMinChars(docs); tooShort {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("网页内容过短: 仅 %d 字符（最低要求 %d），未建立索引", total, loader.MinDocChars()),
+		})
+		return
+	}
+
+	// 提取内容预览和总大小
+	contentPreview := ""
+	var totalSize int64
+	for _, doc := range docs {
+		totalSize += int64(len(doc.PageContent))
+	}
+	if len(docs) > 0 {
+		contentPreview = docs[0].PageContent
+		if len(contentPreview) > 1000 {
+			contentPreview = contentPreview[:1000] + "..."
+		}
+	}
+
+	// 切分文档
+	textSplitter := splitter.NewTextSplitter(s.config.ChunkSize, s.config.ChunkOverlap)
+	chunks, err := textSplitter.SplitDocuments(docs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to split document: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// 过滤掉内容为空的chunks
+	validChunks := make([]schema.Document, 0, len(chunks))
+	for _, chunk := range chunks {
+		if len(strings.TrimSpace(chunk.PageContent)) > 0 {
+			validChunks = append(validChunks, chunk)
+		}
+	}
+
+	if len(validChunks) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "网页加载成功但未提取到任何有效文本内容",
+		})
+		return
+	}
+
+	// 为每个chunk添加file_id和chunk_index元数据（用于邻近块扩展）
+	validChunks = s.maybeAppendSummaryChunk(validChunks, docs, req.URL)
+	annotateChunks(validChunks, fileID)
+
+	// 添加到知识库
+	ctx := context.Background()
+	if err := s.ragSystem.AddDocumentsWithProgress(ctx, validChunks, func(processed, total int) {
+		logger.Info("URL %s 向量化进度: %d/%d", req.URL, processed, total)
+	}); err != nil {
+		logger.Error("URL内容向量化失败: %s, 错误: %v", req.URL, err)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("网页加载成功，但向量化失败: %v，请稍后重试", err),
+		})
+		return
+	}
+
+	// 按类型镜像到分片集合（SHARD_BY_TYPE，异步执行，失败不影响主集合）
+	go s.mirrorToShards(validChunks)
+
+	// 以合成ID记录URL来源，使其出现在文件列表中并支持删除
+	fileInfo := &FileInfo{
+		ID:         fileID,
+		Filename:   req.URL,
+		Title:      req.URL,
+		Content:    contentPreview,
+		Size:       totalSize,
+		UploadedAt: time.Now(),
+		Chunks:     len(validChunks),
+		Status:     fileStatusIndexed,
+		SourceType: "url",
+		SourceURL:  req.URL,
+	}
+	s.filesMu.Lock()
+	s.files[fileID] = fileInfo
+	s.filesMu.Unlock()
+
+	logger.Info("✅ URL摄取成功: %s，共 %d 个文本块", req.URL, len(validChunks))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("成功摄取URL: %s，共 %d 个文本块", req.URL, len(validChunks)),
+		"chunks":  len(validChunks),
+		"fileId":  fileID,
+		"url":     req.URL,
+	})
+}
+
 // handleBatchUpload 处理批量文件上传
 func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -875,9 +2292,14 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 在解析前检查请求大小，超限时返回413及明确的提示
+	if !s.checkUploadSize(w, r) {
+		return
+	}
+
 	// 解析multipart form
 	// 优化：增加文件大小限制到500MB，支持更大的文件上传
-	err := r.ParseMultipartForm(500 << 20) // 500MB（从100MB增加到500MB）
+	err := r.ParseMultipartForm(maxUploadBytes) // 500MB（从100MB增加到500MB）
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to parse form: %v (文件可能过大，最大支持500MB)", err), http.StatusBadRequest)
 		return
@@ -911,29 +2333,31 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 	successCount := 0
 	failCount := 0
 
-	// 处理每个文件
-	for _, fileHeader := range files {
-		// 检查文件是否已存在（通过文件名和大小判断）
-		if s.isFileDuplicate(fileHeader.Filename, fileHeader.Size) {
-			results = append(results, FileResult{
-				Filename: fileHeader.Filename,
-				Success:  false,
-				Message:  "文件已存在，请勿重复上传",
-			})
-			failCount++
-			continue
-		}
+	// 并发解析和切分文件（LOAD_CONCURRENCY）：PDF解析是CPU密集型，工作协程数封顶GOMAXPROCS
+	workers := s.config.LoadConcurrency
+	if workers <= 0 || workers > runtime.GOMAXPROCS(0) {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	type fileOutcome struct {
+		result   FileResult
+		chunks   []schema.Document
+		fileInfo *FileInfo
+	}
 
+	// processFile 解析、保存、切分单个文件（只做CPU和磁盘工作，不碰共享的文件列表）
+	processFile := func(fileHeader *multipart.FileHeader) fileOutcome {
 		file, err := fileHeader.Open()
 		if err != nil {
 			logger.Error("Failed to open file %s: %v", fileHeader.Filename, err)
-			results = append(results, FileResult{
+			return fileOutcome{result: FileResult{
 				Filename: fileHeader.Filename,
 				Success:  false,
 				Message:  fmt.Sprintf("打开文件失败: %v", err),
-			})
-			failCount++
-			continue
+			}}
 		}
 
 		// 生成文件ID和保存路径（保留原文件名）
@@ -950,13 +2374,11 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			file.Close()
 			logger.Error("Failed to create file for %s: %v", fileHeader.Filename, err)
-			results = append(results, FileResult{
+			return fileOutcome{result: FileResult{
 				Filename: fileHeader.Filename,
 				Success:  false,
 				Message:  fmt.Sprintf("创建文件失败: %v", err),
-			})
-			failCount++
-			continue
+			}}
 		}
 
 		fileSize, err := io.Copy(savedFile, file)
@@ -966,22 +2388,24 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			// 保存失败文件到失败目录
 			failureReason := fmt.Sprintf("保存文件失败: %v", err)
+			s.setFileStatus(fileID, fileStatusFailed)
 			if saveErr := s.saveFailedFile(savedPath, fileHeader.Filename, failureReason); saveErr != nil {
 				logger.Error("保存失败文件时出错: %v", saveErr)
 				os.Remove(savedPath) // 如果保存失败，删除原文件
 			}
 			logger.Error("Failed to save file %s: %v", fileHeader.Filename, err)
-			results = append(results, FileResult{
+			return fileOutcome{result: FileResult{
 				Filename: fileHeader.Filename,
 				Success:  false,
 				Message:  failureReason,
-			})
-			failCount++
-			continue
+			}}
 		}
 
-		// 加载文档
-		docs, err := fileLoader.Load(savedPath)
+		// 标记为pending：整批向量化完成前文件在列表中可见，但还查询不到内容
+		s.setFileStatus(fileID, fileStatusPending)
+
+		// 加载文档（带上上传方声明的Content-Type：扩展名缺失或错误时按内容推断实际类型）
+		docs, err := fileLoader.LoadWithContentType(savedPath, fileHeader.Header.Get("Content-Type"))
 		if err != nil {
 			logger.Error("Failed to load document %s: %v", fileHeader.Filename, err)
 			// 提取更友好的错误信息
@@ -1003,18 +2427,32 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 
 			// 保存失败文件到失败目录
 			failureReason := fmt.Sprintf("加载文档失败: %s", userFriendlyMsg)
+			s.setFileStatus(fileID, fileStatusFailed)
 			if saveErr := s.saveFailedFile(savedPath, fileHeader.Filename, failureReason); saveErr != nil {
 				logger.Error("保存失败文件时出错: %v", saveErr)
 				os.Remove(savedPath) // 如果保存失败，删除原文件
 			}
 
-			results = append(results, FileResult{
+			return fileOutcome{result: FileResult{
 				Filename: fileHeader.Filename,
 				Success:  false,
 				Message:  failureReason,
-			})
-			failCount++
-			continue
+			}}
+		}
+
+		// 最小文档长度（MIN_DOC_CHARS）：过短的文档标记失败并跳过
+		if total, tooShort := loader.DocBelowMinChars(docs); tooShort {
+			failureReason := fmt.Sprintf("文档内容过短: 仅 %d 字符（最低要求 %d），已跳过", total, loader.MinDocChars())
+			s.setFileStatus(fileID, fileStatusFailed)
+			if saveErr := s.saveFailedFile(savedPath, fileHeader.Filename, failureReason); saveErr != nil {
+				logger.Error("保存失败文件时出错: %v", saveErr)
+				os.Remove(savedPath)
+			}
+			return fileOutcome{result: FileResult{
+				Filename: fileHeader.Filename,
+				Success:  false,
+				Message:  failureReason,
+			}}
 		}
 
 		// 提取文件内容预览（前1000字符）
@@ -1036,18 +2474,17 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			// 保存失败文件到失败目录
 			failureReason := fmt.Sprintf("切分文档失败: %v", err)
+			s.setFileStatus(fileID, fileStatusFailed)
 			if saveErr := s.saveFailedFile(savedPath, fileHeader.Filename, failureReason); saveErr != nil {
 				logger.Error("保存失败文件时出错: %v", saveErr)
 				os.Remove(savedPath) // 如果保存失败，删除原文件
 			}
 			logger.Error("Failed to split document %s: %v", fileHeader.Filename, err)
-			results = append(results, FileResult{
+			return fileOutcome{result: FileResult{
 				Filename: fileHeader.Filename,
 				Success:  false,
 				Message:  failureReason,
-			})
-			failCount++
-			continue
+			}}
 		}
 
 		// 过滤掉内容为空的chunks（只保留有实际文本内容的chunks）
@@ -1063,17 +2500,16 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 		if len(validChunks) == 0 {
 			failureReason := "文件加载成功但未提取到任何有效文本内容。可能是扫描版PDF（纯图片）或文件内容为空，请使用OCR工具提取文本后再上传"
 			logger.Warn("文件 %s 切分后没有有效内容，原始chunks数量: %d", fileHeader.Filename, len(chunks))
+			s.setFileStatus(fileID, fileStatusFailed)
 			if saveErr := s.saveFailedFile(savedPath, fileHeader.Filename, failureReason); saveErr != nil {
 				logger.Error("保存失败文件时出错: %v", saveErr)
 				os.Remove(savedPath)
 			}
-			results = append(results, FileResult{
+			return fileOutcome{result: FileResult{
 				Filename: fileHeader.Filename,
 				Success:  false,
 				Message:  failureReason,
-			})
-			failCount++
-			continue
+			}}
 		}
 
 		// 如果过滤后chunks数量减少，记录警告
@@ -1081,28 +2517,91 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 			logger.Warn("文件 %s 过滤掉 %d 个空chunks，保留 %d 个有效chunks", fileHeader.Filename, len(chunks)-len(validChunks), len(validChunks))
 		}
 
-		allChunks = append(allChunks, validChunks...)
-		logger.Info("文件 %s 处理成功，生成 %d 个有效文本块（原始 %d 个），累计 %d 个文本块", fileHeader.Filename, len(validChunks), len(chunks), len(allChunks))
+		// 为每个chunk添加file_id和chunk_index元数据（用于邻近块扩展）
+		validChunks = s.maybeAppendSummaryChunk(validChunks, docs, title)
+		annotateChunks(validChunks, fileID)
+
+		logger.Info("文件 %s 处理成功，生成 %d 个有效文本块（原始 %d 个）", fileHeader.Filename, len(validChunks), len(chunks))
 
 		// 保存文件信息
 		fileInfo := &FileInfo{
 			ID:         fileID,
 			Filename:   fileHeader.Filename,
+			Status:     fileStatusPending,
 			Title:      title,
 			Content:    contentPreview,
 			Size:       fileSize,
 			UploadedAt: time.Now(),
 			Chunks:     len(validChunks),
 		}
-		s.files[fileID] = fileInfo
+		fileInfo.Author, fileInfo.Date = docAuthorDate(docs)
 
-		results = append(results, FileResult{
-			Filename: fileHeader.Filename,
-			Success:  true,
-			Message:  fmt.Sprintf("成功处理，共 %d 个文本块", len(validChunks)),
-			Chunks:   len(validChunks),
-			FileID:   fileID,
-		})
+		return fileOutcome{
+			result: FileResult{
+				Filename: fileHeader.Filename,
+				Success:  true,
+				Message:  fmt.Sprintf("成功处理，共 %d 个文本块", len(validChunks)),
+				Chunks:   len(validChunks),
+				FileID:   fileID,
+			},
+			chunks:   validChunks,
+			fileInfo: fileInfo,
+		}
+	}
+
+	// 重复文件检查会重新加载共享的文件列表，必须在并发处理前串行完成；
+	// 同一批次内的重名文件也在这里挡掉
+	outcomes := make([]fileOutcome, len(files))
+	pending := make([]int, 0, len(files))
+	seen := make(map[string]bool, len(files))
+	for i, fileHeader := range files {
+		dupKey := fmt.Sprintf("%s|%d", fileHeader.Filename, fileHeader.Size)
+		if seen[dupKey] || s.isFileDuplicate(fileHeader.Filename, fileHeader.Size) {
+			outcomes[i] = fileOutcome{result: FileResult{
+				Filename: fileHeader.Filename,
+				Success:  false,
+				Message:  "文件已存在，请勿重复上传",
+			}}
+			continue
+		}
+		seen[dupKey] = true
+		pending = append(pending, i)
+	}
+
+	if len(pending) > 0 {
+		logger.Info("并发解析 %d 个文件（工作协程: %d）", len(pending), workers)
+		jobs := make(chan int, len(pending))
+		for _, idx := range pending {
+			jobs <- idx
+		}
+		close(jobs)
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for idx := range jobs {
+					outcomes[idx] = processFile(files[idx])
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// 串行收集结果（保持输入顺序）并注册文件信息
+	for _, outcome := range outcomes {
+		results = append(results, outcome.result)
+		if !outcome.result.Success {
+			failCount++
+			continue
+		}
+		allChunks = append(allChunks, outcome.chunks...)
+		s.filesMu.Lock()
+		s.files[outcome.fileInfo.ID] = outcome.fileInfo
+		s.filesMu.Unlock()
+		// 上传时计算下载限制状态（是否包含"公开形式"字样）
+		s.refreshDownloadBlock(outcome.fileInfo)
 		successCount++
 	}
 
@@ -1112,7 +2611,9 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 	if len(allChunks) > 0 {
 		ctx := context.Background()
 		logger.Info("开始向量化 %d 个文本块...", len(allChunks))
-		if err := s.ragSystem.AddDocuments(ctx, allChunks); err != nil {
+		if err := s.ragSystem.AddDocumentsWithProgress(ctx, allChunks, func(processed, total int) {
+			logger.Info("批量上传向量化进度: %d/%d", processed, total)
+		}); err != nil {
 			logger.Error("向量化失败: %v", err)
 			vectorizationError = err
 
@@ -1122,7 +2623,10 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 				result := &results[i]
 				if result.Success && result.FileID != "" {
 					// 查找对应的文件路径
-					if fileInfo, exists := s.files[result.FileID]; exists {
+					s.filesMu.RLock()
+					fileInfo, exists := s.files[result.FileID]
+					s.filesMu.RUnlock()
+					if exists {
 						// 构建文件路径
 						cleanedFilename := strings.ReplaceAll(fileInfo.Filename, "/", "_")
 						cleanedFilename = strings.ReplaceAll(cleanedFilename, "\\", "_")
@@ -1133,8 +2637,11 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 						if saveErr := s.saveFailedFile(filePath, fileInfo.Filename, failureReason); saveErr != nil {
 							logger.Error("保存失败文件时出错: %v", saveErr)
 						} else {
+							s.setFileStatus(result.FileID, fileStatusFailed)
 							// 从文件列表中删除
+							s.filesMu.Lock()
 							delete(s.files, result.FileID)
+							s.filesMu.Unlock()
 							// 更新结果状态
 							result.Success = false
 							result.Message = failureReason
@@ -1147,6 +2654,14 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 		} else {
 			logger.Info("向量化成功，共处理 %d 个文本块", len(allChunks))
 			vectorizedChunks = len(allChunks)
+			// 按类型镜像到分片集合（SHARD_BY_TYPE，异步执行，失败不影响主集合）
+			go s.mirrorToShards(allChunks)
+			// 整批向量化完成，把本批成功的文件从pending标记为indexed
+			for i := range results {
+				if results[i].Success && results[i].FileID != "" {
+					s.setFileStatus(results[i].FileID, fileStatusIndexed)
+				}
+			}
 		}
 	} else {
 		logger.Info("没有需要向量化的文本块")
@@ -1173,979 +2688,2340 @@ func (s *Server) handleBatchUpload(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleQuery 处理查询请求
-func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
-	// 添加panic恢复，确保即使发生panic也不会导致服务崩溃
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Error("⚠️ handleQuery发生panic: %v, 堆栈: %s", r, getStackTrace())
-			// 尝试返回错误响应
-			if w.Header().Get("Content-Type") == "" {
-				w.Header().Set("Content-Type", "application/json; charset=utf-8")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"error":   "服务器内部错误",
-					"message": "查询处理时发生意外错误",
-				})
-			}
-		}
-	}()
+// ZIP压缩包上传的防护限制（防止zip炸弹）
+const (
+	maxArchiveEntries      = 500     // 压缩包内最大条目数
+	maxArchiveUncompressed = 1 << 30 // 解压后总大小上限：1GB
+)
 
-	streamEnabled := r.URL.Query().Get("stream") == "1"
-	if streamEnabled {
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("X-Accel-Buffering", "no")
-	} else {
-		// 提前设置响应头，确保即使发生错误也能正确返回
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	}
+// archiveSupportedExts 压缩包内支持导入的文件类型
+var archiveSupportedExts = map[string]bool{
+	".txt":  true,
+	".pdf":  true,
+	".docx": true,
+	".doc":  true,
+	".html": true,
+	".htm":  true,
+}
 
+// handleUploadArchive 处理ZIP压缩包上传：解压后将每个支持的文件走现有的导入流程
+func (s *Server) handleUploadArchive(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "Method not allowed",
-		})
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 检查用户是否登录
-	if !s.checkUserAuth(r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   "Unauthorized",
-			"message": "请先登录才能使用AI搜索功能",
-		})
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	var req struct {
-		Question string `json:"question"`
-		TopK     int    `json:"topk"`
+	// 在解析前检查请求大小，超限时返回413及明确的提示
+	if !s.checkUploadSize(w, r) {
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Error("解析请求体失败: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   "Invalid request",
-			"message": "无法解析请求体",
-		})
+	// 解析multipart form（压缩包本身最大500MB，与批量上传一致）
+	err := r.ParseMultipartForm(maxUploadBytes)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse form: %v (文件可能过大，最大支持500MB)", err), http.StatusBadRequest)
 		return
 	}
 
-	if req.Question == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   "Question is required",
-			"message": "问题不能为空",
-		})
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "No file uploaded", http.StatusBadRequest)
 		return
 	}
+	defer file.Close()
 
-	if req.TopK == 0 {
-		req.TopK = 8
+	if strings.ToLower(filepath.Ext(header.Filename)) != ".zip" {
+		http.Error(w, "只支持ZIP格式的压缩包", http.StatusBadRequest)
+		return
 	}
 
-	sendSSE := func(event string, data interface{}) error {
-		payload, err := json.Marshal(data)
-		if err != nil {
-			return err
-		}
-		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
-			return err
-		}
-		if flusher, ok := w.(http.Flusher); ok {
-			flusher.Flush()
-		}
-		return nil
+	// 读取压缩包内容（zip.NewReader需要io.ReaderAt）
+	archiveData, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("读取压缩包失败: %v", err), http.StatusBadRequest)
+		return
 	}
 
-	// 创建临时RAG实例用于查询（使用指定的topK）
-	tempRAG := rag.NewRAG(s.embedder, s.store, s.llm, req.TopK)
-
-	logger.Info("收到查询请求: %s (topK=%d), 客户端: %s", req.Question, req.TopK, r.RemoteAddr)
-
-	// 优化：使用请求的context，并添加超时控制（50秒），确保请求可以取消
-	// 减少超时时间，避免LLM调用时间过长导致服务被停止
-	ctx, cancel := context.WithTimeout(r.Context(), 50*time.Second)
-	defer cancel()
-
-	// 使用 QueryWithResults 方法，避免重复搜索
-	// 添加panic恢复，确保LLM调用失败不会导致服务崩溃
-	var queryResult *rag.QueryResult
-	var err error
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.Error("⚠️ QueryWithResults发生panic: %v, 堆栈: %s", r, getStackTrace())
-				err = fmt.Errorf("查询处理时发生panic: %v", r)
-			}
-		}()
-		if streamEnabled {
-			_ = sendSSE("start", map[string]interface{}{"message": "开始生成回答"})
-			queryResult, err = tempRAG.QueryWithResultsStream(ctx, req.Question, func(chunk string) error {
-				return sendSSE("chunk", map[string]interface{}{"text": chunk})
-			})
-		} else {
-			queryResult, err = tempRAG.QueryWithResults(ctx, req.Question)
-		}
-	}()
+	zipReader, err := zip.NewReader(bytes.NewReader(archiveData), int64(len(archiveData)))
 	if err != nil {
-		logger.Error("查询失败 - 问题: %s, 错误: %v, 错误类型: %T, 客户端: %s", req.Question, err, err, r.RemoteAddr)
-		// 返回更详细的错误信息
-		if streamEnabled {
-			_ = sendSSE("error", map[string]interface{}{
-				"error":   "查询失败",
-				"message": err.Error(),
-			})
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			if encodeErr := json.NewEncoder(w).Encode(map[string]interface{}{
-				"error":   "查询失败",
-				"message": err.Error(),
-			}); encodeErr != nil {
-				logger.Error("编码错误响应失败: %v", encodeErr)
-			}
-		}
+		http.Error(w, fmt.Sprintf("解析压缩包失败: %v", err), http.StatusBadRequest)
 		return
 	}
-	logger.Info("查询成功，答案长度: %d 字符, 结果数量: %d", len(queryResult.Answer), len(queryResult.Results))
-
-	// debug模式：/api/query?debug=1 返回命中的文件信息，便于判断“检索问题”还是“模型问题”
-	debugEnabled := r.URL.Query().Get("debug") == "1"
-
-	// 分析答案中的标注，找出被使用的文档片段编号
-	usedIndices := extractUsedAnnotations(queryResult.Answer)
 
-	// 关键修复：当LLM返回“找不到相关信息”时，通常不会输出①②标注，导致usedIndices为空，
-	// 进而 docGroupsMap数量=0、results数量=0，前端看不到任何检索上下文。
-	// 为了让用户仍能看到“实际检索到了哪些片段/来自哪些文件”，这里在无标注时回退为：返回前N个片段。
-	if len(usedIndices) == 0 {
-		fallbackN := req.TopK
-		if fallbackN <= 0 {
-			fallbackN = 3
-		}
-		if fallbackN > len(queryResult.Results) {
-			fallbackN = len(queryResult.Results)
-		}
-		for i := 0; i < fallbackN; i++ {
-			usedIndices[i+1] = true // 标注索引从1开始
-		}
+	// 防护：限制条目数量
+	if len(zipReader.File) > maxArchiveEntries {
+		http.Error(w, fmt.Sprintf("压缩包条目过多（%d），最多支持 %d 个", len(zipReader.File), maxArchiveEntries), http.StatusBadRequest)
+		return
 	}
 
-	// 按文档来源分组，只返回被标注使用的文档片段
-	// 使用 map 来按文档来源分组
-	// DocGroup 类型已在包级别定义
+	// 防护：限制解压后的总大小（先按头部声明的大小快速检查）
+	var declaredTotal uint64
+	for _, entry := range zipReader.File {
+		declaredTotal += entry.UncompressedSize64
+	}
+	if declaredTotal > maxArchiveUncompressed {
+		http.Error(w, fmt.Sprintf("压缩包解压后过大（%d 字节），最大支持 %d 字节", declaredTotal, maxArchiveUncompressed), http.StatusBadRequest)
+		return
+	}
 
-	// 优化：使用sync.Map和并发处理文档分组，提升性能
-	type docProcessResult struct {
-		index    int
-		result   map[string]interface{}
-		groupKey string
-		group    *DocGroup
+	// 创建文件加载器（使用带OCR支持的文件加载器，纯Go实现）
+	var fileLoader *loader.FileLoader
+	if s.ocrProcessor != nil {
+		fileLoader = loader.NewFileLoaderWithOCR(s.ocrProcessor)
+	} else {
+		fileLoader = loader.NewFileLoader()
 	}
+	textSplitter := splitter.NewTextSplitter(s.config.ChunkSize, s.config.ChunkOverlap)
 
-	// 使用带缓冲的channel收集处理结果
-	// 限制缓冲区大小，避免大结果集导致内存问题（最多1000个结果）
-	const maxChannelBuffer = 1000
-	bufferSize := len(queryResult.Results)
-	if bufferSize > maxChannelBuffer {
-		bufferSize = maxChannelBuffer
+	type FileResult struct {
+		Filename string `json:"filename"`
+		Success  bool   `json:"success"`
+		Message  string `json:"message"`
+		Chunks   int    `json:"chunks"`
+		FileID   string `json:"fileId,omitempty"`
 	}
-	resultChan := make(chan docProcessResult, bufferSize)
 
-	// 并发处理所有文档片段
-	var wg sync.WaitGroup
-	for i, doc := range queryResult.Results {
-		// 检查这个文档片段是否在答案中被标注使用（索引从1开始，所以i+1）
-		if !usedIndices[i+1] {
+	var results []FileResult
+	var allChunks []schema.Document
+	successCount := 0
+	failCount := 0
+	skippedCount := 0
+	var extractedTotal int64
+
+	// 处理压缩包内的每个条目
+	for _, entry := range zipReader.File {
+		// 跳过目录
+		if entry.FileInfo().IsDir() {
 			continue
 		}
 
-		wg.Add(1)
-		go func(idx int, d schema.Document) {
-			defer wg.Done()
-			defer func() {
-				if r := recover(); r != nil {
-					logger.Error("⚠️ 处理文档片段时发生panic: %v, 索引: %d", r, idx)
-				}
-			}()
-
-			// 使用原始索引（idx+1），与AI答案中的标注保持一致
-			originalIndex := idx + 1
+		// 只取文件名部分，忽略压缩包内的目录结构
+		entryName := filepath.Base(entry.Name)
 
-			// 获取文档来源信息
-			var docTitle, docSource, sourceType, fileType, fileID string
-			if source, ok := d.Metadata["source"].(string); ok {
-				docSource = source
-				// 判断是文件还是URL
-				if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
-					sourceType = "url"
-					docTitle = source // URL直接使用完整URL作为标题
-				} else {
-					sourceType = "file"
-					// 从文件路径中提取原始文件名（去除UUID前缀）
-					docTitle = extractOriginalFilename(filepath.Base(source))
-					// 从文件路径中提取fileID（格式：{fileID}_{原文件名}）
-					baseName := filepath.Base(source)
-					if idx := strings.Index(baseName, "_"); idx > 0 {
-						fileID = baseName[:idx]
-					}
-					// 判断文件类型
-					ext := strings.ToLower(filepath.Ext(docTitle))
-					if ext != "" {
-						fileType = ext[1:] // 去掉点号
-					}
-				}
-			}
-			// 优先使用file_name元数据（如果存在且不包含UUID）
-			if fileName, ok := d.Metadata["file_name"].(string); ok && fileName != "" {
-				// 从file_name中提取原始文件名（去除UUID前缀）
-				originalFileName := extractOriginalFilename(fileName)
-				if originalFileName != "" {
-					docTitle = originalFileName
-				}
-				// 从file_name中提取fileID
-				if idx := strings.Index(fileName, "_"); idx > 0 {
-					fileID = fileName[:idx]
-				}
-				// 判断文件类型
-				ext := strings.ToLower(filepath.Ext(originalFileName))
-				if ext != "" {
-					fileType = ext[1:] // 去掉点号
-				}
-			}
-			if docTitle == "" {
-				docTitle = "未命名文档"
-			}
+		// 跳过系统文件（如.DS_Store、Office临时文件等）
+		if isSystemFile(entryName) {
+			skippedCount++
+			continue
+		}
 
-			// 生成预览（前200字符）
-			preview := d.PageContent
-			if len(preview) > 200 {
-				preview = preview[:200] + "..."
-			}
+		// 跳过不支持的文件类型
+		if !archiveSupportedExts[strings.ToLower(filepath.Ext(entryName))] {
+			skippedCount++
+			logger.Info("压缩包条目 %s 类型不支持，跳过", entry.Name)
+			continue
+		}
 
-			// 创建文档片段结果
-			result := map[string]interface{}{
-				"content":     d.PageContent,
-				"pageContent": d.PageContent,
-				"index":       originalIndex, // 使用原始索引，与AI答案中的标注保持一致
-				"source":      docSource,
-				"title":       docTitle,
-				"preview":     preview,
-			}
+		// 检查文件是否已存在（通过文件名和大小判断）
+		if s.isFileDuplicate(entryName, int64(entry.UncompressedSize64)) {
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  "文件已存在，请勿重复上传",
+			})
+			failCount++
+			continue
+		}
 
-			// 按文档来源分组
-			groupKey := docSource
-			if groupKey == "" {
-				groupKey = docTitle // 如果没有source，使用title作为分组key
-			}
-
-			// 创建文档组
-			group := &DocGroup{
-				DocTitle:   docTitle,
-				DocSource:  docSource,
-				SourceType: sourceType,
-				FileType:   fileType,
-				FileID:     fileID,
-				Chunks:     []map[string]interface{}{result},
-			}
-
-			resultChan <- docProcessResult{
-				index:    originalIndex,
-				result:   result,
-				groupKey: groupKey,
-				group:    group,
-			}
-		}(i, doc)
-	}
-
-	// 等待所有goroutine完成
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	// 收集结果并分组
-	docGroupsMap := make(map[string]*DocGroup)
-	var searchResults []map[string]interface{} // 保留平铺格式以兼容旧前端
-
-	// 使用sync.Map确保并发安全
-	var mu sync.Mutex
+		rc, err := entry.Open()
+		if err != nil {
+			logger.Error("Failed to open archive entry %s: %v", entry.Name, err)
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  fmt.Sprintf("打开压缩包条目失败: %v", err),
+			})
+			failCount++
+			continue
+		}
 
-	// 收集所有结果
-	for res := range resultChan {
-		mu.Lock()
-		// 添加到平铺格式（兼容旧前端）
-		searchResults = append(searchResults, res.result)
+		// 生成文件ID和保存路径（保留原文件名）
+		fileID := uuid.New().String()
+		// 清理文件名中的危险字符
+		cleanedFilename := strings.ReplaceAll(entryName, "/", "_")
+		cleanedFilename = strings.ReplaceAll(cleanedFilename, "\\", "_")
+		cleanedFilename = strings.ReplaceAll(cleanedFilename, "..", "_")
+		// 格式：{fileID}_{原文件名}
+		savedPath := filepath.Join(s.filesDir, fileID+"_"+cleanedFilename)
 
-		// 按文档来源分组
-		if existingGroup, exists := docGroupsMap[res.groupKey]; exists {
-			// 如果组已存在，更新文件类型和文件ID（如果当前文档片段有这些信息）
-			if res.group.FileType != "" && existingGroup.FileType == "" {
-				existingGroup.FileType = res.group.FileType
-			}
-			if res.group.FileID != "" && existingGroup.FileID == "" {
-				existingGroup.FileID = res.group.FileID
-			}
-			existingGroup.Chunks = append(existingGroup.Chunks, res.result)
-		} else {
-			// 创建新组
-			docGroupsMap[res.groupKey] = res.group
+		savedFile, err := os.Create(savedPath)
+		if err != nil {
+			rc.Close()
+			logger.Error("Failed to create file for %s: %v", entryName, err)
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  fmt.Sprintf("创建文件失败: %v", err),
+			})
+			failCount++
+			continue
 		}
-		mu.Unlock()
-	}
-
-	// 对searchResults按index排序，确保顺序正确
-	sort.Slice(searchResults, func(i, j int) bool {
-		idxI, _ := searchResults[i]["index"].(int)
-		idxJ, _ := searchResults[j]["index"].(int)
-		return idxI < idxJ
-	})
 
-	// 将 map 转换为 slice，并异步检查pdf、word、txt文档中是否包含"公开形式"字眼
-	// 完全异步：主请求立即返回，检查在后台进行
-	docGroups := make([]DocGroup, 0, len(docGroupsMap))
+		// 防护：解压时按剩余预算限制读取量，防止声明大小与实际不符的zip炸弹
+		remaining := int64(maxArchiveUncompressed) - extractedTotal
+		fileSize, err := io.Copy(savedFile, io.LimitReader(rc, remaining+1))
+		rc.Close()
+		savedFile.Close()
+		extractedTotal += fileSize
 
-	// 先将所有文档放入异步检查队列，等待一小段时间看是否能快速完成
-	checkTasks := make([]*checkTaskWithResult, 0)
-	for _, group := range docGroupsMap {
-		// 只对pdf、word、txt文档检查
-		fileTypeLower := strings.ToLower(group.FileType)
-		if (fileTypeLower == "pdf" || fileTypeLower == "doc" || fileTypeLower == "docx" || fileTypeLower == "txt") && group.FileID != "" {
-			// 创建结果channel，用于等待检查结果
-			resultChan := make(chan bool, 1)
+		if err == nil && fileSize > remaining {
+			err = fmt.Errorf("解压后总大小超过 %d 字节限制", maxArchiveUncompressed)
+		}
+		if err != nil {
+			os.Remove(savedPath)
+			logger.Error("Failed to extract archive entry %s: %v", entry.Name, err)
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  fmt.Sprintf("解压文件失败: %v", err),
+			})
+			failCount++
+			continue
+		}
 
-			// 创建检查任务，放入异步队列
-			checkTask := &checkTaskWithResult{
-				group:      group,
-				resultChan: resultChan,
-			}
+		// 标记为pending：整包向量化完成前文件在列表中可见，但还查询不到内容
+		s.setFileStatus(fileID, fileStatusPending)
 
-			// 尝试放入队列（非阻塞）
-			select {
-			case s.checkQueue <- checkTask:
-				logger.Info("📋 文档 %s 已加入异步检查队列", group.DocTitle)
-				checkTasks = append(checkTasks, checkTask)
-			default:
-				// 队列已满，记录警告，使用更安全的默认值（不允许下载）
-				logger.Info("⚠️ 检查队列已满，跳过异步检查: %s（使用安全默认值：不允许下载）", group.DocTitle)
-				group.HasPublicForm = true // 改为true，不允许下载（更安全）
+		// 加载文档
+		docs, err := fileLoader.Load(savedPath)
+		if err != nil {
+			failureReason := fmt.Sprintf("加载文档失败: %v", err)
+			s.setFileStatus(fileID, fileStatusFailed)
+			if saveErr := s.saveFailedFile(savedPath, entryName, failureReason); saveErr != nil {
+				logger.Error("保存失败文件时出错: %v", saveErr)
+				os.Remove(savedPath)
 			}
-		} else {
-			// 非pdf/word/txt文档，不需要检查，允许下载
-			group.HasPublicForm = false
+			logger.Error("Failed to load document %s: %v", entryName, err)
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  failureReason,
+			})
+			failCount++
+			continue
 		}
-	}
-
-	// 异步检查：快速检查已完成的检查结果（非阻塞，等待足够时间确保检查完成）
-	// 平衡：既要避免502错误，又要确保检查完成
-	if len(checkTasks) > 0 {
-		// 使用map跟踪已处理的task，避免重复处理
-		processedTasks := make(map[*DocGroup]bool)
 
-		// 先立即检查一次（可能检查已经完成）
-		completedCount := 0
-		for _, task := range checkTasks {
-			select {
-			case hasPublicForm := <-task.resultChan:
-				task.group.HasPublicForm = hasPublicForm
-				processedTasks[task.group] = true
-				completedCount++
-				if hasPublicForm {
-					logger.Info("✅ 文档 %s 检查完成，包含'公开形式'（不允许下载）", task.group.DocTitle)
-				} else {
-					logger.Info("✅ 文档 %s 检查完成，不包含'公开形式'（允许下载）", task.group.DocTitle)
-				}
-			default:
-				// 检查未完成，稍后处理
+		// 最小文档长度（MIN_DOC_CHARS）：过短的文档标记失败并跳过
+		if total, tooShort := loader.DocBelowMinChars(docs); tooShort {
+			failureReason := fmt.Sprintf("文档内容过短: 仅 %d 字符（最低要求 %d），已跳过", total, loader.MinDocChars())
+			s.setFileStatus(fileID, fileStatusFailed)
+			if saveErr := s.saveFailedFile(savedPath, entryName, failureReason); saveErr != nil {
+				logger.Error("保存失败文件时出错: %v", saveErr)
+				os.Remove(savedPath)
 			}
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  failureReason,
+			})
+			failCount++
+			continue
 		}
 
-		// 如果还有未完成的检查，等待足够的时间（500ms，确保检查能完成）
-		if completedCount < len(checkTasks) {
-			maxWaitTime := 500 * time.Millisecond // 增加到500ms，确保检查完成
-			if len(checkTasks) > 10 {
-				maxWaitTime = 300 * time.Millisecond // 文档多时300ms
+		// 提取文件内容预览（前1000字符）
+		contentPreview := ""
+		title := strings.TrimSuffix(entryName, filepath.Ext(entryName))
+		if len(docs) > 0 {
+			contentPreview = docs[0].PageContent
+			if len(contentPreview) > 1000 {
+				contentPreview = contentPreview[:1000] + "..."
 			}
+			if docTitle, ok := docs[0].Metadata["title"].(string); ok && docTitle != "" {
+				title = docTitle
+			}
+		}
 
-			logger.Info("等待 %d 个文档的检查结果（最多等待%v）...", len(checkTasks)-completedCount, maxWaitTime)
-
-			// 使用带超时的select，非阻塞等待
-			timeout := time.NewTimer(maxWaitTime)
-			defer timeout.Stop()
-
-			// 每50ms检查一次，直到超时或全部完成
-			ticker := time.NewTicker(50 * time.Millisecond)
-			defer ticker.Stop()
-
-		waitLoop:
-			for completedCount < len(checkTasks) {
-				select {
-				case <-timeout.C:
-					// 超时，停止等待
-					logger.Info("等待超时，已收集 %d/%d 个检查结果", completedCount, len(checkTasks))
-					break waitLoop
-				case <-ticker.C:
-					// 检查是否有新的完成
-					for _, task := range checkTasks {
-						if processedTasks[task.group] {
-							continue // 已处理
-						}
-						select {
-						case hasPublicForm := <-task.resultChan:
-							task.group.HasPublicForm = hasPublicForm
-							processedTasks[task.group] = true
-							completedCount++
-							if hasPublicForm {
-								logger.Info("✅ 文档 %s 检查完成，包含'公开形式'（不允许下载）", task.group.DocTitle)
-							} else {
-								logger.Info("✅ 文档 %s 检查完成，不包含'公开形式'（允许下载）", task.group.DocTitle)
-							}
-						default:
-						}
-					}
-				}
+		// 切分文档
+		chunks, err := textSplitter.SplitDocuments(docs)
+		if err != nil {
+			failureReason := fmt.Sprintf("切分文档失败: %v", err)
+			s.setFileStatus(fileID, fileStatusFailed)
+			if saveErr := s.saveFailedFile(savedPath, entryName, failureReason); saveErr != nil {
+				logger.Error("保存失败文件时出错: %v", saveErr)
+				os.Remove(savedPath)
 			}
+			logger.Error("Failed to split document %s: %v", entryName, err)
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  failureReason,
+			})
+			failCount++
+			continue
 		}
 
-		// 处理未完成的检查，使用更安全的默认值（不允许下载，更安全）
-		for _, task := range checkTasks {
-			if processedTasks[task.group] {
-				continue // 已处理
+		// 过滤掉内容为空的chunks（只保留有实际文本内容的chunks）
+		validChunks := make([]schema.Document, 0, len(chunks))
+		for _, chunk := range chunks {
+			content := strings.TrimSpace(chunk.PageContent)
+			if len(content) > 0 {
+				validChunks = append(validChunks, chunk)
 			}
+		}
 
-			// 尝试最后一次读取
-			select {
-			case hasPublicForm := <-task.resultChan:
-				task.group.HasPublicForm = hasPublicForm
-				processedTasks[task.group] = true
-				if hasPublicForm {
-					logger.Info("✅ 文档 %s 检查完成（最后读取），包含'公开形式'（不允许下载）", task.group.DocTitle)
-				} else {
-					logger.Info("✅ 文档 %s 检查完成（最后读取），不包含'公开形式'（允许下载）", task.group.DocTitle)
-				}
-			default:
-				// 检查未完成，使用更安全的默认值（不允许下载）
-				// 这样即使检查失败，也不会误允许下载包含"公开形式"的文档
-				task.group.HasPublicForm = true // 改为true，不允许下载（更安全）
-				logger.Info("⏳ 文档 %s 检查未完成，使用安全默认值：不允许下载（检查在后台继续）", task.group.DocTitle)
+		if len(validChunks) == 0 {
+			failureReason := "文件加载成功但未提取到任何有效文本内容"
+			logger.Warn("压缩包文件 %s 切分后没有有效内容，原始chunks数量: %d", entryName, len(chunks))
+			s.setFileStatus(fileID, fileStatusFailed)
+			if saveErr := s.saveFailedFile(savedPath, entryName, failureReason); saveErr != nil {
+				logger.Error("保存失败文件时出错: %v", saveErr)
+				os.Remove(savedPath)
 			}
+			results = append(results, FileResult{
+				Filename: entryName,
+				Success:  false,
+				Message:  failureReason,
+			})
+			failCount++
+			continue
 		}
 
-		logger.Info("检查结果收集完成，完成: %d/%d（异步检查，不阻塞主请求）", completedCount, len(checkTasks))
-	}
+		// 为每个chunk添加file_id和chunk_index元数据（用于邻近块扩展）
+		validChunks = s.maybeAppendSummaryChunk(validChunks, docs, title)
+		annotateChunks(validChunks, fileID)
 
-	logger.Info("所有文档检查处理完成，立即返回响应")
+		allChunks = append(allChunks, validChunks...)
+		logger.Info("压缩包文件 %s 处理成功，生成 %d 个有效文本块，累计 %d 个文本块", entryName, len(validChunks), len(allChunks))
 
-	// 按原始顺序添加到docGroups（完全异步，不等待检查结果）
-	logger.Info("开始构建响应数据，docGroupsMap数量: %d", len(docGroupsMap))
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Error("⚠️ 构建响应数据时发生panic: %v, 堆栈: %s", r, getStackTrace())
+		// 保存文件信息
+		fileInfo := &FileInfo{
+			ID:         fileID,
+			Filename:   entryName,
+			Status:     fileStatusPending,
+			Title:      title,
+			Content:    contentPreview,
+			Size:       fileSize,
+			UploadedAt: time.Now(),
+			Chunks:     len(validChunks),
 		}
-	}()
+		fileInfo.Author, fileInfo.Date = docAuthorDate(docs)
+		s.filesMu.Lock()
+		s.files[fileID] = fileInfo
+		s.filesMu.Unlock()
 
-	// 直接使用docGroupsMap构建响应（检查在后台异步进行）
-	for _, group := range docGroupsMap {
-		docGroups = append(docGroups, *group)
-	}
-	logger.Info("docGroups构建完成，共 %d 个文档组（检查在后台异步进行）", len(docGroups))
+		// 上传时计算下载限制状态（是否包含"公开形式"字样）
+		s.refreshDownloadBlock(fileInfo)
 
-	// 构建响应数据
-	// 限制响应大小，避免内存溢出和502错误
-	// 如果docGroups太大，只返回前50个
-	const maxDocGroups = 50
-	limitedDocGroups := docGroups
-	if len(docGroups) > maxDocGroups {
-		logger.Info("⚠️ 文档组数量过多 (%d > %d)，只返回前 %d 个", len(docGroups), maxDocGroups, maxDocGroups)
-		limitedDocGroups = docGroups[:maxDocGroups]
+		results = append(results, FileResult{
+			Filename: entryName,
+			Success:  true,
+			Message:  fmt.Sprintf("成功处理，共 %d 个文本块", len(validChunks)),
+			Chunks:   len(validChunks),
+			FileID:   fileID,
+		})
+		successCount++
 	}
 
-	// 限制每个文档组的chunks数量，避免响应过大
-	// 同时限制每个chunk的内容长度，避免单个chunk过大
-	const maxChunksPerGroup = 20
-	const maxChunkContentLength = 2000 // 每个chunk最多2000字符
+	// 添加到知识库（如果有成功的文件）
+	var vectorizationError error
+	var vectorizedChunks int
+	if len(allChunks) > 0 {
+		ctx := context.Background()
+		logger.Info("开始向量化 %d 个文本块...", len(allChunks))
+		if err := s.ragSystem.AddDocumentsWithProgress(ctx, allChunks, func(processed, total int) {
+			logger.Info("压缩包上传向量化进度: %d/%d", processed, total)
+		}); err != nil {
+			logger.Error("向量化失败: %v", err)
+			vectorizationError = err
 
-	totalChunksBefore := 0
-	for i := range limitedDocGroups {
-		totalChunksBefore += len(limitedDocGroups[i].Chunks)
+			// 向量化失败时，将所有成功处理的文件移动到失败目录
+			failureReason := fmt.Sprintf("向量化失败: %v", err)
+			for i := range results {
+				result := &results[i]
+				if result.Success && result.FileID != "" {
+					s.filesMu.RLock()
+					fileInfo, exists := s.files[result.FileID]
+					s.filesMu.RUnlock()
+					if exists {
+						cleanedFilename := strings.ReplaceAll(fileInfo.Filename, "/", "_")
+						cleanedFilename = strings.ReplaceAll(cleanedFilename, "\\", "_")
+						cleanedFilename = strings.ReplaceAll(cleanedFilename, "..", "_")
+						filePath := filepath.Join(s.filesDir, result.FileID+"_"+cleanedFilename)
 
-		// 限制chunks数量
-		if len(limitedDocGroups[i].Chunks) > maxChunksPerGroup {
-			logger.Info("⚠️ 文档 %s 的chunks数量过多 (%d > %d)，只返回前 %d 个", limitedDocGroups[i].DocTitle, len(limitedDocGroups[i].Chunks), maxChunksPerGroup, maxChunksPerGroup)
-			limitedDocGroups[i].Chunks = limitedDocGroups[i].Chunks[:maxChunksPerGroup]
+						if saveErr := s.saveFailedFile(filePath, fileInfo.Filename, failureReason); saveErr != nil {
+							logger.Error("保存失败文件时出错: %v", saveErr)
+						} else {
+							s.setFileStatus(result.FileID, fileStatusFailed)
+							s.filesMu.Lock()
+							delete(s.files, result.FileID)
+							s.filesMu.Unlock()
+							result.Success = false
+							result.Message = failureReason
+							successCount--
+							failCount++
+						}
+					}
+				}
+			}
+		} else {
+			logger.Info("向量化成功，共处理 %d 个文本块", len(allChunks))
+			vectorizedChunks = len(allChunks)
+			// 按类型镜像到分片集合（SHARD_BY_TYPE，异步执行，失败不影响主集合）
+			go s.mirrorToShards(allChunks)
+			// 整批向量化完成，把本批成功的文件从pending标记为indexed
+			for i := range results {
+				if results[i].Success && results[i].FileID != "" {
+					s.setFileStatus(results[i].FileID, fileStatusIndexed)
+				}
+			}
 		}
+	} else {
+		logger.Info("没有需要向量化的文本块")
+	}
 
-		// 限制每个chunk的内容长度
-		for j := range limitedDocGroups[i].Chunks {
-			chunk := limitedDocGroups[i].Chunks[j]
-			if content, ok := chunk["content"].(string); ok && len(content) > maxChunkContentLength {
-				chunk["content"] = content[:maxChunkContentLength] + "..."
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"success":          true,
+		"message":          fmt.Sprintf("压缩包处理完成：成功 %d 个，失败 %d 个，跳过 %d 个", successCount, failCount, skippedCount),
+		"archive":          header.Filename,
+		"totalEntries":     len(zipReader.File),
+		"successCount":     successCount,
+		"failCount":        failCount,
+		"skippedCount":     skippedCount,
+		"results":          results,
+		"totalChunks":      len(allChunks),
+		"vectorizedChunks": vectorizedChunks,
+	}
+
+	if vectorizationError != nil {
+		response["vectorizationError"] = vectorizationError.Error()
+		response["message"] = fmt.Sprintf("压缩包处理完成：成功 %d 个，失败 %d 个，跳过 %d 个。⚠️ 向量化失败: %v", successCount, failCount, skippedCount, vectorizationError)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxMultiQueryQuestions 单次批量查询的问题数量上限
+const maxMultiQueryQuestions = 10
+
+// handleQueryMulti 处理批量查询请求
+// POST /api/query/multi，body: {"questions": [...], "topK": 3}
+// 各问题并发执行（受LLM并发限制约束），单个问题失败不影响其他问题，按原顺序返回结果数组
+func (s *Server) handleQueryMulti(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Method not allowed",
+		})
+		return
+	}
+
+	// 检查用户是否登录
+	if !s.checkUserAuth(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Unauthorized",
+			"message": "请先登录才能使用AI搜索功能",
+		})
+		return
+	}
+
+	var req struct {
+		Questions []string `json:"questions"`
+		TopK      int      `json:"topK"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid request",
+			"message": "无法解析请求体",
+		})
+		return
+	}
+
+	// 过滤空白问题
+	questions := make([]string, 0, len(req.Questions))
+	for _, q := range req.Questions {
+		if strings.TrimSpace(q) != "" {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Questions are required",
+			"message": "questions不能为空",
+		})
+		return
+	}
+	if len(questions) > maxMultiQueryQuestions {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Too many questions",
+			"message": fmt.Sprintf("单次最多支持 %d 个问题", maxMultiQueryQuestions),
+		})
+		return
+	}
+	if req.TopK <= 0 {
+		req.TopK = 3
+	}
+
+	logger.Info("收到批量查询请求：%d 个问题 (topK=%d), 客户端: %s", len(questions), req.TopK, r.RemoteAddr)
+
+	// 整批的超时比单次查询放宽，给排队等待LLM槽位留出时间
+	ctx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+	defer cancel()
+
+	type multiResult struct {
+		Question string   `json:"question"`
+		Success  bool     `json:"success"`
+		Answer   string   `json:"answer,omitempty"`
+		Message  string   `json:"message,omitempty"`
+		Sources  []string `json:"sources,omitempty"`
+	}
+
+	results := make([]multiResult, len(questions))
+	var wg sync.WaitGroup
+	for i, question := range questions {
+		wg.Add(1)
+		go func(i int, question string) {
+			defer wg.Done()
+			defer func() {
+				if rec := recover(); rec != nil {
+					logger.Error("⚠️ 批量查询第 %d 个问题发生panic: %v, 堆栈: %s", i+1, rec, getStackTrace())
+					results[i] = multiResult{Question: question, Success: false, Message: fmt.Sprintf("查询处理时发生panic: %v", rec)}
+				}
+			}()
+
+			// LLM并发限制：各问题排队获取槽位，把突发平滑成上游允许的速率
+			release, acquired := s.acquireLLMSlot(ctx)
+			if !acquired {
+				results[i] = multiResult{Question: question, Success: false, Message: "当前查询请求过多，请稍后重试"}
+				return
 			}
-			if pageContent, ok := chunk["pageContent"].(string); ok && len(pageContent) > maxChunkContentLength {
-				chunk["pageContent"] = pageContent[:maxChunkContentLength] + "..."
+			defer release()
+
+			// 后端三元组在锁下取值：在线迁移cutover期间不能拿到新store+旧集合名的组合
+			queryEmbedder, mainStore, mainCollection := s.currentBackend()
+			tempRAG := rag.NewRAG(queryEmbedder, mainStore, s.llm, req.TopK)
+			tempRAG.SetStoreConnection(s.config.QdrantURL, s.config.QdrantAPIKey, mainCollection)
+			if s.config.NeighborWindow > 0 {
+				tempRAG.EnableNeighborExpansion(s.config.QdrantURL, s.config.QdrantAPIKey, mainCollection, s.config.NeighborWindow)
+			}
+			tempRAG.SetKeywordGramRange(s.config.KeywordMinGram, s.config.KeywordMaxGram)
+			tempRAG.SetFuzzyMatch(s.config.FuzzyMatch)
+			tempRAG.SetRelevanceFallbackCount(s.config.RelevanceFallbackCount)
+			tempRAG.SetPinnedBoost(s.config.PinnedBoost)
+			tempRAG.SetPromptOrder(s.config.PromptOrder)
+			tempRAG.SetQueryBudget(s.config.QueryBudgetMS)
+			tempRAG.SetAnswerLanguage(s.config.AnswerLanguage)
+			tempRAG.SetAnswerFrame(s.config.AnswerHeader, s.config.AnswerFooter)
+			tempRAG.SetAllowGeneralKnowledge(s.config.AllowGeneralKnowledge)
+			tempRAG.SetSearchFanOut(s.extraStores, s.config.FanOutTimeoutMS, s.config.FanOutConcurrency)
+			tempRAG.SetEnforceCitations(s.config.EnforceCitations)
+			tempRAG.SetNoAnswerMessage(s.config.NoAnswerMessage)
+			tempRAG.SetMaxChunksPerSource(s.config.MaxChunksPerSource)
+			tempRAG.SetAnswerCleanup(s.config.AnswerCleanup, s.config.AnswerStripPatterns)
+			tempRAG.SetMinDistinctSources(s.config.MinDistinctSources)
+			tempRAG.SetContextBudget(s.config.MaxContextChars, s.config.ContextStrategy)
+			tempRAG.SetGenerationMode(s.config.GenerationMode, s.config.RefineMaxIterations)
+			tempRAG.SetMaxAnswerChars(s.config.MaxAnswerChars)
+			tempRAG.SetQueryRewritePrompt(s.config.QueryRewritePrompt)
+
+			queryResult, err := tempRAG.QueryWithResults(ctx, question)
+			if err != nil {
+				logger.Error("批量查询第 %d 个问题失败: %s, 错误: %v", i+1, question, err)
+				results[i] = multiResult{Question: question, Success: false, Message: err.Error()}
+				return
+			}
+
+			// 从被引用的片段中提取来源文件名（去重）
+			usedIndices := extractUsedAnnotations(queryResult.Answer)
+			seenSources := make(map[string]bool)
+			sources := make([]string, 0)
+			for j, doc := range queryResult.Results {
+				if !usedIndices[j+1] {
+					continue
+				}
+				source, _ := doc.Metadata["source"].(string)
+				if source == "" {
+					continue
+				}
+				filename := extractOriginalFilename(filepath.Base(source))
+				if !seenSources[filename] {
+					seenSources[filename] = true
+					sources = append(sources, filename)
+				}
+			}
+
+			results[i] = multiResult{Question: question, Success: true, Answer: queryResult.Answer, Sources: sources}
+		}(i, question)
+	}
+	wg.Wait()
+
+	successCount := 0
+	for _, res := range results {
+		if res.Success {
+			successCount++
+		}
+	}
+	logger.Info("批量查询完成：成功 %d/%d", successCount, len(questions))
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"total":        len(questions),
+		"successCount": successCount,
+		"results":      results,
+	})
+}
+
+// acquireLLMSlot 获取一个LLM调用槽位，用于限制全局并发的Generate调用，保护上游RPM配额
+// 槽位满时在等待队列中排队（受ctx超时控制）；等待队列也满或等待超时时返回false，调用方应返回503
+// 返回的release函数用于释放槽位，ok为true时必须调用
+func (s *Server) acquireLLMSlot(ctx context.Context) (release func(), ok bool) {
+	if s.llmSlots == nil {
+		return func() {}, true
+	}
+
+	// 先进入等待队列，队列满说明积压已经超出处理能力，直接拒绝
+	select {
+	case s.llmWaiters <- struct{}{}:
+	default:
+		return nil, false
+	}
+	defer func() { <-s.llmWaiters }()
+
+	// 在队列中等待执行槽位，等待时间受请求超时控制
+	select {
+	case s.llmSlots <- struct{}{}:
+		return func() { <-s.llmSlots }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// maxAnswerCandidates 单次查询允许的候选答案数量上限（每个候选都是一次完整的LLM调用）
+const maxAnswerCandidates = 3
+
+// candidateConcurrency 额外候选答案生成的并发上限
+const candidateConcurrency = 2
+
+// generateCandidates 为同一问题生成总计n个候选答案（首个为已生成的primary）
+// 额外的生成有界并发执行，多样性来自LLM采样的随机性；检索结果缓存启用时额外代价只有LLM调用
+// 单个候选失败只记录警告，不影响其余候选
+func (s *Server) generateCandidates(ctx context.Context, tempRAG *rag.RAG, question string, n int, primary string) []string {
+	if n > maxAnswerCandidates {
+		n = maxAnswerCandidates
+	}
+
+	extras := make([]string, n-1)
+	sem := make(chan struct{}, candidateConcurrency)
+	var wg sync.WaitGroup
+	for i := range extras {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			result, err := tempRAG.QueryWithResults(ctx, question)
+			if err != nil {
+				logger.Warn("⚠️ 生成候选答案 #%d 失败: %v", idx+2, err)
+				return
+			}
+			extras[idx] = result.Answer
+		}(i)
+	}
+	wg.Wait()
+
+	answers := make([]string, 0, n)
+	answers = append(answers, primary)
+	for _, answer := range extras {
+		if answer != "" {
+			answers = append(answers, answer)
+		}
+	}
+	return answers
+}
+
+// handleQuery 处理查询请求
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	// 添加panic恢复，确保即使发生panic也不会导致服务崩溃
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("⚠️ handleQuery发生panic: %v, 堆栈: %s", r, getStackTrace())
+			// 尝试返回错误响应
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"error":   "服务器内部错误",
+					"message": "查询处理时发生意外错误",
+				})
 			}
 		}
-	}
-	totalChunksAfter := 0
-	for _, g := range limitedDocGroups {
-		totalChunksAfter += len(g.Chunks)
-	}
-	logger.Info("响应数据限制完成，文档组数: %d, 总chunks数: %d -> %d", len(limitedDocGroups), totalChunksBefore, totalChunksAfter)
+	}()
+
+	streamEnabled := r.URL.Query().Get("stream") == "1"
+	if streamEnabled {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+	} else {
+		// 提前设置响应头，确保即使发生错误也能正确返回
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Method not allowed",
+		})
+		return
+	}
+
+	// 检查用户是否登录
+	if !s.checkUserAuth(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Unauthorized",
+			"message": "请先登录才能使用AI搜索功能",
+		})
+		return
+	}
+
+	var req struct {
+		Question string `json:"question"`
+		TopK     int    `json:"topk"`
+		// 可选的LLM覆盖（仅管理员可用，用于在同一问题上对比不同模型的回答质量）
+		LLMProvider string `json:"llmProvider"`
+		LLMModel    string `json:"llmModel"`
+		// minimal模式：只返回答案和来源标题，跳过docGroups/results组装（移动端/低带宽客户端）
+		Minimal bool `json:"minimal"`
+		// exact模式：只保留字面包含查询短语的chunk（法规、合同等需要精确措辞的查询）
+		Exact bool `json:"exact"`
+		// 关键词重排序开关（省略或true保持现有行为）：false时走纯语义检索，
+		// 保留向量相似度原始顺序和分数；适合概念性问题（关键词重合度低、激进过滤会误伤）
+		Rerank *bool `json:"rerank"`
+		// 文件类型提示（SHARD_BY_TYPE开启时生效）：只在对应类型的分片集合中检索，
+		// 省略或分片不存在时照常搜索主集合（即全部内容）
+		FileType string `json:"fileType"`
+		// 多轮对话的历史问题（按时间顺序），最近几轮的关键词会承接进检索查询
+		History []string `json:"history"`
+		// 结构化输出："json"时要求LLM返回JSON并在服务端校验；jsonSchema为期望的结构说明（可选）
+		ResponseFormat string `json:"responseFormat"`
+		JSONSchema     string `json:"jsonSchema"`
+		// 返回每个检索结果进入提示词的实际文本（经过单片段长度截断，即LLM真正看到的内容）
+		// 与docGroups的chunk全文不同，用于RAG评估和"信息在chunk里但答案没覆盖"的诊断
+		IncludeContext bool `json:"includeContext"`
+		// 为每个被引用的chunk标出最能支撑答案的原文句子（supportingSentences），
+		// 供前端高亮精确证据；纯词法计算但有额外开销，按需开启
+		IncludeEvidence bool `json:"includeEvidence"`
+		// 候选答案数量（1或0表示单答案）：对同一问题生成多个答案供用户挑选，上限3
+		// 每个候选都是一次完整的LLM调用，仅非流式请求生效
+		Candidates int `json:"candidates"`
+		// 引用标注的展示风格：inline（默认，保持①②③）、footnote（[1][2]脚注+参考来源列表）、none（去掉标注）
+		CitationStyle string `json:"citationStyle"`
+		// 回答语言（覆盖ANSWER_LANGUAGE）：跨语言提问时指定目标语言，如"en"
+		AnswerLanguage string `json:"answerLanguage"`
+		// 入库时间范围过滤："2006-01-02"或RFC3339，检索只命中范围内入库的文档
+		// （如"根据本月新增的文档回答"）；省略表示不限制
+		After  string `json:"after"`
+		Before string `json:"before"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Error("解析请求体失败: %v", err)
+		if isBodyTooLarge(err) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Request body too large",
+				"message": fmt.Sprintf("请求体超过大小限制（%dKB）", s.config.MaxJSONBodyKB),
+			})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid request",
+			"message": "无法解析请求体",
+		})
+		return
+	}
+
+	if req.Question == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Question is required",
+			"message": "问题不能为空",
+		})
+		return
+	}
+
+	if req.TopK == 0 {
+		req.TopK = 8
+	}
+
+	sendSSE := func(event string, data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	// 处理LLM覆盖：管理员可以为单次请求指定provider/model，用于A/B对比回答质量
+	llmClient := s.llm
+	if req.LLMProvider != "" || req.LLMModel != "" {
+		if !s.checkAdminAuth(r) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Forbidden",
+				"message": "只有管理员可以指定LLM provider/model",
+			})
+			return
+		}
+		overrideClient, err := s.buildLLMClient(req.LLMProvider, req.LLMModel)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "Invalid LLM override",
+				"message": err.Error(),
+			})
+			return
+		}
+		llmClient = overrideClient
+		logger.Info("本次查询使用覆盖的LLM: provider=%s, model=%s", req.LLMProvider, req.LLMModel)
+	}
+
+	// 创建临时RAG实例用于查询（使用指定的topK）
+	// 后端三元组在锁下取值：在线迁移cutover期间不能拿到新store+旧embedder的组合
+	queryEmbedder, _, _ := s.currentBackend()
+	// 按文件类型分片（SHARD_BY_TYPE）：带fileType提示时只搜对应分片集合
+	searchStore, searchCollection := s.searchBackendFor(req.FileType)
+	tempRAG := rag.NewRAG(queryEmbedder, searchStore, llmClient, req.TopK)
+	tempRAG.SetStoreConnection(s.config.QdrantURL, s.config.QdrantAPIKey, searchCollection)
+	if s.config.NeighborWindow > 0 {
+		tempRAG.EnableNeighborExpansion(s.config.QdrantURL, s.config.QdrantAPIKey, searchCollection, s.config.NeighborWindow)
+	}
+	tempRAG.SetKeywordGramRange(s.config.KeywordMinGram, s.config.KeywordMaxGram)
+	tempRAG.SetFuzzyMatch(s.config.FuzzyMatch)
+	tempRAG.SetRelevanceFallbackCount(s.config.RelevanceFallbackCount)
+	tempRAG.SetPinnedBoost(s.config.PinnedBoost)
+	tempRAG.SetPromptOrder(s.config.PromptOrder)
+	tempRAG.SetQueryBudget(s.config.QueryBudgetMS)
+	answerLanguage := s.config.AnswerLanguage
+	if req.AnswerLanguage != "" {
+		answerLanguage = req.AnswerLanguage
+	}
+	tempRAG.SetAnswerLanguage(answerLanguage)
+	tempRAG.SetAnswerFrame(s.config.AnswerHeader, s.config.AnswerFooter)
+	tempRAG.SetAllowGeneralKnowledge(s.config.AllowGeneralKnowledge)
+	// 入库时间范围过滤（after/before）：格式非法时返回400，避免静默检索全量数据
+	afterUnix, afterErr := parseIngestedBound(req.After, false)
+	beforeUnix, beforeErr := parseIngestedBound(req.Before, true)
+	if afterErr != nil || beforeErr != nil {
+		parseErr := afterErr
+		if parseErr == nil {
+			parseErr = beforeErr
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("无效的时间范围参数: %v", parseErr),
+		})
+		return
+	}
+	if afterUnix > 0 || beforeUnix > 0 {
+		tempRAG.SetIngestedRange(afterUnix, beforeUnix)
+	}
+	tempRAG.SetSearchFanOut(s.extraStores, s.config.FanOutTimeoutMS, s.config.FanOutConcurrency)
+	tempRAG.SetEnforceCitations(s.config.EnforceCitations)
+	tempRAG.SetNoAnswerMessage(s.config.NoAnswerMessage)
+	tempRAG.SetMaxChunksPerSource(s.config.MaxChunksPerSource)
+	tempRAG.SetAnswerCleanup(s.config.AnswerCleanup, s.config.AnswerStripPatterns)
+	tempRAG.SetMinDistinctSources(s.config.MinDistinctSources)
+	tempRAG.SetContextBudget(s.config.MaxContextChars, s.config.ContextStrategy)
+	tempRAG.SetGenerationMode(s.config.GenerationMode, s.config.RefineMaxIterations)
+	tempRAG.SetExactPhrase(req.Exact)
+	rerankEnabled := req.Rerank == nil || *req.Rerank
+	tempRAG.SetRerank(rerankEnabled)
+	tempRAG.SetConversationHistory(req.History, s.config.HistoryCarryTurns)
+	tempRAG.SetMaxAnswerChars(s.config.MaxAnswerChars)
+	if err := tempRAG.SetResponseFormat(req.ResponseFormat, req.JSONSchema); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid responseFormat",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 引用标注展示风格：请求未指定时使用配置默认值
+	citationStyle := req.CitationStyle
+	if citationStyle == "" {
+		citationStyle = s.config.CitationStyle
+	}
+	if !validCitationStyle(citationStyle) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid citationStyle",
+			"message": fmt.Sprintf("不支持的引用风格: %s（可选: inline/footnote/none）", citationStyle),
+		})
+		return
+	}
+
+	logger.Info("收到查询请求: %s (topK=%d, exact=%v, 历史轮次=%d), 客户端: %s", req.Question, req.TopK, req.Exact, len(req.History), r.RemoteAddr)
+
+	// 优化：使用请求的context，并添加超时控制（50秒），确保请求可以取消
+	// 减少超时时间，避免LLM调用时间过长导致服务被停止
+	ctx, cancel := context.WithTimeout(r.Context(), 50*time.Second)
+	defer cancel()
+
+	// LLM并发限制：超出并发上限时排队等待，队列满或等待超时返回503
+	release, acquired := s.acquireLLMSlot(ctx)
+	if !acquired {
+		logger.Warn("LLM并发已达上限，拒绝查询请求: %s, 客户端: %s", req.Question, r.RemoteAddr)
+		w.Header().Set("Retry-After", "5")
+		if streamEnabled {
+			_ = sendSSE("error", map[string]interface{}{
+				"error":   "服务繁忙",
+				"message": "当前查询请求过多，请稍后重试",
+			})
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "服务繁忙",
+				"message": "当前查询请求过多，请稍后重试",
+			})
+		}
+		return
+	}
+	defer release()
+
+	// 使用 QueryWithResults 方法，避免重复搜索
+	// 添加panic恢复，确保LLM调用失败不会导致服务崩溃
+	var queryResult *rag.QueryResult
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("⚠️ QueryWithResults发生panic: %v, 堆栈: %s", r, getStackTrace())
+				err = fmt.Errorf("查询处理时发生panic: %v", r)
+			}
+		}()
+		if streamEnabled {
+			_ = sendSSE("start", map[string]interface{}{"message": "开始生成回答"})
+			queryResult, err = tempRAG.QueryWithResultsStream(ctx, req.Question, func(chunk string) error {
+				return sendSSE("chunk", map[string]interface{}{"text": chunk})
+			})
+		} else {
+			queryResult, err = tempRAG.QueryWithResults(ctx, req.Question)
+		}
+	}()
+	if err != nil {
+		logger.Error("查询失败 - 问题: %s, 错误: %v, 错误类型: %T, 客户端: %s", req.Question, err, err, r.RemoteAddr)
+		// 返回更详细的错误信息
+		if streamEnabled {
+			_ = sendSSE("error", map[string]interface{}{
+				"error":   "查询失败",
+				"message": err.Error(),
+			})
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			if encodeErr := json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "查询失败",
+				"message": err.Error(),
+			}); encodeErr != nil {
+				logger.Error("编码错误响应失败: %v", encodeErr)
+			}
+		}
+		return
+	}
+	logger.Info("查询成功，答案长度: %d 字符, 结果数量: %d", len(queryResult.Answer), len(queryResult.Results))
+
+	// 多候选答案：对同一问题额外再生成N-1个答案供用户挑选（流式请求忽略该参数）
+	var candidateAnswers []string
+	if req.Candidates > 1 && !streamEnabled {
+		candidateAnswers = s.generateCandidates(ctx, tempRAG, req.Question, req.Candidates, queryResult.Answer)
+	}
+	llmCalls := 1 + len(candidateAnswers)
+	if len(candidateAnswers) > 0 {
+		llmCalls = len(candidateAnswers) // 候选列表已含primary
+	}
+
+	// 引用标注展示风格变换（footnote/none时改写答案，inline保持原样）
+	// 只变换响应中的answer，内部的标注分析仍基于原始答案（queryResult.Answer）
+	displayAnswer := applyCitationStyle(queryResult.Answer, citationStyle, queryResult.Results)
+	for i := range candidateAnswers {
+		candidateAnswers[i] = applyCitationStyle(candidateAnswers[i], citationStyle, queryResult.Results)
+	}
+
+	// minimal模式：只返回答案和去重后的来源标题，跳过重量级的分组和内容组装
+	// 响应体从几十KB降到几百字节，适合只展示答案的客户端（流式响应不走此分支）
+	if req.Minimal && !streamEnabled {
+		sourceTitles := make([]string, 0, len(queryResult.Results))
+		seen := make(map[string]bool)
+		for _, d := range queryResult.Results {
+			var title string
+			if fileName, ok := d.Metadata["file_name"].(string); ok && fileName != "" {
+				title = extractOriginalFilename(fileName)
+			} else if source, ok := d.Metadata["source"].(string); ok && source != "" {
+				if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+					title = source
+				} else {
+					title = extractOriginalFilename(filepath.Base(source))
+				}
+			}
+			if title == "" || seen[title] {
+				continue
+			}
+			seen[title] = true
+			sourceTitles = append(sourceTitles, title)
+		}
+		minimalResponse := map[string]interface{}{
+			"answer":            displayAnswer,
+			"noAnswer":          len(queryResult.Results) == 0,
+			"truncated":         queryResult.Truncated,
+			"degraded":          queryResult.Degraded,
+			"fromKnowledgeBase": queryResult.FromKnowledgeBase, // false表示通用知识兜底答案，没有知识库出处
+			"sources":           sourceTitles,
+		}
+		if req.IncludeContext {
+			minimalResponse["context"] = rag.PromptContextFor(queryResult.Results)
+		}
+		if len(candidateAnswers) > 1 {
+			minimalResponse["candidates"] = candidateAnswers
+		}
+		if err := json.NewEncoder(w).Encode(minimalResponse); err != nil {
+			logger.Error("编码minimal响应失败: %v", err)
+		}
+		return
+	}
+
+	// debug模式：/api/query?debug=1 返回命中的文件信息，便于判断“检索问题”还是“模型问题”
+	debugEnabled := r.URL.Query().Get("debug") == "1"
+
+	// 分析答案中的标注，找出被使用的文档片段编号
+	usedIndices := extractUsedAnnotations(queryResult.Answer)
+
+	// 关键修复：当LLM返回“找不到相关信息”时，通常不会输出①②标注，导致usedIndices为空，
+	// 进而 docGroupsMap数量=0、results数量=0，前端看不到任何检索上下文。
+	// 为了让用户仍能看到“实际检索到了哪些片段/来自哪些文件”，这里在无标注时回退为：返回前N个片段。
+	if len(usedIndices) == 0 {
+		fallbackN := req.TopK
+		if fallbackN <= 0 {
+			fallbackN = 3
+		}
+		if fallbackN > len(queryResult.Results) {
+			fallbackN = len(queryResult.Results)
+		}
+		for i := 0; i < fallbackN; i++ {
+			usedIndices[i+1] = true // 标注索引从1开始
+		}
+	}
+
+	// 按文档来源分组，只返回被标注使用的文档片段
+	// 小结果集顺序组装，被使用的chunk数量超过阈值（DOC_GROUP_PARALLEL_THRESHOLD）才并发
+	searchResults, docGroupsMap := s.buildDocGroups(queryResult.Results, usedIndices)
+
+	// 将 map 转换为 slice，并异步检查pdf、word、txt文档中是否包含"公开形式"字眼
+	// 完全异步：主请求立即返回，检查在后台进行
+	docGroups := make([]DocGroup, 0, len(docGroupsMap))
+
+	// 先将所有文档放入异步检查队列，等待一小段时间看是否能快速完成
+	checkTasks := make([]*checkTaskWithResult, 0)
+	for _, group := range docGroupsMap {
+		// 只对pdf、word、txt文档检查
+		fileTypeLower := strings.ToLower(group.FileType)
+		if (fileTypeLower == "pdf" || fileTypeLower == "doc" || fileTypeLower == "docx" || fileTypeLower == "txt") && group.FileID != "" {
+			// 创建结果channel，用于等待检查结果
+			resultChan := make(chan bool, 1)
+
+			// 创建检查任务，放入异步队列
+			checkTask := &checkTaskWithResult{
+				group:      group,
+				resultChan: resultChan,
+			}
+
+			// 尝试放入队列（非阻塞）
+			select {
+			case s.checkQueue <- checkTask:
+				logger.Info("📋 文档 %s 已加入异步检查队列", group.DocTitle)
+				checkTasks = append(checkTasks, checkTask)
+			default:
+				// 队列已满，记录警告，使用更安全的默认值（不允许下载）
+				logger.Info("⚠️ 检查队列已满，跳过异步检查: %s（使用安全默认值：不允许下载）", group.DocTitle)
+				group.HasPublicForm = true // 改为true，不允许下载（更安全）
+			}
+		} else {
+			// 非pdf/word/txt文档，不需要检查，允许下载
+			group.HasPublicForm = false
+		}
+	}
+
+	// 异步检查：快速检查已完成的检查结果（非阻塞，等待足够时间确保检查完成）
+	// 平衡：既要避免502错误，又要确保检查完成
+	if len(checkTasks) > 0 {
+		// 使用map跟踪已处理的task，避免重复处理
+		processedTasks := make(map[*DocGroup]bool)
+
+		// 先立即检查一次（可能检查已经完成）
+		completedCount := 0
+		for _, task := range checkTasks {
+			select {
+			case hasPublicForm := <-task.resultChan:
+				task.group.HasPublicForm = hasPublicForm
+				processedTasks[task.group] = true
+				completedCount++
+				if hasPublicForm {
+					logger.Info("✅ 文档 %s 检查完成，包含'公开形式'（不允许下载）", task.group.DocTitle)
+				} else {
+					logger.Info("✅ 文档 %s 检查完成，不包含'公开形式'（允许下载）", task.group.DocTitle)
+				}
+			default:
+				// 检查未完成，稍后处理
+			}
+		}
+
+		// 如果还有未完成的检查，等待足够的时间（500ms，确保检查能完成）
+		if completedCount < len(checkTasks) {
+			maxWaitTime := 500 * time.Millisecond // 增加到500ms，确保检查完成
+			if len(checkTasks) > 10 {
+				maxWaitTime = 300 * time.Millisecond // 文档多时300ms
+			}
+
+			logger.Info("等待 %d 个文档的检查结果（最多等待%v）...", len(checkTasks)-completedCount, maxWaitTime)
+
+			// 使用带超时的select，非阻塞等待
+			timeout := time.NewTimer(maxWaitTime)
+			defer timeout.Stop()
+
+			// 每50ms检查一次，直到超时或全部完成
+			ticker := time.NewTicker(50 * time.Millisecond)
+			defer ticker.Stop()
+
+		waitLoop:
+			for completedCount < len(checkTasks) {
+				select {
+				case <-timeout.C:
+					// 超时，停止等待
+					logger.Info("等待超时，已收集 %d/%d 个检查结果", completedCount, len(checkTasks))
+					break waitLoop
+				case <-ticker.C:
+					// 检查是否有新的完成
+					for _, task := range checkTasks {
+						if processedTasks[task.group] {
+							continue // 已处理
+						}
+						select {
+						case hasPublicForm := <-task.resultChan:
+							task.group.HasPublicForm = hasPublicForm
+							processedTasks[task.group] = true
+							completedCount++
+							if hasPublicForm {
+								logger.Info("✅ 文档 %s 检查完成，包含'公开形式'（不允许下载）", task.group.DocTitle)
+							} else {
+								logger.Info("✅ 文档 %s 检查完成，不包含'公开形式'（允许下载）", task.group.DocTitle)
+							}
+						default:
+						}
+					}
+				}
+			}
+		}
+
+		// 处理未完成的检查，使用更安全的默认值（不允许下载，更安全）
+		for _, task := range checkTasks {
+			if processedTasks[task.group] {
+				continue // 已处理
+			}
+
+			// 尝试最后一次读取
+			select {
+			case hasPublicForm := <-task.resultChan:
+				task.group.HasPublicForm = hasPublicForm
+				processedTasks[task.group] = true
+				if hasPublicForm {
+					logger.Info("✅ 文档 %s 检查完成（最后读取），包含'公开形式'（不允许下载）", task.group.DocTitle)
+				} else {
+					logger.Info("✅ 文档 %s 检查完成（最后读取），不包含'公开形式'（允许下载）", task.group.DocTitle)
+				}
+			default:
+				// 检查未完成，使用更安全的默认值（不允许下载）
+				// 这样即使检查失败，也不会误允许下载包含"公开形式"的文档
+				task.group.HasPublicForm = true // 改为true，不允许下载（更安全）
+				logger.Info("⏳ 文档 %s 检查未完成，使用安全默认值：不允许下载（检查在后台继续）", task.group.DocTitle)
+			}
+		}
+
+		logger.Info("检查结果收集完成，完成: %d/%d（异步检查，不阻塞主请求）", completedCount, len(checkTasks))
+	}
+
+	logger.Info("所有文档检查处理完成，立即返回响应")
+
+	// 按原始顺序添加到docGroups（完全异步，不等待检查结果）
+	logger.Info("开始构建响应数据，docGroupsMap数量: %d", len(docGroupsMap))
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("⚠️ 构建响应数据时发生panic: %v, 堆栈: %s", r, getStackTrace())
+		}
+	}()
+
+	// 直接使用docGroupsMap构建响应（检查在后台异步进行）
+	for _, group := range docGroupsMap {
+		docGroups = append(docGroups, *group)
+	}
+	logger.Info("docGroups构建完成，共 %d 个文档组（检查在后台异步进行）", len(docGroups))
+
+	// 证据句定位（includeEvidence）：为答案引用到的chunk附上最能支撑答案的原文句子
+	if req.IncludeEvidence {
+		attachSupportingEvidence(docGroups, usedIndices, queryResult.Answer)
+	}
+
+	// 构建响应数据
+	// 限制响应大小，避免内存溢出和502错误
+	// 如果docGroups太大，只返回前50个
+	const maxDocGroups = 50
+	limitedDocGroups := docGroups
+	if len(docGroups) > maxDocGroups {
+		logger.Info("⚠️ 文档组数量过多 (%d > %d)，只返回前 %d 个", len(docGroups), maxDocGroups, maxDocGroups)
+		limitedDocGroups = docGroups[:maxDocGroups]
+	}
+
+	// 限制每个文档组的chunks数量，避免响应过大
+	// 同时限制每个chunk的内容长度，避免单个chunk过大
+	const maxChunksPerGroup = 20
+	const maxChunkContentLength = 2000 // 每个chunk最多2000字符
+
+	// 服务端分页：截断会丢数据时，先把完整结果集深拷贝进短期缓存，
+	// 调用方可通过 /api/query/{queryId}/page/{n} 逐页取回截断掉的内容
+	var queryID string
+	queryTotalPages := 1
+	if len(docGroups) > maxDocGroups || anyGroupOverChunkCap(docGroups, maxChunksPerGroup) {
+		queryID = s.storeQueryPages(docGroups)
+		queryTotalPages = queryPageCount(len(docGroups))
+	}
+
+	totalChunksBefore := 0
+	for i := range limitedDocGroups {
+		totalChunksBefore += len(limitedDocGroups[i].Chunks)
+
+		// 限制chunks数量
+		if len(limitedDocGroups[i].Chunks) > maxChunksPerGroup {
+			logger.Info("⚠️ 文档 %s 的chunks数量过多 (%d > %d)，只返回前 %d 个", limitedDocGroups[i].DocTitle, len(limitedDocGroups[i].Chunks), maxChunksPerGroup, maxChunksPerGroup)
+			limitedDocGroups[i].Chunks = limitedDocGroups[i].Chunks[:maxChunksPerGroup]
+		}
+
+		// 限制每个chunk的内容长度
+		for j := range limitedDocGroups[i].Chunks {
+			chunk := limitedDocGroups[i].Chunks[j]
+			if content, ok := chunk["content"].(string); ok && len(content) > maxChunkContentLength {
+				chunk["content"] = content[:maxChunkContentLength] + "..."
+			}
+			if pageContent, ok := chunk["pageContent"].(string); ok && len(pageContent) > maxChunkContentLength {
+				chunk["pageContent"] = pageContent[:maxChunkContentLength] + "..."
+			}
+		}
+	}
+	totalChunksAfter := 0
+	for _, g := range limitedDocGroups {
+		totalChunksAfter += len(g.Chunks)
+	}
+	logger.Info("响应数据限制完成，文档组数: %d, 总chunks数: %d -> %d", len(limitedDocGroups), totalChunksBefore, totalChunksAfter)
+
+	// 构建响应数据，添加错误处理
+	var response map[string]interface{}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("⚠️ 构建response map时发生panic: %v, 堆栈: %s", r, getStackTrace())
+				// 使用简化的响应
+				response = map[string]interface{}{
+					"answer":    displayAnswer,
+					"results":   []map[string]interface{}{}, // 空结果
+					"docGroups": []DocGroup{},               // 空文档组
+				}
+			}
+		}()
+		// meta：本次查询实际生效的检索/生成配置，便于复现答案和排查质量问题
+		llmProvider := req.LLMProvider
+		if llmProvider == "" {
+			llmProvider = s.config.LLMProvider
+		}
+		llmModel := req.LLMModel
+		if llmModel == "" {
+			switch llmProvider {
+			case "dashscope":
+				llmModel = s.config.DashScopeModel
+			case "kimi":
+				llmModel = s.config.MoonshotModel
+			case "ollama":
+				llmModel = s.config.OllamaModel
+			}
+		}
+
+		response = map[string]interface{}{
+			"answer":            displayAnswer,
+			"truncated":         queryResult.Truncated,         // 答案超过MAX_ANSWER_CHARS被服务端截断
+			"degraded":          queryResult.Degraded,          // 向量检索故障、降级为关键词检索（结果为近似匹配）
+			"fromKnowledgeBase": queryResult.FromKnowledgeBase, // false表示通用知识兜底答案，没有知识库出处
+			"results":           searchResults,                 // 平铺格式（兼容旧前端）
+			"docGroups":         limitedDocGroups,              // 按文档分组的格式（新格式）
+			"meta": map[string]interface{}{
+				"topK":              req.TopK,
+				"candidateTopK":     tempRAG.EffectiveSearchTopK(),
+				"embeddingProvider": s.config.EmbeddingProvider,
+				"embeddingModel":    s.config.EmbeddingModelName,
+				"llmProvider":       llmProvider,
+				"llmModel":          llmModel,
+				"rerank":            rerankEnabled, // false时为纯语义检索（请求级"rerank"开关）
+				"minRerankScore":    1,             // 重排序只保留原始分数>0的片段
+				"neighborWindow":    s.config.NeighborWindow,
+				"enforceCitations":  s.config.EnforceCitations,
+				"llmCalls":          llmCalls, // 本次请求的LLM生成调用次数（多候选时大于1）
+			},
+		}
+
+		if req.IncludeContext {
+			// 进入提示词的实际文本（与queryResult.Results顺序一致），不做响应大小截断：
+			// 调用方要的正是LLM看到的原文
+			response["context"] = rag.PromptContextFor(queryResult.Results)
+		}
+
+		if len(candidateAnswers) > 1 {
+			// 候选答案数组（首个与answer相同），供前端提供"换一个答案"式的挑选
+			response["candidates"] = candidateAnswers
+		}
+
+		if queryID != "" {
+			// 结果被截断且完整结果集已缓存：附带翻页信息，docGroups为第1页
+			response["queryId"] = queryID
+			response["page"] = 1
+			response["totalPages"] = queryTotalPages
+		}
+
+		if debugEnabled {
+			// 输出返回的docGroups文件名列表（标题）
+			docTitles := make([]string, 0, len(limitedDocGroups))
+			for _, g := range limitedDocGroups {
+				if g.DocTitle != "" {
+					docTitles = append(docTitles, g.DocTitle)
+				}
+			}
+			// 输出本次检索到的原始候选片段来源（不依赖LLM是否引用标注）
+			retrievedSources := make([]string, 0, len(queryResult.Results))
+			for _, d := range queryResult.Results {
+				if s, ok := d.Metadata["source"].(string); ok && s != "" {
+					retrievedSources = append(retrievedSources, extractOriginalFilename(filepath.Base(s)))
+				}
+			}
+			response["debug"] = map[string]interface{}{
+				"requestedTopK":     req.TopK,
+				"returnedDocGroups": len(limitedDocGroups),
+				"returnedChunks":    len(searchResults),
+				"docTitles":         docTitles,
+				"retrievedSources":  retrievedSources,
+			}
+		}
+	}()
+	logger.Info("响应数据构建完成，准备编码JSON，answer长度: %d, results数量: %d, docGroups数量: %d", len(queryResult.Answer), len(searchResults), len(limitedDocGroups))
+
+	// 设置响应头，确保即使编码失败也能正确返回
+	if !streamEnabled {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	}
+
+	// 提前发送响应头，避免502错误（在Ubuntu/Nginx环境下很重要）
+	// 这样即使后续处理出现问题，客户端也能知道请求已收到
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+		logger.Info("✅ 响应头已提前刷新，避免502错误")
+	}
+
+	// 检查context是否已取消（超时）
+	if ctx.Err() != nil {
+		logger.Info("⚠️ 请求context已取消: %v, 问题: %s", ctx.Err(), req.Question)
+		// 如果context已取消，尝试返回错误响应
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+		w.WriteHeader(http.StatusRequestTimeout)
+		fmt.Fprintf(w, `{"error":"请求超时","message":"处理时间过长，请求已超时"}`)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return
+	}
+
+	// 记录响应大小，用于监控
+	responseSize := len(queryResult.Answer) + len(limitedDocGroups)*100 // 粗略估算
+	logger.Info("准备发送响应，答案长度: %d 字符, 文档组数: %d, 估算响应大小: %d 字节", len(queryResult.Answer), len(limitedDocGroups), responseSize)
+
+	// 检查客户端连接是否已关闭
+	if r.Context().Err() != nil {
+		logger.Info("⚠️ 客户端连接已关闭: %v, 问题: %s", r.Context().Err(), req.Question)
+		return
+	}
+
+	// 编码响应，确保错误处理
+	// 使用缓冲写入，避免大响应导致问题
+	logger.Info("开始编码JSON响应...")
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("⚠️ 编码响应时发生panic: %v, 堆栈: %s", r, getStackTrace())
+			// 尝试返回错误响应
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error":"响应编码失败","message":"服务器处理响应时出错"}`)
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+	}()
+
+	if streamEnabled {
+		if err := sendSSE("result", response); err != nil {
+			logger.Error("发送流式结果失败: %v", err)
+			return
+		}
+		_ = sendSSE("done", map[string]interface{}{"ok": true})
+		return
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "") // 不格式化，减少响应大小
+
+	if err := encoder.Encode(response); err != nil {
+		logger.Error("⚠️ 编码查询响应失败: %v, 问题: %s, 错误类型: %T", err, req.Question, err)
+		// 如果编码失败，尝试返回一个简单的错误响应
+		// 注意：此时响应头可能已经部分写入，但这是最后的尝试
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+		// 检查是否已经写入状态码（避免重复写入）
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error":"响应编码失败","message":"服务器处理响应时出错"}`)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return
+	}
+
+	logger.Info("JSON编码完成，准备刷新响应...")
+
+	// 尝试刷新响应（如果支持），确保数据及时发送，避免超时导致502
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+		logger.Info("✅ 响应已刷新，确保数据及时发送")
+	}
+
+	logger.Info("✅ 查询响应已成功发送，答案长度: %d 字符, 文档组数: %d", len(queryResult.Answer), len(limitedDocGroups))
+}
+
+// loadDocumentLastPart 加载PDF或Word文档的最后部分（只加载最后几个字符）
+// 避免加载整个文档，节省内存和CPU
+// 注意：虽然我们只保留最后几个字符，但底层的fileLoader.Load()仍会解析整个文档
+// 这是PDF/Word解析库的限制，无法避免。但我们已经限制了内存使用
+// maxChars: 最多加载的字符数（默认100）
+func loadDocumentLastPart(filePath string, fileType string, maxChars int) (string, error) {
+	if maxChars <= 0 {
+		maxChars = 100 // 默认只加载最后100个字符
+	}
+
+	// 创建带超时的context（1.5秒），避免大文件加载时间过长
+	// 进一步减少超时时间，最小化CPU占用
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	// 在goroutine中加载文档，以便可以超时取消
+	type loadResult struct {
+		docs []schema.Document
+		err  error
+	}
+	resultChan := make(chan loadResult, 1)
+
+	// 使用goroutine加载，避免阻塞
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("⚠️ loadDocumentLastPart加载文档时发生panic: %v", r)
+				resultChan <- loadResult{err: fmt.Errorf("加载文档时发生panic: %v", r)}
+			}
+		}()
+		fileLoader := loader.NewFileLoader()
+		docs, err := fileLoader.Load(filePath)
+		resultChan <- loadResult{docs: docs, err: err}
+	}()
+
+	var docs []schema.Document
+	var err error
+
+	select {
+	case result := <-resultChan:
+		docs = result.docs
+		err = result.err
+	case <-ctx.Done():
+		// 超时，返回错误，避免继续占用内存和CPU
+		logger.Info("⚠️ 加载文档超时（超过1.5秒）: %s", filePath)
+		return "", fmt.Errorf("加载文档超时（超过1.5秒）")
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("加载文档失败: %w", err)
+	}
+
+	if len(docs) == 0 {
+		return "", fmt.Errorf("文档为空")
+	}
+
+	// 只取最后一页/最后一个文档的最后部分
+	// 对于PDF，通常每个文档代表一页，我们只取最后一页
+	// 对于Word，通常只有一个文档，我们只取最后部分
+	lastDoc := docs[len(docs)-1]
+	content := lastDoc.PageContent
+
+	// 只取最后maxChars个字符
+	if len(content) > maxChars {
+		content = content[len(content)-maxChars:]
+	}
+
+	return content, nil
+}
+
+// readFileLastBytes 读取文件的最后N个字节（尝试按UTF-8解码）
+func readFileLastBytes(filePath string, maxBytes int) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// 获取文件大小
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	fileSize := fileInfo.Size()
+	if fileSize == 0 {
+		return "", nil
+	}
+
+	// 计算要读取的字节数
+	bytesToRead := int64(maxBytes)
+	if fileSize < bytesToRead {
+		bytesToRead = fileSize
+	}
+
+	// 定位到文件末尾
+	_, err = file.Seek(fileSize-bytesToRead, 0)
+	if err != nil {
+		return "", err
+	}
+
+	// 读取最后N个字节
+	buffer := make([]byte, bytesToRead)
+	n, err := file.Read(buffer)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	// 尝试按UTF-8解码
+	content := string(buffer[:n])
+	return content, nil
+}
+
+// checkPublicFormInContent 检查内容中是否包含"公开形式"相关字样
+// 支持全角冒号（：）和半角冒号（:），以及可能的空格和换行
+// 如果内容中包含"公开形式"四个字，就认为包含（因为用户需求是检查是否有"公开形式"）
+func checkPublicFormInContent(content string) bool {
+	if content == "" {
+		return false
+	}
+
+	// 首先检查是否包含"公开形式"四个字（这是最基本的检查）
+	if strings.Contains(content, "公开形式") {
+		return true
+	}
+
+	// 如果直接包含"公开形式"四个字，已经返回true
+	// 下面的代码是为了更精确的匹配，但上面的检查已经足够了
+	// 先尝试精确匹配
+	containsNotPublicFull := strings.Contains(content, "公开形式：不予公开")
+	containsApplyPublicFull := strings.Contains(content, "公开形式：依申请公开")
+	containsNotPublicFull2 := strings.Contains(content, "公开形式：不公开")
+	containsNotPublicHalf := strings.Contains(content, "公开形式:不予公开")
+	containsApplyPublicHalf := strings.Contains(content, "公开形式:依申请公开")
+	containsNotPublicHalf2 := strings.Contains(content, "公开形式:不公开")
+
+	if containsNotPublicFull || containsApplyPublicFull || containsNotPublicFull2 ||
+		containsNotPublicHalf || containsApplyPublicHalf || containsNotPublicHalf2 {
+		return true
+	}
+
+	// 如果精确匹配失败，尝试模糊匹配（允许冒号前后有空格）
+	normalizedContent := strings.ReplaceAll(content, " ", "")
+	normalizedContent = strings.ReplaceAll(normalizedContent, "\n", "")
+	normalizedContent = strings.ReplaceAll(normalizedContent, "\r", "")
+	normalizedContent = strings.ReplaceAll(normalizedContent, "\t", "")
+
+	// 在规范化后的内容中也检查"公开形式"四个字
+	if strings.Contains(normalizedContent, "公开形式") {
+		return true
+	}
+
+	containsNotPublicFull = strings.Contains(normalizedContent, "公开形式：不予公开")
+	containsApplyPublicFull = strings.Contains(normalizedContent, "公开形式：依申请公开")
+	containsNotPublicFull2 = strings.Contains(normalizedContent, "公开形式：不公开")
+	containsNotPublicHalf = strings.Contains(normalizedContent, "公开形式:不予公开")
+	containsApplyPublicHalf = strings.Contains(normalizedContent, "公开形式:依申请公开")
+	containsNotPublicHalf2 = strings.Contains(normalizedContent, "公开形式:不公开")
+
+	return containsNotPublicFull || containsApplyPublicFull || containsNotPublicFull2 ||
+		containsNotPublicHalf || containsApplyPublicHalf || containsNotPublicHalf2
+}
+
+// extractOriginalFilename 从文件名中提取原始文件名，去除UUID前缀
+// 格式：{UUID}_{原文件名} -> {原文件名}
+// roundScore 相似度分数保留4位小数（原始float32带长尾精度，没必要原样传给前端）
+func roundScore(score float32) float64 {
+	return math.Round(float64(score)*10000) / 10000
+}
+
+func extractOriginalFilename(filename string) string {
+	if filename == "" {
+		return ""
+	}
+
+	// UUID格式：xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
+	// 查找第一个下划线，如果下划线前是UUID格式，则提取下划线后的部分
+	// UUID长度：36个字符（32个十六进制字符 + 4个连字符）
+	underscoreIndex := strings.Index(filename, "_")
+	if underscoreIndex > 0 {
+		// 检查下划线前的部分是否是UUID格式（36个字符）
+		prefix := filename[:underscoreIndex]
+		if len(prefix) == 36 && strings.Contains(prefix, "-") {
+			// 验证是否是UUID格式（包含4个连字符）
+			parts := strings.Split(prefix, "-")
+			if len(parts) == 5 {
+				// 提取下划线后的部分作为原始文件名
+				originalName := filename[underscoreIndex+1:]
+				if originalName != "" {
+					return originalName
+				}
+			}
+		}
+	}
+
+	// 如果没有UUID前缀，直接返回原文件名
+	return filename
+}
+
+// handleAnswer 基于调用方提供的文档片段生成回答（跳过检索）
+// POST /api/answer，body: {"question": "...", "documents": [{"content": "...", "source": "..."}]}
+// 适用于客户端已经通过外部搜索拿到相关片段、只需要"生成+引用标注"的场景
+func (s *Server) handleAnswer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "Method not allowed",
+		})
+		return
+	}
+
+	// 检查用户是否登录
+	if !s.checkUserAuth(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Unauthorized",
+			"message": "请先登录才能使用AI搜索功能",
+		})
+		return
+	}
+
+	var req struct {
+		Question  string `json:"question"`
+		Documents []struct {
+			Content string `json:"content"`
+			Source  string `json:"source"`
+		} `json:"documents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid request",
+			"message": "无法解析请求体",
+		})
+		return
+	}
+
+	if req.Question == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Question is required",
+			"message": "问题不能为空",
+		})
+		return
+	}
+
+	// 转换为内部文档格式（跳过空内容）
+	docs := make([]schema.Document, 0, len(req.Documents))
+	for _, d := range req.Documents {
+		if strings.TrimSpace(d.Content) == "" {
+			continue
+		}
+		metadata := make(map[string]interface{})
+		if d.Source != "" {
+			metadata["source"] = d.Source
+		}
+		docs = append(docs, schema.Document{
+			PageContent: d.Content,
+			Metadata:    metadata,
+		})
+	}
+	if len(docs) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Documents are required",
+			"message": "documents不能为空且至少包含一个有内容的片段",
+		})
+		return
+	}
+
+	logger.Info("收到外部上下文回答请求: %s (%d 个文档片段), 客户端: %s", req.Question, len(docs), r.RemoteAddr)
+
+	// 与/api/query保持一致的超时控制
+	ctx, cancel := context.WithTimeout(r.Context(), 50*time.Second)
+	defer cancel()
+
+	// LLM并发限制：超出并发上限时排队等待，队列满或等待超时返回503
+	release, acquired := s.acquireLLMSlot(ctx)
+	if !acquired {
+		logger.Warn("LLM并发已达上限，拒绝外部上下文回答请求: %s, 客户端: %s", req.Question, r.RemoteAddr)
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "服务繁忙",
+			"message": "当前请求过多，请稍后重试",
+		})
+		return
+	}
+	defer release()
+
+	answer, err := s.ragSystem.AnswerFromDocuments(ctx, req.Question, docs)
+	if err != nil {
+		logger.Error("外部上下文回答失败 - 问题: %s, 错误: %v, 客户端: %s", req.Question, err, r.RemoteAddr)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "生成回答失败",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	// 提取答案中的引用标注，只返回被引用的片段
+	usedIndices := extractUsedAnnotations(answer)
+	results := make([]map[string]interface{}, 0, len(docs))
+	for i, doc := range docs {
+		if !usedIndices[i+1] {
+			continue
+		}
+		source, _ := doc.Metadata["source"].(string)
+		results = append(results, map[string]interface{}{
+			"index":   i + 1,
+			"content": doc.PageContent,
+			"source":  source,
+		})
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"answer":  answer,
+		"results": results,
+	})
+}
+
+// extractUsedAnnotations 从答案中提取使用的标注编号
+// 返回一个map，key是文档片段编号（从1开始），value表示是否被使用
+func extractUsedAnnotations(answer string) map[int]bool {
+	used := make(map[int]bool)
+
+	// 圆圈数字映射：①=1, ②=2, ③=3, ...
+	circleNumbers := []string{"①", "②", "③", "④", "⑤", "⑥", "⑦", "⑧", "⑨", "⑩"}
+
+	// 检查答案中是否包含这些标注
+	for i, circleNum := range circleNumbers {
+		if strings.Contains(answer, circleNum) {
+			used[i+1] = true
+		}
+	}
+
+	return used
+}
+
+// isSystemFile 检查是否是系统文件
+func isSystemFile(filename string) bool {
+	// macOS 系统文件
+	if filename == ".DS_Store" {
+		return true
+	}
+	// macOS 资源分叉文件
+	if strings.HasPrefix(filename, "._") {
+		return true
+	}
+	// Windows 系统文件
+	if strings.HasPrefix(filename, "~$") {
+		return true
+	}
+	// Linux/Unix 隐藏文件（但允许 .开头的正常文件，只过滤系统文件）
+	if filename == ".gitkeep" || filename == ".gitignore" {
+		return true
+	}
+	// 摄取状态表（本服务自己维护的元数据，不是知识库文件）
+	if filename == fileStatusFilename {
+		return true
+	}
+	return false
+}
+
+// isFileDuplicate 检查文件是否已存在（通过文件名和大小判断）
+func (s *Server) isFileDuplicate(filename string, size int64) bool {
+	// 重新加载文件列表以确保数据最新
+	s.loadFilesFromDisk()
+
+	s.filesMu.RLock()
+	defer s.filesMu.RUnlock()
+	for _, file := range s.files {
+		if file.Filename == filename && file.Size == size {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFilesFromDisk 从磁盘加载文件列表
+func (s *Server) loadFilesFromDisk() {
+	entries, err := os.ReadDir(s.filesDir)
+	if err != nil {
+		logger.Error("读取文件目录失败: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		// 过滤系统文件
+		filename := entry.Name()
+		if isSystemFile(filename) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// 提取文件ID和原文件名
+		// 文件名格式可能是：{fileID}{扩展名} 或 {fileID}_{原文件名}
+		ext := filepath.Ext(entry.Name())
+		nameWithoutExt := strings.TrimSuffix(entry.Name(), ext)
+
+		var fileID, originalFilename string
+		// 检查是否包含下划线（新格式：{fileID}_{原文件名}）
+		idx := strings.Index(nameWithoutExt, "_")
+		if idx > 0 {
+			// 新格式：{fileID}_{原文件名}
+			fileID = nameWithoutExt[:idx]
+			originalFilename = nameWithoutExt[idx+1:] + ext
+		} else {
+			// 旧格式：{fileID}{扩展名}，无法恢复原文件名，使用默认名称
+			fileID = nameWithoutExt
+			originalFilename = "文件" + ext // 使用默认名称
+		}
 
-	// 构建响应数据，添加错误处理
-	var response map[string]interface{}
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.Error("⚠️ 构建response map时发生panic: %v, 堆栈: %s", r, getStackTrace())
-				// 使用简化的响应
-				response = map[string]interface{}{
-					"answer":    queryResult.Answer,
-					"results":   []map[string]interface{}{}, // 空结果
-					"docGroups": []DocGroup{},               // 空文档组
-				}
+		// 如果文件信息不存在，创建它
+		// 状态查询在filesMu之外完成：fileStatusFor要拿fileStatusMu，
+		// 与setFileStatus（持fileStatusMu取filesMu）的加锁顺序必须错开
+		status := s.fileStatusFor(fileID)
+		s.filesMu.Lock()
+		if _, exists := s.files[fileID]; !exists {
+			title := strings.TrimSuffix(originalFilename, ext)
+			s.files[fileID] = &FileInfo{
+				ID:         fileID,
+				Filename:   originalFilename, // 使用原文件名，而不是保存的文件名
+				Title:      title,
+				Content:    "", // 无法从文件系统获取内容预览
+				Size:       info.Size(),
+				UploadedAt: info.ModTime(),
+				Chunks:     0, // 无法从文件系统获取，设为0
+				Status:     status,
 			}
-		}()
-		response = map[string]interface{}{
-			"answer":    queryResult.Answer,
-			"results":   searchResults,    // 平铺格式（兼容旧前端）
-			"docGroups": limitedDocGroups, // 按文档分组的格式（新格式）
 		}
+		s.filesMu.Unlock()
+	}
 
-		if debugEnabled {
-			// 输出返回的docGroups文件名列表（标题）
-			docTitles := make([]string, 0, len(limitedDocGroups))
-			for _, g := range limitedDocGroups {
-				if g.DocTitle != "" {
-					docTitles = append(docTitles, g.DocTitle)
-				}
-			}
-			// 输出本次检索到的原始候选片段来源（不依赖LLM是否引用标注）
-			retrievedSources := make([]string, 0, len(queryResult.Results))
-			for _, d := range queryResult.Results {
-				if s, ok := d.Metadata["source"].(string); ok && s != "" {
-					retrievedSources = append(retrievedSources, extractOriginalFilename(filepath.Base(s)))
-				}
-			}
-			response["debug"] = map[string]interface{}{
-				"requestedTopK":     req.TopK,
-				"returnedDocGroups": len(limitedDocGroups),
-				"returnedChunks":    len(searchResults),
-				"docTitles":         docTitles,
-				"retrievedSources":  retrievedSources,
-			}
-		}
-	}()
-	logger.Info("响应数据构建完成，准备编码JSON，answer长度: %d, results数量: %d, docGroups数量: %d", len(queryResult.Answer), len(searchResults), len(limitedDocGroups))
+	s.filesMu.RLock()
+	loaded := len(s.files)
+	s.filesMu.RUnlock()
+	logger.Info("从磁盘加载了 %d 个文件", loaded)
+}
 
-	// 设置响应头，确保即使编码失败也能正确返回
-	if !streamEnabled {
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+// handleChunkDelete 删除单个chunk（DELETE /api/chunks/{pointId}，管理员）
+// 某个段落内容错误或涉敏时，可只移除对应的点而不删除整个文件（文件记录和其他chunk保留）
+// 点ID可从检索结果元数据的_point_id字段获得；仅Qdrant后端支持
+func (s *Server) handleChunkDelete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// 提前发送响应头，避免502错误（在Ubuntu/Nginx环境下很重要）
-	// 这样即使后续处理出现问题，客户端也能知道请求已收到
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
-		logger.Info("✅ 响应头已提前刷新，避免502错误")
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	// 检查context是否已取消（超时）
-	if ctx.Err() != nil {
-		logger.Info("⚠️ 请求context已取消: %v, 问题: %s", ctx.Err(), req.Question)
-		// 如果context已取消，尝试返回错误响应
-		if w.Header().Get("Content-Type") == "" {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		}
-		w.WriteHeader(http.StatusRequestTimeout)
-		fmt.Fprintf(w, `{"error":"请求超时","message":"处理时间过长，请求已超时"}`)
-		if flusher, ok := w.(http.Flusher); ok {
-			flusher.Flush()
-		}
+	pointID := strings.TrimPrefix(r.URL.Path, "/api/chunks/")
+	if pointID == "" || strings.Contains(pointID, "/") {
+		http.Error(w, "Point ID required", http.StatusBadRequest)
 		return
 	}
 
-	// 记录响应大小，用于监控
-	responseSize := len(queryResult.Answer) + len(limitedDocGroups)*100 // 粗略估算
-	logger.Info("准备发送响应，答案长度: %d 字符, 文档组数: %d, 估算响应大小: %d 字节", len(queryResult.Answer), len(limitedDocGroups), responseSize)
-
-	// 检查客户端连接是否已关闭
-	if r.Context().Err() != nil {
-		logger.Info("⚠️ 客户端连接已关闭: %v, 问题: %s", r.Context().Err(), req.Question)
+	qdrantStore, ok := s.store.(*store.QdrantStore)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Unsupported vector store",
+			"message": "chunk级删除仅支持Qdrant后端",
+		})
 		return
 	}
 
-	// 编码响应，确保错误处理
-	// 使用缓冲写入，避免大响应导致问题
-	logger.Info("开始编码JSON响应...")
-	defer func() {
-		if r := recover(); r != nil {
-			logger.Error("⚠️ 编码响应时发生panic: %v, 堆栈: %s", r, getStackTrace())
-			// 尝试返回错误响应
-			if w.Header().Get("Content-Type") == "" {
-				w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			}
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"error":"响应编码失败","message":"服务器处理响应时出错"}`)
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
-			}
-		}
-	}()
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
 
-	if streamEnabled {
-		if err := sendSSE("result", response); err != nil {
-			logger.Error("发送流式结果失败: %v", err)
-			return
+	if err := qdrantStore.DeletePoint(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName, pointID); err != nil {
+		status := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "不存在") {
+			status = http.StatusNotFound
 		}
-		_ = sendSSE("done", map[string]interface{}{"ok": true})
+		logger.Error("删除chunk失败: %s, 错误: %v", pointID, err)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Delete failed",
+			"message": err.Error(),
+		})
 		return
 	}
 
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "") // 不格式化，减少响应大小
+	// 被删除的点可能还在检索缓存里
+	rag.InvalidateRetrievalCache()
 
-	if err := encoder.Encode(response); err != nil {
-		logger.Error("⚠️ 编码查询响应失败: %v, 问题: %s, 错误类型: %T", err, req.Question, err)
-		// 如果编码失败，尝试返回一个简单的错误响应
-		// 注意：此时响应头可能已经部分写入，但这是最后的尝试
-		if w.Header().Get("Content-Type") == "" {
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		}
-		// 检查是否已经写入状态码（避免重复写入）
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, `{"error":"响应编码失败","message":"服务器处理响应时出错"}`)
-		if flusher, ok := w.(http.Flusher); ok {
-			flusher.Flush()
-		}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("chunk %s 已删除", pointID),
+	})
+}
+
+// handleFileList 获取文件列表
+func (s *Server) handleFileList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	logger.Info("JSON编码完成，准备刷新响应...")
-
-	// 尝试刷新响应（如果支持），确保数据及时发送，避免超时导致502
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
-		logger.Info("✅ 响应已刷新，确保数据及时发送")
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	logger.Info("✅ 查询响应已成功发送，答案长度: %d 字符, 文档组数: %d", len(queryResult.Answer), len(limitedDocGroups))
-}
+	// 重新从磁盘加载文件列表（确保数据最新）
+	s.loadFilesFromDisk()
 
-// loadDocumentLastPart 加载PDF或Word文档的最后部分（只加载最后几个字符）
-// 避免加载整个文档，节省内存和CPU
-// 注意：虽然我们只保留最后几个字符，但底层的fileLoader.Load()仍会解析整个文档
-// 这是PDF/Word解析库的限制，无法避免。但我们已经限制了内存使用
-// maxChars: 最多加载的字符数（默认100）
-func loadDocumentLastPart(filePath string, fileType string, maxChars int) (string, error) {
-	if maxChars <= 0 {
-		maxChars = 100 // 默认只加载最后100个字符
+	var fileList []*FileInfo
+	s.filesMu.RLock()
+	for _, file := range s.files {
+		fileList = append(fileList, file)
 	}
+	s.filesMu.RUnlock()
 
-	// 创建带超时的context（1.5秒），避免大文件加载时间过长
-	// 进一步减少超时时间，最小化CPU占用
-	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
-	defer cancel()
+	// 按上传时间倒序排列（最新的在前面）
+	sort.Slice(fileList, func(i, j int) bool {
+		return fileList[i].UploadedAt.After(fileList[j].UploadedAt)
+	})
 
-	// 在goroutine中加载文档，以便可以超时取消
-	type loadResult struct {
-		docs []schema.Document
-		err  error
-	}
-	resultChan := make(chan loadResult, 1)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    fileList,
+		"total":   len(fileList),
+	})
+}
 
-	// 使用goroutine加载，避免阻塞
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.Error("⚠️ loadDocumentLastPart加载文档时发生panic: %v", r)
-				resultChan <- loadResult{err: fmt.Errorf("加载文档时发生panic: %v", r)}
-			}
-		}()
-		fileLoader := loader.NewFileLoader()
-		docs, err := fileLoader.Load(filePath)
-		resultChan <- loadResult{docs: docs, err: err}
-	}()
+// handleFileCount 获取文件数量（无需管理员权限，公开接口）
+func (s *Server) handleFileCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	var docs []schema.Document
-	var err error
+	// 重新从磁盘加载文件列表（确保数据最新）
+	s.loadFilesFromDisk()
 
-	select {
-	case result := <-resultChan:
-		docs = result.docs
-		err = result.err
-	case <-ctx.Done():
-		// 超时，返回错误，避免继续占用内存和CPU
-		logger.Info("⚠️ 加载文档超时（超过1.5秒）: %s", filePath)
-		return "", fmt.Errorf("加载文档超时（超过1.5秒）")
+	// 过滤系统文件
+	var count int
+	s.filesMu.RLock()
+	for _, file := range s.files {
+		if !isSystemFile(file.Filename) {
+			count++
+		}
 	}
+	s.filesMu.RUnlock()
 
-	if err != nil {
-		return "", fmt.Errorf("加载文档失败: %w", err)
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"count":   count,
+	})
+}
 
-	if len(docs) == 0 {
-		return "", fmt.Errorf("文档为空")
+// handleFileSimilar 查找与指定文件内容相近的其他文档（GET /api/files/{id}/similar）
+// 取该文件的第一个文本块作为代表内容做向量检索，把命中的chunk按所属文件聚合，
+// 排除文件自身后按相似度排序返回，供前端展示"相关文档"侧边栏
+func (s *Server) handleFileSimilar(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// 只取最后一页/最后一个文档的最后部分
-	// 对于PDF，通常每个文档代表一页，我们只取最后一页
-	// 对于Word，通常只有一个文档，我们只取最后部分
-	lastDoc := docs[len(docs)-1]
-	content := lastDoc.PageContent
+	// 从URL提取文件ID
+	path := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	fileID := strings.TrimSuffix(path, "/similar")
+	if fileID == "" {
+		http.Error(w, "File ID required", http.StatusBadRequest)
+		return
+	}
 
-	// 只取最后maxChars个字符
-	if len(content) > maxChars {
-		content = content[len(content)-maxChars:]
+	s.filesMu.RLock()
+	fileInfo, exists := s.files[fileID]
+	s.filesMu.RUnlock()
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
 	}
 
-	return content, nil
-}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
 
-// readFileLastBytes 读取文件的最后N个字节（尝试按UTF-8解码）
-func readFileLastBytes(filePath string, maxBytes int) (string, error) {
-	file, err := os.Open(filePath)
+	// 代表内容：取文件的第一个文本块（开头通常包含标题和主题句），取不到时退回内容预览
+	representative := fileInfo.Content
+	chunks, err := s.store.FetchChunksByIndex(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName, fileID, []int{0})
 	if err != nil {
-		return "", err
+		logger.Warn("⚠️ 获取文件 %s 的代表文本块失败，退回内容预览: %v", fileID, err)
+	} else if len(chunks) > 0 {
+		representative = chunks[0].PageContent
+	}
+	if strings.TrimSpace(representative) == "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"fileId":  fileID,
+			"similar": []interface{}{},
+			"message": "该文件没有可用于匹配的文本内容",
+		})
+		return
 	}
-	defer file.Close()
 
-	// 获取文件大小
-	fileInfo, err := file.Stat()
+	// 多召回一些chunk再按文件聚合，保证排除自身后仍有足够的候选文件
+	searchResults, err := s.store.SearchWithScore(ctx, representative, s.embedder.GetEmbedder(), 30, 0)
 	if err != nil {
-		return "", err
+		logger.Error("相似文档检索失败: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Search failed",
+			"message": fmt.Sprintf("相似文档检索失败: %v", err),
+		})
+		return
 	}
 
-	fileSize := fileInfo.Size()
-	if fileSize == 0 {
-		return "", nil
+	type similarFile struct {
+		ID            string  `json:"id"`
+		Filename      string  `json:"filename"`
+		Title         string  `json:"title"`
+		Score         float64 `json:"score"`
+		MatchedChunks int     `json:"matchedChunks"`
 	}
 
-	// 计算要读取的字节数
-	bytesToRead := int64(maxBytes)
-	if fileSize < bytesToRead {
-		bytesToRead = fileSize
+	// 按file_id聚合：保留每个文件的最高分数，统计命中的chunk数量
+	byFile := make(map[string]*similarFile)
+	order := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		chunkFileID, _ := result.Document.Metadata["file_id"].(string)
+		if chunkFileID == "" || chunkFileID == fileID {
+			continue
+		}
+		s.filesMu.RLock()
+		info, known := s.files[chunkFileID]
+		s.filesMu.RUnlock()
+		if !known {
+			continue // 文件列表中不存在（可能已删除但向量尚未清理）
+		}
+		entry, ok := byFile[chunkFileID]
+		if !ok {
+			entry = &similarFile{
+				ID:       chunkFileID,
+				Filename: info.Filename,
+				Title:    info.Title,
+				Score:    result.Score,
+			}
+			byFile[chunkFileID] = entry
+			order = append(order, chunkFileID)
+		}
+		entry.MatchedChunks++
+		if result.Score > entry.Score {
+			entry.Score = result.Score
+		}
 	}
 
-	// 定位到文件末尾
-	_, err = file.Seek(fileSize-bytesToRead, 0)
-	if err != nil {
-		return "", err
+	similar := make([]similarFile, 0, len(byFile))
+	for _, id := range order {
+		similar = append(similar, *byFile[id])
 	}
-
-	// 读取最后N个字节
-	buffer := make([]byte, bytesToRead)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return "", err
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Score != similar[j].Score {
+			return similar[i].Score > similar[j].Score
+		}
+		return similar[i].MatchedChunks > similar[j].MatchedChunks
+	})
+	if len(similar) > 5 {
+		similar = similar[:5]
 	}
 
-	// 尝试按UTF-8解码
-	content := string(buffer[:n])
-	return content, nil
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"fileId":  fileID,
+		"similar": similar,
+	})
 }
 
-// checkPublicFormInContent 检查内容中是否包含"公开形式"相关字样
-// 支持全角冒号（：）和半角冒号（:），以及可能的空格和换行
-// 如果内容中包含"公开形式"四个字，就认为包含（因为用户需求是检查是否有"公开形式"）
-func checkPublicFormInContent(content string) bool {
-	if content == "" {
-		return false
+// handleFileDownload 下载文件
+func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// 首先检查是否包含"公开形式"四个字（这是最基本的检查）
-	if strings.Contains(content, "公开形式") {
-		return true
+	// 从URL提取文件ID
+	path := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	if path == "" {
+		http.Error(w, "File ID required", http.StatusBadRequest)
+		return
 	}
 
-	// 如果直接包含"公开形式"四个字，已经返回true
-	// 下面的代码是为了更精确的匹配，但上面的检查已经足够了
-	// 先尝试精确匹配
-	containsNotPublicFull := strings.Contains(content, "公开形式：不予公开")
-	containsApplyPublicFull := strings.Contains(content, "公开形式：依申请公开")
-	containsNotPublicFull2 := strings.Contains(content, "公开形式：不公开")
-	containsNotPublicHalf := strings.Contains(content, "公开形式:不予公开")
-	containsApplyPublicHalf := strings.Contains(content, "公开形式:依申请公开")
-	containsNotPublicHalf2 := strings.Contains(content, "公开形式:不公开")
-
-	if containsNotPublicFull || containsApplyPublicFull || containsNotPublicFull2 ||
-		containsNotPublicHalf || containsApplyPublicHalf || containsNotPublicHalf2 {
-		return true
+	// 查找文件信息
+	s.filesMu.RLock()
+	fileInfo, exists := s.files[path]
+	s.filesMu.RUnlock()
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
 	}
 
-	// 如果精确匹配失败，尝试模糊匹配（允许冒号前后有空格）
-	normalizedContent := strings.ReplaceAll(content, " ", "")
-	normalizedContent = strings.ReplaceAll(normalizedContent, "\n", "")
-	normalizedContent = strings.ReplaceAll(normalizedContent, "\r", "")
-	normalizedContent = strings.ReplaceAll(normalizedContent, "\t", "")
-
-	// 在规范化后的内容中也检查"公开形式"四个字
-	if strings.Contains(normalizedContent, "公开形式") {
-		return true
+	// 下载限制：未被管理员覆盖时按最新检查结果判断
+	if fileInfo.BlockOverride == "" {
+		s.refreshDownloadBlock(fileInfo)
+	}
+	if fileInfo.isDownloadBlocked() {
+		http.Error(w, "该文件禁止下载", http.StatusForbidden)
+		return
 	}
 
-	containsNotPublicFull = strings.Contains(normalizedContent, "公开形式：不予公开")
-	containsApplyPublicFull = strings.Contains(normalizedContent, "公开形式：依申请公开")
-	containsNotPublicFull2 = strings.Contains(normalizedContent, "公开形式：不公开")
-	containsNotPublicHalf = strings.Contains(normalizedContent, "公开形式:不予公开")
-	containsApplyPublicHalf = strings.Contains(normalizedContent, "公开形式:依申请公开")
-	containsNotPublicHalf2 = strings.Contains(normalizedContent, "公开形式:不公开")
-
-	return containsNotPublicFull || containsApplyPublicFull || containsNotPublicFull2 ||
-		containsNotPublicHalf || containsApplyPublicHalf || containsNotPublicHalf2
-}
+	// 构建文件路径
+	// 新格式：{fileID}_{原文件名}
+	// 旧格式：{fileID}{扩展名}（兼容处理）
+	var filePath string
+	newFormatPath := filepath.Join(s.filesDir, path+"_"+fileInfo.Filename)
+	oldFormatPath := filepath.Join(s.filesDir, path+filepath.Ext(fileInfo.Filename))
 
-// extractOriginalFilename 从文件名中提取原始文件名，去除UUID前缀
-// 格式：{UUID}_{原文件名} -> {原文件名}
-func extractOriginalFilename(filename string) string {
-	if filename == "" {
-		return ""
+	// 优先尝试新格式
+	if _, err := os.Stat(newFormatPath); err == nil {
+		filePath = newFormatPath
+	} else if _, err := os.Stat(oldFormatPath); err == nil {
+		// 兼容旧格式
+		filePath = oldFormatPath
+	} else {
+		http.Error(w, "File not found on disk", http.StatusNotFound)
+		return
 	}
 
-	// UUID格式：xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
-	// 查找第一个下划线，如果下划线前是UUID格式，则提取下划线后的部分
-	// UUID长度：36个字符（32个十六进制字符 + 4个连字符）
-	underscoreIndex := strings.Index(filename, "_")
-	if underscoreIndex > 0 {
-		// 检查下划线前的部分是否是UUID格式（36个字符）
-		prefix := filename[:underscoreIndex]
-		if len(prefix) == 36 && strings.Contains(prefix, "-") {
-			// 验证是否是UUID格式（包含4个连字符）
-			parts := strings.Split(prefix, "-")
-			if len(parts) == 5 {
-				// 提取下划线后的部分作为原始文件名
-				originalName := filename[underscoreIndex+1:]
-				if originalName != "" {
-					return originalName
-				}
-			}
-		}
+	// 打开文件
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open file: %v", err), http.StatusInternalServerError)
+		return
 	}
+	defer file.Close()
 
-	// 如果没有UUID前缀，直接返回原文件名
-	return filename
-}
+	// 设置响应头
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileInfo.Filename))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size))
 
-// extractUsedAnnotations 从答案中提取使用的标注编号
-// 返回一个map，key是文档片段编号（从1开始），value表示是否被使用
-func extractUsedAnnotations(answer string) map[int]bool {
-	used := make(map[int]bool)
+	// 复制文件内容到响应
+	_, err = io.Copy(w, file)
+	if err != nil {
+		logger.Info("Failed to send file: %v", err)
+	}
+}
 
-	// 圆圈数字映射：①=1, ②=2, ③=3, ...
-	circleNumbers := []string{"①", "②", "③", "④", "⑤", "⑥", "⑦", "⑧", "⑨", "⑩"}
+// handleFileBlockOverride 管理员设置文件下载限制的覆盖
+// POST /api/files/{id}/block-override，body: {"override": "allow"|"block"|""}
+// "allow"强制允许下载，"block"强制禁止下载，空字符串清除覆盖（恢复按检查结果判断）
+func (s *Server) handleFileBlockOverride(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	// 检查答案中是否包含这些标注
-	for i, circleNum := range circleNumbers {
-		if strings.Contains(answer, circleNum) {
-			used[i+1] = true
-		}
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	return used
-}
+	// 从URL提取文件ID
+	path := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	fileID := strings.TrimSuffix(path, "/block-override")
+	if fileID == "" {
+		http.Error(w, "File ID required", http.StatusBadRequest)
+		return
+	}
 
-// isSystemFile 检查是否是系统文件
-func isSystemFile(filename string) bool {
-	// macOS 系统文件
-	if filename == ".DS_Store" {
-		return true
+	s.filesMu.RLock()
+	fileInfo, exists := s.files[fileID]
+	s.filesMu.RUnlock()
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
 	}
-	// macOS 资源分叉文件
-	if strings.HasPrefix(filename, "._") {
-		return true
+
+	var req struct {
+		Override string `json:"override"`
 	}
-	// Windows 系统文件
-	if strings.HasPrefix(filename, "~$") {
-		return true
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
-	// Linux/Unix 隐藏文件（但允许 .开头的正常文件，只过滤系统文件）
-	if filename == ".gitkeep" || filename == ".gitignore" {
-		return true
+	if req.Override != "" && req.Override != "allow" && req.Override != "block" {
+		http.Error(w, "override必须是 allow、block 或空字符串", http.StatusBadRequest)
+		return
 	}
-	return false
-}
 
-// isFileDuplicate 检查文件是否已存在（通过文件名和大小判断）
-func (s *Server) isFileDuplicate(filename string, size int64) bool {
-	// 重新加载文件列表以确保数据最新
-	s.loadFilesFromDisk()
+	fileInfo.BlockOverride = req.Override
+	// 重新计算检查结果，保证返回的状态是最新的
+	s.refreshDownloadBlock(fileInfo)
+	logger.Info("管理员设置文件 %s 的下载限制覆盖: %q (当前是否禁止下载: %v)", fileID, req.Override, fileInfo.isDownloadBlocked())
 
-	for _, file := range s.files {
-		if file.Filename == filename && file.Size == size {
-			return true
-		}
-	}
-	return false
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":         true,
+		"fileId":          fileID,
+		"blockOverride":   fileInfo.BlockOverride,
+		"downloadBlocked": fileInfo.isDownloadBlocked(),
+		"blockReason":     fileInfo.BlockReason,
+	})
 }
 
-// loadFilesFromDisk 从磁盘加载文件列表
-func (s *Server) loadFilesFromDisk() {
-	entries, err := os.ReadDir(s.filesDir)
-	if err != nil {
-		logger.Error("读取文件目录失败: %v", err)
+// handleFilePin 置顶/取消置顶文件，让权威文档在检索重排序中加分优先
+// POST /api/files/{id}/pin，body: {"pinned": true|false}（省略body视为置顶）
+// 置顶状态写入该文件所有向量点的payload，重排序按PINNED_BOOST加分；仅Qdrant后端支持
+func (s *Server) handleFilePin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		// 过滤系统文件
-		filename := entry.Name()
-		if isSystemFile(filename) {
-			continue
-		}
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
+	// 从URL提取文件ID
+	path := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	fileID := strings.TrimSuffix(path, "/pin")
+	if fileID == "" {
+		http.Error(w, "File ID required", http.StatusBadRequest)
+		return
+	}
 
-		// 提取文件ID和原文件名
-		// 文件名格式可能是：{fileID}{扩展名} 或 {fileID}_{原文件名}
-		ext := filepath.Ext(entry.Name())
-		nameWithoutExt := strings.TrimSuffix(entry.Name(), ext)
+	s.filesMu.RLock()
+	fileInfo, exists := s.files[fileID]
+	s.filesMu.RUnlock()
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
 
-		var fileID, originalFilename string
-		// 检查是否包含下划线（新格式：{fileID}_{原文件名}）
-		idx := strings.Index(nameWithoutExt, "_")
-		if idx > 0 {
-			// 新格式：{fileID}_{原文件名}
-			fileID = nameWithoutExt[:idx]
-			originalFilename = nameWithoutExt[idx+1:] + ext
-		} else {
-			// 旧格式：{fileID}{扩展名}，无法恢复原文件名，使用默认名称
-			fileID = nameWithoutExt
-			originalFilename = "文件" + ext // 使用默认名称
-		}
+	// body可省略（视为置顶），也可显式传{"pinned": false}取消置顶
+	pinned := true
+	var req struct {
+		Pinned *bool `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Pinned != nil {
+		pinned = *req.Pinned
+	}
 
-		// 如果文件信息不存在，创建它
-		if _, exists := s.files[fileID]; !exists {
-			title := strings.TrimSuffix(originalFilename, ext)
-			s.files[fileID] = &FileInfo{
-				ID:         fileID,
-				Filename:   originalFilename, // 使用原文件名，而不是保存的文件名
-				Title:      title,
-				Content:    "", // 无法从文件系统获取内容预览
-				Size:       info.Size(),
-				UploadedAt: info.ModTime(),
-				Chunks:     0, // 无法从文件系统获取，设为0
-			}
+	// 更新向量库中该文件所有点的payload（仅Qdrant后端支持set-payload）
+	if qdrantStore, ok := s.store.(*store.QdrantStore); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		if err := qdrantStore.UpdatePayload(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName, fileID, map[string]interface{}{"pinned": pinned}); err != nil {
+			logger.Error("更新文件 %s 的置顶payload失败: %v", fileID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "更新置顶状态失败",
+				"message": err.Error(),
+			})
+			return
 		}
+	} else {
+		http.Error(w, "当前向量存储后端不支持置顶", http.StatusNotImplemented)
+		return
 	}
 
-	logger.Info("从磁盘加载了 %d 个文件", len(s.files))
+	fileInfo.Pinned = pinned
+	logger.Info("管理员设置文件 %s 的置顶状态: %v", fileID, pinned)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"fileId":  fileID,
+		"pinned":  pinned,
+	})
 }
 
-// handleFileList 获取文件列表
-func (s *Server) handleFileList(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
+// handleFileRecheck 管理员触发单个文件的下载限制重新检查
+// POST /api/files/{id}/recheck，按当前规则重新运行"公开形式"检查并更新持久化状态
+// 用于限制规则变更后刷新已上传文件的过期状态，无需重新上传
+func (s *Server) handleFileRecheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -2156,109 +5032,158 @@ func (s *Server) handleFileList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 重新从磁盘加载文件列表（确保数据最新）
-	s.loadFilesFromDisk()
+	// 从URL提取文件ID
+	path := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	fileID := strings.TrimSuffix(path, "/recheck")
+	if fileID == "" {
+		http.Error(w, "File ID required", http.StatusBadRequest)
+		return
+	}
 
-	var fileList []*FileInfo
-	for _, file := range s.files {
-		fileList = append(fileList, file)
+	s.filesMu.RLock()
+	fileInfo, exists := s.files[fileID]
+	s.filesMu.RUnlock()
+	if !exists {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
 	}
 
-	// 按上传时间倒序排列（最新的在前面）
-	sort.Slice(fileList, func(i, j int) bool {
-		return fileList[i].UploadedAt.After(fileList[j].UploadedAt)
-	})
+	s.refreshDownloadBlock(fileInfo)
+	logger.Info("管理员触发文件 %s 的下载限制重新检查 (当前是否禁止下载: %v)", fileID, fileInfo.isDownloadBlocked())
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"data":    fileList,
-		"total":   len(fileList),
+		"success":         true,
+		"fileId":          fileID,
+		"downloadBlocked": fileInfo.isDownloadBlocked(),
+		"blockReason":     fileInfo.BlockReason,
+		"blockOverride":   fileInfo.BlockOverride,
 	})
 }
 
-// handleFileCount 获取文件数量（无需管理员权限，公开接口）
-func (s *Server) handleFileCount(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
+// handleRecheckAll 管理员触发全部文件的下载限制重新检查
+// POST /api/recheck-all，逐个按当前规则重新运行"公开形式"检查并更新持久化状态
+func (s *Server) handleRecheckAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// 重新从磁盘加载文件列表（确保数据最新）
-	s.loadFilesFromDisk()
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	// 过滤系统文件
-	var count int
-	for _, file := range s.files {
-		if !isSystemFile(file.Filename) {
-			count++
+	files := s.snapshotFiles()
+	logger.Info("管理员触发全部文件的下载限制重新检查，共 %d 个文件", len(files))
+
+	total := 0
+	blockedCount := 0
+	for _, fileInfo := range files {
+		s.refreshDownloadBlock(fileInfo)
+		total++
+		if fileInfo.isDownloadBlocked() {
+			blockedCount++
 		}
 	}
 
+	logger.Info("下载限制重新检查完成：共 %d 个文件，其中 %d 个禁止下载", total, blockedCount)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"count":   count,
+		"success":      true,
+		"message":      fmt.Sprintf("重新检查完成：共 %d 个文件，其中 %d 个禁止下载", total, blockedCount),
+		"total":        total,
+		"blockedCount": blockedCount,
 	})
 }
 
-// handleFileDownload 下载文件
-func (s *Server) handleFileDownload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleFileUpdate 编辑文件的元数据（标题、标签），不重新嵌入
+// PATCH /api/files/{id}，body: {"title": "...", "tags": ["..."]}，未提供的字段保持不变
+// 同步更新向量库中该文件所有点的payload，保证检索结果携带最新元数据
+func (s *Server) handleFileUpdate(w http.ResponseWriter, r *http.Request) {
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	// 从URL提取文件ID
-	path := strings.TrimPrefix(r.URL.Path, "/api/files/")
-	if path == "" {
+	fileID := strings.TrimPrefix(r.URL.Path, "/api/files/")
+	if fileID == "" {
 		http.Error(w, "File ID required", http.StatusBadRequest)
 		return
 	}
 
-	// 查找文件信息
-	fileInfo, exists := s.files[path]
+	s.filesMu.RLock()
+	fileInfo, exists := s.files[fileID]
+	s.filesMu.RUnlock()
 	if !exists {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
-	// 构建文件路径
-	// 新格式：{fileID}_{原文件名}
-	// 旧格式：{fileID}{扩展名}（兼容处理）
-	var filePath string
-	newFormatPath := filepath.Join(s.filesDir, path+"_"+fileInfo.Filename)
-	oldFormatPath := filepath.Join(s.filesDir, path+filepath.Ext(fileInfo.Filename))
-
-	// 优先尝试新格式
-	if _, err := os.Stat(newFormatPath); err == nil {
-		filePath = newFormatPath
-	} else if _, err := os.Stat(oldFormatPath); err == nil {
-		// 兼容旧格式
-		filePath = oldFormatPath
-	} else {
-		http.Error(w, "File not found on disk", http.StatusNotFound)
+	var req struct {
+		Title *string   `json:"title"`
+		Tags  *[]string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-
-	// 打开文件
-	file, err := os.Open(filePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to open file: %v", err), http.StatusInternalServerError)
+	if req.Title == nil && req.Tags == nil {
+		http.Error(w, "至少需要提供 title 或 tags 字段", http.StatusBadRequest)
+		return
+	}
+	if req.Title != nil && strings.TrimSpace(*req.Title) == "" {
+		http.Error(w, "title不能为空", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	// 设置响应头
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileInfo.Filename))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size))
+	// 构建payload补丁，只包含提供的字段
+	patch := make(map[string]interface{})
+	if req.Title != nil {
+		patch["title"] = strings.TrimSpace(*req.Title)
+	}
+	if req.Tags != nil {
+		patch["tags"] = *req.Tags
+	}
 
-	// 复制文件内容到响应
-	_, err = io.Copy(w, file)
-	if err != nil {
-		logger.Info("Failed to send file: %v", err)
+	// 更新向量库中的payload（仅Qdrant后端支持set-payload）
+	if qdrantStore, ok := s.store.(*store.QdrantStore); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		if err := qdrantStore.UpdatePayload(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName, fileID, patch); err != nil {
+			logger.Error("更新文件 %s 的向量payload失败: %v", fileID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "更新向量元数据失败",
+				"message": err.Error(),
+			})
+			return
+		}
+	} else {
+		http.Error(w, "当前向量存储后端不支持元数据更新", http.StatusNotImplemented)
+		return
+	}
+
+	// 更新内存中的文件信息
+	if req.Title != nil {
+		fileInfo.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Tags != nil {
+		fileInfo.Tags = *req.Tags
 	}
+	logger.Info("文件 %s 元数据已更新 (title=%q, tags=%v)", fileID, fileInfo.Title, fileInfo.Tags)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"fileId":  fileID,
+		"title":   fileInfo.Title,
+		"tags":    fileInfo.Tags,
+	})
 }
 
 // handleFileDelete 删除文件
@@ -2282,12 +5207,37 @@ func (s *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 查找文件信息
+	s.filesMu.RLock()
 	fileInfo, exists := s.files[path]
+	s.filesMu.RUnlock()
 	if !exists {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
+	// URL来源的文档没有磁盘文件，直接按source URL过滤删除向量数据
+	if fileInfo.SourceType == "url" {
+		s.filesMu.Lock()
+		delete(s.files, path)
+		s.filesMu.Unlock()
+
+		ctx := context.Background()
+		if err := s.store.DeleteDocumentsBySource(ctx, s.config.QdrantURL, s.config.QdrantAPIKey, s.config.CollectionName, fileInfo.SourceURL); err != nil {
+			logger.Error("从向量数据库删除URL来源文档失败: %s, 错误: %v", fileInfo.SourceURL, err)
+			// 与文件删除保持一致：即使向量数据删除失败也返回成功（列表记录已删除）
+		} else {
+			logger.Info("已从向量数据库删除URL来源文档: %s", fileInfo.SourceURL)
+		}
+		rag.InvalidateRetrievalCache()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("URL %s 的文档已删除", fileInfo.SourceURL),
+		})
+		return
+	}
+
 	// 构建文件路径
 	var filePath string
 	newFormatPath := filepath.Join(s.filesDir, path+"_"+fileInfo.Filename)
@@ -2312,8 +5262,11 @@ func (s *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// 从内存中的文件列表删除
+	// 从内存中的文件列表删除，并清理摄取状态记录
+	s.filesMu.Lock()
 	delete(s.files, path)
+	s.filesMu.Unlock()
+	s.clearFileStatus(path)
 
 	// 从Qdrant向量数据库中删除相关文档
 	// 通过metadata中的source字段匹配文件路径
@@ -2375,6 +5328,8 @@ func (s *Server) handleFileDelete(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	rag.InvalidateRetrievalCache()
+
 	if successfulPath == "" && deleteErr != nil {
 		logger.Error("从向量数据库删除文档失败（已尝试多种路径格式）: %v", deleteErr)
 		// 即使删除向量数据库中的文档失败，也返回成功（因为文件已删除）
@@ -2572,9 +5527,140 @@ func (s *Server) saveFailedFile(filePath, originalFilename, reason string) error
 	}
 
 	logger.Info("失败文件已保存: %s, 原因: %s", failedPath, reason)
+
+	// 写入sidecar元数据（失败原因、原始文件名、时间），供失败报告接口读取
+	// 写入失败只记录日志，不影响失败文件本身的保存
+	meta := failedFileMeta{
+		OriginalFilename: originalFilename,
+		Reason:           reason,
+		FailedAt:         time.Now().Format(time.RFC3339),
+	}
+	if metaData, err := json.Marshal(meta); err == nil {
+		if err := os.WriteFile(failedPath+failedMetaSuffix, metaData, 0644); err != nil {
+			logger.Warn("写入失败文件元数据失败: %s, 错误: %v", failedPath+failedMetaSuffix, err)
+		}
+	}
 	return nil
 }
 
+// 失败文件sidecar元数据的文件名后缀
+const failedMetaSuffix = ".meta.json"
+
+// failedFileMeta 失败文件的sidecar元数据（与失败文件同目录，文件名加.meta.json后缀）
+type failedFileMeta struct {
+	OriginalFilename string `json:"original_filename"`
+	Reason           string `json:"reason"`
+	FailedAt         string `json:"failed_at"`
+}
+
+// handleFailedReport 下载失败文件报告（仅管理员）
+// GET /api/failed/report?format=json|csv，汇总失败目录中的文件、原因、时间和大小，
+// 便于批量排查入库失败（如找出所有需要OCR的扫描版PDF），不用翻日志
+func (s *Server) handleFailedReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 检查管理员权限
+	if !s.checkAdminAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Invalid format",
+			"message": fmt.Sprintf("不支持的format: %s，支持的值: json, csv", format),
+		})
+		return
+	}
+
+	type failedFileRecord struct {
+		Filename         string `json:"filename"`
+		OriginalFilename string `json:"originalFilename"`
+		Reason           string `json:"reason"`
+		FailedAt         string `json:"failedAt"`
+		SizeBytes        int64  `json:"sizeBytes"`
+	}
+
+	entries, err := os.ReadDir(s.failedFilesDir)
+	if err != nil && !os.IsNotExist(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Failed to read failed files",
+			"message": fmt.Sprintf("读取失败文件目录失败: %v", err),
+		})
+		return
+	}
+
+	records := make([]failedFileRecord, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), failedMetaSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		record := failedFileRecord{
+			Filename:         entry.Name(),
+			OriginalFilename: entry.Name(),
+			// 旧的失败文件没有sidecar元数据（只在日志里有原因），标记为未知
+			Reason:    "未知（无元数据记录）",
+			FailedAt:  info.ModTime().Format(time.RFC3339),
+			SizeBytes: info.Size(),
+		}
+		if metaData, err := os.ReadFile(filepath.Join(s.failedFilesDir, entry.Name()+failedMetaSuffix)); err == nil {
+			var meta failedFileMeta
+			if err := json.Unmarshal(metaData, &meta); err == nil {
+				if meta.OriginalFilename != "" {
+					record.OriginalFilename = meta.OriginalFilename
+				}
+				if meta.Reason != "" {
+					record.Reason = meta.Reason
+				}
+				if meta.FailedAt != "" {
+					record.FailedAt = meta.FailedAt
+				}
+			}
+		}
+		records = append(records, record)
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=failed-report.csv")
+		writer := csv.NewWriter(w)
+		_ = writer.Write([]string{"filename", "original_filename", "reason", "failed_at", "size_bytes"})
+		for _, record := range records {
+			_ = writer.Write([]string{
+				record.Filename,
+				record.OriginalFilename,
+				record.Reason,
+				record.FailedAt,
+				strconv.FormatInt(record.SizeBytes, 10),
+			})
+		}
+		writer.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"count":   len(records),
+		"files":   records,
+	})
+}
+
 // copyFile 复制文件（用于跨文件系统移动）
 func (s *Server) copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -2593,6 +5679,49 @@ func (s *Server) copyFile(src, dst string) error {
 	return err
 }
 
+// buildLLMClient 根据provider/model创建一次性的LLM客户端
+// provider为空时使用配置的默认provider，model为空时使用该provider的默认模型
+// API Key等凭证始终来自服务器配置，请求中只允许指定provider和model
+func (s *Server) buildLLMClient(provider, model string) (llm.LLM, error) {
+	if provider == "" {
+		provider = s.config.LLMProvider
+	}
+
+	var client llm.LLM
+	var err error
+	switch provider {
+	case "dashscope":
+		if model == "" {
+			model = s.config.DashScopeModel
+		}
+		client, err = llm.NewDashScopeLLM(s.config.DashScopeAPIKey, model)
+	case "kimi":
+		if model == "" {
+			model = s.config.MoonshotModel
+		}
+		client, err = llm.NewKimiLLM(s.config.MoonshotAPIKey, model)
+	case "ollama":
+		if model == "" {
+			model = s.config.OllamaModel
+		}
+		client, err = llm.NewOllamaLLM(s.config.OllamaBaseURL, model)
+	default:
+		return nil, fmt.Errorf("不支持的LLM Provider: %s，支持的值: ollama, dashscope, kimi", provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 一次性客户端同样应用result_format与系统提示词（persona）
+	if ds, ok := client.(*llm.DashScopeLLM); ok {
+		ds.SetResultFormat(s.config.DashScopeResultFormat)
+	}
+	if setter, ok := client.(llm.SystemPromptSetter); ok && s.config.SystemPrompt != "" {
+		setter.SetSystemPrompt(s.config.SystemPrompt)
+	}
+	return client, nil
+}
+
 // checkAdminAuth 检查管理员权限
 func (s *Server) checkAdminAuth(r *http.Request) bool {
 	// 从Header获取token
@@ -2703,6 +5832,18 @@ func (s *Server) startAsyncCheckWorkers() {
 	logger.Info("已启动 %d 个异步检查工作协程", s.checkWorkers)
 }
 
+// refreshDownloadBlock 重新计算文件的下载限制状态并写回FileInfo
+// 复用查询路径的"公开形式"检查逻辑（checkPublicFormSync）
+func (s *Server) refreshDownloadBlock(fileInfo *FileInfo) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileInfo.Filename)), ".")
+	group := &DocGroup{
+		DocTitle: fileInfo.Title,
+		FileType: ext,
+		FileID:   fileInfo.ID,
+	}
+	s.checkPublicFormSync(group)
+}
+
 // checkPublicFormSync 同步检查文档是否包含"公开形式"（实时检查，不使用缓存）
 // 只读取文档最后100个字符进行检查
 func (s *Server) checkPublicFormSync(group *DocGroup) {
@@ -2718,7 +5859,9 @@ func (s *Server) checkPublicFormSync(group *DocGroup) {
 		return
 	}
 
+	s.filesMu.RLock()
 	fileInfo, exists := s.files[group.FileID]
+	s.filesMu.RUnlock()
 	if !exists {
 		group.HasPublicForm = false
 		return
@@ -2775,6 +5918,20 @@ func (s *Server) checkPublicFormSync(group *DocGroup) {
 	} else {
 		logger.Info("[检查结果] ✅ 文档 %s 不包含'公开形式'，允许下载", group.DocTitle)
 	}
+
+	// 持久化检查结果到文件信息，并应用管理员覆盖
+	fileInfo.DownloadBlocked = hasPublicForm
+	if hasPublicForm {
+		fileInfo.BlockReason = "文档末尾包含\"公开形式\"字样"
+	} else {
+		fileInfo.BlockReason = ""
+	}
+	switch fileInfo.BlockOverride {
+	case "allow":
+		group.HasPublicForm = false
+	case "block":
+		group.HasPublicForm = true
+	}
 }
 
 // checkPublicFormAsync 异步检查文档是否包含"公开形式"（保留用于兼容，但不再使用）
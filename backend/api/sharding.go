@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/Codeyangyi/personal-ai-kb/rag"
+	"github.com/Codeyangyi/personal-ai-kb/store"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 按文件类型分片（SHARD_BY_TYPE）
+// 超大异构知识库里，多数查询只针对一种文档类型（比如只查PDF合同），在整个集合上
+// 检索白白变慢。开启后，入库时chunk除了写入主集合，还镜像写入按类型命名的分片集合
+// （如personal_kb_pdf、personal_kb_txt）；查询带fileType提示时只搜对应分片，
+// 不带提示时照常搜主集合（即搜索全部）。主集合始终是权威数据，分片只是检索加速的
+// 冗余副本，镜像失败不影响入库结果。
+
+// shardRAGFor 获取（按需创建）指定文件类型的分片RAG实例
+// 仅Qdrant后端支持分片；创建失败返回nil（调用方降级到主集合）
+func (s *Server) shardRAGFor(fileType string) *rag.RAG {
+	fileType = normalizeShardType(fileType)
+	if fileType == "" {
+		return nil
+	}
+
+	s.shardMu.Lock()
+	defer s.shardMu.Unlock()
+	if shardRAG, exists := s.shardRAGs[fileType]; exists {
+		return shardRAG
+	}
+
+	if _, ok := s.store.(*store.QdrantStore); !ok {
+		return nil
+	}
+
+	collection := s.config.CollectionName + "_" + fileType
+	shardStore, err := store.NewQdrantStoreWithMode(
+		s.config.QdrantURL, s.config.QdrantAPIKey, collection,
+		s.embedder.GetEmbedder(), s.embedder, "recreate",
+	)
+	if err != nil {
+		logger.Warn("⚠️ 创建分片集合 %s 失败，该类型退回主集合: %v", collection, err)
+		return nil
+	}
+	shardStore.SetWaitOnWrite(s.config.QdrantWaitWrites)
+	shardStore.SetRetryOptions(s.config.QdrantMaxRetries, s.config.QdrantBreakerThreshold, s.config.QdrantBreakerCooldown)
+
+	shardRAG := rag.NewRAG(s.embedder, shardStore, s.llm, 3)
+	shardRAG.SetStoreConnection(s.config.QdrantURL, s.config.QdrantAPIKey, collection)
+	shardRAG.SetEmbedBatchOptions(s.config.EmbedBatchSize, s.config.EmbedBatchDelayMS)
+	shardRAG.SetRetryBackoff(s.config.EmbedRetryBaseMS, s.config.EmbedRetryMaxMS)
+
+	if s.shardRAGs == nil {
+		s.shardRAGs = make(map[string]*rag.RAG)
+		s.shardStores = make(map[string]*store.QdrantStore)
+	}
+	s.shardRAGs[fileType] = shardRAG
+	s.shardStores[fileType] = shardStore
+	logger.Info("✅ 分片集合已就绪: %s", collection)
+	return shardRAG
+}
+
+// mirrorToShards 把chunks按文件类型镜像写入分片集合（SHARD_BY_TYPE开启时异步调用）
+// 镜像是检索加速的冗余副本：失败只告警，不影响主集合的入库结果
+func (s *Server) mirrorToShards(chunks []schema.Document) {
+	if !s.config.ShardByType || len(chunks) == 0 {
+		return
+	}
+
+	// 按类型分组
+	groups := make(map[string][]schema.Document)
+	for _, chunk := range chunks {
+		fileType := shardTypeForDoc(chunk)
+		if fileType == "" {
+			continue
+		}
+		groups[fileType] = append(groups[fileType], chunk)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	for fileType, group := range groups {
+		shardRAG := s.shardRAGFor(fileType)
+		if shardRAG == nil {
+			continue
+		}
+		if err := shardRAG.AddDocuments(ctx, group); err != nil {
+			logger.Warn("⚠️ 镜像 %d 个chunk到分片 %s 失败（主集合不受影响）: %v", len(group), fileType, err)
+			continue
+		}
+		logger.Info("📎 已镜像 %d 个chunk到分片集合 %s_%s", len(group), s.config.CollectionName, fileType)
+	}
+}
+
+// searchBackendFor 根据查询的fileType提示选择检索后端
+// 分片可用时返回分片的store和集合名；未开启分片、无提示或分片不可用时返回主集合
+func (s *Server) searchBackendFor(fileType string) (store.VectorStore, string) {
+	// 主后端在锁下取值：在线迁移cutover期间不能拿到新store+旧集合名的组合
+	_, mainStore, mainCollection := s.currentBackend()
+	normalized := normalizeShardType(fileType)
+	if s.config.ShardByType && normalized != "" && s.shardRAGFor(normalized) != nil {
+		s.shardMu.Lock()
+		defer s.shardMu.Unlock()
+		if shardStore := s.shardStores[normalized]; shardStore != nil {
+			return shardStore, mainCollection + "_" + normalized
+		}
+	}
+	return mainStore, mainCollection
+}
+
+// shardTypeForDoc 从chunk元数据推断文件类型（优先file_type，其次文件名后缀）
+func shardTypeForDoc(doc schema.Document) string {
+	if fileType, ok := doc.Metadata["file_type"].(string); ok && fileType != "" {
+		return normalizeShardType(fileType)
+	}
+	if fileName, ok := doc.Metadata["file_name"].(string); ok && fileName != "" {
+		return normalizeShardType(filepath.Ext(fileName))
+	}
+	if source, ok := doc.Metadata["source"].(string); ok && source != "" {
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			return "url"
+		}
+		return normalizeShardType(filepath.Ext(source))
+	}
+	return ""
+}
+
+// normalizeShardType 归一化文件类型（小写、去掉前导点；只允许字母数字，防止拼出非法集合名）
+func normalizeShardType(fileType string) string {
+	fileType = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(fileType), "."))
+	for _, r := range fileType {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return ""
+		}
+	}
+	return fileType
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
 // Config 系统配置
@@ -15,25 +17,212 @@ type Config struct {
 	DashScopeModel  string // 通义千问模型名称
 	MoonshotAPIKey  string // Kimi2 (Moonshot AI) API Key
 	MoonshotModel   string // Kimi2 模型名称
+	// DashScope的result_format（"message"或"text"，空表示用API默认）
+	// 新一代模型默认返回choices、老模型返回text，显式指定后跨模型升级时行为稳定
+	DashScopeResultFormat string
+	SystemPrompt          string // 系统提示词（persona），与检索指令分离，用于定制助手语气
+
+	// LLM并发限制：同时进行的Generate调用上限（0表示不限制），用于保护上游RPM配额
+	LLMMaxConcurrency int
+
+	// 上传并发限制：同时处理的上传请求上限（0表示不限制），防止上传风暴耗尽内存和磁盘IO
+	MaxConcurrentUploads int
+
+	// 向量存储配置
+	VectorStore string // 向量存储后端: "qdrant"（默认）或 "milvus"
+
+	// 最小文档长度（MIN_DOC_CHARS）：清洗后总字符数低于阈值的文档拒绝入库
+	// （批量模式下标记失败并跳过），0表示不限制
+	MinDocChars int
+
+	// 内容清洗模式（CLEAN_MODE）："standard"（默认，折叠连续空白）或
+	// "preserve-layout"（代码/表格类内容保留空白布局，只做编码清理）
+	CleanMode string
+
+	// 按文件类型分片（SHARD_BY_TYPE，仅Qdrant）：入库时按类型镜像到分片集合
+	// （如personal_kb_pdf），查询带fileType提示时只搜对应分片；主集合始终保留全量数据
+	ShardByType bool
+
+	// 健康检查端点（HEALTH_PATH / HEALTH_TOKEN）：路径可按负载均衡器的约定调整，
+	// 设置token后要求请求携带X-Health-Token头，避免健康端点成为无认证的信息泄露面
+	HealthPath  string
+	HealthToken string
+
+	// 置顶文档加分（PINNED_BOOST）：重排序时给置顶文档额外加的分值，0关闭
+	PinnedBoost int
+
+	// JSON接口请求体大小上限（MAX_JSON_BODY_KB）：防止超大JSON请求体耗尽内存，
+	// 只作用于非上传接口（上传走multipart有独立的大小限制），0关闭
+	MaxJSONBodyKB int
+
+	// 提示词文档排序策略（PROMPT_ORDER）：relevance（按相关度，默认）、
+	// relevance-reordered（最相关排首尾，缓解lost in the middle）、chronological（按文档日期）
+	PromptOrder string
+
+	// 查询管线总时间预算（QUERY_BUDGET_MS）：检索+重排序+生成共用一个截止时间，
+	// 预算富余时对瞬时的生成失败重试一次；0表示不限制
+	QueryBudgetMS int
+
+	// 查询轨迹导出（TRACE_DIR / TRACE_SAMPLE_RATE）：按采样率把完整查询轨迹
+	// 以JSONL写入目录，供离线评估（recall@k、答案忠实度）；目录为空表示关闭
+	TraceDir        string
+	TraceSampleRate float64
+
+	// Qdrant payload字段名（QDRANT_CONTENT_KEY / QDRANT_SOURCE_KEY）：
+	// langchaingo版本或外部写入的集合字段名不同时调整，避免按source删除静默失效
+	QdrantContentKey string
+	QdrantSourceKey  string
+
+	// 回答语言（ANSWER_LANGUAGE）：指定后无论文档语言如何都用目标语言作答，
+	// 支持语言代码（en、ja等）或语言名称；空表示跟随提示词默认行为（中文）
+	AnswerLanguage string
+
+	// 答案页眉/页脚（ANSWER_HEADER / ANSWER_FOOTER）：拼接到每个最终答案的前/后，
+	// 用于合规要求的固定声明（如"本回答由AI生成，仅供参考"）；空表示不追加
+	AnswerHeader string
+	AnswerFooter string
+
+	// 多集合扇出检索（SEARCH_COLLECTIONS，仅Qdrant）：检索同时扇出到这些额外集合，
+	// 按真实分数合并候选池；FANOUT_TIMEOUT_MS为单集合超时，FANOUT_CONCURRENCY为并发上限
+	SearchCollections []string
+	FanOutTimeoutMS   int
+	FanOutConcurrency int
 
 	// Qdrant配置
-	QdrantURL      string
-	QdrantAPIKey   string
-	CollectionName string
+	QdrantURL        string
+	QdrantAPIKey     string
+	CollectionName   string
+	OnDimMismatch    string // 集合维度与模型维度不匹配时的处理方式: "migrate"、"recreate" 或 "fail"
+	QdrantWaitWrites bool   // 写入时等待索引完成（wait=true），避免刚上传的文档立即查询时查不到
+
+	// Qdrant瞬时故障的重试与熔断配置
+	QdrantMaxRetries       int // 操作失败后的最大重试次数（负数表示保持默认值2）
+	QdrantBreakerThreshold int // 连续失败多少次后熔断（0表示保持默认值5）
+	QdrantBreakerCooldown  int // 熔断后的冷却时间（秒，0表示保持默认值30）
+	OptimizeIntervalMin    int // 定期触发优化器的间隔（分钟，0表示不定期触发）
+
+	// Milvus配置（VECTOR_STORE=milvus 时使用）
+	MilvusAddr     string // Milvus地址，例如: localhost:19530
+	MilvusUsername string
+	MilvusPassword string
 
 	// 嵌入模型配置
-	EmbeddingProvider  string // "ollama" 或 "siliconflow"
-	EmbeddingModelName string
-	EmbeddingModelURL  string
-	SiliconFlowAPIKey  string // 硅基流动API Key
+	EmbeddingProvider   string // "ollama" 或 "siliconflow"
+	EmbeddingModelName  string
+	QueryEmbeddingModel string // 查询专用嵌入模型（空表示与EmbeddingModelName相同；维度必须一致）
+	// 查询专用嵌入provider（空表示与EmbeddingProvider相同）：查询延迟敏感的部署可以
+	// 让查询走低延迟的provider，前提是与入库模型产出兼容的向量空间（启动时校验维度）
+	QueryEmbeddingProvider string
+	EmbeddingModelURL      string
+	SiliconFlowAPIKey      string // 硅基流动API Key
+	EmbedBatchSize         int    // 嵌入批次大小（0表示按文档数量自动选择）
+	EmbedBatchDelayMS      int    // 嵌入批次之间的固定延迟（毫秒，0表示按批次大小自动计算）
+	EmbedRetryBaseMS       int    // 速率限制重试的基础延迟（毫秒，0表示默认2000）
+	EmbedRetryMaxMS        int    // 速率限制重试的延迟上限（毫秒，0表示默认8000）
+	EmbedTimeoutSec        int    // 单次嵌入请求的超时时间（秒，0表示默认120秒）
+
+	// 磁盘嵌入缓存（EMBED_CACHE_DIR / EMBED_CACHE_MAX_MB）：文档向量按"模型+内容"哈希
+	// 落盘，重新摄取未变化的内容时复用缓存向量；目录为空表示禁用
+	EmbedCacheDir   string
+	EmbedCacheMaxMB int
+
+	// 文档加载配置
+	// OCR文本归一化（默认开启，只影响OCR产出的文档）：去掉汉字间空格、全角转半角
+	OCRNormalize     bool
+	OCRSubstitutions []string // OCR错字替换规则（OCR_SUBSTITUTIONS，"错字=>正字"，分号分隔）
+
+	PDFBackend string // PDF文本提取后端: "builtin"（默认）、"pdftotext" 或 "pdfcpu"
 
 	// 文本切分配置
 	ChunkSize    int
 	ChunkOverlap int
 
+	// 单个文档允许产生的最大chunk数量（0表示不限制），防止超大文档挤占整个集合
+	MaxChunksPerDoc int
+	ChunkCapMode    string // 超限处理方式: "grow"（放大chunk大小压进上限）或 "reject"（拒绝该文档）
+
+	// 摄取时为大文档生成LLM摘要chunk（Metadata["type"]="summary"），供"这份文件讲了什么"类宽泛问题检索
+	GenerateSummaries bool
+
+	// 入库前PII脱敏（REDACT_PII）：向量化和存储前把身份证号、手机号、邮箱等替换为掩码
+	RedactPII      bool
+	RedactPatterns []string // 追加的自定义脱敏正则（REDACT_PATTERNS，分号分隔）
+
+	// 检索配置
+	NeighborWindow int  // 邻近块扩展窗口大小（0表示禁用，1表示前后各取1块）
+	KeywordMinGram int  // 重排序关键词的最小长度（按rune计）
+	KeywordMaxGram int  // 重排序关键词的最大长度（按rune计）
+	FuzzyMatch     bool // 重排序关键词是否启用编辑距离模糊匹配（召回形态变化和OCR错字）
+	// 相关性过滤/重排序严格匹配全部落空时保底保留的结果数量（0表示默认3）
+	RelevanceFallbackCount int
+	EnforceCitations       bool // 答案缺少引用标注时是否重新提示LLM补充/附加兜底标注
+
+	// 进入提示词的每个来源文档的chunk数量上限（0表示不限制）
+	// 设置后单个冗长文档不会占满topK预算，上下文能覆盖更多不同来源
+	MaxChunksPerSource int
+
+	// 检索多样性：topK结果至少覆盖的不同来源数量（0表示禁用）
+	// 启用后结果集中在单个文件时，会换入候选池中其他来源的块
+	MinDistinctSources int
+
+	// 多轮对话承接：追问时把最近几轮对话的关键词带入检索查询（0表示禁用承接）
+	HistoryCarryTurns int
+
+	// 检索结果缓存TTL（秒，0表示禁用）：相同问题短时间内复用向量检索结果，降低Qdrant负载
+	RetrievalCacheTTL int
+
+	// 查询改写的提示词模板（空表示禁用）：检索前用LLM按模板把问题改写成独立、完整的检索查询
+	// 模板中{question}替换为当前问题，{history}替换为按行拼接的历史问题
+	QueryRewritePrompt string
+
+	// 答案引用标注的默认展示风格：inline（保持圆圈数字标注）、footnote（改写为
+	// [1][2]脚注并追加参考来源列表）、none（去掉所有标注），请求可按次覆盖
+	CitationStyle string
+
+	// 透传到引用（chunk结果）中的元数据字段白名单（分号分隔）
+	// 只透传白名单内的字段，避免把敏感元数据（如内部路径）泄漏给前端
+	CitationMetadataFields []string
+
+	// URL摄取的域名白名单（分号分隔，空表示允许所有域名）
+	// 白名单项匹配其自身及所有子域名
+	URLAllowedDomains []string
+
+	// 答案生成模式: "single"（单次生成，默认）或 "refine"（先初稿后分批送入剩余片段迭代修订）
+	// refine模式能覆盖超出单个提示词容量的素材，但会成倍增加LLM调用次数和延迟
+	GenerationMode      string
+	RefineMaxIterations int // refine模式的最大修订轮数（0表示使用默认值3）
+
+	// 上下文预算：进入提示词的片段总字符数上限（0表示不限制）
+	// 超限时按ContextStrategy处理: "drop-lowest-ranked"丢弃排名靠后的片段、
+	// "truncate"截断超出部分、"summarize-then-answer"先用LLM逐块摘要再回答（map-reduce）
+	MaxContextChars int
+	ContextStrategy string
+
+	// 未检索到相关信息时的提示语（空表示按问题语言自动选择中英文）
+	NoAnswerMessage string
+
+	// 通用知识兜底（ALLOW_GENERAL_KNOWLEDGE）：检索落空时改用LLM通用知识作答，
+	// 答案带"此回答不基于知识库"声明且fromKnowledgeBase为false；默认关闭（严格知识库边界）
+	AllowGeneralKnowledge bool
+
+	// 答案后处理：清理LLM泄漏的提示词痕迹（如"根据文档片段1"）并归并重复的引用标注
+	AnswerCleanup       bool
+	AnswerStripPatterns []string // 额外的清理正则（ANSWER_STRIP_PATTERNS，分号分隔）
+
+	// 答案的最大字符数（按rune计，0表示不限制）：超限时服务端在句子边界截断并标记truncated
+	MaxAnswerChars int
+
+	// 文档分组的并发阈值（DOC_GROUP_PARALLEL_THRESHOLD）：被标注使用的chunk数量
+	// 超过该值才并发组装docGroups，小结果集顺序处理；0表示默认32
+	DocGroupParallelThreshold int
+
 	// 服务器配置
-	ServerMode string // 默认运行模式: "server", "query", "load", "load-dir"
-	ServerPort string // 服务器端口
+	ServerMode      string // 默认运行模式: "server", "query", "load", "load-dir"
+	ServerPort      string // 服务器端口
+	Warmup          bool   // 启动时是否预热（一次丢弃结果的嵌入和检索，加快第一个真实查询）
+	BootstrapDir    string // 首次启动（集合为空）时自动导入的种子文档目录（空表示禁用）
+	LoadConcurrency int    // 批量上传时并发解析/切分文件的工作协程数（0表示取GOMAXPROCS，上限也是GOMAXPROCS）
+	StrictStartup   bool   // 启动时对LLM做一次最小生成自检，把模型名/凭证问题在部署阶段暴露
 
 	// MySQL 配置（用于意见反馈等业务数据存储）
 	MySQLDSN string // 例如: user:password@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4&parseTime=true&loc=Local
@@ -57,10 +246,86 @@ func LoadConfig() *Config {
 		DashScopeModel: getEnv("DASHSCOPE_MODEL", "qwen3-max"),                                            // 使用qwen3-max
 		MoonshotAPIKey: getEnv("MOONSHOT_API_KEY", "sk-xvtLcD5Gvzq8vxCOeEo8pEqMeqss8T8jIBx0Xdr8BcgX6aog"), // Kimi2 (Moonshot AI) API Key
 		MoonshotModel:  getEnv("MOONSHOT_MODEL", "moonshot-v1-8k"),                                        // 默认使用moonshot-v1-8k
+		// 显式请求message格式（响应解析对text格式同样兼容），置空可回到API默认
+		DashScopeResultFormat: getEnv("DASHSCOPE_RESULT_FORMAT", "message"),
+
+		// 系统提示词（persona）：支持system消息的provider通过system消息传递，Ollama前置到提示词
+		// 检索相关的指令固定在RAG的buildPrompt中，这里只定制助手的角色和语气
+		SystemPrompt: getEnv("SYSTEM_PROMPT", "你是一个专业的AI助手。"),
+
+		// LLM并发限制（0表示不限制，保持历史行为；突发查询时排队而不是同时打满上游配额）
+		LLMMaxConcurrency: getEnvInt("LLM_MAX_CONCURRENCY", 0),
+
+		// 上传并发限制（0表示不限制；超限的上传请求直接返回503，不排队）
+		MaxConcurrentUploads: getEnvInt("MAX_CONCURRENT_UPLOADS", 0),
+
+		// 向量存储后端（默认Qdrant，可选Milvus）
+		VectorStore: getEnv("VECTOR_STORE", "qdrant"),
+
+		// 最小文档长度（默认0，不限制）
+		MinDocChars: getEnvInt("MIN_DOC_CHARS", 0),
+
+		// 内容清洗模式（默认standard）
+		CleanMode: getEnv("CLEAN_MODE", "standard"),
+
+		// 按文件类型分片（默认关闭，保持单集合行为）
+		ShardByType: getEnv("SHARD_BY_TYPE", "false") == "true",
+
+		// 健康检查端点（默认/api/health，不要求token）
+		HealthPath:  getEnv("HEALTH_PATH", "/api/health"),
+		HealthToken: getEnv("HEALTH_TOKEN", ""),
+
+		// 置顶文档的重排序加分（默认500，0关闭）
+		PinnedBoost: getEnvInt("PINNED_BOOST", 500),
+
+		// JSON请求体大小上限（默认1024KB，0关闭）
+		MaxJSONBodyKB: getEnvInt("MAX_JSON_BODY_KB", 1024),
+
+		// 提示词文档排序策略（默认按相关度）
+		PromptOrder: getEnv("PROMPT_ORDER", "relevance"),
+
+		// 查询管线总时间预算（默认不限制）
+		QueryBudgetMS: getEnvInt("QUERY_BUDGET_MS", 0),
+
+		// 查询轨迹导出（默认关闭；采样率默认1.0，即开启后全量记录）
+		TraceDir:        getEnv("TRACE_DIR", ""),
+		TraceSampleRate: getEnvFloat("TRACE_SAMPLE_RATE", 1.0),
+
+		// Qdrant payload字段名（默认与langchaingo一致）
+		QdrantContentKey: getEnv("QDRANT_CONTENT_KEY", "content"),
+		QdrantSourceKey:  getEnv("QDRANT_SOURCE_KEY", "source"),
+
+		// 回答语言（默认跟随提示词行为）
+		AnswerLanguage: getEnv("ANSWER_LANGUAGE", ""),
+
+		// 答案页眉/页脚（默认不追加）
+		AnswerHeader: getEnv("ANSWER_HEADER", ""),
+		AnswerFooter: getEnv("ANSWER_FOOTER", ""),
+
+		// 多集合扇出检索（默认不配置额外集合；单集合超时10秒，并发上限4）
+		SearchCollections: getEnvList("SEARCH_COLLECTIONS"),
+		FanOutTimeoutMS:   getEnvInt("FANOUT_TIMEOUT_MS", 10000),
+		FanOutConcurrency: getEnvInt("FANOUT_CONCURRENCY", 4),
 
 		QdrantURL:      getEnv("QDRANT_URL", "http://localhost:6333"),
 		QdrantAPIKey:   getEnv("QDRANT_API_KEY", ""),
 		CollectionName: getEnv("QDRANT_COLLECTION", "personal_kb"),
+		// 维度不匹配处理方式：migrate（迁移到新集合并重建索引，原集合保留为备份）、
+		// recreate（删除重建，会丢失已有向量）、fail（启动失败，手动处理）
+		OnDimMismatch: getEnv("ON_DIM_MISMATCH", "recreate"),
+		// 写入等待：默认开启，AddDocuments返回时新增的点已可被检索（修复"刚上传就查不到"）
+		QdrantWaitWrites: getEnv("QDRANT_WAIT_WRITES", "true") == "true",
+		// 瞬时故障重试与熔断：重试耗尽计一次失败，连续失败达到阈值后冷却期内快速失败
+		QdrantMaxRetries:       getEnvInt("QDRANT_MAX_RETRIES", 2),
+		QdrantBreakerThreshold: getEnvInt("QDRANT_BREAKER_THRESHOLD", 5),
+		QdrantBreakerCooldown:  getEnvInt("QDRANT_BREAKER_COOLDOWN", 30),
+		// 定期触发优化器清理软删除点（分钟，0表示只能通过 /api/admin/optimize 手动触发）
+		OptimizeIntervalMin: getEnvInt("OPTIMIZE_INTERVAL_MIN", 0),
+
+		// Milvus配置（VECTOR_STORE=milvus 时使用，集合名称复用 QDRANT_COLLECTION）
+		MilvusAddr:     getEnv("MILVUS_ADDR", "localhost:19530"),
+		MilvusUsername: getEnv("MILVUS_USERNAME", ""),
+		MilvusPassword: getEnv("MILVUS_PASSWORD", ""),
 
 		// 嵌入模型配置
 		// 支持 provider: "ollama" 或 "siliconflow"
@@ -70,16 +335,102 @@ func LoadConfig() *Config {
 		// 注意：bge-large-zh-v1.5 的向量维度是 1024，bge-small-zh-v1.5 是 512
 		EmbeddingProvider:  getEnv("EMBEDDING_PROVIDER", "siliconflow"),         // 默认使用硅基流动
 		EmbeddingModelName: getEnv("EMBEDDING_MODEL", "BAAI/bge-large-zh-v1.5"), // 默认使用BAAI/bge-large-zh-v1.5（带前缀）
-		EmbeddingModelURL:  getEnv("EMBEDDING_MODEL_URL", ""),
-		SiliconFlowAPIKey:  getEnv("SILICONFLOW_API_KEY", "sk-nbgejyepvdcheitaxawefhnyorxzkyphxwzndxfamgfkhwdb"),
+		// 查询/存储模型分离：文档入库用便宜的模型、查询用效果更好的模型（维度必须一致）
+		QueryEmbeddingModel: getEnv("QUERY_EMBEDDING_MODEL", ""),
+		// 查询专用provider（QUERY_EMBEDDING_PROVIDER）：查询路径使用低延迟的provider
+		QueryEmbeddingProvider: getEnv("QUERY_EMBEDDING_PROVIDER", ""),
+		EmbeddingModelURL:      getEnv("EMBEDDING_MODEL_URL", ""),
+		SiliconFlowAPIKey:      getEnv("SILICONFLOW_API_KEY", "sk-nbgejyepvdcheitaxawefhnyorxzkyphxwzndxfamgfkhwdb"),
+
+		// 嵌入批次配置（按账号的TPM额度调整，无需改代码）
+		// 注意：硅基流动API单次请求最大32条，超过时embedder内部会自动拆分
+		EmbedBatchSize:    getEnvInt("EMBED_BATCH_SIZE", 0),     // 0表示按文档数量自动选择（20/25/30）
+		EmbedBatchDelayMS: getEnvInt("EMBED_BATCH_DELAY_MS", 0), // 0表示按批次大小自动计算
+		EmbedRetryBaseMS:  getEnvInt("EMBED_RETRY_BASE_MS", 0),  // 0表示默认2000
+		EmbedRetryMaxMS:   getEnvInt("EMBED_RETRY_MAX_MS", 0),   // 0表示默认8000
+		EmbedTimeoutSec:   getEnvInt("EMBED_TIMEOUT", 0),        // 单次嵌入请求超时（秒），0表示默认120秒
+
+		// 磁盘嵌入缓存（默认禁用，上限512MB）
+		EmbedCacheDir:   getEnv("EMBED_CACHE_DIR", ""),
+		EmbedCacheMaxMB: getEnvInt("EMBED_CACHE_MAX_MB", 512),
+
+		// PDF文本提取后端：builtin（内置解析器）、pdftotext（poppler，中文提取效果更好）、pdfcpu
+		// 外部工具不可用时自动回退到builtin
+		PDFBackend: getEnv("PDF_BACKEND", "builtin"),
+
+		// OCR文本归一化（OCR_NORMALIZE，默认开启）与错字替换规则（OCR_SUBSTITUTIONS）
+		OCRNormalize:     getEnv("OCR_NORMALIZE", "true") == "true",
+		OCRSubstitutions: getEnvList("OCR_SUBSTITUTIONS"),
 
 		// 注意：BAAI/bge-large-zh-v1.5 有512 tokens的限制，建议使用较小的chunk-size
 		ChunkSize:    500, // 默认500字符，适合BAAI/bge-large-zh-v1.5的token限制
 		ChunkOverlap: 100, // 默认100字符重叠
+		// 单个文档的chunk数量上限（0表示不限制）及超限处理方式
+		MaxChunksPerDoc: getEnvInt("MAX_CHUNKS_PER_DOC", 0),
+		ChunkCapMode:    getEnv("CHUNK_CAP_MODE", "grow"),
+		// 摄取时为大文档生成LLM摘要chunk（会增加上传耗时和LLM调用量）
+		GenerateSummaries: getEnv("GENERATE_SUMMARIES", "false") == "true",
+
+		// 检索配置
+		// 邻近块扩展：命中某个文本块时，额外取它前后相邻的块补充上下文
+		NeighborWindow: getEnvInt("NEIGHBOR_WINDOW", 1),
+		// 重排序关键词长度范围：分词后只保留长度在此范围内的词
+		KeywordMinGram: getEnvInt("KEYWORD_MIN_GRAM", 2),
+		KeywordMaxGram: getEnvInt("KEYWORD_MAX_GRAM", 8),
+		// 关键词模糊匹配：精确匹配失败时退化为编辑距离匹配（默认关闭保持精确匹配）
+		FuzzyMatch: getEnv("FUZZY_MATCH", "false") == "true",
+		// 严格匹配落空时的保底结果数量（相关性过滤与重排序共用）
+		RelevanceFallbackCount: getEnvInt("RELEVANCE_FALLBACK_COUNT", 3),
+		// 引用标注校验：答案没有①②标注时重新提示LLM补充一次，仍失败则附加最相关片段的标注
+		EnforceCitations: getEnv("ENFORCE_CITATIONS", "false") == "true",
+		// 每个来源文档进入提示词的chunk数量上限（0表示不限制）
+		MaxChunksPerSource: getEnvInt("MAX_CHUNKS_PER_SOURCE", 0),
+		// 检索多样性：topK结果至少覆盖的不同来源数量（0表示禁用）
+		MinDistinctSources: getEnvInt("MIN_DISTINCT_SOURCES", 0),
+		// 多轮对话承接：最近几轮对话的关键词带入检索查询（0表示禁用）
+		HistoryCarryTurns: getEnvInt("HISTORY_CARRY_TURNS", 2),
+		// 检索结果缓存（秒，0表示禁用；文档增删时自动失效）
+		RetrievalCacheTTL: getEnvInt("RETRIEVAL_CACHE_TTL", 0),
+
+		// 入库前PII脱敏（默认关闭）：内置身份证号/手机号/邮箱规则，REDACT_PATTERNS可追加自定义正则
+		RedactPII:      getEnv("REDACT_PII", "false") == "true",
+		RedactPatterns: getEnvList("REDACT_PATTERNS"),
+		// 查询改写模板（空表示禁用，{question}/{history}为占位符）
+		QueryRewritePrompt: getEnv("QUERY_REWRITE_PROMPT", ""),
+		// 答案引用标注的默认展示风格（CITATION_STYLE：inline/footnote/none）
+		CitationStyle: getEnv("CITATION_STYLE", "inline"),
+
+		// 引用元数据透传白名单（CITATION_METADATA_FIELDS，分号分隔）
+		CitationMetadataFields: getEnvListDefault("CITATION_METADATA_FIELDS", []string{"page", "heading", "tags", "author", "date"}),
+		// URL摄取域名白名单（分号分隔，空表示允许所有域名）
+		URLAllowedDomains: getEnvList("URL_ALLOWED_DOMAINS"),
+		// 答案生成模式与refine修订轮数
+		GenerationMode:      getEnv("GENERATION_MODE", "single"),
+		RefineMaxIterations: getEnvInt("REFINE_MAX_ITERATIONS", 3),
+		// 上下文预算与超限策略（0表示不限制）
+		MaxContextChars: getEnvInt("MAX_CONTEXT_CHARS", 0),
+		ContextStrategy: getEnv("CONTEXT_STRATEGY", "drop-lowest-ranked"),
+		// 未检索到相关信息时的提示语（默认按问题语言自动选择：中文问题返回中文，其他返回英文）
+		NoAnswerMessage: getEnv("NO_ANSWER_MESSAGE", ""),
+
+		// 通用知识兜底（默认关闭）
+		AllowGeneralKnowledge: getEnv("ALLOW_GENERAL_KNOWLEDGE", "false") == "true",
+		// 答案后处理（保守的正则清理，默认开启；额外模式用分号分隔）
+		AnswerCleanup:       getEnv("ANSWER_CLEANUP", "true") == "true",
+		AnswerStripPatterns: getEnvList("ANSWER_STRIP_PATTERNS"),
+		// 答案长度硬上限（0表示不限制）
+		MaxAnswerChars: getEnvInt("MAX_ANSWER_CHARS", 0),
+
+		// 文档分组的并发阈值（默认32）
+		DocGroupParallelThreshold: getEnvInt("DOC_GROUP_PARALLEL_THRESHOLD", 32),
 
 		// 服务器配置（默认启动服务器模式）
-		ServerMode: getEnv("SERVER_MODE", "server"), // 默认模式: server（启动API服务器）
-		ServerPort: getEnv("SERVER_PORT", "8005"),   // 默认端口: 8005
+		ServerMode:      getEnv("SERVER_MODE", "server"),             // 默认模式: server（启动API服务器）
+		ServerPort:      getEnv("SERVER_PORT", "8005"),               // 默认端口: 8005
+		Warmup:          getEnv("WARMUP", "false") == "true",         // 启动预热，降低重启后首个查询的延迟
+		BootstrapDir:    getEnv("BOOTSTRAP_DIR", ""),                 // 集合为空时自动导入的种子文档目录
+		LoadConcurrency: getEnvInt("LOAD_CONCURRENCY", 0),            // 批量上传的并发解析数，0表示取GOMAXPROCS
+		StrictStartup:   getEnv("STRICT_STARTUP", "false") == "true", // 启动时对LLM做最小生成自检
 
 		// MySQL 配置（可选，如果不配置则不启用数据库相关功能）
 		// 注意：必须包含 parseTime=true 参数，否则无法正确解析时间字段
@@ -100,6 +451,48 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvList 从环境变量读取分号分隔的列表配置，未设置时返回nil
+func getEnvListDefault(key string, defaultValue []string) []string {
+	if items := getEnvList(key); items != nil {
+		return items
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvInt 从环境变量读取整数配置，解析失败时使用默认值
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getEnvFloat 获取浮点数类型的环境变量，解析失败时返回默认值
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 // Validate 验证配置
 func (c *Config) Validate() error {
 	// 验证LLM配置
@@ -122,12 +515,36 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("不支持的LLM Provider: %s，支持的值: ollama, dashscope, kimi", c.LLMProvider)
 	}
 
-	if c.QdrantURL == "" {
-		return fmt.Errorf("QDRANT_URL is required")
+	// 验证向量存储配置
+	if c.VectorStore == "qdrant" {
+		if c.QdrantURL == "" {
+			return fmt.Errorf("QDRANT_URL is required")
+		}
+	} else if c.VectorStore == "milvus" {
+		if c.MilvusAddr == "" {
+			return fmt.Errorf("使用Milvus时需要设置 MILVUS_ADDR 环境变量")
+		}
+	} else {
+		return fmt.Errorf("不支持的向量存储后端: %s，支持的值: qdrant, milvus", c.VectorStore)
 	}
 	if c.CollectionName == "" {
 		return fmt.Errorf("QDRANT_COLLECTION is required")
 	}
+	if c.OnDimMismatch != "migrate" && c.OnDimMismatch != "recreate" && c.OnDimMismatch != "fail" {
+		return fmt.Errorf("不支持的 ON_DIM_MISMATCH: %s，支持的值: migrate, recreate, fail", c.OnDimMismatch)
+	}
+	if c.PDFBackend != "builtin" && c.PDFBackend != "pdftotext" && c.PDFBackend != "pdfcpu" {
+		return fmt.Errorf("不支持的 PDF_BACKEND: %s，支持的值: builtin, pdftotext, pdfcpu", c.PDFBackend)
+	}
+	if c.ChunkCapMode != "grow" && c.ChunkCapMode != "reject" {
+		return fmt.Errorf("不支持的 CHUNK_CAP_MODE: %s，支持的值: grow, reject", c.ChunkCapMode)
+	}
+	if c.GenerationMode != "single" && c.GenerationMode != "refine" {
+		return fmt.Errorf("不支持的 GENERATION_MODE: %s，支持的值: single, refine", c.GenerationMode)
+	}
+	if c.ContextStrategy != "drop-lowest-ranked" && c.ContextStrategy != "truncate" && c.ContextStrategy != "summarize-then-answer" {
+		return fmt.Errorf("不支持的 CONTEXT_STRATEGY: %s，支持的值: drop-lowest-ranked, truncate, summarize-then-answer", c.ContextStrategy)
+	}
 	// 如果使用硅基流动，需要API Key
 	if c.EmbeddingProvider == "siliconflow" && c.SiliconFlowAPIKey == "" {
 		return fmt.Errorf("使用硅基流动时需要设置 SILICONFLOW_API_KEY 环境变量")
@@ -0,0 +1,169 @@
+package embedding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 磁盘嵌入缓存（EMBED_CACHE_DIR / EMBED_CACHE_MAX_MB）
+// 向量化是重索引时最贵的一步：同样的chunk内容每次重建集合都要重新调API。
+// 启用磁盘缓存后，文档向量化的结果按"模型名+chunk内容"的哈希落盘，
+// 重新摄取未变化的内容时直接复用缓存向量，不再调用嵌入API。
+// 缓存跨重启持久，与进程内的检索缓存互补；超出大小上限时按最近使用时间淘汰。
+
+const (
+	// embedCachePruneEvery 每写入多少个向量检查一次缓存大小（遍历目录有成本，不必每次写都做）
+	embedCachePruneEvery = 256
+	// embedCachePruneTarget 淘汰后把缓存压到上限的比例（留出余量，避免每次写入都触发淘汰）
+	embedCachePruneTarget = 0.9
+)
+
+var (
+	embedCacheMu       sync.Mutex
+	embedCacheDir      string
+	embedCacheMaxBytes int64
+	embedCacheWrites   int
+)
+
+// SetDiskCache 设置磁盘嵌入缓存的目录和大小上限（EMBED_CACHE_DIR / EMBED_CACHE_MAX_MB）
+// 目录为空表示禁用（默认行为）；目录不可创建时告警并保持禁用
+func SetDiskCache(dir string, maxMB int) {
+	embedCacheMu.Lock()
+	defer embedCacheMu.Unlock()
+	if dir == "" {
+		embedCacheDir = ""
+		return
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		logger.Warn("⚠️ 无法创建嵌入缓存目录 %s，磁盘嵌入缓存已禁用: %v", dir, err)
+		embedCacheDir = ""
+		return
+	}
+	embedCacheDir = dir
+	if maxMB > 0 {
+		embedCacheMaxBytes = int64(maxMB) * 1024 * 1024
+	} else {
+		embedCacheMaxBytes = 0 // 不限制大小
+	}
+	logger.Info("✅ 磁盘嵌入缓存已启用: %s (上限: %dMB)", dir, maxMB)
+}
+
+// embedCacheKey 计算缓存键：模型名+chunk内容的SHA-256
+// 模型参与哈希，换嵌入模型后旧缓存自然失效，不会混用不同向量空间的向量
+func embedCacheKey(model, text string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// embedCachePath 缓存文件路径（按哈希前两位分目录，避免单目录文件过多）
+func embedCachePath(dir, key string) string {
+	return filepath.Join(dir, key[:2], key+".json")
+}
+
+// loadCachedVector 从磁盘读取缓存的向量，命中时刷新文件的修改时间（供LRU淘汰）
+func loadCachedVector(key string) ([]float32, bool) {
+	embedCacheMu.Lock()
+	dir := embedCacheDir
+	embedCacheMu.Unlock()
+	if dir == "" {
+		return nil, false
+	}
+
+	path := embedCachePath(dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var vector []float32
+	if err := json.Unmarshal(data, &vector); err != nil || len(vector) == 0 {
+		// 损坏的缓存文件直接删掉，下次重新计算
+		os.Remove(path)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return vector, true
+}
+
+// storeCachedVector 把向量写入磁盘缓存，定期检查大小并淘汰最久未使用的条目
+// 写入失败只告警：缓存是加速手段，不能影响摄取流程
+func storeCachedVector(key string, vector []float32) {
+	embedCacheMu.Lock()
+	dir := embedCacheDir
+	embedCacheMu.Unlock()
+	if dir == "" || len(vector) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(vector)
+	if err != nil {
+		return
+	}
+	path := embedCachePath(dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		logger.Warn("⚠️ 写入嵌入缓存失败: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		logger.Warn("⚠️ 写入嵌入缓存失败: %v", err)
+		return
+	}
+
+	embedCacheMu.Lock()
+	embedCacheWrites++
+	shouldPrune := embedCacheMaxBytes > 0 && embedCacheWrites%embedCachePruneEvery == 0
+	maxBytes := embedCacheMaxBytes
+	embedCacheMu.Unlock()
+	if shouldPrune {
+		pruneEmbedCache(dir, maxBytes)
+	}
+}
+
+// pruneEmbedCache 缓存超出大小上限时，按修改时间从旧到新删除，压到上限的九成以下
+func pruneEmbedCache(dir string, maxBytes int64) {
+	type cacheEntry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []cacheEntry
+	var total int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if total <= maxBytes {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	target := int64(float64(maxBytes) * embedCachePruneTarget)
+	removed := 0
+	for _, entry := range entries {
+		if total <= target {
+			break
+		}
+		if err := os.Remove(entry.path); err == nil {
+			total -= entry.size
+			removed++
+		}
+	}
+	logger.Info("🔍 嵌入缓存淘汰: 删除 %d 个最久未使用的条目，当前大小 %.1fMB", removed, float64(total)/1024/1024)
+}
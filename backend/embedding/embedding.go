@@ -15,20 +15,31 @@ type EmbedderInterface interface {
 	EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error)
 	EmbedQuery(ctx context.Context, text string) ([]float32, error)
 	GetDimensions() int
+	MaxBatch() int // 单次请求允许的最大文本数量（0表示不限制）
 }
 
 // Embedder 嵌入向量生成器（支持多种后端）
 type Embedder struct {
-	embedder EmbedderInterface
-	provider string
+	embedder  EmbedderInterface
+	provider  string
+	modelName string // 磁盘嵌入缓存的键的一部分：换模型后旧缓存自然失效
+
+	// 查询专用嵌入模型（通过UseQueryModel设置，可选）：EmbedQuery改走该模型，文档向量化仍走embedder
+	queryEmbedder EmbedderInterface
 }
 
-// NewEmbedder 创建新的嵌入向量生成器
+// NewEmbedder 创建新的嵌入向量生成器（使用默认的嵌入请求超时）
 // provider: "ollama" 或 "siliconflow"
 // baseURL: Ollama服务器地址（仅用于ollama provider）
 // modelName: 模型名称
 // apiKey: API密钥（仅用于siliconflow provider）
 func NewEmbedder(provider, baseURL, modelName, apiKey string) (*Embedder, error) {
+	return NewEmbedderWithTimeout(provider, baseURL, modelName, apiKey, 0)
+}
+
+// NewEmbedderWithTimeout 创建新的嵌入向量生成器，并指定嵌入请求的超时时间（EMBED_TIMEOUT）
+// embedTimeout传0表示使用默认超时；目前仅siliconflow provider支持超时配置
+func NewEmbedderWithTimeout(provider, baseURL, modelName, apiKey string, embedTimeout time.Duration) (*Embedder, error) {
 	// 如果没有指定provider，默认使用ollama
 	if provider == "" {
 		provider = "ollama"
@@ -40,13 +51,14 @@ func NewEmbedder(provider, baseURL, modelName, apiKey string) (*Embedder, error)
 		if modelName == "" {
 			modelName = "BAAI/bge-large-zh-v1.5" // 默认模型（带前缀）
 		}
-		embedder, err := NewSiliconFlowEmbedder(apiKey, modelName)
+		embedder, err := NewSiliconFlowEmbedderWithTimeout(apiKey, modelName, embedTimeout)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create siliconflow embedder: %w", err)
 		}
 		return &Embedder{
-			embedder: embedder,
-			provider: "siliconflow",
+			embedder:  embedder,
+			provider:  "siliconflow",
+			modelName: modelName,
 		}, nil
 
 	case "ollama":
@@ -67,8 +79,9 @@ func NewEmbedder(provider, baseURL, modelName, apiKey string) (*Embedder, error)
 		}
 
 		return &Embedder{
-			embedder: &OllamaEmbedderWrapper{embedder: embedder},
-			provider: "ollama",
+			embedder:  &OllamaEmbedderWrapper{embedder: embedder},
+			provider:  "ollama",
+			modelName: modelName,
 		}, nil
 	}
 }
@@ -90,13 +103,47 @@ func (o *OllamaEmbedderWrapper) GetDimensions() int {
 	return 512 // bge-small-zh-v1.5 的维度是 512
 }
 
+func (o *OllamaEmbedderWrapper) MaxBatch() int {
+	return 0 // Ollama本地服务没有单次请求数量限制
+}
+
 // EmbedDocuments 将文档转换为向量
+// 启用磁盘嵌入缓存（EMBED_CACHE_DIR）时，先按"模型名+内容"哈希查缓存，
+// 只对未命中的文本调用嵌入API，结果写回缓存；重新摄取未变化的内容不再调API
 func (e *Embedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	// 先查磁盘缓存，收集未命中的文本
+	vectors := make([][]float32, len(texts))
+	keys := make([]string, len(texts))
+	missIndexes := make([]int, 0, len(texts))
+	for i, text := range texts {
+		keys[i] = embedCacheKey(e.modelName, text)
+		if cached, ok := loadCachedVector(keys[i]); ok {
+			vectors[i] = cached
+		} else {
+			missIndexes = append(missIndexes, i)
+		}
+	}
+	if len(missIndexes) == 0 {
+		if len(texts) > 0 {
+			logger.Info("    [向量化 %s] %d 个文档全部命中磁盘缓存，跳过API调用", e.provider, len(texts))
+		}
+		return vectors, nil
+	}
+
+	missTexts := make([]string, len(missIndexes))
+	for i, idx := range missIndexes {
+		missTexts[i] = texts[idx]
+	}
+
 	// 添加详细日志以便诊断
-	logger.Info("    [向量化 %s] 开始处理 %d 个文档...", e.provider, len(texts))
+	if hits := len(texts) - len(missIndexes); hits > 0 {
+		logger.Info("    [向量化 %s] %d 个文档命中磁盘缓存，开始处理剩余 %d 个...", e.provider, hits, len(missTexts))
+	} else {
+		logger.Info("    [向量化 %s] 开始处理 %d 个文档...", e.provider, len(missTexts))
+	}
 	startTime := time.Now()
 
-	vectors, err := e.embedder.EmbedDocuments(ctx, texts)
+	missVectors, err := e.embedder.EmbedDocuments(ctx, missTexts)
 
 	duration := time.Since(startTime)
 	if err != nil {
@@ -104,17 +151,50 @@ func (e *Embedder) EmbedDocuments(ctx context.Context, texts []string) ([][]floa
 	} else {
 		logger.Info(" ✅ 完成 (耗时: %v, 速度: %.1f 文档/秒)",
 			duration.Round(time.Millisecond),
-			float64(len(texts))/duration.Seconds())
+			float64(len(missTexts))/duration.Seconds())
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to embed documents: %w", err)
 	}
+	if len(missVectors) != len(missTexts) {
+		return nil, fmt.Errorf("向量数量 (%d) 与文本数量 (%d) 不匹配", len(missVectors), len(missTexts))
+	}
+
+	for i, idx := range missIndexes {
+		vectors[idx] = missVectors[i]
+		storeCachedVector(keys[idx], missVectors[i])
+	}
 	return vectors, nil
 }
 
+// UseQueryModel 启用查询/存储嵌入分离（QUERY_EMBEDDING_PROVIDER / QUERY_EMBEDDING_MODEL）
+// 文档向量化仍使用原模型，查询向量化改用queryEmbedder的provider/模型；
+// 两边的向量会在同一集合中比较，维度不一致时报错（启动自检，向量空间不兼容）
+func (e *Embedder) UseQueryModel(queryEmbedder *Embedder) error {
+	if queryEmbedder == nil {
+		return nil
+	}
+	if e.GetDimensions() != queryEmbedder.GetDimensions() {
+		return fmt.Errorf("查询嵌入（%s）维度 %d 与存储嵌入（%s）维度 %d 不一致，向量空间不兼容，无法启用查询嵌入分离",
+			queryEmbedder.provider, queryEmbedder.GetDimensions(), e.provider, e.GetDimensions())
+	}
+	if e.provider != queryEmbedder.provider {
+		// 维度一致只是必要条件：跨provider时两边必须提供同一个嵌入模型，
+		// 否则向量空间不同，检索结果会完全失真
+		logger.Warn("⚠️ 查询嵌入provider（%s）与存储嵌入provider（%s）不同，请确认两边部署的是同一个嵌入模型", queryEmbedder.provider, e.provider)
+	}
+	e.queryEmbedder = queryEmbedder.embedder
+	logger.Info("✅ 已启用查询专用嵌入（provider: %s，维度一致: %d）", queryEmbedder.provider, e.GetDimensions())
+	return nil
+}
+
 // EmbedQuery 将查询转换为向量
+// 设置了查询专用模型（QUERY_EMBEDDING_MODEL）时使用查询模型，否则与文档向量化共用同一模型
 func (e *Embedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	if e.queryEmbedder != nil {
+		return e.queryEmbedder.EmbedQuery(ctx, text)
+	}
 	return e.embedder.EmbedQuery(ctx, text)
 }
 
@@ -123,6 +203,12 @@ func (e *Embedder) GetDimensions() int {
 	return e.embedder.GetDimensions()
 }
 
+// MaxBatch 获取provider单次请求允许的最大文本数量（0表示不限制）
+// 超过上限的批次EmbedDocuments内部会自动拆分，调用方可用该值对齐自己的批次大小
+func (e *Embedder) MaxBatch() int {
+	return e.embedder.MaxBatch()
+}
+
 // Embedder 属性访问（用于兼容旧代码）
 func (e *Embedder) GetEmbedder() embeddings.Embedder {
 	// 如果是Ollama，返回原始embedder
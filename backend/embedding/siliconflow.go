@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"strings"
 	"time"
+	"unicode"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
 )
 
 // SiliconFlowEmbedder 硅基流动嵌入向量生成器
@@ -16,8 +19,12 @@ type SiliconFlowEmbedder struct {
 	apiKey  string
 	baseURL string
 	model   string
+	client  *http.Client // 共享HTTP客户端（连接复用，超时可通过EMBED_TIMEOUT配置）
 }
 
+// defaultEmbedTimeout 嵌入请求的默认超时时间
+const defaultEmbedTimeout = 120 * time.Second
+
 // SiliconFlowEmbeddingRequest 硅基流动API请求格式
 type SiliconFlowEmbeddingRequest struct {
 	Model string   `json:"model"`
@@ -45,14 +52,24 @@ func normalizeModelName(model string) string {
 	return model
 }
 
-// NewSiliconFlowEmbedder 创建硅基流动嵌入向量生成器
+// NewSiliconFlowEmbedder 创建硅基流动嵌入向量生成器（使用默认超时）
 func NewSiliconFlowEmbedder(apiKey, model string) (*SiliconFlowEmbedder, error) {
+	return NewSiliconFlowEmbedderWithTimeout(apiKey, model, 0)
+}
+
+// NewSiliconFlowEmbedderWithTimeout 创建硅基流动嵌入向量生成器，并指定请求超时时间
+// timeout传0表示使用默认超时（120秒）
+// 使用单个共享的HTTP客户端：连接可以复用，且请求通过ctx创建，调用方取消ctx时进行中的请求会被中止
+func NewSiliconFlowEmbedderWithTimeout(apiKey, model string, timeout time.Duration) (*SiliconFlowEmbedder, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("硅基流动API Key不能为空")
 	}
 	if model == "" {
 		model = "BAAI/bge-large-zh-v1.5" // 默认模型（带前缀）
 	}
+	if timeout <= 0 {
+		timeout = defaultEmbedTimeout
+	}
 
 	// 保留模型名称的原始格式（可能带BAAI/前缀）
 	model = normalizeModelName(model)
@@ -61,22 +78,117 @@ func NewSiliconFlowEmbedder(apiKey, model string) (*SiliconFlowEmbedder, error)
 		apiKey:  apiKey,
 		baseURL: "https://api.siliconflow.cn/v1", // 硅基流动API地址
 		model:   model,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConns:        100,              // 最大空闲连接数
+				MaxIdleConnsPerHost: 10,               // 每个主机的最大空闲连接数
+				IdleConnTimeout:     90 * time.Second, // 空闲连接超时
+			},
+		},
 	}, nil
 }
 
+// maxBatchSize 硅基流动API单次请求允许的最大文本数量
+const maxBatchSize = 32
+
+// MaxBatch 返回API单次请求允许的最大文本数量
+// 调用方传入更大的批次也能正常工作（EmbedDocuments内部会自动拆分），这里仅供调用方对齐批次大小
+func (s *SiliconFlowEmbedder) MaxBatch() int {
+	return maxBatchSize
+}
+
+// MaxTokens 返回模型单条输入允许的最大token数量
+// BAAI/bge-m3支持8192 tokens，其余bge系列模型的最大序列长度都是512 tokens
+func (s *SiliconFlowEmbedder) MaxTokens() int {
+	if strings.Contains(strings.ToLower(s.model), "bge-m3") {
+		return 8192
+	}
+	return 512
+}
+
+// estimatedTokenBudget 按保守的启发式把token预算换算成权重预算（1个CJK字符≈1 token，4个其他字符≈1 token）
+// 实际token数由API端的分词器决定，这里的估算偏保守：宁可多截一点，也不把超限文本交给API
+const tokenWeightScale = 4
+
+// truncateToMaxTokens 将单条文本截断到模型token上限以内
+// 返回截断后的文本和是否发生了截断
+func (s *SiliconFlowEmbedder) truncateToMaxTokens(text string) (string, bool) {
+	budget := s.MaxTokens() * tokenWeightScale
+	weight := 0
+	for i, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			weight += tokenWeightScale
+		} else {
+			weight++
+		}
+		if weight > budget {
+			return text[:i], true
+		}
+	}
+	return text, false
+}
+
+// truncateOversizedTexts 对超过模型token上限的文本做截断，避免API报错或静默截断
+// 发生截断时记录日志，提示调整chunk大小
+func (s *SiliconFlowEmbedder) truncateOversizedTexts(texts []string) []string {
+	truncatedCount := 0
+	result := texts
+	for i, text := range texts {
+		truncated, ok := s.truncateToMaxTokens(text)
+		if !ok {
+			continue
+		}
+		if truncatedCount == 0 {
+			// 写时复制：没有超长文本时不额外分配
+			result = make([]string, len(texts))
+			copy(result, texts)
+		}
+		result[i] = truncated
+		truncatedCount++
+		logger.Warn("⚠️ 第 %d 条文本超过模型 %s 的token上限 (约%d tokens)，已截断 %d -> %d 字符",
+			i+1, s.model, s.MaxTokens(), len(text), len(truncated))
+	}
+	if truncatedCount > 0 {
+		logger.Warn("⚠️ 共截断 %d 条超长文本，建议调小chunk-size以避免内容丢失", truncatedCount)
+	}
+	return result
+}
+
 // EmbedDocuments 批量向量化文档
-// 注意：硅基流动API最大批次大小为32，超过此限制会导致错误
+// 硅基流动API单次最多接受32条文本，超过时内部自动拆分成多次请求，对调用方透明
+// 超过模型token上限的文本会被截断（并记录日志），避免API报错或静默截断
 func (s *SiliconFlowEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
 	if len(texts) == 0 {
 		return nil, fmt.Errorf("文本列表不能为空")
 	}
 
-	// 安全检查：确保批次大小不超过API限制（32）
-	const maxBatchSize = 32
-	if len(texts) > maxBatchSize {
-		return nil, fmt.Errorf("批次大小 (%d) 超过API限制 (%d)，请减小批次大小", len(texts), maxBatchSize)
+	// 按模型token上限截断超长文本
+	texts = s.truncateOversizedTexts(texts)
+
+	// 不超过API限制时直接单次请求
+	if len(texts) <= maxBatchSize {
+		return s.embedBatch(ctx, texts)
 	}
 
+	// 超过API限制时按maxBatchSize拆分，依次请求后合并结果
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batchVectors, err := s.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batchVectors...)
+	}
+	return vectors, nil
+}
+
+// embedBatch 向量化单个批次（不超过maxBatchSize条文本）
+func (s *SiliconFlowEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
 	// 构建请求
 	reqBody := SiliconFlowEmbeddingRequest{
 		Model: s.model,
@@ -97,18 +209,8 @@ func (s *SiliconFlowEmbedder) EmbedDocuments(ctx context.Context, texts []string
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+s.apiKey)
 
-	// 发送请求
-	// 优化：使用连接池和更长的超时时间以提高性能
-	client := &http.Client{
-		Timeout: 120 * time.Second, // 增加超时时间到120秒（优化：从60秒增加）
-		Transport: &http.Transport{
-			MaxIdleConns:        100,              // 最大空闲连接数
-			MaxIdleConnsPerHost: 10,               // 每个主机的最大空闲连接数
-			IdleConnTimeout:     90 * time.Second, // 空闲连接超时
-		},
-	}
-
-	resp, err := client.Do(req)
+	// 发送请求（共享客户端：连接复用；请求携带ctx，上传被取消时进行中的嵌入请求会随之中止）
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("请求失败: %w", err)
 	}
@@ -16,10 +16,56 @@ import (
 
 // DashScopeLLM 通义千问大语言模型客户端
 type DashScopeLLM struct {
-	apiKey  string
-	model   string
-	baseURL string
-	client  *http.Client
+	apiKey       string
+	model        string
+	baseURL      string
+	client       *http.Client
+	systemPrompt string // 系统提示词（persona），为空时不发送system消息
+	jsonMode     bool   // 原生JSON输出模式（结构化输出responseFormat=json时启用）
+	resultFormat string // 显式请求的result_format（"message"或"text"，空表示用API默认）
+}
+
+// SetSystemPrompt 设置系统提示词，通过system角色消息传递给模型
+func (d *DashScopeLLM) SetSystemPrompt(prompt string) {
+	d.systemPrompt = prompt
+}
+
+// WithJSONMode 返回启用/关闭原生JSON输出模式的浅拷贝（原客户端不变，可安全共享）
+// DashScope的JSON模式要求result_format=message（响应解析已兼容choices格式）
+func (d *DashScopeLLM) WithJSONMode(enabled bool) LLM {
+	clone := *d
+	clone.jsonMode = enabled
+	return &clone
+}
+
+// SetResultFormat 显式指定DashScope的result_format参数
+// 新一代模型默认返回choices（message格式）、老模型返回text，响应解析两种都兼容；
+// 显式指定后不再依赖各代模型的默认行为，跨模型升级时行为稳定
+func (d *DashScopeLLM) SetResultFormat(format string) {
+	d.resultFormat = format
+}
+
+// warnIfTruncated 完成原因为长度截断时记录警告（答案被max_tokens截断，内容不完整）
+func warnIfTruncated(finishReason string) {
+	if finishReason == "length" || finishReason == "incomplete" {
+		logger.Warn("⚠️ [DashScope] 答案因token上限被截断（finish_reason=%s），内容可能不完整", finishReason)
+	}
+}
+
+// buildMessages 构建消息列表（设置了系统提示词时前置system消息）
+func (d *DashScopeLLM) buildMessages(prompt string) []map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, 2)
+	if d.systemPrompt != "" {
+		messages = append(messages, map[string]interface{}{
+			"role":    "system",
+			"content": d.systemPrompt,
+		})
+	}
+	messages = append(messages, map[string]interface{}{
+		"role":    "user",
+		"content": prompt,
+	})
+	return messages
 }
 
 // DashScopeRequest 请求结构
@@ -84,6 +130,10 @@ func NewDashScopeLLM(apiKey, model string) (*DashScopeLLM, error) {
 	if model == "" {
 		model = "qwen-turbo" // 默认模型
 	}
+	// 提前校验模型名，拼错时在部署阶段就报错，而不是第一次查询时返回晦涩的API错误
+	if err := validateKnownModel("dashscope", model); err != nil {
+		return nil, err
+	}
 
 	// DashScope API URL格式：https://dashscope.aliyuncs.com/api/v1/services/aigc/text-generation/generation
 	// 注意：模型名称在请求体中指定，不在URL中
@@ -104,12 +154,7 @@ func (d *DashScopeLLM) Generate(ctx context.Context, prompt string) (string, err
 	reqBody := map[string]interface{}{
 		"model": d.model,
 		"input": map[string]interface{}{
-			"messages": []map[string]interface{}{
-				{
-					"role":    "user",
-					"content": prompt,
-				},
-			},
+			"messages": d.buildMessages(prompt),
 		},
 		"parameters": map[string]interface{}{
 			"max_tokens":  2000,
@@ -118,6 +163,20 @@ func (d *DashScopeLLM) Generate(ctx context.Context, prompt string) (string, err
 		},
 	}
 
+	// 显式指定result_format（DASHSCOPE_RESULT_FORMAT配置），不依赖各代模型的默认行为
+	if d.resultFormat != "" {
+		parameters := reqBody["parameters"].(map[string]interface{})
+		parameters["result_format"] = d.resultFormat
+	}
+
+	// 原生JSON输出模式：DashScope要求result_format=message配合response_format使用
+	// 流式接口的增量解析与message格式不兼容，流式场景只靠提示词约束
+	if d.jsonMode {
+		parameters := reqBody["parameters"].(map[string]interface{})
+		parameters["result_format"] = "message"
+		parameters["response_format"] = map[string]interface{}{"type": "json_object"}
+	}
+
 	// 调试：记录请求信息（不记录完整prompt，可能很长）
 	logger.Debug("[DashScope] 调用模型: %s, prompt长度: %d 字符\n", d.model, len(prompt))
 
@@ -187,6 +246,9 @@ func (d *DashScopeLLM) Generate(ctx context.Context, prompt string) (string, err
 		return "", fmt.Errorf("no text or choices in response, body: %s", string(body))
 	}
 
+	// 答案被token上限截断时给出明确警告（否则只能从答案戛然而止猜测）
+	warnIfTruncated(finishReason)
+
 	// 调试：显示LLM响应的详细信息
 	logger.Debug("[DashScope] 收到响应 - 答案长度: %d 字符, 完成原因: %s\n", len(answer), finishReason)
 	if dashScopeResp.Usage.InputTokens > 0 || dashScopeResp.Usage.OutputTokens > 0 {
@@ -210,21 +272,21 @@ func (d *DashScopeLLM) GenerateStream(ctx context.Context, prompt string, onChun
 	reqBody := map[string]interface{}{
 		"model": d.model,
 		"input": map[string]interface{}{
-			"messages": []map[string]interface{}{
-				{
-					"role":    "user",
-					"content": prompt,
-				},
-			},
+			"messages": d.buildMessages(prompt),
 		},
 		"parameters": map[string]interface{}{
-			"max_tokens":  2000,
-			"temperature": 0.7,
-			"top_p":       0.8,
+			"max_tokens":         2000,
+			"temperature":        0.7,
+			"top_p":              0.8,
 			"incremental_output": true,
 		},
 	}
 
+	// 显式指定result_format：流式增量解析对text和choices两种chunk格式都兼容
+	if d.resultFormat != "" {
+		reqBody["parameters"].(map[string]interface{})["result_format"] = d.resultFormat
+	}
+
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal stream request: %w", err)
@@ -257,8 +319,9 @@ func (d *DashScopeLLM) GenerateStream(ctx context.Context, prompt string, onChun
 		Message streamMessage `json:"message"`
 	}
 	type streamOutput struct {
-		Choices []streamChoice `json:"choices"`
-		Text    string         `json:"text"`
+		Choices      []streamChoice `json:"choices"`
+		Text         string         `json:"text"`
+		FinishReason string         `json:"finish_reason"`
 	}
 	type streamResp struct {
 		Output streamOutput `json:"output"`
@@ -286,6 +349,8 @@ func (d *DashScopeLLM) GenerateStream(ctx context.Context, prompt string, onChun
 			continue
 		}
 
+		warnIfTruncated(chunk.Output.FinishReason)
+
 		text := chunk.Output.Text
 		if text == "" && len(chunk.Output.Choices) > 0 {
 			text = chunk.Output.Choices[0].Message.Content
@@ -16,19 +16,40 @@ import (
 
 // KimiLLM Kimi2大语言模型客户端（Moonshot AI）
 type KimiLLM struct {
-	apiKey  string
-	model   string
-	baseURL string
-	client  *http.Client
+	apiKey       string
+	model        string
+	baseURL      string
+	client       *http.Client
+	systemPrompt string // 系统提示词（persona），为空时不发送system消息
+	jsonMode     bool   // 原生JSON输出模式（结构化输出responseFormat=json时启用）
+}
+
+// SetSystemPrompt 设置系统提示词，通过system角色消息传递给模型
+func (k *KimiLLM) SetSystemPrompt(prompt string) {
+	k.systemPrompt = prompt
+}
+
+// WithJSONMode 返回启用/关闭原生JSON输出模式的浅拷贝（OpenAI兼容的response_format参数）
+// 原客户端不变：默认路径的客户端是进程级共享的，不能按请求写回
+func (k *KimiLLM) WithJSONMode(enabled bool) LLM {
+	clone := *k
+	clone.jsonMode = enabled
+	return &clone
 }
 
 // KimiRequest 请求结构（兼容OpenAI格式）
 type KimiRequest struct {
-	Model       string        `json:"model"`
-	Messages    []KimiMessage `json:"messages"`
-	Temperature float64       `json:"temperature,omitempty"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
-	TopP        float64       `json:"top_p,omitempty"`
+	Model          string              `json:"model"`
+	Messages       []KimiMessage       `json:"messages"`
+	Temperature    float64             `json:"temperature,omitempty"`
+	MaxTokens      int                 `json:"max_tokens,omitempty"`
+	TopP           float64             `json:"top_p,omitempty"`
+	ResponseFormat *KimiResponseFormat `json:"response_format,omitempty"`
+}
+
+// KimiResponseFormat 输出格式约束（兼容OpenAI格式，JSON模式时type为json_object）
+type KimiResponseFormat struct {
+	Type string `json:"type"`
 }
 
 // KimiMessage 消息结构
@@ -69,6 +90,10 @@ func NewKimiLLM(apiKey, model string) (*KimiLLM, error) {
 	if model == "" {
 		model = "moonshot-v1-8k" // 默认模型
 	}
+	// 提前校验模型名，拼错时在部署阶段就报错，而不是第一次查询时返回晦涩的API错误
+	if err := validateKnownModel("kimi", model); err != nil {
+		return nil, err
+	}
 
 	// Moonshot AI API URL
 	// 注意：Moonshot AI 使用类似 OpenAI 的 API 格式
@@ -84,19 +109,23 @@ func NewKimiLLM(apiKey, model string) (*KimiLLM, error) {
 
 // Generate 生成回答
 func (k *KimiLLM) Generate(ctx context.Context, prompt string) (string, error) {
-	// 构建请求（使用OpenAI兼容格式）
+	// 构建请求（使用OpenAI兼容格式，设置了系统提示词时前置system消息）
+	messages := make([]KimiMessage, 0, 2)
+	if k.systemPrompt != "" {
+		messages = append(messages, KimiMessage{Role: "system", Content: k.systemPrompt})
+	}
+	messages = append(messages, KimiMessage{Role: "user", Content: prompt})
 	reqBody := KimiRequest{
-		Model: k.model,
-		Messages: []KimiMessage{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
+		Model:       k.model,
+		Messages:    messages,
 		Temperature: 0.7,
 		MaxTokens:   2000,
 		TopP:        0.8,
 	}
+	// 原生JSON输出模式（OpenAI兼容）
+	if k.jsonMode {
+		reqBody.ResponseFormat = &KimiResponseFormat{Type: "json_object"}
+	}
 
 	// 调试：记录请求信息（不记录完整prompt，可能很长）
 	logger.Debug("[Kimi2] 调用模型: %s, prompt长度: %d 字符\n", k.model, len(prompt))
@@ -177,19 +206,30 @@ func (k *KimiLLM) Generate(ctx context.Context, prompt string) (string, error) {
 
 // GenerateStream 流式生成回答（同时返回完整答案）
 func (k *KimiLLM) GenerateStream(ctx context.Context, prompt string, onChunk func(string) error) (string, error) {
+	// 设置了系统提示词时前置system消息
+	streamMessages := make([]map[string]interface{}, 0, 2)
+	if k.systemPrompt != "" {
+		streamMessages = append(streamMessages, map[string]interface{}{
+			"role":    "system",
+			"content": k.systemPrompt,
+		})
+	}
+	streamMessages = append(streamMessages, map[string]interface{}{
+		"role":    "user",
+		"content": prompt,
+	})
 	reqBody := map[string]interface{}{
-		"model": k.model,
-		"messages": []map[string]interface{}{
-			{
-				"role":    "user",
-				"content": prompt,
-			},
-		},
+		"model":       k.model,
+		"messages":    streamMessages,
 		"temperature": 0.7,
 		"max_tokens":  2000,
 		"top_p":       0.8,
 		"stream":      true,
 	}
+	// 原生JSON输出模式（OpenAI兼容，流式的delta解析不受影响）
+	if k.jsonMode {
+		reqBody["response_format"] = map[string]interface{}{"type": "json_object"}
+	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
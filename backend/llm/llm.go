@@ -9,3 +9,19 @@ type LLM interface {
 	Generate(ctx context.Context, prompt string) (string, error)
 	GenerateStream(ctx context.Context, prompt string, onChunk func(string) error) (string, error)
 }
+
+// SystemPromptSetter 支持独立系统提示词（persona）的LLM客户端实现此接口
+// 支持system消息的provider（通义千问、Kimi2）通过system消息传递，
+// 不支持的provider（Ollama）将其前置到用户提示词
+type SystemPromptSetter interface {
+	SetSystemPrompt(prompt string)
+}
+
+// JSONModeLLM 支持原生JSON输出模式的LLM客户端实现此接口
+// 启用后API层约束模型只输出合法JSON（结构化输出responseFormat=json时使用），
+// 不支持的provider（Ollama）仅靠提示词约束。
+// WithJSONMode返回开启JSON模式的浅拷贝而不是修改原客户端：默认路径下客户端是
+// 进程级共享的，按请求的JSON模式写回共享实例会在并发查询间串扰
+type JSONModeLLM interface {
+	WithJSONMode(enabled bool) LLM
+}
@@ -10,7 +10,22 @@ import (
 
 // OllamaLLM Ollama大语言模型客户端
 type OllamaLLM struct {
-	llm llms.Model
+	llm          llms.Model
+	systemPrompt string // 系统提示词（persona），为空时不前置
+}
+
+// SetSystemPrompt 设置系统提示词
+// Ollama的Call接口没有独立的system消息，直接前置到用户提示词
+func (o *OllamaLLM) SetSystemPrompt(prompt string) {
+	o.systemPrompt = prompt
+}
+
+// withSystemPrompt 将系统提示词前置到用户提示词
+func (o *OllamaLLM) withSystemPrompt(prompt string) string {
+	if o.systemPrompt == "" {
+		return prompt
+	}
+	return o.systemPrompt + "\n\n" + prompt
 }
 
 // NewOllamaLLM 创建新的Ollama LLM客户端
@@ -31,7 +46,7 @@ func NewOllamaLLM(baseURL, modelName string) (*OllamaLLM, error) {
 // Generate 生成回答
 func (o *OllamaLLM) Generate(ctx context.Context, prompt string) (string, error) {
 	// 优化生成参数：平衡响应速度和回答完整性
-	completion, err := o.llm.Call(ctx, prompt,
+	completion, err := o.llm.Call(ctx, o.withSystemPrompt(prompt),
 		llms.WithMaxTokens(10000),                  // 增加最大生成token数（1500），获取更完整的回答
 		llms.WithTemperature(0.5),                  // 降低温度，减少随机性，加快生成
 		llms.WithTopP(0.8),                         // 降低TopP，加快生成
@@ -46,7 +61,7 @@ func (o *OllamaLLM) Generate(ctx context.Context, prompt string) (string, error)
 // GenerateStream 流式生成回答（同时返回完整答案）
 func (o *OllamaLLM) GenerateStream(ctx context.Context, prompt string, onChunk func(string) error) (string, error) {
 	var fullAnswer string
-	completion, err := o.llm.Call(ctx, prompt,
+	completion, err := o.llm.Call(ctx, o.withSystemPrompt(prompt),
 		llms.WithMaxTokens(10000),
 		llms.WithTemperature(0.5),
 		llms.WithTopP(0.8),
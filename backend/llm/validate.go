@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 模型名称校验
+// 模型名拼错（如qwen-trubo）只会在第一次查询时以晦涩的API错误暴露，这里在创建客户端时
+// 提前把关：按各provider的已知模型前缀做本地校验，拼错时直接报错并列出有效模型；
+// STRICT_STARTUP=true时启动阶段再发一次最小生成请求，实测凭证和模型都可用。
+
+// knownModelPrefixes 各provider的已知模型名前缀
+// 按前缀匹配而不是全名匹配，避免provider发布新版本号（qwen-turbo-latest等）时误拦
+var knownModelPrefixes = map[string][]string{
+	"dashscope": {"qwen-turbo", "qwen-plus", "qwen-max", "qwen-long", "qwen2", "qwen3", "qwq"},
+	"kimi":      {"moonshot-v1", "kimi"},
+}
+
+// validateKnownModel 校验模型名是否匹配该provider的已知前缀
+// Ollama的模型由本地服务自行管理，不在这里校验
+func validateKnownModel(provider, model string) error {
+	prefixes, ok := knownModelPrefixes[provider]
+	if !ok {
+		return nil
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(model, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("不支持的%s模型: %s，有效的模型名以这些前缀开头: %s",
+		provider, model, strings.Join(prefixes, ", "))
+}
+
+// ValidateStartup 对LLM客户端发一次最小生成请求（STRICT_STARTUP=true时在启动阶段执行）
+// 能在部署时立即暴露模型名、凭证或网络问题，代价是启动多一次LLM调用
+func ValidateStartup(ctx context.Context, client LLM) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := client.Generate(ctx, "ping"); err != nil {
+		return fmt.Errorf("LLM启动自检失败（STRICT_STARTUP）: %w", err)
+	}
+	logger.Info("✅ LLM启动自检通过 (耗时: %v)", time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,64 @@
+package loader
+
+import "strings"
+
+// 清洗模式（CLEAN_MODE，与store包保持一致）
+// cleanTextEncoding会把连续空格和多余空行激进地折叠掉，这对普通文章无害，
+// 但会破坏代码缩进和表格对齐。preserve-layout模式对代码/表格类文件只做
+// 编码清理（剔除无效UTF-8和控制字符），保留有意义的空白；其他内容仍走标准清洗。
+const (
+	// cleanModeStandard 标准清洗（默认）：编码清理+折叠连续空白
+	cleanModeStandard = "standard"
+	// cleanModePreserveLayout 布局保留：代码/表格类内容跳过空白折叠
+	cleanModePreserveLayout = "preserve-layout"
+)
+
+// cleanMode 当前的清洗模式
+var cleanMode = cleanModeStandard
+
+// SetCleanMode 设置清洗模式（CLEAN_MODE），无效值忽略
+func SetCleanMode(mode string) {
+	switch mode {
+	case cleanModeStandard, cleanModePreserveLayout:
+		cleanMode = mode
+	}
+}
+
+// cleanLoadedContent 按清洗模式清理加载后的文档内容
+// preserve-layout模式下，按扩展名或内容特征识别为代码/表格的文档保留空白布局
+func cleanLoadedContent(text, ext string) string {
+	if cleanMode == cleanModePreserveLayout &&
+		(layoutSignificantExts[strings.ToLower(ext)] || looksLikeLayoutContent(text)) {
+		return stripInvalidChars(text)
+	}
+	return cleanTextEncoding(text)
+}
+
+// layoutSignificantExts 空白即语义的文件类型（代码、表格、标记语言）
+var layoutSignificantExts = map[string]bool{
+	".md": true, ".markdown": true, ".csv": true, ".tsv": true,
+	".go": true, ".py": true, ".js": true, ".ts": true, ".java": true,
+	".c": true, ".cpp": true, ".h": true, ".sh": true, ".sql": true,
+	".json": true, ".yaml": true, ".yml": true, ".xml": true,
+}
+
+// looksLikeLayoutContent 内容级启发式：代码围栏、制表符缩进、连续缩进行或表格行
+func looksLikeLayoutContent(text string) bool {
+	if strings.Contains(text, "```") {
+		return true
+	}
+	indentedLines := 0
+	tableLines := 0
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "    ") {
+			indentedLines++
+		}
+		if strings.Count(line, "|") >= 2 {
+			tableLines++
+		}
+		if indentedLines >= 3 || tableLines >= 2 {
+			return true
+		}
+	}
+	return false
+}
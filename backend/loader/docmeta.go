@@ -0,0 +1,157 @@
+package loader
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 结构化文档元数据（作者、日期等）提取
+// docx从docProps/core.xml读取核心属性，PDF从Info字典做尽力而为的解析
+// 提取结果写入metadata的 title/author/date 键，用于丰富引用展示和按日期过滤
+
+// docxCoreProps docx核心属性（docProps/core.xml，按本地名匹配忽略命名空间前缀）
+type docxCoreProps struct {
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator"`
+	Created  string `xml:"created"`
+	Modified string `xml:"modified"`
+}
+
+// extractDocxCoreProperties 从docx的docProps/core.xml提取标题、作者和日期
+// 提取失败时返回错误，调用方应忽略错误继续主流程（元数据是增强信息，不影响正文加载）
+func extractDocxCoreProperties(path string) (map[string]string, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开docx失败: %w", err)
+	}
+	defer reader.Close()
+
+	for _, f := range reader.File {
+		if f.Name != "docProps/core.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("打开core.xml失败: %w", err)
+		}
+		defer rc.Close()
+
+		var props docxCoreProps
+		if err := xml.NewDecoder(rc).Decode(&props); err != nil {
+			return nil, fmt.Errorf("解析core.xml失败: %w", err)
+		}
+
+		meta := make(map[string]string)
+		if title := strings.TrimSpace(props.Title); title != "" {
+			meta["title"] = title
+		}
+		if author := strings.TrimSpace(props.Creator); author != "" {
+			meta["author"] = author
+		}
+		// 优先使用创建日期，缺失时退回修改日期（dcterms日期是ISO8601格式，截取日期部分）
+		date := strings.TrimSpace(props.Created)
+		if date == "" {
+			date = strings.TrimSpace(props.Modified)
+		}
+		if date != "" {
+			if len(date) > 10 {
+				date = date[:10]
+			}
+			meta["date"] = date
+		}
+		return meta, nil
+	}
+	return nil, fmt.Errorf("docx中没有docProps/core.xml")
+}
+
+// PDF Info字典中的文本属性（只处理括号包裹的字面量字符串，十六进制字符串跳过）
+var (
+	pdfTitleRe        = regexp.MustCompile(`/Title\s*\(([^)]*)\)`)
+	pdfAuthorRe       = regexp.MustCompile(`/Author\s*\(([^)]*)\)`)
+	pdfCreationDateRe = regexp.MustCompile(`/CreationDate\s*\(\s*D:(\d{4})(\d{2})?(\d{2})?`)
+)
+
+// extractPDFInfo 从PDF的Info字典提取标题、作者和创建日期（尽力而为）
+// Info字典通常在文件开头或结尾附近，这里只扫描首尾各2MB避免读取整个大文件
+// 加密或使用十六进制/UTF-16字符串的PDF可能提取不到，返回空map
+func extractPDFInfo(path string) map[string]string {
+	data, err := readFileHeadTail(path, 2<<20)
+	if err != nil {
+		return nil
+	}
+
+	meta := make(map[string]string)
+	if m := pdfTitleRe.FindSubmatch(data); m != nil {
+		if title := cleanTextEncoding(string(m[1])); title != "" {
+			meta["title"] = title
+		}
+	}
+	if m := pdfAuthorRe.FindSubmatch(data); m != nil {
+		if author := cleanTextEncoding(string(m[1])); author != "" {
+			meta["author"] = author
+		}
+	}
+	if m := pdfCreationDateRe.FindSubmatch(data); m != nil {
+		date := string(m[1])
+		if len(m[2]) > 0 {
+			date += "-" + string(m[2])
+		}
+		if len(m[3]) > 0 {
+			date += "-" + string(m[3])
+		}
+		meta["date"] = date
+	}
+	return meta
+}
+
+// readFileHeadTail 读取文件的首尾各limit字节（小文件直接整个读取）
+func readFileHeadTail(path string, limit int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() <= limit*2 {
+		return io.ReadAll(file)
+	}
+
+	head := make([]byte, limit)
+	if _, err := io.ReadFull(file, head); err != nil {
+		return nil, err
+	}
+	tail := make([]byte, limit)
+	if _, err := file.ReadAt(tail, info.Size()-limit); err != nil {
+		return nil, err
+	}
+	return append(head, tail...), nil
+}
+
+// applyDocMetadata 把结构化元数据合并到文档的metadata中（已有的键不覆盖）
+func applyDocMetadata(docs []schema.Document, meta map[string]string) {
+	if len(meta) == 0 {
+		return
+	}
+	for i := range docs {
+		if docs[i].Metadata == nil {
+			docs[i].Metadata = make(map[string]interface{})
+		}
+		for key, value := range meta {
+			if _, exists := docs[i].Metadata[key]; !exists {
+				docs[i].Metadata[key] = value
+			}
+		}
+	}
+}
@@ -44,7 +44,19 @@ func NewFileLoaderWithOCR(ocrProcessor *ocr.OCRProcessor) *FileLoader {
 
 // Load 根据文件类型加载文档
 func (l *FileLoader) Load(path string) ([]schema.Document, error) {
+	return l.LoadWithContentType(path, "")
+}
+
+// LoadWithContentType 加载文档，扩展名缺失或不认识时按内容推断实际类型
+// declaredType为上传方声明的Content-Type（没有时传空），优先于内容嗅探使用
+func (l *FileLoader) LoadWithContentType(path, declaredType string) ([]schema.Document, error) {
 	ext := strings.ToLower(filepath.Ext(path))
+	if !knownLoaderExts[ext] {
+		if resolved := resolveExtByContent(path, declaredType); resolved != "" {
+			logger.Info("📎 文件 %s 的扩展名无法识别（%q），按内容推断为 %s 处理", filepath.Base(path), ext, resolved)
+			ext = resolved
+		}
+	}
 
 	var loader documentloaders.Loader
 	var err error
@@ -93,6 +105,33 @@ func (l *FileLoader) Load(path string) ([]schema.Document, error) {
 			return nil, fmt.Errorf("failed to seek to file start: %w", err)
 		}
 
+		// 优先使用配置的外部提取后端（PDF_BACKEND），提取失败或结果为空时回退到内置解析器
+		if backend := resolvePDFBackend(); backend != PDFBackendBuiltin {
+			text, extErr := extractPDFWithTool(backend, path)
+			if extErr == nil && strings.TrimSpace(text) != "" {
+				logger.Info("✅ 使用%s提取PDF文本成功，长度: %d字符", backend, len(text))
+				documents := []schema.Document{
+					{
+						PageContent: cleanTextEncoding(text),
+						Metadata: map[string]interface{}{
+							"source":      path,
+							"file_name":   filepath.Base(path),
+							"file_type":   "pdf",
+							"pdf_backend": backend,
+						},
+					},
+				}
+				// 从Info字典提取标题、作者、日期（尽力而为）
+				applyDocMetadata(documents, extractPDFInfo(path))
+				return documents, nil
+			}
+			if extErr != nil {
+				logger.Warn("⚠️ %s提取PDF失败，回退到内置解析器: %v", backend, extErr)
+			} else {
+				logger.Warn("⚠️ %s未提取到文本内容，回退到内置解析器", backend)
+			}
+		}
+
 		loader = documentloaders.NewPDF(file, fileInfo.Size())
 
 	case ".docx":
@@ -122,6 +161,11 @@ func (l *FileLoader) Load(path string) ([]schema.Document, error) {
 			},
 		}
 
+		// 提取核心属性（docProps/core.xml中的标题、作者、日期）丰富引用展示，失败时忽略
+		if coreMeta, err := extractDocxCoreProperties(path); err == nil {
+			applyDocMetadata(documents, coreMeta)
+		}
+
 		return documents, nil
 
 	case ".doc":
@@ -148,15 +192,15 @@ func (l *FileLoader) Load(path string) ([]schema.Document, error) {
 
 	ctx := context.Background()
 	docs, err := loader.Load(ctx)
-	
+
 	// 如果是PDF文件，自动检测是否为扫描件并处理（纯Go实现）
 	if ext == ".pdf" {
 		// 自动检测PDF是否为扫描件
 		isScanned, scanReason := l.detectScannedPDF(docs, err, path)
-		
+
 		if isScanned {
 			logger.Info("📄 检测到扫描版PDF: %s (原因: %s)", filepath.Base(path), scanReason)
-			
+
 			// 如果是扫描件且配置了OCR，自动使用OCR提取文本（纯Go实现）
 			if l.ocrProcessor != nil {
 				logger.Info("🔍 开始使用OCR处理扫描版PDF（纯Go实现）: %s", filepath.Base(path))
@@ -168,12 +212,12 @@ func (l *FileLoader) Load(path string) ([]schema.Document, error) {
 					}
 					return nil, fmt.Errorf("PDF为扫描件但OCR处理失败: %w", ocrErr)
 				}
-				
+
 				// OCR成功，创建文档对象
 				if ocrText == "" {
 					return nil, fmt.Errorf("OCR处理完成但未提取到任何文本内容")
 				}
-				
+
 				docs = []schema.Document{
 					{
 						PageContent: ocrText,
@@ -187,6 +231,9 @@ func (l *FileLoader) Load(path string) ([]schema.Document, error) {
 					},
 				}
 				logger.Info("✅ OCR处理成功（纯Go实现），提取文本长度: %d字符", len(ocrText))
+
+				// OCR文本归一化：去掉汉字间空格、全角转半角、应用错字替换规则
+				docs = NormalizeOCRDocuments(docs)
 			} else {
 				// 是扫描件但没有配置OCR
 				if err != nil {
@@ -223,9 +270,18 @@ func (l *FileLoader) Load(path string) ([]schema.Document, error) {
 		}
 		docs[i].Metadata["source"] = path
 		docs[i].Metadata["file_name"] = filepath.Base(path)
-		
-		// 清理和修复文本编码，确保是有效的UTF-8
-		docs[i].PageContent = cleanTextEncoding(docs[i].PageContent)
+		// 标记PDF文本提取使用的后端（外部后端在提取成功时已提前返回）
+		if ext == ".pdf" {
+			docs[i].Metadata["pdf_backend"] = PDFBackendBuiltin
+		}
+
+		// 清理和修复文本编码，确保是有效的UTF-8（按清洗模式决定是否保留空白布局）
+		docs[i].PageContent = cleanLoadedContent(docs[i].PageContent, ext)
+	}
+
+	// PDF结构化元数据（Info字典中的标题、作者、日期），尽力而为
+	if ext == ".pdf" {
+		applyDocMetadata(docs, extractPDFInfo(path))
 	}
 
 	return docs, nil
@@ -246,12 +302,12 @@ func (l *FileLoader) detectScannedPDF(docs []schema.Document, loadErr error, pat
 		// 其他加载错误，可能是扫描件
 		return true, "PDF加载失败，可能是扫描件"
 	}
-	
+
 	if len(docs) == 0 {
 		// 加载成功但没有提取到内容，很可能是扫描件
 		return true, "未提取到任何文本内容"
 	}
-	
+
 	// 检查提取的文本是否足够
 	totalTextLength := 0
 	nonEmptyDocs := 0
@@ -262,17 +318,17 @@ func (l *FileLoader) detectScannedPDF(docs []schema.Document, loadErr error, pat
 			totalTextLength += len(content)
 		}
 	}
-	
+
 	// 如果总文本长度少于100字符，可能是扫描件
 	// 或者如果所有文档都是空的，肯定是扫描件
 	if nonEmptyDocs == 0 {
 		return true, "所有页面都未提取到文本内容"
 	}
-	
+
 	if totalTextLength < 100 {
 		return true, fmt.Sprintf("提取的文本很少（%d字符，%d页有内容）", totalTextLength, nonEmptyDocs)
 	}
-	
+
 	// 正常PDF，有足够的文本内容
 	return false, fmt.Sprintf("提取到足够的文本内容（%d字符，%d页）", totalTextLength, nonEmptyDocs)
 }
@@ -307,6 +363,24 @@ func cleanWordText(text string) string {
 // cleanTextEncoding 清理和修复文本编码，确保是有效的UTF-8
 // 移除无效的UTF-8字符、控制字符和乱码字符，替换为空格或删除
 func cleanTextEncoding(text string) string {
+	text = stripInvalidChars(text)
+
+	// 清理多余的空白字符
+	// 多个空格/制表符替换为单个空格
+	for strings.Contains(text, "  ") {
+		text = strings.ReplaceAll(text, "  ", " ")
+	}
+	// 多个换行符替换为两个
+	for strings.Contains(text, "\n\n\n") {
+		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+	}
+	text = strings.TrimSpace(text)
+
+	return text
+}
+
+// stripInvalidChars 编码级清理：剔除无效UTF-8、控制字符和乱码字符，不动空白布局
+func stripInvalidChars(text string) string {
 	if text == "" {
 		return text
 	}
@@ -317,7 +391,7 @@ func cleanTextEncoding(text string) string {
 	// 逐字符处理，确保所有字符都是有效的UTF-8
 	for len(text) > 0 {
 		r, size := utf8.DecodeRuneInString(text)
-		
+
 		// 处理无效的UTF-8字符
 		if r == utf8.RuneError && size == 1 {
 			// 遇到无效的UTF-8字符，跳过
@@ -358,23 +432,17 @@ func cleanTextEncoding(text string) string {
 	// 清理连续的乱码字符模式（如连续的替换字符或控制字符）
 	// 移除连续的无效字符序列
 	text = strings.ReplaceAll(text, "\uFFFD", " ")
-	
-	// 清理多余的空白字符
-	// 多个空格/制表符替换为单个空格
-	for strings.Contains(text, "  ") {
-		text = strings.ReplaceAll(text, "  ", " ")
-	}
-	// 多个换行符替换为两个
-	for strings.Contains(text, "\n\n\n") {
-		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
-	}
-	text = strings.TrimSpace(text)
 
 	return text
 }
 
 // LoadFromURL 从URL加载网页内容
+// 配置了URL_ALLOWED_DOMAINS时，白名单外的域名直接拒绝
 func LoadFromURL(url string) ([]schema.Document, error) {
+	if err := CheckURLAllowed(url); err != nil {
+		return nil, err
+	}
+
 	// 下载网页内容
 	resp, err := http.Get(url)
 	if err != nil {
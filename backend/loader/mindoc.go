@@ -0,0 +1,38 @@
+package loader
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 最小文档长度（MIN_DOC_CHARS）
+// 只有几个词的小文件会产出低价值的单chunk向量，在检索时纯属噪声。
+// 设置阈值后，清洗后总字符数低于阈值的文档在上传阶段被拒绝（批量模式下标记跳过），
+// 默认0保持现有行为（不限制）。
+
+// minDocChars 文档进入索引所需的最小字符数（0表示不限制）
+var minDocChars int
+
+// SetMinDocChars 设置文档进入索引所需的最小字符数（MIN_DOC_CHARS，0表示不限制）
+func SetMinDocChars(chars int) {
+	if chars >= 0 {
+		minDocChars = chars
+	}
+}
+
+// MinDocChars 返回当前配置的最小文档字符数
+func MinDocChars() int {
+	return minDocChars
+}
+
+// DocBelowMinChars 统计文档清洗后的总字符数（按rune计），并判断是否低于阈值
+// 阈值为0时恒为false，保持现有行为
+func DocBelowMinChars(docs []schema.Document) (int, bool) {
+	total := 0
+	for _, doc := range docs {
+		total += utf8.RuneCountInString(strings.TrimSpace(doc.PageContent))
+	}
+	return total, minDocChars > 0 && total < minDocChars
+}
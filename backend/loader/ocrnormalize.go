@@ -0,0 +1,125 @@
+package loader
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// OCR文本归一化（OCR_NORMALIZE / OCR_SUBSTITUTIONS）
+// 扫描件OCR的文本常在汉字之间混入多余空格（"培 训 要 求"），并出现全角字母数字，
+// 既破坏嵌入质量也让关键词匹配失效。归一化在加载阶段对Metadata["ocr"]=true的
+// 文档做三件事：去掉汉字之间的空格、全角字母数字转半角、应用可配置的错字替换规则。
+
+// ocrNormalizeEnabled OCR归一化开关（默认开启，只影响OCR产出的文档）
+var ocrNormalizeEnabled = true
+
+// ocrSubstitutions 错字替换规则（原文->替换），通过SetOCRNormalization追加
+var ocrSubstitutions [][2]string
+
+// SetOCRNormalization 设置OCR归一化（OCR_NORMALIZE）和自定义错字替换规则
+// 规则格式为"错字=>正字"（OCR_SUBSTITUTIONS，分号分隔），格式不合法的规则跳过并告警
+func SetOCRNormalization(enabled bool, substitutions []string) {
+	ocrNormalizeEnabled = enabled
+	ocrSubstitutions = ocrSubstitutions[:0]
+	if !enabled {
+		return
+	}
+	for _, rule := range substitutions {
+		parts := strings.SplitN(rule, "=>", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			logger.Warn("⚠️ OCR错字替换规则无效，已跳过: %q（格式应为\"错字=>正字\"）", rule)
+			continue
+		}
+		ocrSubstitutions = append(ocrSubstitutions, [2]string{parts[0], parts[1]})
+	}
+	if len(ocrSubstitutions) > 0 {
+		logger.Info("✅ OCR错字替换规则已加载: %d 条", len(ocrSubstitutions))
+	}
+}
+
+// NormalizeOCRDocuments 对OCR产出的文档（Metadata["ocr"]=true）做文本归一化
+func NormalizeOCRDocuments(docs []schema.Document) []schema.Document {
+	if !ocrNormalizeEnabled {
+		return docs
+	}
+	for i := range docs {
+		if isOCR, ok := docs[i].Metadata["ocr"].(bool); !ok || !isOCR {
+			continue
+		}
+		normalized := NormalizeOCRText(docs[i].PageContent)
+		if normalized != docs[i].PageContent {
+			logger.Info("🔍 OCR文本归一化: 长度 %d -> %d 字符", len(docs[i].PageContent), len(normalized))
+			docs[i].PageContent = normalized
+		}
+	}
+	return docs
+}
+
+// NormalizeOCRText 归一化OCR文本：去掉汉字间空格、全角字母数字转半角、应用错字替换
+func NormalizeOCRText(text string) string {
+	runes := []rune(text)
+
+	// 1. 全角字母数字转半角（全角空格转普通空格）
+	for i, r := range runes {
+		switch {
+		case r == '　':
+			runes[i] = ' '
+		case r >= '０' && r <= '９', r >= 'Ａ' && r <= 'Ｚ', r >= 'ａ' && r <= 'ｚ':
+			runes[i] = r - 0xFEE0
+		}
+	}
+
+	// 2. 去掉汉字之间的空格（"培 训 要 求" -> "培训要求"）
+	// 两侧至少一侧是汉字、另一侧是汉字或数字时去掉（覆盖"第 1 章"这类编号），
+	// 保留中英文混排（"安全 management"）和英文单词之间的空格
+	result := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == ' ' {
+			// 跳过连续空格，看前后的非空格字符
+			j := i
+			for j < len(runes) && runes[j] == ' ' {
+				j++
+			}
+			var prev, next rune
+			if len(result) > 0 {
+				prev = result[len(result)-1]
+			}
+			if j < len(runes) {
+				next = runes[j]
+			}
+			if !shouldJoinOCRRunes(prev, next) {
+				result = append(result, ' ')
+			}
+			i = j - 1
+			continue
+		}
+		result = append(result, runes[i])
+	}
+	normalized := string(result)
+
+	// 3. 应用错字替换规则
+	for _, sub := range ocrSubstitutions {
+		normalized = strings.ReplaceAll(normalized, sub[0], sub[1])
+	}
+	return normalized
+}
+
+// shouldJoinOCRRunes 判断两个字符之间的OCR空格是否应去掉
+// 至少一侧是汉字、另一侧是汉字或数字时去掉（数字之间的空格保留，避免合并独立数值）
+func shouldJoinOCRRunes(prev, next rune) bool {
+	prevHan := unicode.Is(unicode.Han, prev)
+	nextHan := unicode.Is(unicode.Han, next)
+	if prevHan && nextHan {
+		return true
+	}
+	if prevHan && next >= '0' && next <= '9' {
+		return true
+	}
+	if nextHan && prev >= '0' && prev <= '9' {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestNormalizeOCRText(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "汉字间空格",
+			text: "培 训 要 求",
+			want: "培训要求",
+		},
+		{
+			name: "连续空格",
+			text: "信息  安全   管理",
+			want: "信息安全管理",
+		},
+		{
+			name: "保留中英文混排的空格",
+			text: "安全 management 要求",
+			want: "安全 management 要求",
+		},
+		{
+			name: "保留英文单词间空格",
+			text: "reset admin password",
+			want: "reset admin password",
+		},
+		{
+			name: "全角字母数字转半角",
+			text: "编号ＡＢＣ１２３",
+			want: "编号ABC123",
+		},
+		{
+			name: "全角空格视同空格",
+			text: "培　训　要　求",
+			want: "培训要求",
+		},
+		{
+			name: "混合噪声",
+			text: "第 １ 章　安 全 培 训",
+			want: "第1章安全培训",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := NormalizeOCRText(tc.text)
+			if got != tc.want {
+				t.Errorf("NormalizeOCRText(%q) = %q, want %q", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeOCRTextSubstitutions(t *testing.T) {
+	SetOCRNormalization(true, []string{"安金=>安全", "无效规则", "=>空原文"})
+	defer SetOCRNormalization(true, nil)
+
+	got := NormalizeOCRText("信息安金管理制度")
+	want := "信息安全管理制度"
+	if got != want {
+		t.Errorf("NormalizeOCRText with substitutions = %q, want %q", got, want)
+	}
+}
+
+func TestNormalizeOCRDocuments(t *testing.T) {
+	docs := []schema.Document{
+		{
+			PageContent: "培 训 要 求",
+			Metadata:    map[string]interface{}{"ocr": true},
+		},
+		{
+			PageContent: "培 训 要 求",
+			Metadata:    map[string]interface{}{},
+		},
+	}
+
+	normalized := NormalizeOCRDocuments(docs)
+	if normalized[0].PageContent != "培训要求" {
+		t.Errorf("OCR文档应被归一化, got %q", normalized[0].PageContent)
+	}
+	if normalized[1].PageContent != "培 训 要 求" {
+		t.Errorf("非OCR文档不应被修改, got %q", normalized[1].PageContent)
+	}
+
+	// 关闭开关后不做任何处理
+	SetOCRNormalization(false, nil)
+	defer SetOCRNormalization(true, nil)
+	docs[0].PageContent = "培 训 要 求"
+	normalized = NormalizeOCRDocuments(docs)
+	if normalized[0].PageContent != "培 训 要 求" {
+		t.Errorf("归一化关闭时不应修改文本, got %q", normalized[0].PageContent)
+	}
+}
@@ -0,0 +1,150 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// PDF文本提取后端（PDF_BACKEND环境变量）
+// builtin使用langchaingo内置解析器；pdftotext调用poppler的pdftotext命令（-enc UTF-8，
+// 对复杂排版和中文PDF的提取效果明显更好）；pdfcpu调用pdfcpu命令行工具
+const (
+	PDFBackendBuiltin   = "builtin"
+	PDFBackendPdftotext = "pdftotext"
+	PDFBackendPdfcpu    = "pdfcpu"
+)
+
+// defaultPDFBackend 全局默认的PDF提取后端，启动时通过SetDefaultPDFBackend设置
+var defaultPDFBackend = PDFBackendBuiltin
+
+// pdfToolTimeout 外部PDF提取工具的执行超时时间
+const pdfToolTimeout = 5 * time.Minute
+
+// SetDefaultPDFBackend 设置默认的PDF文本提取后端（PDF_BACKEND）
+// 传入空字符串或不支持的值时保持builtin
+func SetDefaultPDFBackend(backend string) {
+	switch backend {
+	case PDFBackendBuiltin, PDFBackendPdftotext, PDFBackendPdfcpu:
+		defaultPDFBackend = backend
+	case "":
+		defaultPDFBackend = PDFBackendBuiltin
+	default:
+		logger.Warn("⚠️ 不支持的PDF后端: %s，使用builtin", backend)
+		defaultPDFBackend = PDFBackendBuiltin
+	}
+}
+
+// resolvePDFBackend 解析实际可用的PDF提取后端
+// 配置了外部工具但工具不在PATH中时，回退到builtin并记录警告
+func resolvePDFBackend() string {
+	switch defaultPDFBackend {
+	case PDFBackendPdftotext:
+		if _, err := exec.LookPath("pdftotext"); err != nil {
+			logger.Warn("⚠️ 未找到pdftotext命令（需要安装poppler-utils），回退到内置PDF解析器")
+			return PDFBackendBuiltin
+		}
+	case PDFBackendPdfcpu:
+		if _, err := exec.LookPath("pdfcpu"); err != nil {
+			logger.Warn("⚠️ 未找到pdfcpu命令，回退到内置PDF解析器")
+			return PDFBackendBuiltin
+		}
+	}
+	return defaultPDFBackend
+}
+
+// extractPDFWithTool 使用外部工具提取PDF文本
+func extractPDFWithTool(backend, path string) (string, error) {
+	switch backend {
+	case PDFBackendPdftotext:
+		return extractPDFWithPdftotext(path)
+	case PDFBackendPdfcpu:
+		return extractPDFWithPdfcpu(path)
+	default:
+		return "", fmt.Errorf("未知的PDF提取后端: %s", backend)
+	}
+}
+
+// extractPDFWithPdftotext 调用poppler的pdftotext提取文本
+// 使用 -enc UTF-8 确保中文等非ASCII字符正确编码，输出到stdout
+func extractPDFWithPdftotext(path string) (string, error) {
+	cmd := exec.Command("pdftotext", "-enc", "UTF-8", path, "-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := runWithTimeout(cmd, pdfToolTimeout); err != nil {
+		return "", fmt.Errorf("pdftotext执行失败: %w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// extractPDFWithPdfcpu 调用pdfcpu提取PDF内容
+// pdfcpu的extract命令将内容写入目录，这里使用临时目录并合并所有输出文件
+func extractPDFWithPdfcpu(path string) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "pdfcpu_extract_*")
+	if err != nil {
+		return "", fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cmd := exec.Command("pdfcpu", "extract", "-mode", "content", path, tmpDir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := runWithTimeout(cmd, pdfToolTimeout); err != nil {
+		return "", fmt.Errorf("pdfcpu执行失败: %w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// 按文件名排序合并输出，保持页面顺序
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return "", fmt.Errorf("读取pdfcpu输出目录失败: %w", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var result strings.Builder
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			return "", fmt.Errorf("读取pdfcpu输出文件失败: %w", err)
+		}
+		result.Write(data)
+		result.WriteString("\n")
+	}
+	return result.String(), nil
+}
+
+// runWithTimeout 运行外部命令并限制执行时间，超时后杀掉进程
+func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		_ = cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("命令执行超时（%v）", timeout)
+	}
+}
@@ -0,0 +1,100 @@
+package loader
+
+import (
+	"archive/zip"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// 上传客户端不一定保留扩展名（或带着错误的扩展名），纯按扩展名分发会把
+// PDF当文本读出乱码。扩展名不认识时先看上传方声明的Content-Type，
+// 再对文件头做内容嗅探（http.DetectContentType读前512字节）兜底。
+
+// knownLoaderExts Load的switch能直接处理的扩展名
+var knownLoaderExts = map[string]bool{
+	".txt":  true,
+	".pdf":  true,
+	".docx": true,
+	".doc":  true,
+	".html": true,
+	".htm":  true,
+}
+
+// resolveExtByContent 在扩展名缺失或不认识时推断实际的文件类型
+// 优先使用上传方声明的Content-Type，不可用时读文件头嗅探；两者都失败返回空字符串
+func resolveExtByContent(path, declaredType string) string {
+	if ext := extFromContentType(declaredType); ext != "" {
+		return ext
+	}
+	return sniffFileType(path)
+}
+
+// extFromContentType 把Content-Type映射到加载器使用的扩展名
+func extFromContentType(contentType string) string {
+	// 去掉"; charset=utf-8"等参数部分
+	if idx := strings.Index(contentType, ";"); idx >= 0 {
+		contentType = contentType[:idx]
+	}
+	switch strings.TrimSpace(strings.ToLower(contentType)) {
+	case "application/pdf":
+		return ".pdf"
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return ".docx"
+	case "application/msword":
+		return ".doc"
+	case "text/html":
+		return ".html"
+	case "text/plain":
+		return ".txt"
+	}
+	return ""
+}
+
+// sniffFileType 读取文件前512字节，用http.DetectContentType判断真实类型
+// 返回对应的扩展名，无法判断时返回空字符串
+func sniffFileType(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, _ := file.Read(buf)
+	if n == 0 {
+		return ""
+	}
+
+	contentType := http.DetectContentType(buf[:n])
+	switch {
+	case strings.HasPrefix(contentType, "application/pdf"):
+		return ".pdf"
+	case strings.HasPrefix(contentType, "text/html"):
+		return ".html"
+	case strings.HasPrefix(contentType, "text/plain"):
+		return ".txt"
+	case strings.HasPrefix(contentType, "application/zip"):
+		// docx是zip容器：内部存在word/document.xml才按Word文档处理
+		if zipContainsWordDocument(path) {
+			return ".docx"
+		}
+	}
+	return ""
+}
+
+// zipContainsWordDocument 判断zip文件内部是否为Word文档（docx）
+func zipContainsWordDocument(path string) bool {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return false
+	}
+	defer reader.Close()
+
+	for _, f := range reader.File {
+		if f.Name == "word/document.xml" {
+			return true
+		}
+	}
+	return false
+}
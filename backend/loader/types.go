@@ -0,0 +1,21 @@
+package loader
+
+// SupportedType 描述一种后端支持的文件类型及其处理说明
+type SupportedType struct {
+	Ext       string `json:"ext"`       // 扩展名（含点号）
+	Supported bool   `json:"supported"` // 是否能完整解析
+	Note      string `json:"note"`      // 处理说明/限制
+}
+
+// SupportedTypes 返回后端支持的文件类型清单（与Load的分发逻辑保持一致）
+// 前端的accept过滤和帮助文案从这里派生，避免硬编码的列表与后端实际能力漂移
+func SupportedTypes() []SupportedType {
+	return []SupportedType{
+		{Ext: ".txt", Supported: true, Note: "纯文本，直接加载"},
+		{Ext: ".pdf", Supported: true, Note: "文本型PDF直接解析（最大100MB），扫描件PDF需要OCR支持"},
+		{Ext: ".docx", Supported: true, Note: "解析正文文本及标题、作者等核心属性"},
+		{Ext: ".doc", Supported: false, Note: "旧版Word暂不支持，请转换为.docx后上传"},
+		{Ext: ".html", Supported: true, Note: "提取网页正文文本"},
+		{Ext: ".htm", Supported: true, Note: "提取网页正文文本"},
+	}
+}
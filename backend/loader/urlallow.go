@@ -0,0 +1,55 @@
+package loader
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// URL摄取的域名白名单
+// 开放URL摄取后，管理员需要控制哪些站点的内容可以进入知识库；
+// 白名单为空时允许所有域名（保持历史行为）。
+
+// allowedURLDomains 全局域名白名单，启动时通过SetAllowedDomains设置（已小写化）
+var allowedURLDomains []string
+
+// SetAllowedDomains 设置URL摄取的域名白名单（URL_ALLOWED_DOMAINS，分号分隔）
+// 白名单项匹配其自身及所有子域名（如配置example.com时docs.example.com也允许）
+func SetAllowedDomains(domains []string) {
+	allowedURLDomains = allowedURLDomains[:0]
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			allowedURLDomains = append(allowedURLDomains, domain)
+		}
+	}
+	if len(allowedURLDomains) > 0 {
+		logger.Info("✅ URL摄取域名白名单已启用: %v", allowedURLDomains)
+	}
+}
+
+// CheckURLAllowed 检查URL的域名是否在白名单内（白名单为空时放行）
+// 域名不在白名单时返回明确的错误，供上传接口直接透出给调用方
+func CheckURLAllowed(rawURL string) error {
+	if len(allowedURLDomains) == 0 {
+		return nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("无法解析URL: %w", err)
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return fmt.Errorf("URL缺少域名: %s", rawURL)
+	}
+
+	for _, domain := range allowedURLDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil
+		}
+	}
+	return fmt.Errorf("域名 %s 不在URL摄取白名单中（URL_ALLOWED_DOMAINS）", host)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -290,3 +291,74 @@ func Close() error {
 	return nil
 }
 
+// tailReadBytes 读取日志尾部时最多回看的字节数，避免把整个大日志文件读进内存
+const tailReadBytes = 1 << 20 // 1MB
+
+// TailLogs 读取当天日志文件的末尾若干行（供 /api/admin/logs 远程排障使用）
+// source为"app"或"error"，对应app-YYYY-MM-DD.log和error-YYYY-MM-DD.log；
+// level非空时只保留该级别的行（按"[LEVEL]"标记过滤），lines为返回的最大行数
+func TailLogs(source, level string, lines int) ([]string, error) {
+	if defaultLogger == nil {
+		return nil, fmt.Errorf("日志系统未初始化")
+	}
+	if source != "app" && source != "error" {
+		return nil, fmt.Errorf("不支持的日志来源: %s，支持的值: app, error", source)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	logPath := filepath.Join(defaultLogger.logDir, fmt.Sprintf("%s-%s.log", source, today))
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil // 当天还没有产生日志
+		}
+		return nil, fmt.Errorf("打开日志文件失败: %v", err)
+	}
+	defer file.Close()
+
+	// 只读文件末尾的一段，从其中截取最后N行
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("读取日志文件信息失败: %v", err)
+	}
+	offset := stat.Size() - tailReadBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("定位日志文件失败: %v", err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取日志文件失败: %v", err)
+	}
+
+	raw := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	// 从截断处开始读时第一行可能不完整，丢弃
+	if offset > 0 && len(raw) > 0 {
+		raw = raw[1:]
+	}
+
+	// 按级别过滤（日志行格式：[时间戳] [LEVEL] 消息）
+	var marker string
+	if level != "" {
+		marker = fmt.Sprintf("[%s]", levelNames[ParseLevel(level)])
+	}
+	filtered := make([]string, 0, len(raw))
+	for _, line := range raw {
+		if line == "" {
+			continue
+		}
+		if marker != "" && !strings.Contains(line, marker) {
+			continue
+		}
+		filtered = append(filtered, line)
+	}
+
+	if lines > 0 && len(filtered) > lines {
+		filtered = filtered[len(filtered)-lines:]
+	}
+	return filtered, nil
+}
+
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Codeyangyi/personal-ai-kb/api"
 	"github.com/Codeyangyi/personal-ai-kb/config"
@@ -32,6 +34,8 @@ func main() {
 		fastMode  = flag.Bool("fast", false, "快速模式：使用更大的文本块以减少向量化次数")
 		ultraFast = flag.Bool("ultra-fast", false, "极速模式：使用超大文本块（10000字符），大幅减少向量化次数")
 		port      = flag.String("port", "", "API服务器端口（仅用于server模式）。如果不指定，使用配置文件中的SERVER_PORT或默认8080")
+		dryRun    = flag.Bool("dry-run", false, "试运行模式：只加载和切分文档并报告块数量，不向量化也不写入知识库（用于预估嵌入成本）")
+		noStream  = flag.Bool("no-stream", false, "关闭流式输出：query模式下等完整回答生成后一次性打印（适合脚本/批处理场景）")
 	)
 	flag.Parse()
 
@@ -69,22 +73,86 @@ func main() {
 		}
 	}
 
+	// 设置PDF文本提取后端（PDF_BACKEND，外部工具不可用时自动回退到builtin）
+	loader.SetDefaultPDFBackend(cfg.PDFBackend)
+	loader.SetAllowedDomains(cfg.URLAllowedDomains)
+	loader.SetOCRNormalization(cfg.OCRNormalize, cfg.OCRSubstitutions)
+	loader.SetMinDocChars(cfg.MinDocChars)
+	loader.SetCleanMode(cfg.CleanMode)
+
+	// 设置单个文档的chunk数量上限（MAX_CHUNKS_PER_DOC / CHUNK_CAP_MODE）
+	splitter.SetDefaultChunkCap(cfg.MaxChunksPerDoc, cfg.ChunkCapMode == "reject")
+
+	// 设置检索结果缓存TTL（RETRIEVAL_CACHE_TTL，0表示禁用）
+	rag.SetRetrievalCacheTTL(cfg.RetrievalCacheTTL)
+
+	// 设置入库PII脱敏规则（REDACT_PII / REDACT_PATTERNS）
+	rag.SetRedaction(cfg.RedactPII, cfg.RedactPatterns)
+	rag.SetTraceOptions(cfg.TraceDir, cfg.TraceSampleRate)
+	store.SetPayloadKeys(cfg.QdrantContentKey, cfg.QdrantSourceKey)
+	store.SetCleanMode(cfg.CleanMode)
+	embedding.SetDiskCache(cfg.EmbedCacheDir, cfg.EmbedCacheMaxMB)
+
 	// 创建嵌入向量生成器
 	// 支持硅基流动或Ollama
-	embedder, err := embedding.NewEmbedder(
+	embedder, err := embedding.NewEmbedderWithTimeout(
 		cfg.EmbeddingProvider,
 		cfg.OllamaBaseURL,
 		cfg.EmbeddingModelName,
 		cfg.SiliconFlowAPIKey,
+		time.Duration(cfg.EmbedTimeoutSec)*time.Second,
 	)
 	if err != nil {
 		logger.Fatal("创建嵌入向量生成器失败: %v", err)
 	}
 
-	// 创建向量存储（会自动创建集合如果不存在）
-	vectorStore, err := store.NewQdrantStore(cfg.QdrantURL, cfg.QdrantAPIKey, cfg.CollectionName, embedder.GetEmbedder(), embedder)
-	if err != nil {
-		logger.Fatal("创建向量存储失败: %v", err)
+	// 查询/存储嵌入分离（QUERY_EMBEDDING_PROVIDER / QUERY_EMBEDDING_MODEL）：
+	// 查询路径可使用独立的provider和模型（延迟敏感部署），启动时校验向量空间兼容
+	queryProvider := cfg.QueryEmbeddingProvider
+	if queryProvider == "" {
+		queryProvider = cfg.EmbeddingProvider
+	}
+	queryModel := cfg.QueryEmbeddingModel
+	if queryModel == "" {
+		queryModel = cfg.EmbeddingModelName
+	}
+	if queryProvider != cfg.EmbeddingProvider || queryModel != cfg.EmbeddingModelName {
+		queryEmbedder, err := embedding.NewEmbedderWithTimeout(
+			queryProvider,
+			cfg.OllamaBaseURL,
+			queryModel,
+			cfg.SiliconFlowAPIKey,
+			time.Duration(cfg.EmbedTimeoutSec)*time.Second,
+		)
+		if err != nil {
+			logger.Fatal("创建查询嵌入向量生成器失败: %v", err)
+		}
+		if err := embedder.UseQueryModel(queryEmbedder); err != nil {
+			logger.Fatal("%v", err)
+		}
+	}
+
+	// 创建向量存储（VECTOR_STORE选择后端，默认Qdrant；会自动创建集合如果不存在，维度不匹配时按ON_DIM_MISMATCH处理）
+	var vectorStore store.VectorStore
+	if cfg.VectorStore == "milvus" {
+		vectorStore, err = store.NewMilvusStore(cfg.MilvusAddr, cfg.MilvusUsername, cfg.MilvusPassword, cfg.CollectionName, embedder)
+		if err != nil {
+			logger.Fatal("创建Milvus向量存储失败: %v", err)
+		}
+		logger.Info("使用Milvus向量存储: %s", cfg.MilvusAddr)
+	} else {
+		vectorStore, err = store.NewQdrantStoreWithMode(cfg.QdrantURL, cfg.QdrantAPIKey, cfg.CollectionName, embedder.GetEmbedder(), embedder, cfg.OnDimMismatch)
+		if err != nil {
+			logger.Fatal("创建向量存储失败: %v", err)
+		}
+		// 写入等待（QDRANT_WAIT_WRITES）：AddDocuments返回时新增的点已可被检索
+		vectorStore.(*store.QdrantStore).SetWaitOnWrite(cfg.QdrantWaitWrites)
+		// 瞬时故障重试与熔断（QDRANT_MAX_RETRIES / QDRANT_BREAKER_THRESHOLD / QDRANT_BREAKER_COOLDOWN）
+		vectorStore.(*store.QdrantStore).SetRetryOptions(cfg.QdrantMaxRetries, cfg.QdrantBreakerThreshold, cfg.QdrantBreakerCooldown)
+	}
+	if vectorStore.CollectionName() != cfg.CollectionName {
+		logger.Warn("检测到维度迁移：当前集合为 %s，原集合 %s 保留为备份，请重新加载文档以重建索引", vectorStore.CollectionName(), cfg.CollectionName)
+		cfg.CollectionName = vectorStore.CollectionName()
 	}
 
 	// 创建LLM客户端（根据配置选择Ollama、通义千问或Kimi2）
@@ -95,6 +163,7 @@ func main() {
 		if err != nil {
 			logger.Fatal("创建通义千问客户端失败: %v", err)
 		}
+		llmClient.(*llm.DashScopeLLM).SetResultFormat(cfg.DashScopeResultFormat)
 		logger.Info("使用通义千问模型: %s", cfg.DashScopeModel)
 	} else if cfg.LLMProvider == "kimi" {
 		// 使用Kimi2
@@ -112,9 +181,88 @@ func main() {
 		logger.Info("使用Ollama模型: %s", cfg.OllamaModel)
 	}
 
+	// STRICT_STARTUP：启动时对LLM发一次最小生成请求，模型名或凭证有问题立即失败
+	if cfg.StrictStartup {
+		if err := llm.ValidateStartup(context.Background(), llmClient); err != nil {
+			logger.Fatal("%v", err)
+		}
+	}
+
+	// 设置系统提示词（persona），与RAG检索指令分离
+	if setter, ok := llmClient.(llm.SystemPromptSetter); ok && cfg.SystemPrompt != "" {
+		setter.SetSystemPrompt(cfg.SystemPrompt)
+	}
+
 	// 创建RAG系统
 	ragSystem := rag.NewRAG(embedder, vectorStore, llmClient, *topK)
 
+	// 设置向量存储连接信息（关键词降级检索的scroll接口需要）
+	ragSystem.SetStoreConnection(cfg.QdrantURL, cfg.QdrantAPIKey, cfg.CollectionName)
+
+	// 应用嵌入批次配置（EMBED_BATCH_SIZE / EMBED_BATCH_DELAY_MS，0表示自动）
+	ragSystem.SetEmbedBatchOptions(cfg.EmbedBatchSize, cfg.EmbedBatchDelayMS)
+	ragSystem.SetQueryRewritePrompt(cfg.QueryRewritePrompt)
+	ragSystem.SetRetryBackoff(cfg.EmbedRetryBaseMS, cfg.EmbedRetryMaxMS)
+
+	// 应用重排序关键词长度范围配置
+	ragSystem.SetKeywordGramRange(cfg.KeywordMinGram, cfg.KeywordMaxGram)
+	ragSystem.SetFuzzyMatch(cfg.FuzzyMatch)
+	ragSystem.SetRelevanceFallbackCount(cfg.RelevanceFallbackCount)
+	ragSystem.SetPinnedBoost(cfg.PinnedBoost)
+	ragSystem.SetPromptOrder(cfg.PromptOrder)
+	ragSystem.SetQueryBudget(cfg.QueryBudgetMS)
+	ragSystem.SetAnswerLanguage(cfg.AnswerLanguage)
+	ragSystem.SetAnswerFrame(cfg.AnswerHeader, cfg.AnswerFooter)
+	ragSystem.SetAllowGeneralKnowledge(cfg.AllowGeneralKnowledge)
+
+	// 多集合扇出检索（SEARCH_COLLECTIONS，仅Qdrant）：额外集合初始化失败只跳过
+	var extraStores []store.VectorStore
+	if len(cfg.SearchCollections) > 0 {
+		if _, ok := vectorStore.(*store.QdrantStore); ok {
+			for _, name := range cfg.SearchCollections {
+				if name == "" || name == cfg.CollectionName {
+					continue
+				}
+				extraStore, err := store.NewQdrantStore(cfg.QdrantURL, cfg.QdrantAPIKey, name, embedder.GetEmbedder(), embedder)
+				if err != nil {
+					logger.Warn("⚠️ 额外检索集合 %s 初始化失败，已跳过: %v", name, err)
+					continue
+				}
+				extraStores = append(extraStores, extraStore)
+			}
+			if len(extraStores) > 0 {
+				ragSystem.SetSearchFanOut(extraStores, cfg.FanOutTimeoutMS, cfg.FanOutConcurrency)
+				logger.Info("✅ 多集合扇出检索已开启: %d 个额外集合", len(extraStores))
+			}
+		} else {
+			logger.Warn("⚠️ SEARCH_COLLECTIONS 仅支持Qdrant后端，已忽略")
+		}
+	}
+
+	// 应用引用标注校验配置（ENFORCE_CITATIONS）
+	ragSystem.SetEnforceCitations(cfg.EnforceCitations)
+
+	// 应用"未找到相关信息"提示语配置（NO_ANSWER_MESSAGE，空表示按问题语言自动选择）
+	ragSystem.SetNoAnswerMessage(cfg.NoAnswerMessage)
+
+	// 应用每个来源文档的chunk数量上限配置（MAX_CHUNKS_PER_SOURCE）
+	ragSystem.SetMaxChunksPerSource(cfg.MaxChunksPerSource)
+
+	// 应用检索多样性配置（MIN_DISTINCT_SOURCES）
+	ragSystem.SetMinDistinctSources(cfg.MinDistinctSources)
+
+	// 应用上下文预算配置（MAX_CONTEXT_CHARS / CONTEXT_STRATEGY）
+	ragSystem.SetContextBudget(cfg.MaxContextChars, cfg.ContextStrategy)
+
+	// 应用答案生成模式配置（GENERATION_MODE / REFINE_MAX_ITERATIONS）
+	ragSystem.SetGenerationMode(cfg.GenerationMode, cfg.RefineMaxIterations)
+
+	// 应用答案后处理配置（ANSWER_CLEANUP / ANSWER_STRIP_PATTERNS）
+	ragSystem.SetAnswerCleanup(cfg.AnswerCleanup, cfg.AnswerStripPatterns)
+
+	// 应用答案长度上限配置（MAX_ANSWER_CHARS）
+	ragSystem.SetMaxAnswerChars(cfg.MaxAnswerChars)
+
 	ctx := context.Background()
 
 	switch *mode {
@@ -166,9 +314,21 @@ func main() {
 
 		fmt.Printf("已切分为 %d 个文本块\n", len(chunks))
 
+		// 试运行模式：只报告切分结果，不调用嵌入API
+		if *dryRun {
+			totalChars := 0
+			for _, chunk := range chunks {
+				totalChars += len(chunk.PageContent)
+			}
+			fmt.Printf("试运行完成: 共 %d 个文本块, %d 个字符，未写入知识库\n", len(chunks), totalChars)
+			return
+		}
+
 		// 添加到知识库
 		fmt.Printf("正在向量化并存储到知识库... (共 %d 个文本块)\n", len(chunks))
-		if err := ragSystem.AddDocuments(ctx, chunks); err != nil {
+		if err := ragSystem.AddDocumentsWithProgress(ctx, chunks, func(processed, total int) {
+			fmt.Printf("进度: %d/%d (%.0f%%)\n", processed, total, float64(processed)/float64(total)*100)
+		}); err != nil {
 			logger.Fatal("添加到知识库失败: %v", err)
 		}
 
@@ -196,24 +356,51 @@ func main() {
 				}
 
 				fmt.Println("正在查询...")
-				answer, err := ragSystem.Query(ctx, input)
+				if *noStream {
+					answer, err := ragSystem.Query(ctx, input)
+					if err != nil {
+						logger.Error("查询失败: %v", err)
+						fmt.Printf("查询失败: %v\n", err)
+						continue
+					}
+					fmt.Printf("\n回答: %s\n\n", answer)
+					continue
+				}
+
+				// 流式输出：token一到就打印，交互体验更即时
+				fmt.Print("\n回答: ")
+				_, err := ragSystem.QueryWithResultsStream(ctx, input, func(chunk string) error {
+					fmt.Print(chunk)
+					return nil
+				})
+				fmt.Println()
+				fmt.Println()
 				if err != nil {
 					logger.Error("查询失败: %v", err)
 					fmt.Printf("查询失败: %v\n", err)
-					continue
 				}
-
-				fmt.Printf("\n回答: %s\n\n", answer)
 			}
 		} else {
 			// 单次查询
 			fmt.Printf("问题: %s\n", *question)
 			fmt.Println("正在查询...")
-			answer, err := ragSystem.Query(ctx, *question)
-			if err != nil {
-				logger.Fatal("查询失败: %v", err)
+			if *noStream {
+				answer, err := ragSystem.Query(ctx, *question)
+				if err != nil {
+					logger.Fatal("查询失败: %v", err)
+				}
+				fmt.Printf("\n回答: %s\n", answer)
+			} else {
+				fmt.Print("\n回答: ")
+				_, err := ragSystem.QueryWithResultsStream(ctx, *question, func(chunk string) error {
+					fmt.Print(chunk)
+					return nil
+				})
+				fmt.Println()
+				if err != nil {
+					logger.Fatal("查询失败: %v", err)
+				}
 			}
-			fmt.Printf("\n回答: %s\n", answer)
 		}
 
 	case "load-dir":
@@ -241,7 +428,6 @@ func main() {
 			".htm":  true,
 		}
 
-		var allChunks []schema.Document
 		// 快速模式：使用更大的文本块
 		actualChunkSize := *chunkSize
 		actualOverlap := *overlap
@@ -256,6 +442,30 @@ func main() {
 		}
 		textSplitter := splitter.NewTextSplitter(actualChunkSize, actualOverlap)
 
+		// 续传清单：记录已成功入库的文件，失败中断后重跑时跳过，避免重复向量化
+		manifestPath := filepath.Join(dir, ".ingest-manifest.json")
+		completed := map[string]int{}
+		if data, err := os.ReadFile(manifestPath); err == nil {
+			if err := json.Unmarshal(data, &completed); err != nil {
+				fmt.Printf("警告: 续传清单 %s 解析失败，忽略: %v\n", manifestPath, err)
+				completed = map[string]int{}
+			} else if len(completed) > 0 {
+				fmt.Printf("检测到续传清单 %s，已完成的 %d 个文件将跳过\n", manifestPath, len(completed))
+			}
+		}
+		saveManifest := func() {
+			data, err := json.MarshalIndent(completed, "", "  ")
+			if err == nil {
+				err = os.WriteFile(manifestPath, data, 0644)
+			}
+			if err != nil {
+				fmt.Printf("警告: 写入续传清单失败: %v\n", err)
+			}
+		}
+
+		var failedFiles []string
+		successCount, totalChunks, totalChars := 0, 0, 0
+
 		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -267,24 +477,59 @@ func main() {
 
 			ext := strings.ToLower(filepath.Ext(path))
 			if !supportedExts[ext] {
+				if *dryRun {
+					fmt.Printf("跳过不支持的文件类型: %s\n", path)
+				}
 				return nil
 			}
 
+			if !*dryRun {
+				if _, done := completed[path]; done {
+					fmt.Printf("跳过已完成的文件（续传清单）: %s\n", path)
+					return nil
+				}
+			}
+
 			fmt.Printf("正在加载: %s\n", path)
 			fileLoader := loader.NewFileLoader()
 			docs, err := fileLoader.Load(path)
 			if err != nil {
 				fmt.Printf("警告: 加载 %s 失败: %v\n", path, err)
+				failedFiles = append(failedFiles, path)
 				return nil
 			}
 
 			chunks, err := textSplitter.SplitDocuments(docs)
 			if err != nil {
 				fmt.Printf("警告: 切分 %s 失败: %v\n", path, err)
+				failedFiles = append(failedFiles, path)
 				return nil
 			}
 
-			allChunks = append(allChunks, chunks...)
+			for _, chunk := range chunks {
+				totalChars += len(chunk.PageContent)
+			}
+
+			if *dryRun {
+				fmt.Printf("  %s: %d 个文本块\n", path, len(chunks))
+				totalChunks += len(chunks)
+				return nil
+			}
+
+			// 逐文件向量化入库：单个文件失败不中断整体导入，已完成的文件立即持久化
+			fmt.Printf("正在向量化并存储: %s (%d 个文本块)\n", path, len(chunks))
+			if err := ragSystem.AddDocumentsWithProgress(ctx, chunks, func(processed, total int) {
+				fmt.Printf("进度: %d/%d (%.0f%%)\n", processed, total, float64(processed)/float64(total)*100)
+			}); err != nil {
+				fmt.Printf("❌ 向量化 %s 失败: %v（其余文件继续）\n", path, err)
+				failedFiles = append(failedFiles, path)
+				return nil
+			}
+
+			completed[path] = len(chunks)
+			saveManifest()
+			successCount++
+			totalChunks += len(chunks)
 			return nil
 		})
 
@@ -292,11 +537,18 @@ func main() {
 			logger.Fatal("遍历目录失败: %v", err)
 		}
 
-		fmt.Printf("\n共加载 %d 个文本块\n", len(allChunks))
-		fmt.Println("正在向量化并存储到知识库...")
+		// 试运行模式：只报告切分结果，不调用嵌入API
+		if *dryRun {
+			fmt.Printf("\n试运行完成: 共 %d 个文本块, %d 个字符，未写入知识库\n", totalChunks, totalChars)
+			return
+		}
 
-		if err := ragSystem.AddDocuments(ctx, allChunks); err != nil {
-			logger.Fatal("添加到知识库失败: %v", err)
+		fmt.Printf("\n导入完成: 成功 %d 个文件, 共 %d 个文本块\n", successCount, totalChunks)
+		if len(failedFiles) > 0 {
+			fmt.Printf("⚠️ 以下 %d 个文件导入失败，重新运行 load-dir 将只处理未完成的文件:\n", len(failedFiles))
+			for _, path := range failedFiles {
+				fmt.Printf("  - %s\n", path)
+			}
 		}
 
 	case "server":
@@ -0,0 +1,29 @@
+package rag
+
+import "strings"
+
+// 答案页眉/页脚（ANSWER_HEADER / ANSWER_FOOTER）
+// 合规场景通常要求在AI答案后固定追加免责声明（如"本回答由AI生成，仅供参考"）。
+// 页眉/页脚在所有后处理（引用标注、清理、截断、轨迹导出）之后才拼接，
+// 核心答案文本保持不变：引用校验、长度截断和轨迹里记录的都是不带声明的答案。
+// JSON模式下跳过拼接（会破坏JSON结构）。
+
+// SetAnswerFrame 设置答案的页眉和页脚（ANSWER_HEADER / ANSWER_FOOTER，空表示不追加）
+func (r *RAG) SetAnswerFrame(header, footer string) {
+	r.answerHeader = strings.TrimSpace(header)
+	r.answerFooter = strings.TrimSpace(footer)
+}
+
+// frameAnswer 把页眉/页脚拼接到最终答案上（后处理的最后一步）
+func (r *RAG) frameAnswer(answer string) string {
+	if r.jsonModeEnabled() {
+		return answer
+	}
+	if r.answerHeader != "" {
+		answer = r.answerHeader + "\n\n" + answer
+	}
+	if r.answerFooter != "" {
+		answer = answer + "\n\n" + r.answerFooter
+	}
+	return answer
+}
@@ -0,0 +1,48 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 回答语言（ANSWER_LANGUAGE / 请求级answerLanguage）
+// 跨语言知识库里，用户可能用英文提问中文文档。多语言嵌入模型保证检索照常命中，
+// 但提示词的固定中文指令会让LLM倾向用中文回答。指定回答语言后，提示词追加
+// 明确的语言指令，要求无论文档片段是什么语言都用目标语言作答，并在引用
+// 非目标语言的原文时附上简短翻译。
+
+// answerLanguageNames 常见语言代码到提示词用语言名的映射
+// 不在映射里的值原样进入提示词（允许直接写"法语"、"Español"等）
+var answerLanguageNames = map[string]string{
+	"zh": "中文",
+	"en": "英文（English）",
+	"ja": "日文（日本語）",
+	"ko": "韩文（한국어）",
+	"fr": "法文（Français）",
+	"de": "德文（Deutsch）",
+	"es": "西班牙文（Español）",
+	"ru": "俄文（Русский）",
+}
+
+// SetAnswerLanguage 设置回答语言（ANSWER_LANGUAGE，空表示跟随提示词默认行为）
+// 接受语言代码（en、ja等）或语言名称，代码会映射为提示词用的语言名
+func (r *RAG) SetAnswerLanguage(lang string) {
+	r.answerLanguage = strings.TrimSpace(lang)
+}
+
+// answerLanguageInstruction 返回追加到提示词的回答语言指令（未设置时返回空）
+func (r *RAG) answerLanguageInstruction() string {
+	if r.answerLanguage == "" {
+		return ""
+	}
+	name := r.answerLanguage
+	if mapped, ok := answerLanguageNames[strings.ToLower(name)]; ok {
+		name = mapped
+	}
+	var builder strings.Builder
+	builder.WriteString("\n**回答语言要求**：\n")
+	builder.WriteString(fmt.Sprintf("- 无论文档片段使用什么语言，回答必须使用%s撰写\n", name))
+	builder.WriteString(fmt.Sprintf("- 引用的原文与回答语言不同时，在引用内容后用括号附上%s的简短翻译，再加编号标注\n", name))
+	builder.WriteString("- 专有名词、文号和标准号保留原文写法\n")
+	return builder.String()
+}
@@ -0,0 +1,74 @@
+package rag
+
+import (
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 答案长度硬上限（MAX_ANSWER_CHARS）
+// 个别模型会忽略max_tokens提示产出超长回答，撑爆响应体积：服务端按字符数兜底截断，
+// 尽量在句子边界收尾并补省略号，响应带truncated标记让前端可以提示用户。
+
+// SetMaxAnswerChars 设置答案的最大字符数（按rune计，0表示不限制）
+func (r *RAG) SetMaxAnswerChars(max int) {
+	if max < 0 {
+		max = 0
+	}
+	r.maxAnswerChars = max
+}
+
+// capAnswerLength 答案超限时截断，返回（截断后的答案, 是否发生截断）
+// 优先回退到上限前最近的句末标点；句末标点太靠前（不足上限一半）时按字符硬截
+func (r *RAG) capAnswerLength(answer string) (string, bool) {
+	if r.maxAnswerChars <= 0 {
+		return answer, false
+	}
+	runes := []rune(answer)
+	if len(runes) <= r.maxAnswerChars {
+		return answer, false
+	}
+
+	cut := runes[:r.maxAnswerChars]
+	boundary := -1
+	for i := len(cut) - 1; i >= 0; i-- {
+		if isSentenceEnd(cut[i]) {
+			boundary = i
+			break
+		}
+	}
+	if boundary >= r.maxAnswerChars/2 {
+		cut = cut[:boundary+1]
+	}
+
+	logger.Warn("⚠️ 答案长度 %d 字符超过上限 %d（MAX_ANSWER_CHARS），已截断为 %d 字符", len(runes), r.maxAnswerChars, len(cut))
+	return string(cut) + "……", true
+}
+
+// isSentenceEnd 判断是否句末标点（中英文句号、问号、感叹号或换行）
+func isSentenceEnd(r rune) bool {
+	switch r {
+	case '。', '！', '？', '.', '!', '?', '\n':
+		return true
+	}
+	return false
+}
+
+// limitStreamChunks 包装流式回调：累计转发的字符数达到上限后不再向客户端转发增量内容
+// 流式场景无法回溯已发送的内容，这里只能按字符截停；最终答案仍由capAnswerLength统一截断
+func (r *RAG) limitStreamChunks(onChunk func(string) error) func(string) error {
+	if r.maxAnswerChars <= 0 || onChunk == nil {
+		return onChunk
+	}
+	sent := 0
+	return func(chunk string) error {
+		if sent >= r.maxAnswerChars {
+			return nil
+		}
+		runes := []rune(chunk)
+		if sent+len(runes) > r.maxAnswerChars {
+			runes = runes[:r.maxAnswerChars-sent]
+			chunk = string(runes)
+		}
+		sent += len(runes)
+		return onChunk(chunk)
+	}
+}
@@ -0,0 +1,156 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 上下文超限处理策略（CONTEXT_STRATEGY）
+const (
+	ContextStrategyDropLowest = "drop-lowest-ranked"    // 丢弃排名靠后的片段
+	ContextStrategyTruncate   = "truncate"              // 截断超出预算的部分
+	ContextStrategySummarize  = "summarize-then-answer" // map-reduce：先用LLM逐块摘要，再基于摘要回答
+)
+
+// SetContextBudget 设置上下文预算和超限处理策略
+// maxChars为进入提示词的片段总字符数上限（0表示不限制）
+func (r *RAG) SetContextBudget(maxChars int, strategy string) {
+	r.maxContextChars = maxChars
+	r.contextStrategy = strategy
+}
+
+// applyContextBudget 在构建提示词前检查上下文总量，超出预算时按配置的策略裁剪
+// 让系统在检索内容超过模型窗口时仍能回答，而不是把超长提示词直接交给API报错
+func (r *RAG) applyContextBudget(ctx context.Context, question string, results []schema.Document) []schema.Document {
+	if r.maxContextChars <= 0 || len(results) == 0 {
+		return results
+	}
+
+	total := 0
+	for _, doc := range results {
+		total += len(doc.PageContent)
+	}
+	if total <= r.maxContextChars {
+		return results
+	}
+
+	logger.Info("⚠️ 检索上下文超出预算 (%d > %d 字符)，按策略 %s 处理", total, r.maxContextChars, r.contextStrategy)
+
+	switch r.contextStrategy {
+	case ContextStrategySummarize:
+		results = r.summarizeResults(ctx, question, results)
+		// 摘要后仍可能超限，回退到丢弃排名靠后的片段兜底
+		return r.dropLowestRanked(results)
+	case ContextStrategyTruncate:
+		return r.truncateResults(results)
+	default:
+		return r.dropLowestRanked(results)
+	}
+}
+
+// dropLowestRanked 从排名靠后的片段开始丢弃，直到总量进入预算
+// 至少保留排名第一的片段（即使它单独超出预算）
+func (r *RAG) dropLowestRanked(results []schema.Document) []schema.Document {
+	total := 0
+	kept := make([]schema.Document, 0, len(results))
+	for _, doc := range results {
+		if len(kept) > 0 && total+len(doc.PageContent) > r.maxContextChars {
+			break
+		}
+		kept = append(kept, doc)
+		total += len(doc.PageContent)
+	}
+	if dropped := len(results) - len(kept); dropped > 0 {
+		logger.Info("上下文预算：丢弃排名靠后的 %d 个片段 (保留 %d 个, 共 %d 字符)", dropped, len(kept), total)
+	}
+	return kept
+}
+
+// truncateResults 按排名顺序填充预算，截断超出预算的片段并丢弃其后的片段
+func (r *RAG) truncateResults(results []schema.Document) []schema.Document {
+	remaining := r.maxContextChars
+	kept := make([]schema.Document, 0, len(results))
+	for i, doc := range results {
+		if remaining <= 0 {
+			logger.Info("上下文预算：丢弃排名靠后的 %d 个片段", len(results)-i)
+			break
+		}
+		if len(doc.PageContent) > remaining {
+			doc.PageContent = truncateAtRune(doc.PageContent, remaining)
+			logger.Info("上下文预算：片段 %d 截断至 %d 字符", i+1, len(doc.PageContent))
+		}
+		remaining -= len(doc.PageContent)
+		kept = append(kept, doc)
+	}
+	return kept
+}
+
+// summarizeResults map阶段：用LLM围绕问题逐块提炼片段内容，降低进入最终提示词的上下文体积
+// 单个片段摘要失败时保留原文，不中断整体流程
+func (r *RAG) summarizeResults(ctx context.Context, question string, results []schema.Document) []schema.Document {
+	logger.Info("上下文预算：开始map-reduce摘要 %d 个片段...", len(results))
+	startTime := time.Now()
+
+	summarized := make([]schema.Document, 0, len(results))
+	for i, doc := range results {
+		summary, err := r.summarizeChunk(ctx, question, doc.PageContent)
+		if err != nil {
+			logger.Warn("⚠️ 摘要片段 %d 失败，保留原文: %v", i+1, err)
+			summarized = append(summarized, doc)
+			continue
+		}
+		if summary == "" || len(summary) >= len(doc.PageContent) {
+			// 摘要为空或没有变短时保留原文
+			summarized = append(summarized, doc)
+			continue
+		}
+		logger.Debug("上下文预算：片段 %d 摘要 %d -> %d 字符", i+1, len(doc.PageContent), len(summary))
+		doc.PageContent = summary
+		summarized = append(summarized, doc)
+	}
+
+	logger.Info(" ✅ 摘要完成 (耗时: %v)", time.Since(startTime).Round(time.Millisecond))
+	return summarized
+}
+
+// summarizeChunk 围绕问题提炼单个片段的相关内容
+func (r *RAG) summarizeChunk(ctx context.Context, question, content string) (string, error) {
+	llmCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	prompt := fmt.Sprintf(`请围绕问题提炼下面文档片段中与问题相关的信息，保留关键事实、数字和名称，省略无关内容，不要添加片段中没有的信息。如果片段与问题完全无关，请输出"无相关内容"。
+
+问题: %s
+
+文档片段:
+%s
+
+提炼结果:`, question, content)
+
+	summary, err := r.llm.Generate(llmCtx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
+
+// truncateAtRune 将字符串截断到不超过max字节，保证不切断多字节字符
+func truncateAtRune(s string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
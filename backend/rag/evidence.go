@@ -0,0 +1,133 @@
+package rag
+
+import (
+	"sort"
+	"strings"
+)
+
+// 证据句定位（includeEvidence）
+// chunk级引用只能告诉用户"答案出自哪一段"，高可信场景需要精确到句子。
+// 生成后对每个被引用的chunk做一次轻量匹配：把chunk切成句子，与答案的各个片段
+// 计算字符二元组（bigram）重合度，返回最能支撑答案的原文句子，供前端高亮展示。
+// 纯词法计算、不发起额外的嵌入或LLM调用，但仍有额外开销，因此按请求开关启用。
+
+const (
+	// maxSupportingSentences 每个chunk返回的证据句数量上限
+	maxSupportingSentences = 2
+	// minSupportScore 证据句的最低相似度（Dice系数），低于该值视为不支撑
+	minSupportScore = 0.2
+	// minSentenceRunes 参与匹配的句子最小长度（按rune计），过短的句子没有区分度
+	minSentenceRunes = 8
+)
+
+// SupportingSentences 返回chunk中最能支撑答案的句子（按原文顺序）
+// 没有达到相似度门槛的句子时返回nil
+func SupportingSentences(answer, chunkContent string) []string {
+	segments := splitSentences(stripCitationMarkers(answer))
+	sentences := splitSentences(chunkContent)
+	if len(segments) == 0 || len(sentences) == 0 {
+		return nil
+	}
+
+	segmentGrams := make([]map[string]bool, len(segments))
+	for i, seg := range segments {
+		segmentGrams[i] = charBigrams(seg)
+	}
+
+	type scoredSentence struct {
+		index int
+		score float64
+	}
+	var supported []scoredSentence
+	for i, sentence := range sentences {
+		grams := charBigrams(sentence)
+		best := 0.0
+		for _, segGrams := range segmentGrams {
+			if score := diceCoefficient(grams, segGrams); score > best {
+				best = score
+			}
+		}
+		if best >= minSupportScore {
+			supported = append(supported, scoredSentence{index: i, score: best})
+		}
+	}
+	if len(supported) == 0 {
+		return nil
+	}
+
+	// 取相似度最高的几句，再按原文顺序输出
+	sort.Slice(supported, func(a, b int) bool { return supported[a].score > supported[b].score })
+	if len(supported) > maxSupportingSentences {
+		supported = supported[:maxSupportingSentences]
+	}
+	sort.Slice(supported, func(a, b int) bool { return supported[a].index < supported[b].index })
+
+	result := make([]string, 0, len(supported))
+	for _, s := range supported {
+		result = append(result, sentences[s.index])
+	}
+	return result
+}
+
+// stripCitationMarkers 去掉答案中的圆圈数字标注，避免干扰相似度计算
+func stripCitationMarkers(answer string) string {
+	for _, marker := range circleNumbers {
+		answer = strings.ReplaceAll(answer, marker, "")
+	}
+	return answer
+}
+
+// splitSentences 按中英文句末标点和换行切分句子，过滤过短的片段
+func splitSentences(text string) []string {
+	var sentences []string
+	var builder strings.Builder
+	flush := func() {
+		sentence := strings.TrimSpace(builder.String())
+		builder.Reset()
+		if len([]rune(sentence)) >= minSentenceRunes {
+			sentences = append(sentences, sentence)
+		}
+	}
+	for _, r := range text {
+		switch r {
+		case '。', '！', '？', '；', '!', '?', ';', '\n':
+			flush()
+		case '.':
+			// 英文句号：直接切分（小数/缩写的误切对重合度计算影响很小）
+			flush()
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	flush()
+	return sentences
+}
+
+// charBigrams 提取文本的字符二元组集合（小写化、忽略空白）
+func charBigrams(text string) map[string]bool {
+	runes := make([]rune, 0, len(text))
+	for _, r := range strings.ToLower(text) {
+		if r != ' ' && r != '\t' {
+			runes = append(runes, r)
+		}
+	}
+	grams := make(map[string]bool)
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])] = true
+	}
+	return grams
+}
+
+// diceCoefficient 计算两个二元组集合的Dice相似系数（0~1）
+func diceCoefficient(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for gram := range a {
+		if b[gram] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
@@ -0,0 +1,66 @@
+package rag
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 精确短语检索模式（请求级"exact": true）
+// 法规、合同等场景用户知道确切措辞，语义检索的"近似命中"反而是干扰：
+// 向量召回后只保留字面包含完整查询短语的chunk，按短语出现位置从前到后排序，
+// 一个都没命中时直接走"未找到相关信息"回复，不让LLM基于近似内容发挥。
+
+// SetExactPhrase 开启/关闭精确短语模式（按请求设置，只影响当次RAG实例）
+func (r *RAG) SetExactPhrase(enabled bool) {
+	r.exactPhrase = enabled
+}
+
+// filterExactPhrase 只保留字面包含查询短语的chunk并按出现位置排序（取前topK个）
+// 匹配前双方都做归一化：转小写、去掉所有空白，容忍PDF提取引入的多余空格
+func (r *RAG) filterExactPhrase(question string, allResults []schema.Document) []schema.Document {
+	phrase := normalizeExactPhrase(question)
+	if phrase == "" {
+		return nil
+	}
+
+	type matched struct {
+		doc schema.Document
+		pos int
+	}
+	hits := make([]matched, 0, len(allResults))
+	for _, doc := range allResults {
+		if pos := strings.Index(normalizeExactPhrase(doc.PageContent), phrase); pos >= 0 {
+			hits = append(hits, matched{doc: doc, pos: pos})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].pos < hits[j].pos
+	})
+
+	results := make([]schema.Document, 0, len(hits))
+	for _, h := range hits {
+		results = append(results, h.doc)
+		if len(results) >= r.topK {
+			break
+		}
+	}
+	logger.Info("精确短语模式: %d 个候选中 %d 个字面命中，返回 %d 个", len(allResults), len(hits), len(results))
+	return results
+}
+
+// normalizeExactPhrase 精确匹配前的归一化：转小写、去掉所有空白字符和末尾的句末标点
+func normalizeExactPhrase(text string) string {
+	var builder strings.Builder
+	builder.Grow(len(text))
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return strings.TrimRight(builder.String(), "？?。.！!")
+}
@@ -0,0 +1,130 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/Codeyangyi/personal-ai-kb/store"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 多集合并发检索（SEARCH_COLLECTIONS / FANOUT_TIMEOUT_MS / FANOUT_CONCURRENCY）
+// 配置了额外集合时，检索同时扇出到主集合和所有额外集合，合并候选池后按真实
+// 相似度分数排序，再进入常规的重排序流程。扇出并发有上限，单个集合有独立超时：
+// 一个慢集合不会拖住整条查询，多集合查询的延迟接近单集合。
+const (
+	// defaultFanOutTimeoutMS 单个集合的默认检索超时
+	defaultFanOutTimeoutMS = 10000
+	// defaultFanOutConcurrency 默认的扇出并发上限
+	defaultFanOutConcurrency = 4
+)
+
+// SetSearchFanOut 设置多集合扇出检索的额外集合和并发参数
+// stores为空表示只搜主集合（默认行为）；timeoutMS/concurrency传0使用默认值
+func (r *RAG) SetSearchFanOut(stores []store.VectorStore, timeoutMS, concurrency int) {
+	r.fanOutStores = stores
+	r.fanOutTimeoutMS = timeoutMS
+	r.fanOutConcurrency = concurrency
+}
+
+// searchCollections 检索入口：配置了额外集合时并发扇出，否则只搜主集合
+func (r *RAG) searchCollections(ctx context.Context, searchQuery string, searchTopK int) ([]schema.Document, error) {
+	if len(r.fanOutStores) == 0 {
+		return r.backendSearch(ctx, r.store, searchQuery, searchTopK)
+	}
+	return r.fanOutSearch(ctx, searchQuery, searchTopK)
+}
+
+// fanOutSearch 并发检索主集合和所有额外集合，按分数合并候选池
+// 单个集合失败或超时只告警；全部失败时返回主集合的错误
+func (r *RAG) fanOutSearch(ctx context.Context, searchQuery string, searchTopK int) ([]schema.Document, error) {
+	stores := append([]store.VectorStore{r.store}, r.fanOutStores...)
+
+	timeout := time.Duration(r.fanOutTimeoutMS) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultFanOutTimeoutMS * time.Millisecond
+	}
+	concurrency := r.fanOutConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultFanOutConcurrency
+	}
+
+	type collectionResult struct {
+		docs []schema.Document
+		err  error
+	}
+	results := make([]collectionResult, len(stores))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	startTime := time.Now()
+	for i, backend := range stores {
+		wg.Add(1)
+		go func(i int, backend store.VectorStore) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			searchCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			docs, err := r.backendSearch(searchCtx, backend, searchQuery, searchTopK)
+			if err != nil {
+				logger.Warn("⚠️ 集合 %s 检索失败（其余集合不受影响）: %v", backend.CollectionName(), err)
+			}
+			results[i] = collectionResult{docs: docs, err: err}
+		}(i, backend)
+	}
+	wg.Wait()
+
+	// 合并候选池：按真实相似度分数降序，跨集合去重
+	merged := make([]schema.Document, 0, searchTopK*len(stores))
+	succeeded := 0
+	for _, result := range results {
+		if result.err == nil {
+			succeeded++
+			merged = append(merged, result.docs...)
+		}
+	}
+	if succeeded == 0 {
+		if results[0].err != nil {
+			return nil, results[0].err
+		}
+		return nil, fmt.Errorf("所有集合检索均失败")
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Score > merged[j].Score
+	})
+	merged = dedupeAcrossCollections(merged)
+	if len(merged) > searchTopK {
+		merged = merged[:searchTopK]
+	}
+
+	logger.Info("✅ 多集合检索完成: %d/%d 个集合成功, 合并后 %d 个候选 (耗时: %v)",
+		succeeded, len(stores), len(merged), time.Since(startTime).Round(time.Millisecond))
+	return merged, nil
+}
+
+// dedupeAcrossCollections 跨集合去重（分片是主集合的冗余副本，同一chunk可能被多个集合命中）
+// 优先用file_id+chunk_index识别同一chunk，缺元数据时退回内容本身
+func dedupeAcrossCollections(docs []schema.Document) []schema.Document {
+	seen := make(map[string]bool, len(docs))
+	deduped := make([]schema.Document, 0, len(docs))
+	for _, doc := range docs {
+		key := doc.PageContent
+		fileID, hasFileID := doc.Metadata["file_id"].(string)
+		if chunkIndex, hasIndex := metadataInt(doc.Metadata["chunk_index"]); hasFileID && hasIndex {
+			key = fmt.Sprintf("%s|%d", fileID, chunkIndex)
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, doc)
+	}
+	return deduped
+}
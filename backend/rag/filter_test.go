@@ -0,0 +1,69 @@
+package rag
+
+import (
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+func docWithContent(content string) schema.Document {
+	return schema.Document{PageContent: content, Metadata: map[string]interface{}{}}
+}
+
+func TestFilterRelevantResultsEnglish(t *testing.T) {
+	r := NewRAG(nil, nil, nil, 3)
+
+	results := []schema.Document{
+		docWithContent("To reset the admin password, open the settings page and click Security."),
+		docWithContent("我们提供多种口味的披萨和意大利面。"),
+	}
+
+	filtered := r.filterRelevantResults("How do I reset the admin password?", results)
+
+	found := false
+	for _, doc := range filtered {
+		if doc.PageContent == results[0].PageContent {
+			found = true
+		}
+		if doc.PageContent == results[1].PageContent {
+			t.Errorf("不相关的片段未被过滤: %q", doc.PageContent)
+		}
+	}
+	if !found {
+		t.Errorf("英文问题的相关片段被误过滤，filtered=%d个", len(filtered))
+	}
+}
+
+func TestFilterRelevantResultsChinese(t *testing.T) {
+	r := NewRAG(nil, nil, nil, 3)
+
+	results := []schema.Document{
+		docWithContent("信息安全管理制度要求所有员工每年参加安全培训。"),
+	}
+
+	filtered := r.filterRelevantResults("信息安全管理制度有哪些要求？", results)
+	if len(filtered) != 1 {
+		t.Errorf("中文问题的相关片段被误过滤，filtered=%d个", len(filtered))
+	}
+}
+
+func TestFilterRelevantResultsMixed(t *testing.T) {
+	r := NewRAG(nil, nil, nil, 3)
+
+	results := []schema.Document{
+		docWithContent("Kubernetes集群的部署步骤：首先安装kubectl工具。"),
+		docWithContent("年度财务报表审计流程说明。"),
+	}
+
+	filtered := r.filterRelevantResults("如何部署Kubernetes集群？", results)
+
+	found := false
+	for _, doc := range filtered {
+		if doc.PageContent == results[0].PageContent {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("中英混合问题的相关片段被误过滤，filtered=%d个", len(filtered))
+	}
+}
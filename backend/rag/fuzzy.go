@@ -0,0 +1,75 @@
+package rag
+
+// 关键词模糊匹配（FUZZY_MATCH）
+// 精确的子串包含匹配会漏掉形态变化和OCR错字（如扫描件里"安全"被识别成"安金"）。
+// 开启后，碎片关键词在精确匹配失败时退化为编辑距离匹配：关键词与内容任意子串的
+// 最小编辑距离在阈值内即视为命中。阈值随关键词长度放宽，短关键词不做模糊匹配，
+// 避免把不相关的词也算作命中。
+
+// fuzzyMinKeywordRunes 参与模糊匹配的关键词最小长度（按rune计）
+// 两字词做1距离匹配时会命中大量不相关的词（如"培训"命中"训练"），因此只对更长的词启用
+const fuzzyMinKeywordRunes = 3
+
+// SetFuzzyMatch 设置是否启用关键词模糊匹配（FUZZY_MATCH，默认关闭保持精确匹配）
+func (r *RAG) SetFuzzyMatch(enabled bool) {
+	r.fuzzyMatch = enabled
+}
+
+// fuzzyKeywordMatch 判断内容中是否存在与关键词编辑距离在阈值内的子串
+// 阈值：3-5个rune的关键词允许1处差异，6个及以上允许2处
+func fuzzyKeywordMatch(content, keyword string) bool {
+	keywordRunes := []rune(keyword)
+	if len(keywordRunes) < fuzzyMinKeywordRunes {
+		return false
+	}
+	maxDist := 1
+	if len(keywordRunes) >= 6 {
+		maxDist = 2
+	}
+	return fuzzySearchDistance(keywordRunes, []rune(content)) <= maxDist
+}
+
+// fuzzySearchDistance 返回keyword与content任意子串的最小编辑距离
+// 标准编辑距离DP的子串搜索变体：首行全置0，允许匹配从内容的任意位置开始
+func fuzzySearchDistance(keyword, content []rune) int {
+	if len(keyword) == 0 {
+		return 0
+	}
+	if len(content) == 0 {
+		return len(keyword)
+	}
+
+	// prev[j]表示keyword前i个rune与content中以位置j结尾的最优子串的编辑距离
+	prev := make([]int, len(content)+1)
+	curr := make([]int, len(content)+1)
+
+	best := len(keyword)
+	for i := 1; i <= len(keyword); i++ {
+		curr[0] = i
+		for j := 1; j <= len(content); j++ {
+			cost := 1
+			if keyword[i-1] == content[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	for j := 0; j <= len(content); j++ {
+		if prev[j] < best {
+			best = prev[j]
+		}
+	}
+	return best
+}
+
+// minInt 返回若干整数中的最小值
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
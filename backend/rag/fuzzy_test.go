@@ -0,0 +1,101 @@
+package rag
+
+import "testing"
+
+func TestFuzzySearchDistance(t *testing.T) {
+	cases := []struct {
+		name    string
+		keyword string
+		content string
+		want    int
+	}{
+		{
+			name:    "精确包含",
+			keyword: "培训要求",
+			content: "本项目的培训要求包括3项",
+			want:    0,
+		},
+		{
+			name:    "一处错字",
+			keyword: "信息安全",
+			content: "加强信息安金管理工作", // OCR常见错字：全→金
+			want:    1,
+		},
+		{
+			name:    "一处缺字",
+			keyword: "管理制度",
+			content: "完善管理度建设",
+			want:    1,
+		},
+		{
+			name:    "完全不相关",
+			keyword: "数据备份",
+			content: "会议纪要",
+			want:    4,
+		},
+		{
+			name:    "空内容",
+			keyword: "培训",
+			content: "",
+			want:    2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := fuzzySearchDistance([]rune(tc.keyword), []rune(tc.content))
+			if got != tc.want {
+				t.Errorf("fuzzySearchDistance(%q, %q) = %d, want %d", tc.keyword, tc.content, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFuzzyKeywordMatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		keyword string
+		want    bool
+	}{
+		{
+			name:    "召回OCR错字",
+			content: "加强信息安金管理工作",
+			keyword: "信息安全",
+			want:    true,
+		},
+		{
+			name:    "召回形态变化",
+			content: "年度培训计划的内容",
+			keyword: "培训计划书", // 比内容多一个字，精确包含失败
+			want:    true,
+		},
+		{
+			name:    "长关键词允许两处差异",
+			content: "网络安全等保测评报告",
+			keyword: "网络安全等级测评",
+			want:    true,
+		},
+		{
+			name:    "不过度匹配不相关词",
+			content: "数据分析平台使用手册",
+			keyword: "数据备份策略",
+			want:    false,
+		},
+		{
+			name:    "短关键词不做模糊匹配",
+			content: "训练场地安排",
+			keyword: "培训",
+			want:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := fuzzyKeywordMatch(tc.content, tc.keyword)
+			if got != tc.want {
+				t.Errorf("fuzzyKeywordMatch(%q, %q) = %v, want %v", tc.content, tc.keyword, got, tc.want)
+			}
+		})
+	}
+}
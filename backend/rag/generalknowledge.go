@@ -0,0 +1,83 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 通用知识兜底（ALLOW_GENERAL_KNOWLEDGE）
+// 默认情况下检索不到相关信息时直接返回"未找到"提示，保持严格的知识库边界。
+// 有些部署更希望"有帮助的通用回答"而不是没有回答：开启后，检索落空时改用LLM的
+// 通用知识作答，并在答案前加上醒目的"此回答不基于知识库"声明；响应里同时带上
+// fromKnowledgeBase:false标记，前端可以据此做视觉区分，避免用户误以为答案有出处。
+
+// generalKnowledgeDisclaimer 通用知识答案的固定声明（服务端拼接，不依赖LLM自觉）
+const generalKnowledgeDisclaimer = "⚠️ 此回答不基于知识库，由AI通用知识生成，仅供参考。\n\n"
+
+// SetAllowGeneralKnowledge 设置检索落空时是否允许用LLM通用知识作答
+// （ALLOW_GENERAL_KNOWLEDGE，默认关闭，保持严格的知识库边界）
+func (r *RAG) SetAllowGeneralKnowledge(allow bool) {
+	r.allowGeneralKnowledge = allow
+}
+
+// buildGeneralKnowledgePrompt 构建通用知识回答的提示词
+// 明确告知LLM知识库中没有相关内容，要求直接回答并保持克制（不确定时承认不确定）
+func (r *RAG) buildGeneralKnowledgePrompt(question string) string {
+	var builder strings.Builder
+	builder.WriteString("知识库中没有找到与用户问题相关的内容。请基于你的通用知识直接回答用户的问题。\n\n")
+	builder.WriteString("要求：\n")
+	builder.WriteString("1. 直接回答问题，不要提及知识库或检索过程\n")
+	builder.WriteString("2. 不确定的内容如实说明，不要编造具体的文号、数据或出处\n")
+	builder.WriteString("3. 回答保持简洁\n")
+	if instruction := r.answerLanguageInstruction(); instruction != "" {
+		builder.WriteString(instruction)
+	}
+	builder.WriteString(fmt.Sprintf("\n用户问题：%s\n", question))
+	return builder.String()
+}
+
+// generalKnowledgeAnswer 检索落空时用LLM通用知识生成答案（非流式）
+// 返回的答案已带固定声明前缀；生成失败时回退到常规的"未找到"提示
+func (r *RAG) generalKnowledgeAnswer(ctx context.Context, question string) string {
+	logger.Info("🔍 知识库未命中，使用LLM通用知识作答（ALLOW_GENERAL_KNOWLEDGE）...")
+	llmCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	answer, err := r.llm.Generate(llmCtx, r.buildGeneralKnowledgePrompt(question))
+	if err != nil {
+		logger.Warn("⚠️ 通用知识回答生成失败，回退到未找到提示: %v", err)
+		return r.noAnswerFor(question)
+	}
+	return generalKnowledgeDisclaimer + strings.TrimSpace(answer)
+}
+
+// generalKnowledgeAnswerStream 检索落空时用LLM通用知识流式生成答案
+// 先把固定声明发给客户端，再转发LLM的增量内容；失败时把"未找到"提示作为chunk发出
+func (r *RAG) generalKnowledgeAnswerStream(ctx context.Context, question string, onChunk func(string) error) (string, error) {
+	logger.Info("🔍 知识库未命中，使用LLM通用知识作答（ALLOW_GENERAL_KNOWLEDGE，流式）...")
+	if onChunk != nil {
+		if err := onChunk(generalKnowledgeDisclaimer); err != nil {
+			return "", err
+		}
+	}
+
+	llmCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	answer, err := r.llm.GenerateStream(llmCtx, r.buildGeneralKnowledgePrompt(question), onChunk)
+	if err != nil {
+		logger.Warn("⚠️ 通用知识回答生成失败，回退到未找到提示: %v", err)
+		noAnswer := r.noAnswerFor(question)
+		if onChunk != nil {
+			if chunkErr := onChunk(noAnswer); chunkErr != nil {
+				return "", chunkErr
+			}
+		}
+		return noAnswer, nil
+	}
+	return generalKnowledgeDisclaimer + strings.TrimSpace(answer), nil
+}
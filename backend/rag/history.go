@@ -0,0 +1,59 @@
+package rag
+
+import (
+	"strings"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 多轮对话的检索偏置
+// 追问常带代词和省略（"它的费用是多少？"），只靠当前问题检索会丢失指代的实体：
+// 把最近几轮对话中的关键词承接到检索查询里（只影响向量检索，不改写送给LLM的问题），
+// 让"它"指向的实体仍然出现在检索词中。
+
+// 承接进检索查询的历史关键词数量上限，避免历史过长时冲淡当前问题
+const maxCarriedKeywords = 8
+
+// SetConversationHistory 设置本次查询携带的历史轮次（按请求设置）
+// history按时间顺序排列（最早的在前），turns为参与关键词承接的最近轮数（0表示禁用承接）
+func (r *RAG) SetConversationHistory(history []string, turns int) {
+	r.conversationHistory = history
+	r.historyCarryTurns = turns
+}
+
+// retrievalQueryFor 构建带历史关键词的检索查询
+// 从最近几轮历史中提取关键词，去掉当前问题已包含的，拼接到查询末尾偏置向量检索
+func (r *RAG) retrievalQueryFor(question string) string {
+	if r.historyCarryTurns <= 0 || len(r.conversationHistory) == 0 {
+		return question
+	}
+
+	recent := r.conversationHistory
+	if len(recent) > r.historyCarryTurns {
+		recent = recent[len(recent)-r.historyCarryTurns:]
+	}
+
+	lowerQuestion := strings.ToLower(question)
+	seen := make(map[string]bool)
+	carried := make([]string, 0, maxCarriedKeywords)
+
+	// 从最近的轮次开始取关键词，越新的轮次与当前追问越相关
+	for i := len(recent) - 1; i >= 0 && len(carried) < maxCarriedKeywords; i-- {
+		for _, keyword := range extractKeywords(recent[i], r.keywordMinGram, r.keywordMaxGram) {
+			if len(carried) >= maxCarriedKeywords {
+				break
+			}
+			if seen[keyword] || strings.Contains(lowerQuestion, keyword) {
+				continue
+			}
+			seen[keyword] = true
+			carried = append(carried, keyword)
+		}
+	}
+
+	if len(carried) == 0 {
+		return question
+	}
+	logger.Debug("[调试] 多轮承接: 检索查询附加历史关键词 %v", carried)
+	return question + " " + strings.Join(carried, " ")
+}
@@ -0,0 +1,74 @@
+package rag
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// 文档编号的归一化匹配
+// 用户输入文号、标准号时格式千差万别："第 2023-15 号"、"第2023-15号"、"２０２３－１５"
+// 其实是同一个编号，逐字符的包含匹配会漏掉。这里只针对编号类token做归一化
+// （全角转半角、去掉分隔符），不碰普通文本，避免过度归一化引入误匹配。
+
+// idTokenRe 匹配编号类token：字母/数字段用横线、斜杠、点等分隔符连接的标识符，
+// 如 2023-15、GB/T 7714-2015、ISO 9001；全角数字和字母也纳入候选
+var idTokenRe = regexp.MustCompile(`[A-Za-z0-9０-９Ａ-Ｚａ-ｚ]+(?:[ 　\-—–－/／._·][A-Za-z0-9０-９Ａ-Ｚａ-ｚ]+)+`)
+
+// idSeparators 归一化时去掉的分隔符（半角/全角空格、各类横线、斜杠、点、间隔号）
+const idSeparators = " 　-—–－/／._·"
+
+// extractIDTokens 从查询中提取编号类token并归一化
+// 只保留至少包含一个数字的token——纯字母的组合（如 open source）不是编号
+func extractIDTokens(text string) []string {
+	matches := idTokenRe.FindAllString(text, -1)
+	tokens := make([]string, 0, len(matches))
+	for _, m := range matches {
+		hasDigit := false
+		for _, r := range m {
+			if unicode.IsDigit(r) {
+				hasDigit = true
+				break
+			}
+		}
+		if !hasDigit {
+			continue
+		}
+		if normalized := normalizeIDToken(m); normalized != "" {
+			tokens = append(tokens, normalized)
+		}
+	}
+	return tokens
+}
+
+// normalizeIDToken 归一化编号类token：全角字母数字转半角、转小写、去掉所有分隔符
+func normalizeIDToken(token string) string {
+	var builder strings.Builder
+	builder.Grow(len(token))
+	for _, r := range token {
+		if strings.ContainsRune(idSeparators, r) {
+			continue
+		}
+		builder.WriteRune(unicode.ToLower(toHalfWidth(r)))
+	}
+	return builder.String()
+}
+
+// normalizeIDText 对整段内容做同样的归一化，用于编号token的包含匹配
+// 内容里的汉字等非编号字符原样保留，只去掉分隔符、折叠全角字母数字
+func normalizeIDText(text string) string {
+	return normalizeIDToken(text)
+}
+
+// toHalfWidth 全角字母数字转半角，其余字符原样返回
+func toHalfWidth(r rune) rune {
+	switch {
+	case r >= '０' && r <= '９':
+		return r - '０' + '0'
+	case r >= 'Ａ' && r <= 'Ｚ':
+		return r - 'Ａ' + 'A'
+	case r >= 'ａ' && r <= 'ｚ':
+		return r - 'ａ' + 'a'
+	}
+	return r
+}
@@ -0,0 +1,89 @@
+package rag
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractIDTokens(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "文号带空格",
+			text: "第 2023-15 号文件的主要内容是什么",
+			want: []string{"202315"},
+		},
+		{
+			name: "标准号带斜杠和空格",
+			text: "GB/T 7714-2015 的引用格式",
+			want: []string{"gbt77142015"},
+		},
+		{
+			name: "全角数字和横线",
+			text: "查一下２０２３－１５号文",
+			want: []string{"202315"},
+		},
+		{
+			name: "纯字母组合不算编号",
+			text: "open-source 项目管理",
+			want: []string{},
+		},
+		{
+			name: "没有编号",
+			text: "信息安全管理制度有哪些要求",
+			want: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := extractIDTokens(c.text)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("extractIDTokens(%q) = %v, 期望 %v", c.text, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIDText(t *testing.T) {
+	cases := []struct {
+		name    string
+		token   string
+		content string
+	}{
+		{
+			name:    "查询带空格内容紧凑",
+			token:   "第 2023-15 号",
+			content: "根据第2023-15号文件的规定",
+		},
+		{
+			name:    "查询紧凑内容带空格",
+			token:   "GB/T7714-2015",
+			content: "参考文献著录规则见 GB/T 7714-2015 标准",
+		},
+		{
+			name:    "内容使用全角字符",
+			token:   "2023-15",
+			content: "文号：２０２３－１５",
+		},
+		{
+			name:    "大小写差异",
+			token:   "iso 9001-2015",
+			content: "已通过ISO 9001-2015质量体系认证",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tokenNorm := normalizeIDToken(c.token)
+			contentNorm := normalizeIDText(c.content)
+			if !strings.Contains(contentNorm, tokenNorm) {
+				t.Errorf("归一化后 %q 应包含 %q（原内容 %q，原token %q）", contentNorm, tokenNorm, c.content, c.token)
+			}
+		})
+	}
+}
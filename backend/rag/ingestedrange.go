@@ -0,0 +1,73 @@
+package rag
+
+import (
+	"context"
+
+	"github.com/Codeyangyi/personal-ai-kb/store"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 按入库时间范围过滤检索（请求参数after / before）
+// 每个chunk在入库时带上ingested_at（Unix秒）元数据，查询指定时间范围后，
+// 检索只命中范围内入库的文档，支持"根据本月新增的文档回答"和审计近期新增内容。
+// Qdrant后端用原生range filter在检索阶段过滤；其他后端检索后按元数据兜底过滤。
+
+// SetIngestedRange 设置检索的入库时间范围（Unix秒，0表示该侧不限制）
+func (r *RAG) SetIngestedRange(afterUnix, beforeUnix int64) {
+	r.ingestedAfter = afterUnix
+	r.ingestedBefore = beforeUnix
+}
+
+// hasIngestedRange 是否设置了入库时间范围
+func (r *RAG) hasIngestedRange() bool {
+	return r.ingestedAfter > 0 || r.ingestedBefore > 0
+}
+
+// backendSearch 对单个后端执行检索，按需应用入库时间范围过滤
+// Qdrant后端走原生range filter（范围外的点不占topK名额）；其他后端先检索再兜底过滤
+func (r *RAG) backendSearch(ctx context.Context, backend store.VectorStore, searchQuery string, searchTopK int) ([]schema.Document, error) {
+	if !r.hasIngestedRange() {
+		return backend.Search(ctx, searchQuery, r.embedder.GetEmbedder(), searchTopK)
+	}
+	if qdrantStore, ok := backend.(*store.QdrantStore); ok {
+		return qdrantStore.SearchWithIngestedRange(ctx, searchQuery, r.embedder.GetEmbedder(), searchTopK, r.ingestedAfter, r.ingestedBefore)
+	}
+	docs, err := backend.Search(ctx, searchQuery, r.embedder.GetEmbedder(), searchTopK)
+	if err != nil {
+		return nil, err
+	}
+	return docsInIngestedRange(docs, r.ingestedAfter, r.ingestedBefore), nil
+}
+
+// docsInIngestedRange 按ingested_at元数据过滤文档（兜底路径，用于不支持range filter的后端）
+// 没有ingested_at元数据的文档（范围过滤功能上线前入库的存量数据）视为范围外
+func docsInIngestedRange(docs []schema.Document, afterUnix, beforeUnix int64) []schema.Document {
+	filtered := make([]schema.Document, 0, len(docs))
+	for _, doc := range docs {
+		ingestedAt, ok := metadataInt64(doc.Metadata["ingested_at"])
+		if !ok {
+			continue
+		}
+		if afterUnix > 0 && ingestedAt < afterUnix {
+			continue
+		}
+		if beforeUnix > 0 && ingestedAt > beforeUnix {
+			continue
+		}
+		filtered = append(filtered, doc)
+	}
+	return filtered
+}
+
+// metadataInt64 从metadata值中解析Unix时间戳（JSON反序列化后数字是float64）
+func metadataInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package rag
+
+import (
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 构造带ingested_at元数据的文档（值类型模拟JSON反序列化后的float64等情况）
+func docIngestedAt(name string, ingestedAt interface{}) schema.Document {
+	meta := map[string]interface{}{"file_name": name}
+	if ingestedAt != nil {
+		meta["ingested_at"] = ingestedAt
+	}
+	return schema.Document{PageContent: name, Metadata: meta}
+}
+
+func TestDocsInIngestedRange(t *testing.T) {
+	docs := []schema.Document{
+		docIngestedAt("old.txt", int64(1000)),
+		docIngestedAt("mid.txt", float64(2000)), // Qdrant返回的数字经JSON反序列化为float64
+		docIngestedAt("new.txt", int64(3000)),
+		docIngestedAt("legacy.txt", nil), // 范围过滤上线前入库的存量数据，没有ingested_at
+	}
+
+	tests := []struct {
+		name   string
+		after  int64
+		before int64
+		want   []string
+	}{
+		{"双侧范围", 1500, 2500, []string{"mid.txt"}},
+		{"只限下限", 2000, 0, []string{"mid.txt", "new.txt"}},
+		{"只限上限", 0, 2000, []string{"old.txt", "mid.txt"}},
+		{"边界值包含", 1000, 3000, []string{"old.txt", "mid.txt", "new.txt"}},
+		{"范围外全部过滤", 5000, 0, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filtered := docsInIngestedRange(docs, tt.after, tt.before)
+			if len(filtered) != len(tt.want) {
+				t.Fatalf("过滤后数量 = %d, 期望 %d", len(filtered), len(tt.want))
+			}
+			for i, doc := range filtered {
+				if name := doc.Metadata["file_name"]; name != tt.want[i] {
+					t.Errorf("第%d个结果 = %v, 期望 %s", i, name, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDocsInIngestedRangeSkipsLegacyDocs(t *testing.T) {
+	docs := []schema.Document{
+		docIngestedAt("legacy.txt", nil),
+		docIngestedAt("typed.txt", "not-a-number"),
+	}
+	if filtered := docsInIngestedRange(docs, 1, 0); len(filtered) != 0 {
+		t.Errorf("缺少/非法ingested_at的文档应视为范围外，实际保留了 %d 个", len(filtered))
+	}
+}
@@ -0,0 +1,76 @@
+package rag
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Codeyangyi/personal-ai-kb/llm"
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 结构化输出模式（responseFormat=json）
+// 下游自动化（如从知识库提取义务条款表格）需要机器可读的答案：
+// 要求LLM按用户给定的结构输出JSON，支持原生JSON模式的provider直接启用，
+// 返回前在服务端校验有效性，解析失败时报错而不是把残缺的JSON交给调用方。
+
+// SetResponseFormat 设置答案输出格式（按请求设置）
+// format支持""（默认纯文本）和"json"；schemaHint为期望的JSON结构说明（JSON Schema或字段示例，可为空）
+func (r *RAG) SetResponseFormat(format, schemaHint string) error {
+	switch format {
+	case "", "json":
+	default:
+		return fmt.Errorf("不支持的responseFormat: %s，支持的值: json", format)
+	}
+	r.responseFormat = format
+	r.jsonSchemaHint = schemaHint
+	// 支持原生JSON模式的provider（通义千问、Kimi2）直接启用，降低输出格式错误的概率
+	// 通过浅拷贝按请求启用：默认路径的客户端是进程级共享的，直接改它的JSON模式
+	// 会在并发查询间串扰（纯文本查询被带上response_format=json，反之亦然）
+	if format == "json" {
+		if jsonLLM, ok := r.llm.(llm.JSONModeLLM); ok {
+			r.llm = jsonLLM.WithJSONMode(true)
+		}
+	}
+	return nil
+}
+
+// jsonModeEnabled 本次查询是否要求结构化JSON输出
+func (r *RAG) jsonModeEnabled() bool {
+	return r.responseFormat == "json"
+}
+
+// appendJSONInstruction 在提示词末尾追加JSON输出要求
+// 引用标注（①②③）会破坏JSON结构，结构化模式下明确禁止
+func (r *RAG) appendJSONInstruction(builder *strings.Builder) {
+	builder.WriteString("\n**输出格式要求（必须遵守）**：只输出一个合法的JSON对象，不要输出Markdown代码块、解释文字或文档编号标注（①②③等）。\n")
+	if r.jsonSchemaHint != "" {
+		builder.WriteString("JSON结构要求如下：\n")
+		builder.WriteString(r.jsonSchemaHint)
+		builder.WriteString("\n")
+	}
+}
+
+// validateJSONAnswer 校验LLM返回的内容是合法JSON，容忍常见的Markdown代码块包裹
+func (r *RAG) validateJSONAnswer(answer string) (string, error) {
+	cleaned := stripJSONCodeFence(answer)
+	if !json.Valid([]byte(cleaned)) {
+		logger.Warn("⚠️ 结构化输出校验失败，LLM返回的内容不是有效JSON（长度 %d 字符）", len(answer))
+		return "", fmt.Errorf("结构化输出解析失败：LLM返回的内容不是有效JSON")
+	}
+	return cleaned, nil
+}
+
+// stripJSONCodeFence 去掉包裹JSON的Markdown代码块标记（```json ... ```）
+// 部分模型即使被要求纯JSON输出，仍习惯性地加上代码块
+func stripJSONCodeFence(answer string) string {
+	trimmed := strings.TrimSpace(answer)
+	if !strings.HasPrefix(trimmed, "```") {
+		return trimmed
+	}
+	trimmed = strings.TrimSuffix(trimmed, "```")
+	if idx := strings.Index(trimmed, "\n"); idx >= 0 {
+		trimmed = trimmed[idx+1:]
+	}
+	return strings.TrimSpace(trimmed)
+}
@@ -0,0 +1,127 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 关键词降级检索
+// 嵌入API故障时所有查询都会失败，但存储的chunk内容本身还在：
+// 降级为scroll取出存量内容，在内存中做BM25关键词打分，返回近似结果并带degraded标记，
+// 比直接报错可用性好得多（答案质量下降，但嵌入服务恢复前用户仍能查到东西）。
+
+// 降级检索扫描的文档数量上限，知识库更大时只在最早写入的部分中检索
+const keywordFallbackScanLimit = 2000
+
+// BM25参数（经验默认值）
+const (
+	bm25K1 = 1.5
+	bm25B  = 0.75
+)
+
+// SetStoreConnection 设置向量存储连接信息（关键词降级检索直接调用scroll接口需要）
+// EnableNeighborExpansion会设置相同的字段，这里独立提供以便降级检索在未启用邻近扩展时也可用
+func (r *RAG) SetStoreConnection(qdrantURL, apiKey, collectionName string) {
+	r.qdrantURL = qdrantURL
+	r.qdrantAPIKey = apiKey
+	r.collectionName = collectionName
+}
+
+// searchWithFallback 向量检索，失败（多为嵌入API故障）时降级为关键词检索
+// 返回（结果, 是否降级, 错误）；降级检索也失败时返回原始的向量检索错误
+func (r *RAG) searchWithFallback(ctx context.Context, searchQuery string, searchTopK int) ([]schema.Document, bool, error) {
+	results, err := r.cachedSearch(ctx, searchQuery, searchTopK)
+	if err == nil {
+		return results, false, nil
+	}
+
+	logger.Warn("⚠️ 向量检索失败，降级为关键词检索: %v", err)
+	fallbackResults, fallbackErr := r.keywordSearch(ctx, searchQuery, searchTopK)
+	if fallbackErr != nil {
+		logger.Error("关键词降级检索也失败: %v", fallbackErr)
+		return nil, false, err
+	}
+	logger.Info("✅ 关键词降级检索返回 %d 个结果（近似，未经过向量相似度）", len(fallbackResults))
+	return fallbackResults, true, nil
+}
+
+// keywordSearch 关键词检索：scroll取出存量chunk，按BM25打分取前topK
+func (r *RAG) keywordSearch(ctx context.Context, searchQuery string, topK int) ([]schema.Document, error) {
+	keywords := extractKeywords(searchQuery, r.keywordMinGram, r.keywordMaxGram)
+	if len(keywords) == 0 {
+		keywords = []string{strings.ToLower(strings.TrimSpace(searchQuery))}
+	}
+
+	docs, err := r.store.ScrollDocuments(ctx, r.qdrantURL, r.qdrantAPIKey, r.collectionName, keywordFallbackScanLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	ranked := bm25Rank(keywords, docs, topK)
+	return ranked, nil
+}
+
+// bm25Rank 对文档按BM25打分并返回得分最高的topK个（得分为0的文档不返回）
+// 词频用子串出现次数近似（中文没有天然分词，关键词本身已是n-gram）
+func bm25Rank(keywords []string, docs []schema.Document, topK int) []schema.Document {
+	lowerContents := make([]string, len(docs))
+	totalLength := 0
+	for i, doc := range docs {
+		lowerContents[i] = strings.ToLower(doc.PageContent)
+		totalLength += len(lowerContents[i])
+	}
+	avgLength := float64(totalLength) / float64(len(docs))
+
+	// 每个关键词的文档频率（出现该关键词的文档数量）
+	docFreq := make(map[string]int, len(keywords))
+	for _, keyword := range keywords {
+		for _, content := range lowerContents {
+			if strings.Contains(content, keyword) {
+				docFreq[keyword]++
+			}
+		}
+	}
+
+	type scoredDoc struct {
+		doc   schema.Document
+		score float64
+	}
+	scored := make([]scoredDoc, 0, len(docs))
+	for i, doc := range docs {
+		docLength := float64(len(lowerContents[i]))
+		score := 0.0
+		for _, keyword := range keywords {
+			tf := float64(strings.Count(lowerContents[i], keyword))
+			if tf == 0 {
+				continue
+			}
+			// IDF带0.5平滑，保证非负
+			idf := math.Log(1 + (float64(len(docs))-float64(docFreq[keyword])+0.5)/(float64(docFreq[keyword])+0.5))
+			score += idf * (tf * (bm25K1 + 1)) / (tf + bm25K1*(1-bm25B+bm25B*docLength/avgLength))
+		}
+		if score > 0 {
+			scored = append(scored, scoredDoc{doc: doc, score: score})
+		}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].score > scored[j].score
+	})
+	if len(scored) > topK {
+		scored = scored[:topK]
+	}
+
+	results := make([]schema.Document, 0, len(scored))
+	for _, s := range scored {
+		results = append(results, s.doc)
+	}
+	return results
+}
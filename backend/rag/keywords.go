@@ -0,0 +1,96 @@
+package rag
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/go-ego/gse"
+)
+
+// 默认的关键词长度范围（按rune计）
+// 过短的词（单字）噪声大，过长的词基本不会在文档中完整命中
+const (
+	defaultKeywordMinGram = 2
+	defaultKeywordMaxGram = 8
+)
+
+// keywordStopWords 关键词停用词：疑问词、助词等对检索没有区分度的词
+var keywordStopWords = map[string]bool{
+	"的": true, "了": true, "是": true, "有": true, "和": true, "或": true,
+	"与": true, "在": true, "对": true, "被": true, "把": true, "个": true,
+	"什么": true, "怎么": true, "如何": true, "哪些": true, "怎样": true,
+	"为什么": true, "请问": true, "一个": true, "可以": true, "需要": true,
+}
+
+var (
+	segmenter     gse.Segmenter
+	segmenterOnce sync.Once
+)
+
+// getSegmenter 返回全局分词器（首次调用时加载内置词典，加载需要数秒）
+func getSegmenter() *gse.Segmenter {
+	segmenterOnce.Do(func() {
+		if err := segmenter.LoadDict(); err != nil {
+			logger.Error("加载gse分词词典失败: %v", err)
+		}
+	})
+	return &segmenter
+}
+
+// extractKeywords 从文本中提取检索关键词
+// 中文通过gse分词，拉丁单词按空白切分（gse分词时会保留完整单词），
+// 只保留长度（按rune计）在[minGram, maxGram]范围内且不是停用词的词，结果去重、保持原顺序
+func extractKeywords(text string, minGram, maxGram int) []string {
+	if minGram <= 0 {
+		minGram = defaultKeywordMinGram
+	}
+	if maxGram < minGram {
+		maxGram = defaultKeywordMaxGram
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+
+	keywords := make([]string, 0, 8)
+	seen := make(map[string]bool)
+	for _, token := range getSegmenter().Cut(text, true) {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" || keywordStopWords[token] || isPunctToken(token) {
+			continue
+		}
+		length := len([]rune(token))
+		if length < minGram || length > maxGram {
+			continue
+		}
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+		keywords = append(keywords, token)
+	}
+	return keywords
+}
+
+// containsCJK 判断文本中是否包含CJK（中日韩）字符，用于简单的语言判断
+func containsCJK(text string) bool {
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPunctToken 判断token是否全部由标点/符号组成
+func isPunctToken(token string) bool {
+	for _, r := range token {
+		if !unicode.IsPunct(r) && !unicode.IsSymbol(r) {
+			return false
+		}
+	}
+	return true
+}
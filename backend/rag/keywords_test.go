@@ -0,0 +1,76 @@
+package rag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractKeywords(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		minGram int
+		maxGram int
+		want    []string
+	}{
+		{
+			name:    "中文分词",
+			text:    "信息安全管理制度有哪些要求",
+			minGram: 2,
+			maxGram: 8,
+			want:    []string{"信息安全", "管理制度", "要求"},
+		},
+		{
+			name:    "过滤疑问词和助词",
+			text:    "什么是机器学习",
+			minGram: 2,
+			maxGram: 8,
+			want:    []string{"机器", "学习"},
+		},
+		{
+			name:    "拉丁单词按空白切分",
+			text:    "reset admin password",
+			minGram: 2,
+			maxGram: 8,
+			want:    []string{"reset", "admin", "password"},
+		},
+		{
+			name:    "长度范围过滤",
+			text:    "信息安全管理制度有哪些要求",
+			minGram: 4,
+			maxGram: 8,
+			want:    []string{"信息安全", "管理制度"},
+		},
+		{
+			name:    "结果去重",
+			text:    "培训 培训 要求",
+			minGram: 2,
+			maxGram: 8,
+			want:    []string{"培训", "要求"},
+		},
+		{
+			name:    "空文本",
+			text:    "   ",
+			minGram: 2,
+			maxGram: 8,
+			want:    nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := extractKeywords(tc.text, tc.minGram, tc.maxGram)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("extractKeywords(%q, %d, %d) = %v, want %v", tc.text, tc.minGram, tc.maxGram, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExtractKeywordsInvalidRange(t *testing.T) {
+	// 非法范围时回退到默认范围，不应panic、不应返回空
+	got := extractKeywords("信息安全管理制度", 0, -1)
+	if len(got) == 0 {
+		t.Errorf("extractKeywords 使用非法范围时应回退到默认范围，got %v", got)
+	}
+}
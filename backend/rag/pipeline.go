@@ -0,0 +1,89 @@
+package rag
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 查询管线总预算（QUERY_BUDGET_MS）
+// 检索、重排序、LLM生成各有自己的超时，但没有覆盖整条管线的总上限：
+// 每个阶段都"差一点超时"叠加起来仍可能让用户等太久。开启预算后整条查询链路共用
+// 一个截止时间，并在预算还有富余时对单次瞬时的LLM生成失败重试一次。
+const (
+	// pipelineRetryMinBudget 重试生成所需的最小剩余预算
+	// 剩余时间比这还少时重试大概率也会超时，不如直接把错误返回给调用方
+	pipelineRetryMinBudget = 5 * time.Second
+
+	// pipelineRetryDelay 重试前的短暂等待，给瞬时故障（连接抖动、网关5xx）恢复的时间
+	pipelineRetryDelay = 1 * time.Second
+)
+
+// SetQueryBudget 设置查询管线的总时间预算（QUERY_BUDGET_MS，0表示不限制）
+// 预算覆盖检索、重排序和生成的全部耗时；超出预算的查询以超时错误结束
+func (r *RAG) SetQueryBudget(budgetMS int) {
+	if budgetMS >= 0 {
+		r.queryBudgetMS = budgetMS
+	}
+}
+
+// pipelineContext 按配置的总预算派生带截止时间的context
+// 未配置预算时原样返回；返回的cancel在任何分支下都可安全调用
+func (r *RAG) pipelineContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.queryBudgetMS <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(r.queryBudgetMS)*time.Millisecond)
+}
+
+// generateWithRetry 生成答案，瞬时失败且预算富余时重试一次
+// 流式路径不走这里：增量内容已经发给客户端，重试会输出重复内容
+func (r *RAG) generateWithRetry(ctx context.Context, question string, results []schema.Document, prompt string) (string, error) {
+	answer, err := r.generate(ctx, question, results, prompt)
+	if err == nil || !isTransientLLMError(err) || !budgetAllowsRetry(ctx) {
+		return answer, err
+	}
+
+	logger.Warn("⚠️ 生成失败（疑似瞬时故障），%v 后重试一次: %v", pipelineRetryDelay, err)
+	select {
+	case <-ctx.Done():
+		return "", err
+	case <-time.After(pipelineRetryDelay):
+	}
+	return r.generate(ctx, question, results, prompt)
+}
+
+// budgetAllowsRetry 判断当前context的剩余时间是否足够再试一次生成
+// 没有截止时间（未配置预算）时允许重试
+func budgetAllowsRetry(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return true
+	}
+	return time.Until(deadline) >= pipelineRetryMinBudget
+}
+
+// isTransientLLMError 判断生成错误是否是值得重试的瞬时故障
+// 覆盖连接抖动、网关5xx和速率限制；内容类错误（如JSON校验失败）不重试
+func isTransientLLMError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errMsg := strings.ToLower(err.Error())
+	transientMarkers := []string{
+		"timeout", "超时",
+		"connection refused", "connection reset", "broken pipe", "eof",
+		"rate limit", "rate limiting", "too many requests", "429",
+		"502", "503", "504", "bad gateway", "service unavailable",
+		"temporarily",
+	}
+	for _, marker := range transientMarkers {
+		if strings.Contains(errMsg, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package rag
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 答案后处理：清理LLM泄漏的提示词痕迹
+// LLM偶尔会不顾提示词约束，把指令片段（如"根据文档片段1"）或"回答："前缀带进答案
+// 这里用保守的正则清理已知的泄漏模式，并归并重复的引用标注，避免误伤正常内容
+
+// defaultAnswerStripPatterns 内置的清理模式（只匹配明确的提示词痕迹）
+var defaultAnswerStripPatterns = []*regexp.Regexp{
+	// 开头的"答案："/"回答："前缀
+	regexp.MustCompile(`^(答案|回答)[:：]\s*`),
+	// "根据文档片段1，"之类的指令回显（片段编号在答案里应以①②标注出现）
+	regexp.MustCompile(`根据(提供的)?文档片段\s*\d+\s*(的内容)?\s*[，,：:]\s*`),
+	// 开头的"根据提供的文档片段，"/"根据以上资料，"等套话
+	regexp.MustCompile(`^根据(提供的|以上|上述)?(文档片段|文档|资料|知识库)(内容)?\s*[，,：:]\s*`),
+}
+
+// SetAnswerCleanup 设置答案后处理（ANSWER_CLEANUP / ANSWER_STRIP_PATTERNS）
+// enabled控制是否启用；extraPatterns为额外的清理正则，编译失败的模式记录警告后跳过
+func (r *RAG) SetAnswerCleanup(enabled bool, extraPatterns []string) {
+	r.answerCleanup = enabled
+	r.extraStripPatterns = nil
+	for _, pattern := range extraPatterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warn("⚠️ 无效的答案清理正则，已跳过: %s (%v)", pattern, err)
+			continue
+		}
+		r.extraStripPatterns = append(r.extraStripPatterns, re)
+	}
+}
+
+// postProcessAnswer 清理答案中的提示词痕迹并归并重复的引用标注
+// 未启用时原样返回
+func (r *RAG) postProcessAnswer(answer string) string {
+	if !r.answerCleanup || answer == "" {
+		return answer
+	}
+
+	cleaned := answer
+	for _, re := range defaultAnswerStripPatterns {
+		cleaned = re.ReplaceAllString(cleaned, "")
+	}
+	for _, re := range r.extraStripPatterns {
+		cleaned = re.ReplaceAllString(cleaned, "")
+	}
+
+	// 归并连续重复的引用标注（如"①①"归并为"①"）
+	for _, marker := range circleNumbers {
+		for strings.Contains(cleaned, marker+marker) {
+			cleaned = strings.ReplaceAll(cleaned, marker+marker, marker)
+		}
+	}
+
+	cleaned = strings.TrimSpace(cleaned)
+	// 保守兜底：清理后为空说明模式误伤了整个答案，回退到原始答案
+	if cleaned == "" {
+		return answer
+	}
+	return cleaned
+}
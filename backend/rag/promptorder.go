@@ -0,0 +1,90 @@
+package rag
+
+import (
+	"sort"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 提示词文档排序策略（PROMPT_ORDER）
+// LLM对提示词中间位置的内容注意力偏低（lost in the middle），把最相关的片段排在
+// 提示词两端能提升多文档综合的回答质量。排序在进入buildPrompt前对最终结果集生效，
+// QueryResult.Results与提示词编号保持同一顺序，引用标注①②③不受影响。
+const (
+	// promptOrderRelevance 按相关度降序（默认，保持原有行为）
+	promptOrderRelevance = "relevance"
+	// promptOrderReordered 最相关的排在首尾、次相关的靠中间（缓解lost in the middle）
+	promptOrderReordered = "relevance-reordered"
+	// promptOrderChronological 按文档日期升序（无日期的排在最后，同日期保持相关度顺序）
+	promptOrderChronological = "chronological"
+)
+
+// SetPromptOrder 设置提示词文档排序策略（PROMPT_ORDER）
+// 支持relevance（默认）、relevance-reordered、chronological，其他值忽略
+func (r *RAG) SetPromptOrder(strategy string) {
+	switch strategy {
+	case promptOrderRelevance, promptOrderReordered, promptOrderChronological, "":
+		r.promptOrder = strategy
+	}
+}
+
+// reorderForPrompt 按配置的策略重排进入提示词的文档片段
+// 输入按相关度降序（重排序后的顺序），返回新切片，不修改输入
+func (r *RAG) reorderForPrompt(results []schema.Document) []schema.Document {
+	switch r.promptOrder {
+	case promptOrderReordered:
+		return reorderToEdges(results)
+	case promptOrderChronological:
+		return orderChronologically(results)
+	default:
+		return results
+	}
+}
+
+// reorderToEdges 把相关度高的片段排到首尾、低的放中间
+// 奇数名次从头部向内排，偶数名次从尾部向内排：[1,2,3,4,5] -> [1,3,5,4,2]，
+// 最相关的第1名在开头、第2名在结尾，注意力最低的中间位置留给排名靠后的片段
+func reorderToEdges(results []schema.Document) []schema.Document {
+	if len(results) <= 2 {
+		return results
+	}
+	reordered := make([]schema.Document, len(results))
+	front, back := 0, len(results)-1
+	for i, doc := range results {
+		if i%2 == 0 {
+			reordered[front] = doc
+			front++
+		} else {
+			reordered[back] = doc
+			back--
+		}
+	}
+	return reordered
+}
+
+// orderChronologically 按文档日期（Metadata["date"]，格式YYYY-MM-DD）升序排列
+// 字符串比较即时间先后；无日期的片段排在最后，稳定排序保持同日期片段的相关度顺序
+func orderChronologically(results []schema.Document) []schema.Document {
+	ordered := make([]schema.Document, len(results))
+	copy(ordered, results)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		di := docDate(ordered[i])
+		dj := docDate(ordered[j])
+		if di == "" {
+			return false
+		}
+		if dj == "" {
+			return true
+		}
+		return di < dj
+	})
+	return ordered
+}
+
+// docDate 读取文档片段的日期元数据（加载时从docx核心属性或PDF Info字典提取）
+func docDate(doc schema.Document) string {
+	if date, ok := doc.Metadata["date"].(string); ok {
+		return date
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package rag
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// makeOrderDocs 生成按相关度降序编号的测试片段（doc1最相关）
+func makeOrderDocs(n int) []schema.Document {
+	docs := make([]schema.Document, n)
+	for i := range docs {
+		docs[i] = schema.Document{
+			PageContent: fmt.Sprintf("doc%d", i+1),
+			Metadata:    map[string]interface{}{},
+		}
+	}
+	return docs
+}
+
+func contents(docs []schema.Document) []string {
+	out := make([]string, len(docs))
+	for i, doc := range docs {
+		out[i] = doc.PageContent
+	}
+	return out
+}
+
+func TestReorderToEdges(t *testing.T) {
+	cases := []struct {
+		n    int
+		want []string
+	}{
+		// 最相关的在开头，第2名在结尾，排名靠后的落在中间
+		{n: 5, want: []string{"doc1", "doc3", "doc5", "doc4", "doc2"}},
+		{n: 4, want: []string{"doc1", "doc3", "doc4", "doc2"}},
+		// 片段太少时无需重排
+		{n: 2, want: []string{"doc1", "doc2"}},
+		{n: 1, want: []string{"doc1"}},
+	}
+
+	for _, tc := range cases {
+		got := contents(reorderToEdges(makeOrderDocs(tc.n)))
+		for i, want := range tc.want {
+			if got[i] != want {
+				t.Errorf("reorderToEdges(%d个片段) = %v, want %v", tc.n, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOrderChronologically(t *testing.T) {
+	docs := []schema.Document{
+		{PageContent: "新文档", Metadata: map[string]interface{}{"date": "2024-06-01"}},
+		{PageContent: "无日期", Metadata: map[string]interface{}{}},
+		{PageContent: "旧文档", Metadata: map[string]interface{}{"date": "2021-01-15"}},
+	}
+
+	got := contents(orderChronologically(docs))
+	want := []string{"旧文档", "新文档", "无日期"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("orderChronologically = %v, want %v", got, want)
+		}
+	}
+
+	// 不修改输入切片
+	if docs[0].PageContent != "新文档" {
+		t.Errorf("输入切片不应被修改, got %v", contents(docs))
+	}
+}
+
+func TestReorderForPromptStrategy(t *testing.T) {
+	r := &RAG{}
+
+	// 默认relevance保持原顺序
+	got := contents(r.reorderForPrompt(makeOrderDocs(3)))
+	if got[0] != "doc1" || got[1] != "doc2" || got[2] != "doc3" {
+		t.Errorf("默认策略应保持原顺序, got %v", got)
+	}
+
+	r.SetPromptOrder(promptOrderReordered)
+	got = contents(r.reorderForPrompt(makeOrderDocs(3)))
+	if got[0] != "doc1" || got[1] != "doc3" || got[2] != "doc2" {
+		t.Errorf("relevance-reordered策略 = %v, want [doc1 doc3 doc2]", got)
+	}
+
+	// 无效策略被忽略，保持当前配置
+	r.SetPromptOrder("shuffle")
+	got = contents(r.reorderForPrompt(makeOrderDocs(3)))
+	if got[0] != "doc1" || got[1] != "doc3" || got[2] != "doc2" {
+		t.Errorf("无效策略应被忽略, got %v", got)
+	}
+}
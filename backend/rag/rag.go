@@ -3,7 +3,9 @@ package rag
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -17,19 +19,435 @@ import (
 // RAG RAG系统
 type RAG struct {
 	embedder *embedding.Embedder
-	store    *store.QdrantStore
+	store    store.VectorStore
 	llm      llm.LLM
 	topK     int
+
+	// 邻近块扩展配置（通过EnableNeighborExpansion启用）
+	neighborWindow int    // 窗口大小：命中块前后各取多少个相邻块（0表示禁用）
+	qdrantURL      string // Qdrant地址（扩展时直接调用Qdrant API需要）
+	qdrantAPIKey   string
+	collectionName string
+
+	// 嵌入批次配置（通过SetEmbedBatchOptions设置，0表示自动）
+	embedBatchSize    int // 每批向量化的文档数量
+	embedBatchDelayMS int // 批次之间的固定延迟（毫秒）
+
+	// 速率限制重试退避配置（通过SetRetryBackoff设置，0表示默认2秒/8秒）
+	retryBaseDelayMS int // 首次重试的基础延迟（毫秒）
+	retryMaxDelayMS  int // 指数退避的延迟上限（毫秒）
+
+	// 关键词提取的长度范围（按rune计），用于重排序
+	keywordMinGram int
+	keywordMaxGram int
+
+	// 重排序关键词的模糊匹配开关（编辑距离匹配，默认关闭保持精确子串匹配）
+	fuzzyMatch bool
+
+	// 相关性过滤/重排序全部落空时保底保留的结果数量（RELEVANCE_FALLBACK_COUNT）
+	relevanceFallbackCount int
+	pinnedBoost            int    // 置顶文档的重排序加分（PINNED_BOOST，0表示关闭）
+	promptOrder            string // 提示词文档排序策略（PROMPT_ORDER）：relevance/relevance-reordered/chronological
+	queryBudgetMS          int    // 查询管线总时间预算（QUERY_BUDGET_MS，0表示不限制）
+	answerLanguage         string // 回答语言（ANSWER_LANGUAGE，空表示跟随提示词默认行为）
+	answerHeader           string // 拼接到最终答案前的页眉（ANSWER_HEADER，空表示不追加）
+	answerFooter           string // 拼接到最终答案后的页脚（ANSWER_FOOTER，空表示不追加）
+	ingestedAfter          int64  // 检索的入库时间下限（Unix秒，0表示不限制）
+	ingestedBefore         int64  // 检索的入库时间上限（Unix秒，0表示不限制）
+	allowGeneralKnowledge  bool   // 检索落空时是否允许用LLM通用知识作答（ALLOW_GENERAL_KNOWLEDGE）
+
+	// 多集合扇出检索（SEARCH_COLLECTIONS / FANOUT_TIMEOUT_MS / FANOUT_CONCURRENCY）
+	fanOutStores      []store.VectorStore
+	fanOutTimeoutMS   int
+	fanOutConcurrency int
+
+	// 引用标注校验（通过SetEnforceCitations启用）：答案缺少标注时重新提示或附加兜底标注
+	enforceCitations bool
+
+	// 未检索到相关信息时的提示语（通过SetNoAnswerMessage设置，空表示按问题语言自动选择）
+	noAnswerMessage string
+
+	// 进入提示词的每个来源文档的chunk数量上限（通过SetMaxChunksPerSource设置，0表示不限制）
+	maxChunksPerSource int
+
+	// 答案后处理（通过SetAnswerCleanup设置）：清理泄漏的提示词痕迹并归并重复标注
+	answerCleanup      bool
+	extraStripPatterns []*regexp.Regexp
+
+	// 检索多样性（通过SetMinDistinctSources设置，0表示禁用）：topK结果至少覆盖多少个不同来源
+	minDistinctSources int
+
+	// 上下文预算（通过SetContextBudget设置，0表示不限制）：片段总字符数超限时按策略裁剪
+	maxContextChars int
+	contextStrategy string
+
+	// 答案生成模式（通过SetGenerationMode设置）：single单次生成，refine迭代修订
+	generationMode      string
+	refineMaxIterations int
+
+	// 精确短语模式（通过SetExactPhrase按请求设置）：只保留字面包含查询短语的chunk
+	exactPhrase bool
+
+	// 纯语义检索模式（通过SetRerank按请求设置）：跳过关键词重排序与相关性过滤，
+	// 保留向量相似度的原始顺序和分数
+	rerankDisabled bool
+
+	// 多轮对话承接（通过SetConversationHistory按请求设置）：历史轮次的关键词偏置检索查询
+	conversationHistory []string
+	historyCarryTurns   int
+
+	// 答案长度上限（通过SetMaxAnswerChars设置，0表示不限制）：超限时在句子边界截断
+	maxAnswerChars int
+
+	// 结构化输出（通过SetResponseFormat按请求设置）："json"时要求LLM输出JSON并在服务端校验
+	responseFormat string
+	jsonSchemaHint string
+
+	// 查询改写模板（通过SetQueryRewritePrompt设置，空表示禁用）：检索前用LLM改写问题
+	rewritePrompt string
 }
 
 // NewRAG 创建新的RAG系统
-func NewRAG(embedder *embedding.Embedder, store *store.QdrantStore, llm llm.LLM, topK int) *RAG {
+func NewRAG(embedder *embedding.Embedder, store store.VectorStore, llm llm.LLM, topK int) *RAG {
 	return &RAG{
-		embedder: embedder,
-		store:    store,
-		llm:      llm,
-		topK:     topK,
+		embedder:               embedder,
+		store:                  store,
+		llm:                    llm,
+		topK:                   topK,
+		keywordMinGram:         defaultKeywordMinGram,
+		keywordMaxGram:         defaultKeywordMaxGram,
+		relevanceFallbackCount: defaultRelevanceFallbackCount,
+		pinnedBoost:            defaultPinnedBoost,
+	}
+}
+
+// defaultRelevanceFallbackCount 严格匹配全部落空时保底保留的结果数量默认值
+const defaultRelevanceFallbackCount = 3
+
+// defaultPinnedBoost 置顶文档在重排序中的默认加分
+// 介于文件名命中（800）和关键词命中（5）之间：足以让置顶文档胜过同等相关的
+// 普通文档，但不至于压过核心短语完整命中的非置顶文档
+const defaultPinnedBoost = 500
+
+// SwapBackend 原子切换嵌入器和向量存储（嵌入模型在线迁移完成时调用）
+// 切换瞬间进行中的查询可能新旧混用一次检索，属于可接受的过渡状态；
+// 新发起的查询全部走新后端
+func (r *RAG) SwapBackend(embedder *embedding.Embedder, store store.VectorStore) {
+	r.embedder = embedder
+	r.store = store
+}
+
+// SetRelevanceFallbackCount 设置严格匹配落空时保底保留的结果数量（RELEVANCE_FALLBACK_COUNT）
+// 相关性过滤和重排序的保底行为共用该值；传入非法值（<=0）时保持默认值
+func (r *RAG) SetRelevanceFallbackCount(count int) {
+	if count <= 0 {
+		return
+	}
+	r.relevanceFallbackCount = count
+}
+
+// SetPinnedBoost 设置置顶文档的重排序加分（PINNED_BOOST）
+// 管理端把文件标记为置顶后，其chunk的payload带pinned=true，重排序时额外加该分值，
+// 让权威文档在同等相关度下优先出现；设为0关闭置顶加分
+func (r *RAG) SetPinnedBoost(boost int) {
+	if boost >= 0 {
+		r.pinnedBoost = boost
+	}
+}
+
+// SetRerank 开启/关闭关键词重排序（按请求设置，默认开启）
+// 关闭后走纯语义检索：保留向量相似度的原始顺序和真实分数，跳过关键词重排序、
+// 多样性约束和相关性过滤。概念性问题（关键词重合度低但语义相关）适合关闭；
+// 查条款、查编号等措辞明确的问题保持开启，关键词重排序能显著提升命中精度
+func (r *RAG) SetRerank(enabled bool) {
+	r.rerankDisabled = !enabled
+}
+
+// rawTopKResults 纯语义检索：直接取向量相似度顺序的前topK个结果
+func (r *RAG) rawTopKResults(allResults []schema.Document) []schema.Document {
+	logger.Info("纯语义检索模式（rerank=false）：保留向量相似度原始顺序")
+	if len(allResults) > r.topK {
+		return allResults[:r.topK]
+	}
+	return allResults
+}
+
+// SetKeywordGramRange 设置关键词提取的长度范围（按rune计）
+// 传入非法值（min<=0 或 max<min）时保持默认范围
+func (r *RAG) SetKeywordGramRange(minGram, maxGram int) {
+	if minGram <= 0 || maxGram < minGram {
+		return
+	}
+	r.keywordMinGram = minGram
+	r.keywordMaxGram = maxGram
+}
+
+// effectiveSearchTopK 计算实际检索的候选数量
+// 混合搜索策略：先搜索更多结果（topK*5）扩大召回，减少“命不中正确文件”的概率，限制在[30, 80]
+func effectiveSearchTopK(topK int) int {
+	searchTopK := topK * 5
+	if searchTopK < 30 {
+		searchTopK = 30 // 至少搜索30个结果
+	}
+	if searchTopK > 80 {
+		searchTopK = 80 // 最多搜索80个结果
+	}
+	return searchTopK
+}
+
+// EffectiveSearchTopK 返回本实例实际检索的候选数量（用于在查询响应中回显检索配置）
+func (r *RAG) EffectiveSearchTopK() int {
+	return effectiveSearchTopK(r.topK)
+}
+
+// 默认的"未找到相关信息"提示语（按语言区分，问题不含CJK字符时使用英文）
+const (
+	defaultNoAnswerMessageZH = "抱歉，我在知识库中没有找到相关信息。"
+	defaultNoAnswerMessageEN = "Sorry, I could not find any relevant information in the knowledge base."
+)
+
+// SetNoAnswerMessage 设置未检索到相关信息时的提示语
+// 传空字符串表示按问题语言自动选择（中文问题返回中文提示，其他返回英文提示）
+func (r *RAG) SetNoAnswerMessage(message string) {
+	r.noAnswerMessage = message
+}
+
+// noAnswerFor 返回针对该问题的"未找到相关信息"提示语
+// 优先使用NO_ANSWER_MESSAGE配置，未配置时根据问题是否包含CJK字符选择中英文
+func (r *RAG) noAnswerFor(question string) string {
+	if r.noAnswerMessage != "" {
+		return r.noAnswerMessage
+	}
+	if containsCJK(question) {
+		return defaultNoAnswerMessageZH
+	}
+	return defaultNoAnswerMessageEN
+}
+
+// SetMaxChunksPerSource 设置进入提示词的每个来源文档的chunk数量上限
+// 0表示不限制；设置后单个冗长文档不会占满topK预算，上下文能覆盖更多不同来源
+func (r *RAG) SetMaxChunksPerSource(max int) {
+	if max < 0 {
+		max = 0
+	}
+	r.maxChunksPerSource = max
+}
+
+// capResultsPerSource 按来源文档限制chunk数量，保持原有排序
+// 每个source最多保留maxChunksPerSource个chunk，没有source元数据的chunk不受限制
+func (r *RAG) capResultsPerSource(results []schema.Document) []schema.Document {
+	if r.maxChunksPerSource <= 0 {
+		return results
+	}
+
+	perSource := make(map[string]int)
+	capped := make([]schema.Document, 0, len(results))
+	for _, doc := range results {
+		source, ok := doc.Metadata["source"].(string)
+		if !ok || source == "" {
+			capped = append(capped, doc)
+			continue
+		}
+		if perSource[source] >= r.maxChunksPerSource {
+			continue
+		}
+		perSource[source]++
+		capped = append(capped, doc)
+	}
+
+	if len(capped) < len(results) {
+		logger.Debug("[调试] 按来源限制chunk数量（每个来源最多%d个）：%d -> %d", r.maxChunksPerSource, len(results), len(capped))
+	}
+	return capped
+}
+
+// SetMinDistinctSources 设置topK结果至少覆盖的不同来源数量（检索多样性）
+// 0表示禁用；启用后重排序结果集中在单个文件时，会用候选池中其他来源的块替换排名靠后的重复来源块
+func (r *RAG) SetMinDistinctSources(min int) {
+	if min < 0 {
+		min = 0
+	}
+	r.minDistinctSources = min
+}
+
+// ensureSourceDiversity 重排序后的多样性约束：确保结果至少覆盖minDistinctSources个不同来源
+// 不够时从候选池（按相似度排序）挑选新来源的块，替换已选结果中排名靠后、来源重复的块
+// 候选池中没有更多来源时保持原结果不变
+func (r *RAG) ensureSourceDiversity(selected, candidates []schema.Document) []schema.Document {
+	if r.minDistinctSources <= 1 || len(selected) == 0 {
+		return selected
+	}
+
+	sourceOf := func(doc schema.Document) string {
+		source, _ := doc.Metadata["source"].(string)
+		return source
+	}
+
+	// 统计已选结果中每个来源的块数量
+	counts := make(map[string]int)
+	for _, doc := range selected {
+		counts[sourceOf(doc)]++
+	}
+	if len(counts) >= r.minDistinctSources {
+		return selected
+	}
+
+	replaced := 0
+	for _, cand := range candidates {
+		if len(counts) >= r.minDistinctSources {
+			break
+		}
+		candSource := sourceOf(cand)
+		if candSource == "" || counts[candSource] > 0 {
+			continue
+		}
+
+		// 从后往前找一个来源重复的块替换（不能把某个来源的最后一个块换掉，否则多样性不增反降）
+		replaceIdx := -1
+		for i := len(selected) - 1; i >= 0; i-- {
+			if counts[sourceOf(selected[i])] > 1 {
+				replaceIdx = i
+				break
+			}
+		}
+		if replaceIdx == -1 {
+			break
+		}
+
+		counts[sourceOf(selected[replaceIdx])]--
+		selected[replaceIdx] = cand
+		counts[candSource]++
+		replaced++
+	}
+
+	if replaced > 0 {
+		logger.Debug("[调试] 检索多样性：替换了%d个块，结果覆盖%d个来源（要求至少%d个）", replaced, len(counts), r.minDistinctSources)
+	}
+	return selected
+}
+
+// SetEnforceCitations 设置是否启用引用标注校验
+// 启用后，答案缺少文档编号标注时会重新提示LLM补充一次，仍失败则附加最相关片段的标注
+func (r *RAG) SetEnforceCitations(enabled bool) {
+	r.enforceCitations = enabled
+}
+
+// EnableNeighborExpansion 启用邻近块扩展
+// 命中某个文本块时，额外取它前后window个相邻块拼接到上下文中，缓解小块缺少上下文的问题
+func (r *RAG) EnableNeighborExpansion(qdrantURL, apiKey, collectionName string, window int) {
+	r.neighborWindow = window
+	r.qdrantURL = qdrantURL
+	r.qdrantAPIKey = apiKey
+	r.collectionName = collectionName
+}
+
+// SetEmbedBatchOptions 设置嵌入批次参数
+// batchSize为每批向量化的文档数量，delayMS为批次之间的固定延迟（毫秒）
+// 传0表示保持自动策略（批次大小按文档数量选择，延迟按批次大小计算）
+func (r *RAG) SetEmbedBatchOptions(batchSize, delayMS int) {
+	r.embedBatchSize = batchSize
+	r.embedBatchDelayMS = delayMS
+}
+
+// SetRetryBackoff 设置速率限制重试的退避参数
+// baseMS为首次重试的基础延迟，maxMS为指数退避的延迟上限（均为毫秒，传0表示默认2000/8000）
+func (r *RAG) SetRetryBackoff(baseMS, maxMS int) {
+	r.retryBaseDelayMS = baseMS
+	r.retryMaxDelayMS = maxMS
+}
+
+// retryWaitTime 计算第retry次重试（从0计）的等待时间：指数退避+随机抖动
+// 固定的退避序列会让并发批次在速率限制时同步重试、再次触顶；
+// 抖动取[一半, 全额]区间内的随机值，把重试流量在时间上摊开
+func (r *RAG) retryWaitTime(retry int) time.Duration {
+	base := time.Duration(r.retryBaseDelayMS) * time.Millisecond
+	if base <= 0 {
+		base = 2 * time.Second
+	}
+	max := time.Duration(r.retryMaxDelayMS) * time.Millisecond
+	if max <= 0 {
+		max = 8 * time.Second
+	}
+
+	waitTime := base * time.Duration(1<<uint(retry))
+	if waitTime > max || waitTime <= 0 {
+		waitTime = max
+	}
+	// 等量抖动：保留一半确定性延迟，另一半随机
+	half := waitTime / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// expandNeighborChunks 对检索结果进行邻近块扩展
+// 对带有 file_id 和 chunk_index 元数据的块，从Qdrant取出相邻块，前置/追加到该块内容中
+// 扩展失败时只记录日志，不影响主流程
+func (r *RAG) expandNeighborChunks(ctx context.Context, results []schema.Document) []schema.Document {
+	if r.neighborWindow <= 0 {
+		return results
+	}
+
+	for i := range results {
+		fileID, ok := results[i].Metadata["file_id"].(string)
+		if !ok || fileID == "" {
+			continue
+		}
+		chunkIndex, ok := metadataInt(results[i].Metadata["chunk_index"])
+		if !ok {
+			continue
+		}
+
+		// 收集前后window范围内的索引（不含自身）
+		indices := make([]int, 0, r.neighborWindow*2)
+		for offset := 1; offset <= r.neighborWindow; offset++ {
+			if chunkIndex-offset >= 0 {
+				indices = append(indices, chunkIndex-offset)
+			}
+			indices = append(indices, chunkIndex+offset)
+		}
+
+		neighbors, err := r.store.FetchChunksByIndex(ctx, r.qdrantURL, r.qdrantAPIKey, r.collectionName, fileID, indices)
+		if err != nil {
+			logger.Warn("邻近块扩展失败 (file_id=%s, chunk_index=%d): %v", fileID, chunkIndex, err)
+			continue
+		}
+		if len(neighbors) == 0 {
+			continue
+		}
+
+		// 按chunk_index排序后拼接：小于命中块的前置，大于的追加
+		var before, after []string
+		for _, n := range neighbors {
+			nIdx, ok := metadataInt(n.Metadata["chunk_index"])
+			if !ok {
+				continue
+			}
+			if nIdx < chunkIndex {
+				before = append(before, n.PageContent)
+			} else if nIdx > chunkIndex {
+				after = append(after, n.PageContent)
+			}
+		}
+
+		parts := make([]string, 0, len(before)+1+len(after))
+		parts = append(parts, before...)
+		parts = append(parts, results[i].PageContent)
+		parts = append(parts, after...)
+		results[i].PageContent = strings.Join(parts, "\n")
+		logger.Debug("[调试] 邻近块扩展：块%d 前置%d个、追加%d个相邻块\n", chunkIndex, len(before), len(after))
+	}
+
+	return results
+}
+
+// metadataInt 从metadata值中解析整数（JSON反序列化后数字是float64）
+func metadataInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case float64:
+		return int(n), true
 	}
+	return 0, false
 }
 
 // Query 查询并生成回答
@@ -41,6 +459,10 @@ func NewRAG(embedder *embedding.Embedder, store *store.QdrantStore, llm llm.LLM,
 func (r *RAG) Query(ctx context.Context, question string) (string, error) {
 	startTime := time.Now()
 
+	// 查询管线总预算（QUERY_BUDGET_MS）：整条链路共用一个截止时间
+	ctx, cancelBudget := r.pipelineContext(ctx)
+	defer cancelBudget()
+
 	// 步骤1: 向量化查询问题并在向量数据库中进行相似性搜索
 	// Search方法内部会自动：
 	// - 使用embedder将问题文本转换为向量
@@ -48,34 +470,59 @@ func (r *RAG) Query(ctx context.Context, question string) (string, error) {
 	// - 返回最相关的topK个文本块
 
 	// 混合搜索策略：先搜索更多结果（topK*5）扩大召回，减少“命不中正确文件”的概率
-	searchTopK := r.topK * 5
-	if searchTopK < 30 {
-		searchTopK = 30 // 至少搜索30个结果
-	}
-	if searchTopK > 80 {
-		searchTopK = 80 // 最多搜索80个结果
-	}
+	searchTopK := effectiveSearchTopK(r.topK)
+
+	// 多轮承接：检索查询附加最近几轮对话的关键词（不改写送给LLM的问题）
+	searchQuery := r.searchQueryFor(ctx, question)
 
 	logger.Info("正在向量化问题并搜索知识库...")
 	embedStart := time.Now()
-	allResults, err := r.store.Search(ctx, question, r.embedder.GetEmbedder(), searchTopK)
+	allResults, _, err := r.searchWithFallback(ctx, searchQuery, searchTopK)
 	embedDuration := time.Since(embedStart)
 	if err != nil {
 		return "", fmt.Errorf("failed to search: %w", err)
 	}
 	logger.Info(" ✅ (耗时: %v, 检索到 %d 个候选片段)", embedDuration.Round(time.Millisecond), len(allResults))
 
-	// 对结果进行严格的重排序和相关性过滤：优先选择真正相关的片段
-	results := r.reRankResults(question, allResults, r.topK)
+	var results []schema.Document
+	if r.exactPhrase {
+		// 精确短语模式（"exact": true）：跳过语义重排序，只保留字面包含查询短语的chunk
+		results = r.filterExactPhrase(question, allResults)
+	} else if r.rerankDisabled {
+		// 纯语义检索（"rerank": false）：保留向量相似度的原始顺序和分数
+		results = r.rawTopKResults(allResults)
+	} else {
+		// 对结果进行严格的重排序和相关性过滤：优先选择真正相关的片段
+		results = r.reRankResults(question, allResults, r.topK)
+
+		// 多样性约束（MIN_DISTINCT_SOURCES）：结果集中在单个文件时换入其他来源的候选块
+		results = r.ensureSourceDiversity(results, allResults)
+
+		// 二次验证：确保结果与问题真正相关
+		results = r.filterRelevantResults(question, results)
+
+		// 按来源限制进入提示词的chunk数量（MAX_CHUNKS_PER_SOURCE，0表示不限制）
+		results = r.capResultsPerSource(results)
+	}
+
+	// 邻近块扩展：取命中块前后的相邻块补充上下文
+	results = r.expandNeighborChunks(ctx, results)
 
-	// 二次验证：确保结果与问题真正相关
-	results = r.filterRelevantResults(question, results)
+	// 上下文预算（MAX_CONTEXT_CHARS）：上下文超限时按CONTEXT_STRATEGY裁剪
+	results = r.applyContextBudget(ctx, question, results)
+
+	// 提示词文档排序（PROMPT_ORDER）：最终结果集按策略重排后再进入提示词
+	results = r.reorderForPrompt(results)
 
 	// 调试：显示重排序后的结果
 	logger.Debug("[调试] 重排序后选择的前 %d 个片段（包含关键词的优先）\n", len(results))
 
 	if len(results) == 0 {
-		return "抱歉，我在知识库中没有找到相关信息。", nil
+		// 通用知识兜底（ALLOW_GENERAL_KNOWLEDGE）：开启时改用LLM通用知识作答
+		if r.allowGeneralKnowledge {
+			return r.generalKnowledgeAnswer(ctx, question), nil
+		}
+		return r.noAnswerFor(question), nil
 	}
 
 	// 调试：显示检索到的文档片段（完整内容，方便检查是否包含相关信息）
@@ -123,20 +570,37 @@ func (r *RAG) Query(ctx context.Context, question string) (string, error) {
 	logger.Info("正在生成回答...")
 	llmStart := time.Now()
 
-	// 创建带超时的context（120秒超时，给LLM更多时间生成）
-	llmCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
-	defer cancel()
-
-	answer, err := r.llm.Generate(llmCtx, prompt)
+	// 按生成模式产出答案（GENERATION_MODE=refine时走迭代修订链，内部多次调用LLM）
+	answer, err := r.generateWithRetry(ctx, question, results, prompt)
 	llmDuration := time.Since(llmStart)
 	if err != nil {
-		if llmCtx.Err() == context.DeadlineExceeded {
-			return "", fmt.Errorf("生成回答超时（超过120秒），请尝试：1) 减少检索文档数量 2) 检查网络连接 3) 检查API服务状态")
-		}
-		return "", fmt.Errorf("failed to generate answer: %w", err)
+		return "", err
 	}
 	logger.Info(" ✅ (耗时: %v)\n", llmDuration.Round(time.Millisecond))
 
+	// 结构化输出模式：只校验JSON有效性，跳过引用标注和文本类后处理（会破坏JSON结构）
+	if r.jsonModeEnabled() {
+		answer, err = r.validateJSONAnswer(answer)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		// 引用标注校验：答案缺少标注时重新提示一次，仍失败则附加兜底标注
+		answer = r.enforceCitationMarkers(ctx, question, results, answer, true)
+
+		// 答案后处理：清理泄漏的提示词痕迹（ANSWER_CLEANUP）
+		answer = r.postProcessAnswer(answer)
+
+		// 答案长度上限（MAX_ANSWER_CHARS）：超限时在句子边界截断
+		answer, _ = r.capAnswerLength(answer)
+	}
+
+	// 查询轨迹导出（TRACE_DIR）：按采样率异步记录完整轨迹供离线评估
+	go traceQuery(question, results, prompt, answer)
+
+	// 答案页眉/页脚（ANSWER_HEADER / ANSWER_FOOTER）：最后一步拼接，不影响核心答案
+	answer = r.frameAnswer(answer)
+
 	totalDuration := time.Since(startTime)
 	logger.Info("\n[性能] 总耗时: %v (向量检索: %v, LLM生成: %v)\n",
 		totalDuration.Round(time.Millisecond),
@@ -148,8 +612,12 @@ func (r *RAG) Query(ctx context.Context, question string) (string, error) {
 
 // QueryResult 查询结果，包含答案和检索到的文档片段
 type QueryResult struct {
-	Answer  string
-	Results []schema.Document
+	Answer    string
+	Results   []schema.Document
+	Truncated bool // 答案超过MAX_ANSWER_CHARS被服务端截断时为true
+	Degraded  bool // 向量检索故障、降级为关键词检索时为true（结果为近似匹配）
+	// 答案是否基于知识库内容：检索落空、改用LLM通用知识作答（ALLOW_GENERAL_KNOWLEDGE）时为false
+	FromKnowledgeBase bool
 }
 
 // QueryWithResults 查询并生成回答，同时返回检索到的文档片段
@@ -157,6 +625,10 @@ type QueryResult struct {
 func (r *RAG) QueryWithResults(ctx context.Context, question string) (*QueryResult, error) {
 	startTime := time.Now()
 
+	// 查询管线总预算（QUERY_BUDGET_MS）：整条链路共用一个截止时间
+	ctx, cancelBudget := r.pipelineContext(ctx)
+	defer cancelBudget()
+
 	// 步骤1: 向量化查询问题并在向量数据库中进行相似性搜索
 	// Search方法内部会自动：
 	// - 使用embedder将问题文本转换为向量
@@ -164,36 +636,68 @@ func (r *RAG) QueryWithResults(ctx context.Context, question string) (*QueryResu
 	// - 返回最相关的topK个文本块
 
 	// 混合搜索策略：先搜索更多结果（topK*5）扩大召回，减少“命不中正确文件”的概率
-	searchTopK := r.topK * 5
-	if searchTopK < 30 {
-		searchTopK = 30 // 至少搜索30个结果
-	}
-	if searchTopK > 80 {
-		searchTopK = 80 // 最多搜索80个结果
-	}
+	searchTopK := effectiveSearchTopK(r.topK)
+
+	// 多轮承接：检索查询附加最近几轮对话的关键词（不改写送给LLM的问题）
+	searchQuery := r.searchQueryFor(ctx, question)
 
 	logger.Info("正在向量化问题并搜索知识库...")
 	embedStart := time.Now()
-	allResults, err := r.store.Search(ctx, question, r.embedder.GetEmbedder(), searchTopK)
+	allResults, degraded, err := r.searchWithFallback(ctx, searchQuery, searchTopK)
 	embedDuration := time.Since(embedStart)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search: %w", err)
 	}
 	logger.Info(" ✅ (耗时: %v, 检索到 %d 个候选片段)\n", embedDuration.Round(time.Millisecond), len(allResults))
 
-	// 对结果进行严格的重排序和相关性过滤：优先选择真正相关的片段
-	results := r.reRankResults(question, allResults, r.topK)
+	var results []schema.Document
+	if r.exactPhrase {
+		// 精确短语模式（"exact": true）：跳过语义重排序，只保留字面包含查询短语的chunk
+		results = r.filterExactPhrase(question, allResults)
+	} else if r.rerankDisabled {
+		// 纯语义检索（"rerank": false）：保留向量相似度的原始顺序和分数
+		results = r.rawTopKResults(allResults)
+	} else {
+		// 对结果进行严格的重排序和相关性过滤：优先选择真正相关的片段
+		results = r.reRankResults(question, allResults, r.topK)
+
+		// 多样性约束（MIN_DISTINCT_SOURCES）：结果集中在单个文件时换入其他来源的候选块
+		results = r.ensureSourceDiversity(results, allResults)
 
-	// 二次验证：确保结果与问题真正相关
-	results = r.filterRelevantResults(question, results)
+		// 二次验证：确保结果与问题真正相关
+		results = r.filterRelevantResults(question, results)
+
+		// 按来源限制进入提示词的chunk数量（MAX_CHUNKS_PER_SOURCE，0表示不限制）
+		results = r.capResultsPerSource(results)
+	}
+
+	// 邻近块扩展：取命中块前后的相邻块补充上下文
+	results = r.expandNeighborChunks(ctx, results)
+
+	// 上下文预算（MAX_CONTEXT_CHARS）：上下文超限时按CONTEXT_STRATEGY裁剪
+	results = r.applyContextBudget(ctx, question, results)
+
+	// 提示词文档排序（PROMPT_ORDER）：最终结果集按策略重排后再进入提示词
+	results = r.reorderForPrompt(results)
 
 	// 调试：显示重排序后的结果
 	logger.Debug("[调试] 重排序后选择的前 %d 个片段（包含关键词的优先）\n", len(results))
 
 	if len(results) == 0 {
+		// 通用知识兜底（ALLOW_GENERAL_KNOWLEDGE）：开启时改用LLM通用知识作答，
+		// FromKnowledgeBase置false，前端据此提示用户答案没有知识库出处
+		if r.allowGeneralKnowledge {
+			return &QueryResult{
+				Answer:   r.generalKnowledgeAnswer(ctx, question),
+				Results:  []schema.Document{},
+				Degraded: degraded,
+			}, nil
+		}
 		return &QueryResult{
-			Answer:  "抱歉，我在知识库中没有找到相关信息。",
-			Results: []schema.Document{},
+			Answer:            r.noAnswerFor(question),
+			Results:           []schema.Document{},
+			Degraded:          degraded,
+			FromKnowledgeBase: true,
 		}, nil
 	}
 
@@ -249,23 +753,41 @@ func (r *RAG) QueryWithResults(ctx context.Context, question string) (*QueryResu
 	logger.Info("正在生成回答...")
 	llmStart := time.Now()
 
-	// 创建带超时的context（120秒超时，给LLM更多时间生成）
-	llmCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
-	defer cancel()
-
-	answer, err := r.llm.Generate(llmCtx, prompt)
+	// 按生成模式产出答案（GENERATION_MODE=refine时走迭代修订链，内部多次调用LLM）
+	answer, err := r.generateWithRetry(ctx, question, results, prompt)
 	llmDuration := time.Since(llmStart)
 	if err != nil {
-		if llmCtx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("生成回答超时（超过120秒），请尝试：1) 减少检索文档数量 2) 检查网络连接 3) 检查API服务状态")
-		}
-		return nil, fmt.Errorf("failed to generate answer: %w", err)
+		return nil, err
 	}
 	logger.Info(" ✅ (耗时: %v)\n", llmDuration.Round(time.Millisecond))
 
 	// 调试：显示LLM返回的答案（完整内容）
 	logger.Debug("\n[调试] LLM返回的答案 (%d 字符):\n%s\n", len(answer), answer)
 
+	// 结构化输出模式：只校验JSON有效性，跳过引用标注和文本类后处理（会破坏JSON结构）
+	var answerTruncated bool
+	if r.jsonModeEnabled() {
+		answer, err = r.validateJSONAnswer(answer)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// 引用标注校验：答案缺少标注时重新提示一次，仍失败则附加兜底标注
+		answer = r.enforceCitationMarkers(ctx, question, results, answer, true)
+
+		// 答案后处理：清理泄漏的提示词痕迹（ANSWER_CLEANUP）
+		answer = r.postProcessAnswer(answer)
+
+		// 答案长度上限（MAX_ANSWER_CHARS）：超限时在句子边界截断
+		answer, answerTruncated = r.capAnswerLength(answer)
+	}
+
+	// 查询轨迹导出（TRACE_DIR）：按采样率异步记录完整轨迹供离线评估
+	go traceQuery(question, results, prompt, answer)
+
+	// 答案页眉/页脚（ANSWER_HEADER / ANSWER_FOOTER）：最后一步拼接，不影响核心答案
+	answer = r.frameAnswer(answer)
+
 	totalDuration := time.Since(startTime)
 	logger.Info("\n[性能] 总耗时: %v (向量检索: %v, LLM生成: %v)\n",
 		totalDuration.Round(time.Millisecond),
@@ -273,8 +795,11 @@ func (r *RAG) QueryWithResults(ctx context.Context, question string) (*QueryResu
 		llmDuration.Round(time.Millisecond))
 
 	return &QueryResult{
-		Answer:  answer,
-		Results: results,
+		Answer:            answer,
+		Results:           results,
+		Truncated:         answerTruncated,
+		Degraded:          degraded,
+		FromKnowledgeBase: true,
 	}, nil
 }
 
@@ -282,48 +807,93 @@ func (r *RAG) QueryWithResults(ctx context.Context, question string) (*QueryResu
 func (r *RAG) QueryWithResultsStream(ctx context.Context, question string, onChunk func(string) error) (*QueryResult, error) {
 	startTime := time.Now()
 
-	searchTopK := r.topK * 5
-	if searchTopK < 30 {
-		searchTopK = 30
-	}
-	if searchTopK > 80 {
-		searchTopK = 80
-	}
+	// 查询管线总预算（QUERY_BUDGET_MS）：整条链路共用一个截止时间
+	ctx, cancelBudget := r.pipelineContext(ctx)
+	defer cancelBudget()
+
+	searchTopK := effectiveSearchTopK(r.topK)
+
+	// 多轮承接：检索查询附加最近几轮对话的关键词（不改写送给LLM的问题）
+	searchQuery := r.searchQueryFor(ctx, question)
 
 	logger.Info("正在向量化问题并搜索知识库...")
 	embedStart := time.Now()
-	allResults, err := r.store.Search(ctx, question, r.embedder.GetEmbedder(), searchTopK)
+	allResults, degraded, err := r.searchWithFallback(ctx, searchQuery, searchTopK)
 	embedDuration := time.Since(embedStart)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search: %w", err)
 	}
 	logger.Info(" ✅ (耗时: %v, 检索到 %d 个候选片段)\n", embedDuration.Round(time.Millisecond), len(allResults))
 
-	results := r.reRankResults(question, allResults, r.topK)
-	results = r.filterRelevantResults(question, results)
+	var results []schema.Document
+	if r.exactPhrase {
+		// 精确短语模式（"exact": true）：跳过语义重排序，只保留字面包含查询短语的chunk
+		results = r.filterExactPhrase(question, allResults)
+	} else if r.rerankDisabled {
+		// 纯语义检索（"rerank": false）：保留向量相似度的原始顺序和分数
+		results = r.rawTopKResults(allResults)
+	} else {
+		results = r.reRankResults(question, allResults, r.topK)
+
+		// 多样性约束（MIN_DISTINCT_SOURCES）：结果集中在单个文件时换入其他来源的候选块
+		results = r.ensureSourceDiversity(results, allResults)
+		results = r.filterRelevantResults(question, results)
+
+		// 按来源限制进入提示词的chunk数量（MAX_CHUNKS_PER_SOURCE，0表示不限制）
+		results = r.capResultsPerSource(results)
+	}
+	results = r.expandNeighborChunks(ctx, results)
+
+	// 上下文预算（MAX_CONTEXT_CHARS）：上下文超限时按CONTEXT_STRATEGY裁剪
+	results = r.applyContextBudget(ctx, question, results)
+
+	// 提示词文档排序（PROMPT_ORDER）：最终结果集按策略重排后再进入提示词
+	results = r.reorderForPrompt(results)
 	logger.Debug("[调试] 重排序后选择的前 %d 个片段（包含关键词的优先）\n", len(results))
 
 	if len(results) == 0 {
-		noAnswer := "抱歉，我在知识库中没有找到相关信息。"
+		// 通用知识兜底（ALLOW_GENERAL_KNOWLEDGE）：开启时改用LLM通用知识流式作答
+		if r.allowGeneralKnowledge {
+			answer, err := r.generalKnowledgeAnswerStream(ctx, question, onChunk)
+			if err != nil {
+				return nil, err
+			}
+			return &QueryResult{
+				Answer:   answer,
+				Results:  []schema.Document{},
+				Degraded: degraded,
+			}, nil
+		}
+		noAnswer := r.noAnswerFor(question)
 		if onChunk != nil {
 			if err := onChunk(noAnswer); err != nil {
 				return nil, err
 			}
 		}
 		return &QueryResult{
-			Answer:  noAnswer,
-			Results: []schema.Document{},
+			Answer:            noAnswer,
+			Results:           []schema.Document{},
+			Degraded:          degraded,
+			FromKnowledgeBase: true,
 		}, nil
 	}
 
 	prompt := r.buildPrompt(question, results)
 	logger.Info("正在生成回答（流式）...")
+
+	// 答案页眉（ANSWER_HEADER）：流式场景下在正文前先发给客户端
+	if r.answerHeader != "" && !r.jsonModeEnabled() && onChunk != nil {
+		if err := onChunk(r.answerHeader + "\n\n"); err != nil {
+			return nil, err
+		}
+	}
 	llmStart := time.Now()
 
 	llmCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
 	defer cancel()
 
-	answer, err := r.llm.GenerateStream(llmCtx, prompt, onChunk)
+	// 答案长度上限（MAX_ANSWER_CHARS）：达到上限后停止向客户端转发增量内容
+	answer, err := r.llm.GenerateStream(llmCtx, prompt, r.limitStreamChunks(onChunk))
 	llmDuration := time.Since(llmStart)
 	if err != nil {
 		if llmCtx.Err() == context.DeadlineExceeded {
@@ -333,6 +903,37 @@ func (r *RAG) QueryWithResultsStream(ctx context.Context, question string, onChu
 	}
 	logger.Info(" ✅ (耗时: %v)\n", llmDuration.Round(time.Millisecond))
 
+	// 答案页脚（ANSWER_FOOTER）：流式场景下在正文结束后补发给客户端
+	if r.answerFooter != "" && !r.jsonModeEnabled() && onChunk != nil {
+		if err := onChunk("\n\n" + r.answerFooter); err != nil {
+			return nil, err
+		}
+	}
+
+	// 结构化输出模式：只校验JSON有效性，跳过引用标注和文本类后处理（会破坏JSON结构）
+	var answerTruncated bool
+	if r.jsonModeEnabled() {
+		answer, err = r.validateJSONAnswer(answer)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// 引用标注校验：流式内容已经发给客户端，不再重新提示，只附加兜底标注用于来源提取
+		answer = r.enforceCitationMarkers(ctx, question, results, answer, false)
+
+		// 答案后处理：清理泄漏的提示词痕迹（ANSWER_CLEANUP）
+		answer = r.postProcessAnswer(answer)
+
+		// 答案长度上限（MAX_ANSWER_CHARS）：超限时在句子边界截断
+		answer, answerTruncated = r.capAnswerLength(answer)
+	}
+
+	// 查询轨迹导出（TRACE_DIR）：按采样率异步记录完整轨迹供离线评估
+	go traceQuery(question, results, prompt, answer)
+
+	// 答案页眉/页脚（ANSWER_HEADER / ANSWER_FOOTER）：最后一步拼接，不影响核心答案
+	answer = r.frameAnswer(answer)
+
 	totalDuration := time.Since(startTime)
 	logger.Info("\n[性能] 总耗时: %v (向量检索: %v, LLM生成: %v)\n",
 		totalDuration.Round(time.Millisecond),
@@ -340,18 +941,50 @@ func (r *RAG) QueryWithResultsStream(ctx context.Context, question string, onChu
 		llmDuration.Round(time.Millisecond))
 
 	return &QueryResult{
-		Answer:  answer,
-		Results: results,
+		Answer:            answer,
+		Results:           results,
+		Truncated:         answerTruncated,
+		Degraded:          degraded,
+		FromKnowledgeBase: true,
 	}, nil
 }
 
+// AnswerFromDocuments 跳过检索，直接基于调用方提供的文档片段生成回答
+// 用于客户端自带上下文（例如来自外部搜索）只需要"生成+引用标注"的场景
+func (r *RAG) AnswerFromDocuments(ctx context.Context, question string, docs []schema.Document) (string, error) {
+	if len(docs) == 0 {
+		return "", fmt.Errorf("文档列表不能为空")
+	}
+
+	prompt := r.buildPrompt(question, docs)
+
+	logger.Info("正在基于 %d 个外部文档片段生成回答...", len(docs))
+	llmStart := time.Now()
+
+	// 创建带超时的context（120秒超时，给LLM更多时间生成）
+	llmCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	answer, err := r.llm.Generate(llmCtx, prompt)
+	if err != nil {
+		if llmCtx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("生成回答超时（超过120秒），请尝试：1) 减少文档数量 2) 检查网络连接 3) 检查API服务状态")
+		}
+		return "", fmt.Errorf("failed to generate answer: %w", err)
+	}
+	logger.Info(" ✅ (耗时: %v)\n", time.Since(llmStart).Round(time.Millisecond))
+
+	return answer, nil
+}
+
 // buildPrompt 构建增强提示
 // 将"原始问题" + "检索到的上下文"组合成一个增强的提示
 // 这个提示会被发送给LLM（Ollama），让LLM基于上下文信息生成精准、基于知识库的答案
+// 注意：助手的角色和语气（persona）由SYSTEM_PROMPT配置、经system消息传递，这里只包含检索相关的指令
 func (r *RAG) buildPrompt(question string, results []schema.Document) string {
 	var builder strings.Builder
 
-	builder.WriteString("你是一个专业的AI助手。请基于以下上下文信息，**深入思考和分析**后回答问题。\n\n")
+	builder.WriteString("请基于以下上下文信息，**深入思考和分析**后回答问题。\n\n")
 	builder.WriteString("**核心要求**：\n")
 	builder.WriteString("1. **严格相关性检查**：只使用与问题真正相关的文档片段。如果某个文档片段与问题无关，请忽略它，不要使用其中的信息\n")
 	builder.WriteString("2. **必须进行思考和总结**：不要直接复制粘贴文档片段的内容，而是要对信息进行理解、分析和组织\n")
@@ -372,19 +1005,19 @@ func (r *RAG) buildPrompt(question string, results []schema.Document) string {
 	builder.WriteString("- 回答应该是完整的、有逻辑的段落或列表，而不是简单的文档片段拼接\n")
 	builder.WriteString("- 如果问题需要多个方面的回答，请分点或分段说明\n")
 	builder.WriteString("- 回答要自然流畅，读起来像是一个专业助手在回答问题\n\n")
+	// 回答语言要求（ANSWER_LANGUAGE）：跨语言提问时强制目标语言作答
+	if instruction := r.answerLanguageInstruction(); instruction != "" {
+		builder.WriteString(instruction)
+		builder.WriteString("\n")
+	}
+
 	builder.WriteString("上下文信息：\n")
 
-	// 限制每个文档片段的长度，避免提示词过长
-	maxDocLength := 800 // 增加长度以保留更多上下文
 	for i, doc := range results {
 		// 使用圆圈数字作为文档编号标记
 		docNumber := getCircleNumber(i + 1)
 		builder.WriteString(fmt.Sprintf("\n[文档片段 %d] %s\n", i+1, docNumber))
-		content := doc.PageContent
-		if len(content) > maxDocLength {
-			content = content[:maxDocLength] + "..."
-		}
-		builder.WriteString(content)
+		builder.WriteString(promptDocContent(doc))
 		builder.WriteString("\n")
 
 		// 添加来源信息
@@ -408,9 +1041,37 @@ func (r *RAG) buildPrompt(question string, results []schema.Document) string {
 	builder.WriteString("- **如果所有文档片段都与问题无关，请明确说明\"根据提供的上下文，我无法找到相关信息\"，不要强行使用不相关的信息**\n")
 	builder.WriteString("\n现在请**首先检查每个文档片段的相关性**，然后**深入思考和分析**真正相关的上下文信息，最后组织成完整、有条理的回答，确保所有引用都包含文档编号标注：\n")
 
+	// 结构化输出模式：追加JSON输出要求（覆盖上面的标注要求，标注会破坏JSON结构）
+	if r.jsonModeEnabled() {
+		r.appendJSONInstruction(&builder)
+	}
+
 	return builder.String()
 }
 
+// maxPromptDocChars 进入提示词的单个文档片段长度上限（字节），避免提示词过长
+const maxPromptDocChars = 800
+
+// promptDocContent 返回单个文档片段进入提示词的实际文本（超长时截断并加省略号）
+func promptDocContent(doc schema.Document) string {
+	content := doc.PageContent
+	if len(content) > maxPromptDocChars {
+		content = content[:maxPromptDocChars] + "..."
+	}
+	return content
+}
+
+// PromptContextFor 返回每个检索结果进入提示词的实际文本（与buildPrompt的截断逻辑一致）
+// docGroups返回的是chunk全文，这里是LLM真正看到的内容，
+// 用于诊断"信息明明在检索到的chunk里、答案却没覆盖"的问题（信息可能被单片段长度截断掉了）
+func PromptContextFor(results []schema.Document) []string {
+	contexts := make([]string, 0, len(results))
+	for _, doc := range results {
+		contexts = append(contexts, promptDocContent(doc))
+	}
+	return contexts
+}
+
 // extractDocFilename 从文档元数据中提取原始文件名（去除UUID前缀和扩展名）
 func extractDocFilename(doc schema.Document) string {
 	filename := ""
@@ -461,31 +1122,14 @@ func (r *RAG) reRankResults(question string, allResults []schema.Document, topK
 	}
 	corePhrase = strings.TrimSpace(corePhrase)
 
-	// 2. 提取碎片关键词（仅4字以上，降低通用词干扰）
-	charStopWords := map[string]bool{
-		"的": true, "有": true, "几": true, "条": true, "是": true,
-		"在": true, "和": true, "或": true, "与": true, "叫": true,
-		"什": true, "么": true, "请": true, "问": true,
-	}
-	fragmentKeywords := []string{}
-	coreRunes := []rune(corePhrase)
-	for i := 0; i < len(coreRunes); i++ {
-		for length := 4; length <= 6 && i+length <= len(coreRunes); length++ {
-			phrase := string(coreRunes[i : i+length])
-			hasStopWord := false
-			for _, ch := range phrase {
-				if charStopWords[string(ch)] {
-					hasStopWord = true
-					break
-				}
-			}
-			if !hasStopWord {
-				fragmentKeywords = append(fragmentKeywords, phrase)
-			}
-		}
-	}
+	// 2. 提取碎片关键词：中文用gse分词、拉丁单词按空白切分，
+	// 只保留长度在配置范围内的词，避免枚举子串产生的大量噪声关键词
+	fragmentKeywords := extractKeywords(corePhrase, r.keywordMinGram, r.keywordMaxGram)
+
+	// 3. 提取编号类token（文号、标准号等），匹配时对两侧都做分隔符/全角归一化
+	idTokens := extractIDTokens(lowerQuestion)
 
-	logger.Debug("[调试] 核心短语: %q  碎片关键词: %v\n", corePhrase, fragmentKeywords)
+	logger.Debug("[调试] 核心短语: %q  碎片关键词: %v  编号token: %v\n", corePhrase, fragmentKeywords, idTokens)
 
 	type scoredDoc struct {
 		doc   schema.Document
@@ -531,14 +1175,36 @@ func (r *RAG) reRankResults(question string, allResults []schema.Document, topK
 			}
 		}
 
+		// 编号归一化命中：查询里的文档编号（如"第 2023-15 号"）与内容中
+		// 空格/横线/全角写法不同的同一编号匹配时加分，解决精确查号漏召回的问题
+		if len(idTokens) > 0 {
+			contentIDNorm := normalizeIDText(lowerContent)
+			for _, token := range idTokens {
+				if strings.Contains(contentIDNorm, token) {
+					score += 1500
+				}
+			}
+		}
+
 		// 第4层：碎片关键词命中（权重极低）
+		// 开启模糊匹配时，精确匹配失败的关键词退化为编辑距离匹配，
+		// 召回形态变化（"培训的"）和OCR错字导致的近似命中
 		for _, keyword := range fragmentKeywords {
 			kwNS := strings.ReplaceAll(keyword, " ", "")
-			if strings.Contains(contentNS, kwNS) || strings.Contains(lowerContent, keyword) {
+			matched := strings.Contains(contentNS, kwNS) || strings.Contains(lowerContent, keyword)
+			if !matched && r.fuzzyMatch {
+				matched = fuzzyKeywordMatch(contentNS, kwNS)
+			}
+			if matched {
 				score += 5
 			}
 		}
 
+		// 置顶加分：管理端标记的权威文档（payload带pinned=true）优先出现
+		if pinned, ok := doc.Metadata["pinned"].(bool); ok && pinned {
+			score += r.pinnedBoost
+		}
+
 		scoredDocs[i] = scoredDoc{
 			doc:   doc,
 			score: score - i,
@@ -572,8 +1238,13 @@ func (r *RAG) reRankResults(question string, allResults []schema.Document, topK
 	}
 
 	if len(result) == 0 {
-		logger.Warn("[警告] 重排序后没有找到相关片段，将使用原始结果的前%d个\n", topK)
-		for i := 0; i < topK && i < len(allResults); i++ {
+		// 保底数量与相关性过滤的落空行为一致（RELEVANCE_FALLBACK_COUNT）
+		fallbackCount := r.relevanceFallbackCount
+		if fallbackCount > topK {
+			fallbackCount = topK
+		}
+		logger.Warn("[警告] 重排序后没有找到相关片段，将使用原始结果的前%d个\n", fallbackCount)
+		for i := 0; i < fallbackCount && i < len(allResults); i++ {
 			result = append(result, allResults[i])
 		}
 	}
@@ -581,7 +1252,6 @@ func (r *RAG) reRankResults(question string, allResults []schema.Document, topK
 	return result
 }
 
-
 // filterRelevantResults 二次验证：过滤掉与问题不真正相关的文档片段
 // 通过检查文档内容是否真正包含问题的核心信息来判断相关性
 func (r *RAG) filterRelevantResults(question string, results []schema.Document) []schema.Document {
@@ -589,34 +1259,10 @@ func (r *RAG) filterRelevantResults(question string, results []schema.Document)
 		return results
 	}
 
-	// 提取问题的核心关键词（去除停用词）
+	// 提取问题的核心关键词（语言感知分词：中文用gse分词、拉丁单词按空白切分）
+	// 之前按停用词切割连续字符的做法对英文会产生整句"关键词"，几乎不可能子串命中，导致相关块被误过滤
 	lowerQuestion := strings.ToLower(question)
-	stopWords := map[string]bool{
-		"的": true, "有": true, "几": true, "条": true, "是": true,
-		"在": true, "和": true, "或": true, "与": true, "什么": true,
-		"怎么": true, "如何": true, "哪些": true, "？": true,
-		"?": true, "：": true, ":": true, "，": true, ",": true,
-		"。": true, ".": true, "！": true, "!": true,
-	}
-
-	// 提取核心关键词（去除停用词后的连续字符）
-	coreKeywords := []string{}
-	runes := []rune(lowerQuestion)
-	currentKeyword := ""
-	for _, r := range runes {
-		char := string(r)
-		if !stopWords[char] && char != " " {
-			currentKeyword += char
-		} else {
-			if len(currentKeyword) >= 2 {
-				coreKeywords = append(coreKeywords, currentKeyword)
-			}
-			currentKeyword = ""
-		}
-	}
-	if len(currentKeyword) >= 2 {
-		coreKeywords = append(coreKeywords, currentKeyword)
-	}
+	coreKeywords := extractKeywords(lowerQuestion, r.keywordMinGram, r.keywordMaxGram)
 
 	// 如果无法提取关键词，返回所有结果
 	if len(coreKeywords) == 0 {
@@ -649,13 +1295,13 @@ func (r *RAG) filterRelevantResults(question string, results []schema.Document)
 		}
 	}
 
-	// 如果过滤后没有结果，至少保留前3个（避免完全无结果）
+	// 如果过滤后没有结果，至少保底保留前几个（避免完全无结果）
 	if len(filtered) == 0 && len(results) > 0 {
-		logger.Warn("[警告] 相关性过滤后没有结果，保留前3个原始结果\n")
-		maxKeep := 3
+		maxKeep := r.relevanceFallbackCount
 		if len(results) < maxKeep {
 			maxKeep = len(results)
 		}
+		logger.Warn("[警告] 相关性过滤后没有结果，保留前%d个原始结果\n", maxKeep)
 		return results[:maxKeep]
 	}
 
@@ -663,9 +1309,11 @@ func (r *RAG) filterRelevantResults(question string, results []schema.Document)
 	return filtered
 }
 
+// circleNumbers 文档编号标注使用的圆圈数字
+var circleNumbers = []string{"①", "②", "③", "④", "⑤", "⑥", "⑦", "⑧", "⑨", "⑩"}
+
 // getCircleNumber 获取圆圈数字（①、②、③等）
 func getCircleNumber(n int) string {
-	circleNumbers := []string{"①", "②", "③", "④", "⑤", "⑥", "⑦", "⑧", "⑨", "⑩"}
 	if n >= 1 && n <= len(circleNumbers) {
 		return circleNumbers[n-1]
 	}
@@ -673,17 +1321,71 @@ func getCircleNumber(n int) string {
 	return fmt.Sprintf("(%d)", n)
 }
 
+// hasCitationMarkers 判断答案中是否包含文档编号标注
+func hasCitationMarkers(answer string) bool {
+	for _, marker := range circleNumbers {
+		if strings.Contains(answer, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// enforceCitationMarkers 引用标注校验：prompt要求标注但LLM偶尔会漏掉，导致前端看不到任何来源
+// 未启用、无上下文或答案已有标注时原样返回；否则先重新提示LLM补充标注（allowReprompt为true时），
+// 仍然失败则退化为在答案末尾附加最相关片段的标注①
+func (r *RAG) enforceCitationMarkers(ctx context.Context, question string, results []schema.Document, answer string, allowReprompt bool) string {
+	if !r.enforceCitations || len(results) == 0 || hasCitationMarkers(answer) {
+		return answer
+	}
+
+	if allowReprompt {
+		logger.Warn("答案缺少引用标注，重新提示LLM补充标注")
+		reprompt := r.buildPrompt(question, results) +
+			"\n\n你上一次的回答缺少文档编号标注：\n" + answer +
+			"\n\n请在保持回答内容不变的前提下，为所有引用的内容补充对应的文档编号标注（①、②等），重新输出完整回答：\n"
+
+		repromptCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		defer cancel()
+
+		fixed, err := r.llm.Generate(repromptCtx, reprompt)
+		if err != nil {
+			logger.Warn("重新提示补充标注失败: %v", err)
+		} else if hasCitationMarkers(fixed) {
+			logger.Info("重新提示成功，答案已包含引用标注")
+			return fixed
+		} else {
+			logger.Warn("重新提示后答案仍缺少引用标注")
+		}
+	}
+
+	// 兜底：把最相关片段的标注附加到答案末尾，保证前端至少能展示一个来源
+	logger.Warn("答案缺少引用标注，附加最相关片段的标注%s", getCircleNumber(1))
+	return answer + getCircleNumber(1)
+}
+
 // AddDocuments 添加文档到知识库（并发优化版本）
 func (r *RAG) AddDocuments(ctx context.Context, docs []schema.Document) error {
+	return r.AddDocumentsWithProgress(ctx, docs, nil)
+}
+
+// AddDocumentsWithProgress 添加文档到知识库，每完成一个批次回调一次进度
+// progress 回调参数为（已处理文档数，总文档数），传nil表示不需要进度通知
+func (r *RAG) AddDocumentsWithProgress(ctx context.Context, docs []schema.Document, progress func(processed, total int)) error {
 	if len(docs) == 0 {
 		return nil
 	}
 
-	// 根据文档数量自动调整批次大小
-	// 注意：硅基流动API最大批次大小为32，我们使用30以留出安全余量
+	// 入库前PII脱敏（REDACT_PII开启时）：掩码后的内容才进入向量化和存储
+	docs = redactDocuments(docs)
+
+	// 批次大小：优先使用EMBED_BATCH_SIZE配置，未配置时根据文档数量自动调整
+	// 注意：硅基流动API单次请求最大32条，超过时embedder内部会自动拆分成多次请求
 	// 优化：增加批次大小以提高处理速度，同时保持合理的速率限制控制
 	var batchSize int
-	if len(docs) < 50 {
+	if r.embedBatchSize > 0 {
+		batchSize = r.embedBatchSize
+	} else if len(docs) < 50 {
 		batchSize = 20 // 少量文档：20个/批（提高速度）
 	} else if len(docs) < 200 {
 		batchSize = 25 // 中等文档：25个/批（提高速度）
@@ -691,6 +1393,11 @@ func (r *RAG) AddDocuments(ctx context.Context, docs []schema.Document) error {
 		batchSize = 30 // 大量文档：30个/批（接近API限制，最大化吞吐量）
 	}
 
+	// 不超过嵌入provider的单次请求上限（超过时embedder内部也会自动拆分，这里提前对齐便于进度统计）
+	if maxBatch := r.embedder.MaxBatch(); maxBatch > 0 && batchSize > maxBatch {
+		batchSize = maxBatch
+	}
+
 	totalBatches := (len(docs) + batchSize - 1) / batchSize
 	startTime := time.Now()
 
@@ -712,7 +1419,6 @@ func (r *RAG) AddDocuments(ctx context.Context, docs []schema.Document) error {
 		// 添加重试机制，处理速率限制错误
 		var err error
 		maxRetries := 3
-		retryDelay := 2 * time.Second
 
 		for retry := 0; retry < maxRetries; retry++ {
 			err = r.store.AddDocuments(ctx, batch, r.embedder.GetEmbedder())
@@ -729,8 +1435,8 @@ func (r *RAG) AddDocuments(ctx context.Context, docs []schema.Document) error {
 				strings.Contains(errMsg, "tokens per minute")
 
 			if isRateLimit && retry < maxRetries-1 {
-				// 速率限制错误，等待后重试（指数退避）
-				waitTime := retryDelay * time.Duration(1<<uint(retry)) // 2秒, 4秒, 8秒
+				// 速率限制错误，等待后重试（指数退避+随机抖动，避免并发批次同步重试）
+				waitTime := r.retryWaitTime(retry)
 				logger.Warn(" ⚠️  遇到速率限制，等待 %v 后重试 (第 %d/%d 次重试)...\n", waitTime.Round(time.Second), retry+1, maxRetries)
 				time.Sleep(waitTime)
 				continue
@@ -745,20 +1451,31 @@ func (r *RAG) AddDocuments(ctx context.Context, docs []schema.Document) error {
 		}
 
 		// 批次之间添加延迟，避免触发速率限制
-		// 优化：减少延迟时间以提高处理速度（从100ms/文档降到50ms/文档）
+		// 优先使用EMBED_BATCH_DELAY_MS配置的固定延迟，未配置时按批次大小自动计算
 		if batchNum < totalBatches {
-			delay := time.Duration(len(batch)) * 50 * time.Millisecond // 每个文档50ms延迟（优化：从100ms降低）
-			if delay > 1*time.Second {
-				delay = 1 * time.Second // 最大延迟1秒（优化：从2秒降低）
-			}
-			if delay < 200*time.Millisecond {
-				delay = 200 * time.Millisecond // 最小延迟200ms（优化：从500ms降低）
+			var delay time.Duration
+			if r.embedBatchDelayMS > 0 {
+				delay = time.Duration(r.embedBatchDelayMS) * time.Millisecond
+			} else {
+				delay = time.Duration(len(batch)) * 50 * time.Millisecond // 每个文档50ms延迟（优化：从100ms降低）
+				if delay > 1*time.Second {
+					delay = 1 * time.Second // 最大延迟1秒（优化：从2秒降低）
+				}
+				if delay < 200*time.Millisecond {
+					delay = 200 * time.Millisecond // 最小延迟200ms（优化：从500ms降低）
+				}
 			}
 			time.Sleep(delay)
 		}
 
 		batchDuration := time.Since(batchStartTime)
 		processedCount := i + len(batch)
+
+		// 批次完成后通知进度
+		if progress != nil {
+			progress(processedCount, len(docs))
+		}
+
 		elapsed := time.Since(startTime)
 		avgTimePerDoc := elapsed / time.Duration(processedCount)
 		remainingDocs := len(docs) - processedCount
@@ -775,5 +1492,8 @@ func (r *RAG) AddDocuments(ctx context.Context, docs []schema.Document) error {
 		(totalDuration / time.Duration(len(docs))).Round(time.Millisecond),
 		float64(len(docs))/totalDuration.Seconds())
 
+	// 文档发生变化，清空检索结果缓存
+	InvalidateRetrievalCache()
+
 	return nil
 }
@@ -0,0 +1,75 @@
+package rag
+
+import (
+	"regexp"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 入库前的PII脱敏（REDACT_PII）
+// 敏感语料入库时，先把身份证号、手机号、邮箱等个人信息替换为掩码，再做向量化和存储，
+// 保证PII既不进入向量库、也不会出现在LLM提示词里。脱敏过的chunk在元数据中打上
+// redacted标记，前端可据此提示内容经过处理。
+
+// redactMask 脱敏后的占位文本
+const redactMask = "***"
+
+// defaultRedactPatterns 内置的PII正则：身份证号、大陆手机号、邮箱
+var defaultRedactPatterns = []string{
+	`\d{17}[\dXx]`, // 18位身份证号
+	`1[3-9]\d{9}`,  // 大陆手机号
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, // 邮箱
+}
+
+var (
+	redactEnabled bool
+	redactRules   []*regexp.Regexp
+)
+
+// SetRedaction 设置入库PII脱敏（REDACT_PII / REDACT_PATTERNS）
+// extraPatterns为追加的自定义正则（分号分隔的配置），编译失败的规则跳过并告警
+func SetRedaction(enabled bool, extraPatterns []string) {
+	redactEnabled = enabled
+	redactRules = redactRules[:0]
+	if !enabled {
+		return
+	}
+	for _, pattern := range append(append([]string{}, defaultRedactPatterns...), extraPatterns...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Warn("⚠️ PII脱敏规则无效，已跳过: %q (%v)", pattern, err)
+			continue
+		}
+		redactRules = append(redactRules, re)
+	}
+	logger.Info("✅ 入库PII脱敏已启用，共 %d 条规则", len(redactRules))
+}
+
+// redactDocuments 对文档内容做PII脱敏（就地修改PageContent）
+// 发生过替换的文档在Metadata中标记redacted=true
+func redactDocuments(docs []schema.Document) []schema.Document {
+	if !redactEnabled || len(redactRules) == 0 {
+		return docs
+	}
+
+	redactedCount := 0
+	for i := range docs {
+		content := docs[i].PageContent
+		for _, rule := range redactRules {
+			content = rule.ReplaceAllString(content, redactMask)
+		}
+		if content != docs[i].PageContent {
+			docs[i].PageContent = content
+			if docs[i].Metadata == nil {
+				docs[i].Metadata = make(map[string]interface{})
+			}
+			docs[i].Metadata["redacted"] = true
+			redactedCount++
+		}
+	}
+	if redactedCount > 0 {
+		logger.Info("🔍 PII脱敏完成: %d/%d 个chunk包含敏感信息并已掩码", redactedCount, len(docs))
+	}
+	return docs
+}
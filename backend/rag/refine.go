@@ -0,0 +1,148 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 答案生成模式（GENERATION_MODE）
+const (
+	GenerationModeSingle = "single" // 单次生成：所有片段进入一个提示词（默认）
+	GenerationModeRefine = "refine" // 迭代修订：先用靠前的片段生成初稿，再分批送入后续片段修订
+)
+
+const (
+	refineChunksPerStep        = 3 // refine链每轮送入的片段数量
+	defaultRefineMaxIterations = 3 // 默认的最大修订轮数
+)
+
+// SetGenerationMode 设置答案生成模式和refine链的最大修订轮数
+// maxIterations传0或负数表示使用默认轮数；refine模式会多次调用LLM，延迟和token消耗成倍增加
+func (r *RAG) SetGenerationMode(mode string, maxIterations int) {
+	r.generationMode = mode
+	r.refineMaxIterations = maxIterations
+}
+
+// generate 按生成模式产出答案
+// single模式（或片段很少时）单次调用LLM；refine模式走迭代修订链，可覆盖超出单个提示词容量的素材
+func (r *RAG) generate(ctx context.Context, question string, results []schema.Document, prompt string) (string, error) {
+	if r.generationMode == GenerationModeRefine && len(results) > refineChunksPerStep {
+		return r.refineAnswer(ctx, question, results)
+	}
+	return r.generateOnce(ctx, prompt)
+}
+
+// generateOnce 单次LLM调用（120秒超时，超时返回带排查建议的错误）
+func (r *RAG) generateOnce(ctx context.Context, prompt string) (string, error) {
+	llmCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	answer, err := r.llm.Generate(llmCtx, prompt)
+	if err != nil {
+		if llmCtx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("生成回答超时（超过120秒），请尝试：1) 减少检索文档数量 2) 检查网络连接 3) 检查API服务状态")
+		}
+		return "", fmt.Errorf("failed to generate answer: %w", err)
+	}
+	return answer, nil
+}
+
+// refineAnswer 迭代修订链（GENERATION_MODE=refine）
+// 先基于排名靠前的片段生成初稿，然后每轮把下一批片段和当前答案一起交给LLM修订，
+// 单轮失败时保留当前答案继续返回；记录LLM调用次数和提示词体积便于评估成本
+func (r *RAG) refineAnswer(ctx context.Context, question string, results []schema.Document) (string, error) {
+	maxIters := r.refineMaxIterations
+	if maxIters <= 0 {
+		maxIters = defaultRefineMaxIterations
+	}
+
+	initial := refineChunksPerStep
+	if initial > len(results) {
+		initial = len(results)
+	}
+
+	logger.Info("refine模式：%d 个片段分批生成（每轮 %d 个，最多修订 %d 轮）", len(results), refineChunksPerStep, maxIters)
+	startTime := time.Now()
+	llmCalls := 0
+	promptChars := 0
+
+	// 初稿：基于排名靠前的片段
+	prompt := r.buildPrompt(question, results[:initial])
+	answer, err := r.generateOnce(ctx, prompt)
+	llmCalls++
+	promptChars += len(prompt)
+	if err != nil {
+		return "", err
+	}
+
+	// 迭代修订：每轮送入下一批片段和当前答案
+	for step := 0; step < maxIters; step++ {
+		start := initial + step*refineChunksPerStep
+		if start >= len(results) {
+			break
+		}
+		end := start + refineChunksPerStep
+		if end > len(results) {
+			end = len(results)
+		}
+
+		stepStart := time.Now()
+		refinePrompt := r.buildRefinePrompt(question, answer, results[start:end], start)
+		refined, err := r.generateOnce(ctx, refinePrompt)
+		llmCalls++
+		promptChars += len(refinePrompt)
+		if err != nil {
+			logger.Warn("⚠️ refine第 %d 轮失败，保留当前答案: %v", step+1, err)
+			break
+		}
+		if strings.TrimSpace(refined) != "" {
+			answer = refined
+		}
+		logger.Info("refine第 %d 轮完成：送入片段 %d-%d (耗时: %v)", step+1, start+1, end, time.Since(stepStart).Round(time.Millisecond))
+	}
+
+	logger.Info("✅ refine模式完成: LLM调用 %d 次, 提示词共 %d 字符 (耗时: %v)",
+		llmCalls, promptChars, time.Since(startTime).Round(time.Millisecond))
+	return answer, nil
+}
+
+// buildRefinePrompt 构建修订提示词：当前答案 + 新一批文档片段
+// 片段编号使用全局索引（indexOffset起），保证标注与最终results的顺序一致
+func (r *RAG) buildRefinePrompt(question string, currentAnswer string, chunks []schema.Document, indexOffset int) string {
+	var builder strings.Builder
+
+	builder.WriteString("你已经基于部分文档片段给出了一个初步回答，现在有新的文档片段补充进来。\n")
+	builder.WriteString("请在保留初步回答中正确内容（包括已有的①②等编号标注）的基础上，用新片段中与问题相关的信息补充和修正回答：\n")
+	builder.WriteString("- 新片段与问题无关时保持原回答不变\n")
+	builder.WriteString("- 引用新片段的内容时，在引用内容末尾添加对应的编号标注\n")
+	builder.WriteString("- 直接输出修订后的完整回答，不要解释修订过程\n\n")
+
+	builder.WriteString("问题: ")
+	builder.WriteString(question)
+	builder.WriteString("\n\n初步回答:\n")
+	builder.WriteString(currentAnswer)
+	builder.WriteString("\n\n新的文档片段：\n")
+
+	maxDocLength := 800
+	for i, doc := range chunks {
+		globalIndex := indexOffset + i + 1
+		builder.WriteString(fmt.Sprintf("\n[文档片段 %d] %s\n", globalIndex, getCircleNumber(globalIndex)))
+		content := doc.PageContent
+		if len(content) > maxDocLength {
+			content = content[:maxDocLength] + "..."
+		}
+		builder.WriteString(content)
+		builder.WriteString("\n")
+		if source, ok := doc.Metadata["source"].(string); ok {
+			builder.WriteString(fmt.Sprintf("来源: %s\n", source))
+		}
+	}
+
+	builder.WriteString("\n现在请输出修订后的完整回答：\n")
+	return builder.String()
+}
@@ -0,0 +1,129 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 检索结果缓存（RETRIEVAL_CACHE_TTL）
+// 热门问题会反复触发相同的向量检索：按归一化后的检索查询缓存检索结果（短TTL），
+// 比缓存完整答案更便宜——LLM输出可以变化，但检索相同时直接复用，降低Qdrant负载。
+// 缓存是包级别的：API查询走的是按请求创建的临时RAG实例，实例级缓存永远不会命中。
+
+// 缓存条目数量上限，写满后淘汰最早写入的条目
+const retrievalCacheMaxEntries = 256
+
+type retrievalCacheEntry struct {
+	results   []schema.Document
+	createdAt time.Time
+}
+
+var (
+	retrievalCacheMu     sync.Mutex
+	retrievalCache       = make(map[string]retrievalCacheEntry)
+	retrievalCacheTTL    time.Duration
+	retrievalCacheHits   int64
+	retrievalCacheMisses int64
+)
+
+// SetRetrievalCacheTTL 设置检索结果缓存的TTL（秒，0表示禁用缓存，保持历史行为）
+func SetRetrievalCacheTTL(seconds int) {
+	if seconds < 0 {
+		seconds = 0
+	}
+	retrievalCacheMu.Lock()
+	retrievalCacheTTL = time.Duration(seconds) * time.Second
+	retrievalCacheMu.Unlock()
+	if seconds > 0 {
+		logger.Info("✅ 检索结果缓存已启用，TTL: %d 秒", seconds)
+	}
+}
+
+// InvalidateRetrievalCache 清空检索结果缓存
+// 文档增删后必须调用，否则TTL内的查询可能返回已删除或过期的chunk
+func InvalidateRetrievalCache() {
+	retrievalCacheMu.Lock()
+	cleared := len(retrievalCache)
+	retrievalCache = make(map[string]retrievalCacheEntry)
+	retrievalCacheMu.Unlock()
+	if cleared > 0 {
+		logger.Debug("[调试] 检索缓存已清空（%d 个条目）", cleared)
+	}
+}
+
+// RetrievalCacheStats 返回缓存统计（供管理接口展示）
+func RetrievalCacheStats() map[string]interface{} {
+	retrievalCacheMu.Lock()
+	defer retrievalCacheMu.Unlock()
+	return map[string]interface{}{
+		"enabled":    retrievalCacheTTL > 0,
+		"ttlSeconds": int(retrievalCacheTTL / time.Second),
+		"entries":    len(retrievalCache),
+		"hits":       retrievalCacheHits,
+		"misses":     retrievalCacheMisses,
+	}
+}
+
+// retrievalCacheKey 归一化缓存键：小写并压缩空白，附加topK（候选数量不同时结果不同）
+func retrievalCacheKey(query string, topK int) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	return fmt.Sprintf("%s|%d", normalized, topK)
+}
+
+// cachedSearch 带缓存的向量检索：TTL内同样的查询直接返回缓存结果
+// 返回给调用方的始终是副本（Document为值类型），后续的邻近块扩展等原地修改不会污染缓存
+func (r *RAG) cachedSearch(ctx context.Context, searchQuery string, searchTopK int) ([]schema.Document, error) {
+	retrievalCacheMu.Lock()
+	ttl := retrievalCacheTTL
+	retrievalCacheMu.Unlock()
+	if ttl <= 0 {
+		return r.searchCollections(ctx, searchQuery, searchTopK)
+	}
+
+	key := retrievalCacheKey(searchQuery, searchTopK)
+	// 入库时间范围参与缓存键：范围不同时检索结果不同，不能互相复用
+	if r.hasIngestedRange() {
+		key = fmt.Sprintf("%s|%d-%d", key, r.ingestedAfter, r.ingestedBefore)
+	}
+	retrievalCacheMu.Lock()
+	if entry, ok := retrievalCache[key]; ok && time.Since(entry.createdAt) < ttl {
+		retrievalCacheHits++
+		cached := make([]schema.Document, len(entry.results))
+		copy(cached, entry.results)
+		retrievalCacheMu.Unlock()
+		logger.Debug("[调试] 检索缓存命中 (topK=%d): %s", searchTopK, searchQuery)
+		return cached, nil
+	}
+	retrievalCacheMisses++
+	retrievalCacheMu.Unlock()
+
+	results, err := r.searchCollections(ctx, searchQuery, searchTopK)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make([]schema.Document, len(results))
+	copy(stored, results)
+	retrievalCacheMu.Lock()
+	// 写满时淘汰最早写入的条目（条目数量小，线性扫描足够）
+	if len(retrievalCache) >= retrievalCacheMaxEntries {
+		oldestKey := ""
+		var oldestAt time.Time
+		for k, e := range retrievalCache {
+			if oldestKey == "" || e.createdAt.Before(oldestAt) {
+				oldestKey = k
+				oldestAt = e.createdAt
+			}
+		}
+		delete(retrievalCache, oldestKey)
+	}
+	retrievalCache[key] = retrievalCacheEntry{results: stored, createdAt: time.Now()}
+	retrievalCacheMu.Unlock()
+	return results, nil
+}
@@ -0,0 +1,59 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// LLM查询改写
+// 关键词承接（history.go）是纯启发式的：代词消解、口语转术语这类改写它做不了。
+// 配置QUERY_REWRITE_PROMPT后，检索前先用LLM按模板把问题改写成独立、完整的检索查询；
+// 模板可配置，检索侧的提示词工程无需改代码即可调优。
+
+// rewriteTimeout 查询改写的超时时间：改写只是检索的前置优化，不值得让用户等太久
+const rewriteTimeout = 20 * time.Second
+
+// SetQueryRewritePrompt 设置查询改写的提示词模板（空表示禁用改写）
+// 模板中的{question}替换为当前问题，{history}替换为按行拼接的历史问题（无历史时为空）
+func (r *RAG) SetQueryRewritePrompt(template string) {
+	r.rewritePrompt = template
+}
+
+// rewriteQuery 用LLM按模板改写检索查询
+// 返回改写后的查询；LLM调用失败或返回空内容时返回错误，调用方应回退到原始查询
+func (r *RAG) rewriteQuery(ctx context.Context, history []string, question string) (string, error) {
+	prompt := strings.ReplaceAll(r.rewritePrompt, "{question}", question)
+	prompt = strings.ReplaceAll(prompt, "{history}", strings.Join(history, "\n"))
+
+	llmCtx, cancel := context.WithTimeout(ctx, rewriteTimeout)
+	defer cancel()
+
+	rewritten, err := r.llm.Generate(llmCtx, prompt)
+	if err != nil {
+		return "", fmt.Errorf("查询改写失败: %w", err)
+	}
+	rewritten = strings.TrimSpace(rewritten)
+	if rewritten == "" {
+		return "", fmt.Errorf("查询改写失败: LLM返回空内容")
+	}
+	logger.Info("🔍 查询改写: %q -> %q", question, rewritten)
+	return rewritten, nil
+}
+
+// searchQueryFor 构建实际送入向量检索的查询
+// 配置了改写模板时优先用LLM改写（失败回退），否则走关键词承接的启发式拼接
+func (r *RAG) searchQueryFor(ctx context.Context, question string) string {
+	if r.rewritePrompt == "" {
+		return r.retrievalQueryFor(question)
+	}
+	rewritten, err := r.rewriteQuery(ctx, r.conversationHistory, question)
+	if err != nil {
+		logger.Warn("⚠️ %v，回退到原始查询", err)
+		return r.retrievalQueryFor(question)
+	}
+	return rewritten
+}
@@ -0,0 +1,78 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 文档级摘要chunk
+// 宽泛问题（"这份文件讲了什么"）与任何具体chunk的相似度都不高，检索往往命中随机段落；
+// 摄取时为大文档额外存一个摘要chunk（Metadata["type"]="summary"），让这类问题有可命中的目标。
+
+const (
+	// summaryMinDocChars 低于此长度的文档不生成摘要：文档本身已够短，具体chunk足以覆盖宽泛问题
+	summaryMinDocChars = 2000
+	// summaryInputMaxChars 送入LLM的原文长度上限（字节），超长文档只取开头部分生成摘要
+	summaryInputMaxChars = 8000
+	// summaryTimeout 摘要生成的超时时间，超时放弃摘要但不影响正常摄取
+	summaryTimeout = 60 * time.Second
+)
+
+// GenerateSummaryChunk 用LLM为整份文档生成摘要chunk
+// 返回的chunk带Metadata["type"]="summary"，与普通chunk一起入库参与检索
+// 文档过短时返回(nil, nil)表示无需摘要；生成失败返回错误，调用方按警告处理（不影响正常摄取）
+func (r *RAG) GenerateSummaryChunk(ctx context.Context, docs []schema.Document, title string) (*schema.Document, error) {
+	var builder strings.Builder
+	for _, doc := range docs {
+		builder.WriteString(doc.PageContent)
+		builder.WriteString("\n")
+		if builder.Len() > summaryInputMaxChars {
+			break
+		}
+	}
+	content := builder.String()
+	if len(content) < summaryMinDocChars {
+		return nil, nil
+	}
+	if len(content) > summaryInputMaxChars {
+		content = content[:summaryInputMaxChars]
+	}
+
+	prompt := fmt.Sprintf(
+		"请用一段话（200字以内）概括以下文档的主要内容，说明文档的主题、涉及的关键信息和用途。"+
+			"直接输出概括内容，不要添加\"本文档\"之外的前缀或评论。\n\n文档标题：%s\n\n文档内容（可能被截断）：\n%s",
+		title, content)
+
+	llmCtx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	summary, err := r.llm.Generate(llmCtx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("生成文档摘要失败: %w", err)
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return nil, fmt.Errorf("生成文档摘要失败: LLM返回空内容")
+	}
+
+	// 复制首个chunk的source等元数据，保证摘要chunk在引用展示和按来源删除时与本文档归组
+	metadata := make(map[string]interface{})
+	if len(docs) > 0 && docs[0].Metadata != nil {
+		for k, v := range docs[0].Metadata {
+			metadata[k] = v
+		}
+	}
+	metadata["type"] = "summary"
+
+	logger.Info("✅ 已为文档 %s 生成摘要chunk（%d 字符）", title, len([]rune(summary)))
+	return &schema.Document{
+		// 摘要正文前加标题行，宽泛问题（含文件名）的向量和关键词都更容易命中
+		PageContent: fmt.Sprintf("【文档摘要】%s\n%s", title, summary),
+		Metadata:    metadata,
+	}, nil
+}
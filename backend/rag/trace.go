@@ -0,0 +1,134 @@
+package rag
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// 查询轨迹导出（TRACE_DIR / TRACE_SAMPLE_RATE）
+// 离线评估检索和回答质量（recall@k、答案忠实度）需要完整的查询轨迹：问题、
+// 召回的片段及分数、最终提示词、答案和引用。设置TRACE_DIR后按采样率把轨迹
+// 以JSONL追加写入按天滚动的文件，供评估工具离线消费；默认关闭，不影响线上请求。
+
+var (
+	traceMu         sync.Mutex
+	traceDir        string
+	traceSampleRate float64
+)
+
+// queryTrace 单条查询轨迹（JSONL的一行）
+type queryTrace struct {
+	Time      string       `json:"time"`
+	Question  string       `json:"question"`
+	Chunks    []traceChunk `json:"chunks"`
+	Prompt    string       `json:"prompt"`
+	Answer    string       `json:"answer"`
+	Citations []int        `json:"citations"` // 答案中实际引用的片段序号（从1计）
+}
+
+// traceChunk 轨迹中的单个召回片段
+type traceChunk struct {
+	Content    string  `json:"content"`
+	Source     string  `json:"source,omitempty"`
+	FileID     string  `json:"fileId,omitempty"`
+	ChunkIndex int     `json:"chunkIndex,omitempty"`
+	Score      float32 `json:"score"`
+}
+
+// SetTraceOptions 设置查询轨迹导出（TRACE_DIR / TRACE_SAMPLE_RATE）
+// dir为空表示关闭；sampleRate取值0~1，超出范围时截断到边界
+func SetTraceOptions(dir string, sampleRate float64) {
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	if sampleRate < 0 {
+		sampleRate = 0
+	}
+	if sampleRate > 1 {
+		sampleRate = 1
+	}
+	traceDir = dir
+	traceSampleRate = sampleRate
+	if dir != "" && sampleRate > 0 {
+		logger.Info("✅ 查询轨迹导出已开启: 目录=%s 采样率=%.2f", dir, sampleRate)
+	}
+}
+
+// traceQuery 按采样率把一次查询的完整轨迹追加写入轨迹文件
+// 在生成成功后异步调用，写入失败只告警，不影响查询结果
+func traceQuery(question string, results []schema.Document, prompt, answer string) {
+	traceMu.Lock()
+	dir, rate := traceDir, traceSampleRate
+	traceMu.Unlock()
+	if dir == "" || rate <= 0 || rand.Float64() >= rate {
+		return
+	}
+
+	trace := queryTrace{
+		Time:      time.Now().Format(time.RFC3339),
+		Question:  question,
+		Chunks:    make([]traceChunk, 0, len(results)),
+		Prompt:    prompt,
+		Answer:    answer,
+		Citations: citedChunkIndexes(answer, len(results)),
+	}
+	for _, doc := range results {
+		chunk := traceChunk{Content: doc.PageContent, Score: doc.Score}
+		if source, ok := doc.Metadata["source"].(string); ok {
+			chunk.Source = source
+		}
+		if fileID, ok := doc.Metadata["file_id"].(string); ok {
+			chunk.FileID = fileID
+		}
+		if idx, ok := metadataInt(doc.Metadata["chunk_index"]); ok {
+			chunk.ChunkIndex = idx
+		}
+		trace.Chunks = append(trace.Chunks, chunk)
+	}
+
+	writeTrace(dir, trace)
+}
+
+// writeTrace 把轨迹追加写入按天滚动的JSONL文件（traces-YYYY-MM-DD.jsonl）
+func writeTrace(dir string, trace queryTrace) {
+	line, err := json.Marshal(trace)
+	if err != nil {
+		logger.Warn("⚠️ 查询轨迹序列化失败: %v", err)
+		return
+	}
+
+	traceMu.Lock()
+	defer traceMu.Unlock()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Warn("⚠️ 创建轨迹目录 %s 失败: %v", dir, err)
+		return
+	}
+	path := filepath.Join(dir, "traces-"+time.Now().Format("2006-01-02")+".jsonl")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Warn("⚠️ 打开轨迹文件 %s 失败: %v", path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logger.Warn("⚠️ 写入轨迹文件 %s 失败: %v", path, err)
+	}
+}
+
+// citedChunkIndexes 提取答案中实际出现的引用标注对应的片段序号（从1计，升序）
+func citedChunkIndexes(answer string, chunkCount int) []int {
+	cited := make([]int, 0)
+	for i := 1; i <= chunkCount && i <= len(circleNumbers); i++ {
+		if strings.Contains(answer, getCircleNumber(i)) {
+			cited = append(cited, i)
+		}
+	}
+	return cited
+}
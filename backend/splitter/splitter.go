@@ -1,13 +1,32 @@
 package splitter
 
 import (
+	"fmt"
 	"strings"
 	"unicode/utf8"
 
+	"github.com/Codeyangyi/personal-ai-kb/logger"
 	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/textsplitter"
 )
 
+// 单个文档允许产生的最大chunk数量（MAX_CHUNKS_PER_DOC，包级默认值，0表示不限制）
+// 防止单个超大文档的几万个chunk挤占整个集合，并保证按文件删除时的速度
+var (
+	defaultMaxChunksPerDoc int
+	defaultChunkCapReject  bool // true时超限直接拒绝，false时放大有效chunk大小把数量压进上限
+)
+
+// SetDefaultChunkCap 设置单个文档的chunk数量上限和超限处理方式（进程级默认值）
+// maxChunks为0表示不限制；reject为true时超限报错，否则自动放大chunk大小
+func SetDefaultChunkCap(maxChunks int, reject bool) {
+	if maxChunks < 0 {
+		maxChunks = 0
+	}
+	defaultMaxChunksPerDoc = maxChunks
+	defaultChunkCapReject = reject
+}
+
 // TextSplitter 文本切分器
 type TextSplitter struct {
 	chunkSize    int
@@ -23,10 +42,47 @@ func NewTextSplitter(chunkSize, chunkOverlap int) *TextSplitter {
 }
 
 // SplitDocuments 切分文档
+// 设置了chunk数量上限（MAX_CHUNKS_PER_DOC）且切分结果超限时，
+// 按配置拒绝该文档，或放大有效chunk大小重新切分使数量进入上限
 func (s *TextSplitter) SplitDocuments(docs []schema.Document) ([]schema.Document, error) {
+	allSplits, err := s.splitWithSize(docs, s.chunkSize, s.chunkOverlap)
+	if err != nil {
+		return nil, err
+	}
+
+	maxChunks := defaultMaxChunksPerDoc
+	if maxChunks > 0 && len(allSplits) > maxChunks {
+		if defaultChunkCapReject {
+			return nil, fmt.Errorf("文档切分出 %d 个文本块，超过上限 %d（MAX_CHUNKS_PER_DOC），请拆分文档后重试或调大上限", len(allSplits), maxChunks)
+		}
+
+		// 按超出比例放大chunk大小重新切分（重叠按相同比例放大），最多尝试几轮
+		chunkSize := s.chunkSize
+		chunkOverlap := s.chunkOverlap
+		for attempt := 0; attempt < 5 && len(allSplits) > maxChunks; attempt++ {
+			factor := (len(allSplits) + maxChunks - 1) / maxChunks
+			if factor < 2 {
+				factor = 2
+			}
+			chunkSize *= factor
+			chunkOverlap *= factor
+			logger.Warn("⚠️ 文档切分出 %d 个文本块，超过上限 %d（MAX_CHUNKS_PER_DOC），放大chunk大小至 %d 重新切分", len(allSplits), maxChunks, chunkSize)
+			allSplits, err = s.splitWithSize(docs, chunkSize, chunkOverlap)
+			if err != nil {
+				return nil, err
+			}
+		}
+		logger.Info("✅ 按chunk数量上限调整后切分为 %d 个文本块 (有效chunk大小: %d)", len(allSplits), chunkSize)
+	}
+
+	return allSplits, nil
+}
+
+// splitWithSize 用指定的chunk大小和重叠执行一次切分并清理编码
+func (s *TextSplitter) splitWithSize(docs []schema.Document, chunkSize, chunkOverlap int) ([]schema.Document, error) {
 	splitter := textsplitter.NewRecursiveCharacter(
-		textsplitter.WithChunkSize(s.chunkSize),
-		textsplitter.WithChunkOverlap(s.chunkOverlap),
+		textsplitter.WithChunkSize(chunkSize),
+		textsplitter.WithChunkOverlap(chunkOverlap),
 	)
 
 	// 使用textsplitter包的SplitDocuments函数
@@ -0,0 +1,425 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/google/uuid"
+	"github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Milvus集合的标量字段长度限制
+const (
+	milvusIDMaxLength      = 64
+	milvusContentMaxLength = 65535
+	milvusSourceMaxLength  = 1024
+	milvusFileIDMaxLength  = 64
+)
+
+// MilvusStore Milvus向量存储实现
+// 通过 VECTOR_STORE=milvus 选择，文档内容和 file_id/source/chunk_index 元数据
+// 映射为Milvus标量字段，支持按元数据过滤和删除
+type MilvusStore struct {
+	client         client.Client
+	collectionName string
+	dimensions     int
+}
+
+// NewMilvusStore 创建新的Milvus存储
+// 如果集合不存在，会按embedder的维度自动创建集合（含索引并加载）；
+// 集合已存在但维度不匹配时返回错误，交给运维人员手动处理
+func NewMilvusStore(addr, username, password, collectionName string, dimensionGetter DimensionGetter) (*MilvusStore, error) {
+	ctx := context.Background()
+
+	dimensions := 1024 // 默认维度
+	if dimensionGetter != nil {
+		dimensions = dimensionGetter.GetDimensions()
+	}
+
+	c, err := client.NewClient(ctx, client.Config{
+		Address:  addr,
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to milvus: %w", err)
+	}
+
+	exists, err := c.HasCollection(ctx, collectionName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check collection existence: %w", err)
+	}
+
+	if !exists {
+		logger.Info("Milvus集合 '%s' 不存在，正在自动创建（向量维度: %d）...", collectionName, dimensions)
+		if err := createMilvusCollection(ctx, c, collectionName, dimensions); err != nil {
+			return nil, fmt.Errorf("failed to create milvus collection: %w", err)
+		}
+		logger.Info("✅ Milvus集合创建成功")
+	} else {
+		existingDims, err := getMilvusCollectionDimensions(ctx, c, collectionName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get milvus collection dimensions: %w", err)
+		}
+		if existingDims != dimensions {
+			return nil, fmt.Errorf("Milvus集合 '%s' 的维度 (%d) 与模型维度 (%d) 不匹配，请手动处理", collectionName, existingDims, dimensions)
+		}
+	}
+
+	// 搜索前需要将集合加载到内存
+	if err := c.LoadCollection(ctx, collectionName, false); err != nil {
+		return nil, fmt.Errorf("failed to load milvus collection: %w", err)
+	}
+
+	return &MilvusStore{
+		client:         c,
+		collectionName: collectionName,
+		dimensions:     dimensions,
+	}, nil
+}
+
+// createMilvusCollection 创建Milvus集合并建立向量索引
+// 文档内容存储在content字段，source/file_id/chunk_index映射为标量字段用于过滤和删除
+func createMilvusCollection(ctx context.Context, c client.Client, collectionName string, dimensions int) error {
+	collSchema := &entity.Schema{
+		CollectionName: collectionName,
+		Fields: []*entity.Field{
+			entity.NewField().WithName("id").WithDataType(entity.FieldTypeVarChar).WithMaxLength(milvusIDMaxLength).WithIsPrimaryKey(true),
+			entity.NewField().WithName("vector").WithDataType(entity.FieldTypeFloatVector).WithDim(int64(dimensions)),
+			entity.NewField().WithName("content").WithDataType(entity.FieldTypeVarChar).WithMaxLength(milvusContentMaxLength),
+			entity.NewField().WithName("source").WithDataType(entity.FieldTypeVarChar).WithMaxLength(milvusSourceMaxLength),
+			entity.NewField().WithName("file_id").WithDataType(entity.FieldTypeVarChar).WithMaxLength(milvusFileIDMaxLength),
+			entity.NewField().WithName("chunk_index").WithDataType(entity.FieldTypeInt64),
+		},
+	}
+
+	if err := c.CreateCollection(ctx, collSchema, entity.DefaultShardNumber); err != nil {
+		return err
+	}
+
+	// 与Qdrant路径一致使用余弦相似度，索引类型交给Milvus自动选择
+	index, err := entity.NewIndexAUTOINDEX(entity.COSINE)
+	if err != nil {
+		return fmt.Errorf("failed to create index definition: %w", err)
+	}
+	if err := c.CreateIndex(ctx, collectionName, "vector", index, false); err != nil {
+		return fmt.Errorf("failed to create vector index: %w", err)
+	}
+
+	return nil
+}
+
+// getMilvusCollectionDimensions 获取集合中向量字段的维度
+func getMilvusCollectionDimensions(ctx context.Context, c client.Client, collectionName string) (int, error) {
+	coll, err := c.DescribeCollection(ctx, collectionName)
+	if err != nil {
+		return 0, err
+	}
+	for _, field := range coll.Schema.Fields {
+		if field.DataType != entity.FieldTypeFloatVector {
+			continue
+		}
+		if dimStr, ok := field.TypeParams[entity.TypeParamDim]; ok {
+			dim, err := strconv.Atoi(dimStr)
+			if err != nil {
+				return 0, fmt.Errorf("invalid dim param %q: %w", dimStr, err)
+			}
+			return dim, nil
+		}
+	}
+	return 0, fmt.Errorf("collection %s has no float vector field", collectionName)
+}
+
+// CollectionName 返回实际使用的集合名称
+func (s *MilvusStore) CollectionName() string {
+	return s.collectionName
+}
+
+// AddDocuments 添加文档到向量数据库
+// 在存储前会清理文档内容的编码，确保没有乱码
+func (s *MilvusStore) AddDocuments(ctx context.Context, docs []schema.Document, embedder embeddings.Embedder) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	texts := make([]string, len(docs))
+	for i := range docs {
+		texts[i] = cleanDocContent(docs[i])
+	}
+
+	vectors, err := embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed documents: %w", err)
+	}
+	if len(vectors) != len(docs) {
+		return fmt.Errorf("embedding count mismatch: got %d vectors for %d documents", len(vectors), len(docs))
+	}
+
+	ids := make([]string, len(docs))
+	contents := make([]string, len(docs))
+	sources := make([]string, len(docs))
+	fileIDs := make([]string, len(docs))
+	chunkIndices := make([]int64, len(docs))
+	for i, doc := range docs {
+		ids[i] = uuid.New().String()
+		contents[i] = truncateToLength(texts[i], milvusContentMaxLength)
+		if source, ok := doc.Metadata["source"].(string); ok {
+			sources[i] = truncateToLength(source, milvusSourceMaxLength)
+		}
+		if fileID, ok := doc.Metadata["file_id"].(string); ok {
+			fileIDs[i] = fileID
+		}
+		chunkIndices[i] = metadataToInt64(doc.Metadata["chunk_index"])
+	}
+
+	_, err = s.client.Insert(ctx, s.collectionName, "",
+		entity.NewColumnVarChar("id", ids),
+		entity.NewColumnFloatVector("vector", s.dimensions, vectors),
+		entity.NewColumnVarChar("content", contents),
+		entity.NewColumnVarChar("source", sources),
+		entity.NewColumnVarChar("file_id", fileIDs),
+		entity.NewColumnInt64("chunk_index", chunkIndices),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert documents: %w", err)
+	}
+
+	return nil
+}
+
+// milvusOutputFields 查询和搜索时返回的标量字段
+var milvusOutputFields = []string{"content", "source", "file_id", "chunk_index"}
+
+// Search 搜索相似文档，返回最相似的topK个文档片段
+func (s *MilvusStore) Search(ctx context.Context, query string, embedder embeddings.Embedder, topK int) ([]schema.Document, error) {
+	results, err := s.searchWithVector(ctx, query, embedder, topK)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]schema.Document, 0, len(results))
+	for _, r := range results {
+		docs = append(docs, r.Document)
+	}
+	return docs, nil
+}
+
+// SearchWithScore 搜索相似文档并返回相似度分数，过滤低于minScore的结果
+func (s *MilvusStore) SearchWithScore(ctx context.Context, query string, embedder embeddings.Embedder, topK int, minScore float64) ([]SearchResult, error) {
+	results, err := s.searchWithVector(ctx, query, embedder, topK)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]SearchResult, 0, len(results))
+	for _, r := range results {
+		if r.Score < minScore {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered, nil
+}
+
+// searchWithVector 向量化查询文本并在Milvus中进行相似性搜索
+func (s *MilvusStore) searchWithVector(ctx context.Context, query string, embedder embeddings.Embedder, topK int) ([]SearchResult, error) {
+	vector, err := embedder.EmbedQuery(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed query: %w", err)
+	}
+
+	sp, err := entity.NewIndexAUTOINDEXSearchParam(1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create search param: %w", err)
+	}
+
+	searchResults, err := s.client.Search(ctx, s.collectionName, nil, "", milvusOutputFields,
+		[]entity.Vector{entity.FloatVector(vector)}, "vector", entity.COSINE, topK, sp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	results := make([]SearchResult, 0, topK)
+	for _, sr := range searchResults {
+		docs := milvusFieldsToDocuments(sr.Fields, sr.ResultCount)
+		for i, doc := range docs {
+			score := 0.0
+			if i < len(sr.Scores) {
+				score = float64(sr.Scores[i])
+			}
+			results = append(results, SearchResult{
+				Document: doc,
+				Score:    score,
+			})
+		}
+	}
+	return results, nil
+}
+
+// FetchChunksByIndex 根据file_id和chunk_index取出指定的文本块（用于邻近块扩展）
+// qdrantURL/apiKey/collectionName 参数为Qdrant实现专用，此处忽略
+func (s *MilvusStore) FetchChunksByIndex(ctx context.Context, _, _, _, fileID string, indices []int) ([]schema.Document, error) {
+	if fileID == "" || len(indices) == 0 {
+		return nil, nil
+	}
+
+	indexStrs := make([]string, 0, len(indices))
+	for _, idx := range indices {
+		indexStrs = append(indexStrs, strconv.Itoa(idx))
+	}
+	expr := fmt.Sprintf("file_id == \"%s\" && chunk_index in [%s]", escapeMilvusString(fileID), strings.Join(indexStrs, ", "))
+
+	resultSet, err := s.client.Query(ctx, s.collectionName, nil, expr, milvusOutputFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chunks: %w", err)
+	}
+
+	rowCount := 0
+	for _, col := range resultSet {
+		if col.Len() > rowCount {
+			rowCount = col.Len()
+		}
+	}
+	return milvusFieldsToDocuments(resultSet, rowCount), nil
+}
+
+// ScrollDocuments 不经过向量检索直接取出存储的文档（最多limit个）
+// qdrantURL/apiKey/collectionName 参数为Qdrant实现专用，此处忽略
+func (s *MilvusStore) ScrollDocuments(ctx context.Context, _, _, _ string, limit int) ([]schema.Document, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	// chunk_index >= 0 对所有点恒成立，作为全量查询的表达式
+	resultSet, err := s.client.Query(ctx, s.collectionName, nil, "chunk_index >= 0", milvusOutputFields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scroll documents: %w", err)
+	}
+
+	rowCount := 0
+	for _, col := range resultSet {
+		if col.Len() > rowCount {
+			rowCount = col.Len()
+		}
+	}
+	docs := milvusFieldsToDocuments(resultSet, rowCount)
+	if len(docs) > limit {
+		docs = docs[:limit]
+	}
+	return docs, nil
+}
+
+// DeleteDocumentsBySource 根据source字段删除文档
+// qdrantURL/apiKey/collectionName 参数为Qdrant实现专用，此处忽略
+func (s *MilvusStore) DeleteDocumentsBySource(ctx context.Context, _, _, _, sourcePath string) error {
+	if sourcePath == "" {
+		return nil
+	}
+
+	expr := fmt.Sprintf("source == \"%s\"", escapeMilvusString(sourcePath))
+	if err := s.client.Delete(ctx, s.collectionName, "", expr); err != nil {
+		return fmt.Errorf("failed to delete documents: %w", err)
+	}
+
+	logger.Info("从Milvus删除文档成功，source: %s", sourcePath)
+	return nil
+}
+
+// Count 返回集合中的向量数量
+// qdrantURL/apiKey/collectionName 参数为Qdrant实现专用，此处忽略
+func (s *MilvusStore) Count(ctx context.Context, _, _, _ string) (int64, error) {
+	stats, err := s.client.GetCollectionStatistics(ctx, s.collectionName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get collection statistics: %w", err)
+	}
+
+	rowCount, ok := stats["row_count"]
+	if !ok {
+		return 0, fmt.Errorf("collection statistics missing row_count")
+	}
+	count, err := strconv.ParseInt(rowCount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid row_count %q: %w", rowCount, err)
+	}
+	return count, nil
+}
+
+// milvusFieldsToDocuments 将Milvus返回的列数据转换为文档列表
+func milvusFieldsToDocuments(columns []entity.Column, rowCount int) []schema.Document {
+	byName := make(map[string]entity.Column, len(columns))
+	for _, col := range columns {
+		byName[col.Name()] = col
+	}
+
+	docs := make([]schema.Document, 0, rowCount)
+	for i := 0; i < rowCount; i++ {
+		content := ""
+		if col, ok := byName["content"]; ok && i < col.Len() {
+			content, _ = col.GetAsString(i)
+		}
+		if content == "" {
+			continue
+		}
+
+		metadata := make(map[string]interface{})
+		if col, ok := byName["source"]; ok && i < col.Len() {
+			if source, err := col.GetAsString(i); err == nil && source != "" {
+				metadata["source"] = source
+			}
+		}
+		if col, ok := byName["file_id"]; ok && i < col.Len() {
+			if fileID, err := col.GetAsString(i); err == nil && fileID != "" {
+				metadata["file_id"] = fileID
+			}
+		}
+		if col, ok := byName["chunk_index"]; ok && i < col.Len() {
+			if chunkIndex, err := col.GetAsInt64(i); err == nil {
+				metadata["chunk_index"] = int(chunkIndex)
+			}
+		}
+
+		docs = append(docs, schema.Document{
+			PageContent: content,
+			Metadata:    metadata,
+		})
+	}
+	return docs
+}
+
+// metadataToInt64 将元数据中的数值转换为int64（JSON反序列化后可能是float64）
+func metadataToInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	default:
+		return 0
+	}
+}
+
+// truncateToLength 将字符串截断到指定的最大字节长度（按rune截断避免产生无效UTF-8）
+func truncateToLength(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && len(string(runes)) > maxLen {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes)
+}
+
+// escapeMilvusString 转义Milvus表达式中的字符串值
+func escapeMilvusString(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	return strings.ReplaceAll(s, "\"", "\\\"")
+}
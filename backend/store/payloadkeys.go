@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// Qdrant payload字段名配置（QDRANT_CONTENT_KEY / QDRANT_SOURCE_KEY）
+// langchaingo把文档内容存在payload的"content"字段、metadata平铺进payload，
+// 但这个映射并非稳定契约：langchaingo版本变化或外部工具写入的集合可能用不同的
+// 字段名。字段名写死会让按source删除静默匹配不到任何点（删除"成功"但数据还在）。
+// 这里把内容字段和来源字段的名字做成可配置，并在首次按source删除前对照实际
+// 存储的payload形状校验一次，字段名对不上时立即告警。
+
+var (
+	// payloadContentKey 文档内容在payload中的字段名（默认与langchaingo一致）
+	payloadContentKey = "content"
+	// payloadSourceKey 来源路径在payload中的字段名（删除和过滤时使用）
+	payloadSourceKey = "source"
+
+	payloadShapeOnce sync.Once
+)
+
+// SetPayloadKeys 设置payload字段名（QDRANT_CONTENT_KEY / QDRANT_SOURCE_KEY）
+// 空字符串保持当前值不变
+func SetPayloadKeys(contentKey, sourceKey string) {
+	if contentKey != "" {
+		payloadContentKey = contentKey
+	}
+	if sourceKey != "" {
+		payloadSourceKey = sourceKey
+	}
+}
+
+// verifyPayloadShape 对照实际存储的payload校验配置的字段名（整个进程只做一次）
+// 取一个点检查内容字段和来源字段是否存在，对不上时告警提示检查QDRANT_*_KEY配置；
+// 集合为空或读取失败时跳过校验，不阻塞调用方
+func (s *QdrantStore) verifyPayloadShape(ctx context.Context, qdrantURL, apiKey, collectionName string) {
+	payloadShapeOnce.Do(func() {
+		payload, err := s.scrollOnePayload(ctx, qdrantURL, apiKey, collectionName)
+		if err != nil || payload == nil {
+			return
+		}
+		if _, ok := payload[payloadContentKey]; !ok {
+			logger.Warn("⚠️ payload中不存在内容字段 %q，请检查QDRANT_CONTENT_KEY配置（实际字段: %v）", payloadContentKey, payloadKeys(payload))
+		}
+		if _, ok := payload[payloadSourceKey]; !ok {
+			logger.Warn("⚠️ payload中不存在来源字段 %q，按source删除将匹配不到任何点，请检查QDRANT_SOURCE_KEY配置（实际字段: %v）", payloadSourceKey, payloadKeys(payload))
+		}
+	})
+}
+
+// payloadKeys 返回payload的字段名列表（用于告警信息）
+func payloadKeys(payload map[string]interface{}) []string {
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		keys = append(keys, k)
+	}
+	return keys
+}
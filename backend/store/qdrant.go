@@ -8,10 +8,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/Codeyangyi/personal-ai-kb/logger"
+	"github.com/google/uuid"
 	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/vectorstores"
@@ -20,7 +22,18 @@ import (
 
 // QdrantStore Qdrant向量存储包装器
 type QdrantStore struct {
-	store vectorstores.VectorStore
+	store          vectorstores.VectorStore
+	collectionName string // 实际使用的集合名称（维度迁移后可能与配置的名称不同）
+	qdrantURL      string
+	apiKey         string
+	waitOnWrite    bool            // 写入时等待索引完成（QDRANT_WAIT_WRITES，通过SetWaitOnWrite设置）
+	breaker        *circuitBreaker // 瞬时故障重试与熔断保护（通过SetRetryOptions配置）
+}
+
+// CollectionName 返回实际使用的集合名称
+// 维度迁移（ON_DIM_MISMATCH=migrate）后会指向新集合，与配置的名称不同
+func (s *QdrantStore) CollectionName() string {
+	return s.collectionName
 }
 
 // DimensionGetter 获取向量维度的接口
@@ -29,8 +42,17 @@ type DimensionGetter interface {
 }
 
 // NewQdrantStore 创建新的Qdrant存储
-// 如果集合不存在，会自动创建集合
+// 如果集合不存在，会自动创建集合；维度不匹配时删除并重新创建（历史默认行为）
 func NewQdrantStore(qdrantURL, apiKey, collectionName string, embedder embeddings.Embedder, dimensionGetter DimensionGetter) (*QdrantStore, error) {
+	return NewQdrantStoreWithMode(qdrantURL, apiKey, collectionName, embedder, dimensionGetter, "recreate")
+}
+
+// NewQdrantStoreWithMode 创建新的Qdrant存储，并指定维度不匹配时的处理方式
+// onDimMismatch 取值：
+//   - "recreate"：删除并重新创建集合（会丢失已有向量，历史默认行为）
+//   - "migrate"：保留原集合作为备份，创建带维度后缀的新集合并切换过去（调用方需要重建索引）
+//   - "fail"：返回错误，交给运维人员手动处理
+func NewQdrantStoreWithMode(qdrantURL, apiKey, collectionName string, embedder embeddings.Embedder, dimensionGetter DimensionGetter, onDimMismatch string) (*QdrantStore, error) {
 	parsedURL, err := url.Parse(qdrantURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid qdrant URL: %w", err)
@@ -61,15 +83,46 @@ func NewQdrantStore(qdrantURL, apiKey, collectionName string, embedder embedding
 			return nil, fmt.Errorf("failed to get collection dimensions: %w", err)
 		}
 		if existingDims != dimensions {
-			logger.Warn("⚠️  集合 '%s' 的维度 (%d) 与模型维度 (%d) 不匹配，正在删除并重新创建...", collectionName, existingDims, dimensions)
-			if err := deleteCollection(ctx, qdrantURL, apiKey, collectionName); err != nil {
-				return nil, fmt.Errorf("failed to delete collection: %w", err)
-			}
-			logger.Info("正在重新创建集合（向量维度: %d）...", dimensions)
-			if err := createCollection(ctx, qdrantURL, apiKey, collectionName, dimensions); err != nil {
-				return nil, fmt.Errorf("failed to create collection: %w", err)
+			switch onDimMismatch {
+			case "fail":
+				return nil, fmt.Errorf("集合 '%s' 的维度 (%d) 与模型维度 (%d) 不匹配（ON_DIM_MISMATCH=fail），请手动处理或改用 migrate/recreate 模式", collectionName, existingDims, dimensions)
+			case "migrate":
+				// 保留原集合作为备份，切换到带维度后缀的新集合
+				newName := fmt.Sprintf("%s_dim%d", collectionName, dimensions)
+				logger.Warn("⚠️  集合 '%s' 的维度 (%d) 与模型维度 (%d) 不匹配，迁移到新集合 '%s'（原集合保留为备份）...", collectionName, existingDims, dimensions, newName)
+				newExists, err := checkCollectionExists(ctx, qdrantURL, apiKey, newName)
+				if err != nil {
+					return nil, fmt.Errorf("failed to check migration collection existence: %w", err)
+				}
+				if !newExists {
+					if err := createCollection(ctx, qdrantURL, apiKey, newName, dimensions); err != nil {
+						return nil, fmt.Errorf("failed to create migration collection: %w", err)
+					}
+					logger.Info("✅ 迁移集合创建成功")
+				} else {
+					// 目标集合已存在时校验维度，避免切换到另一个维度错误的集合
+					newDims, err := getCollectionDimensions(ctx, qdrantURL, apiKey, newName)
+					if err != nil {
+						return nil, fmt.Errorf("failed to get migration collection dimensions: %w", err)
+					}
+					if newDims != dimensions {
+						return nil, fmt.Errorf("迁移集合 '%s' 的维度 (%d) 与模型维度 (%d) 不匹配，请手动清理", newName, newDims, dimensions)
+					}
+					logger.Info("迁移集合 '%s' 已存在，直接使用", newName)
+				}
+				collectionName = newName
+			default:
+				// "recreate"：删除并重新创建（历史默认行为）
+				logger.Warn("⚠️  集合 '%s' 的维度 (%d) 与模型维度 (%d) 不匹配，正在删除并重新创建...", collectionName, existingDims, dimensions)
+				if err := deleteCollection(ctx, qdrantURL, apiKey, collectionName); err != nil {
+					return nil, fmt.Errorf("failed to delete collection: %w", err)
+				}
+				logger.Info("正在重新创建集合（向量维度: %d）...", dimensions)
+				if err := createCollection(ctx, qdrantURL, apiKey, collectionName, dimensions); err != nil {
+					return nil, fmt.Errorf("failed to create collection: %w", err)
+				}
+				logger.Info("✅ 集合重新创建成功")
 			}
-			logger.Info("✅ 集合重新创建成功")
 		}
 	}
 
@@ -89,10 +142,70 @@ func NewQdrantStore(qdrantURL, apiKey, collectionName string, embedder embedding
 	}
 
 	return &QdrantStore{
-		store: store,
+		store:          store,
+		collectionName: collectionName,
+		qdrantURL:      qdrantURL,
+		apiKey:         apiKey,
+		breaker:        newCircuitBreaker(),
 	}, nil
 }
 
+// SetRetryOptions 配置瞬时故障的重试次数、熔断阈值和熔断冷却时间
+// maxRetries传负数、threshold/cooldownSec传0或负数时保持默认值
+func (s *QdrantStore) SetRetryOptions(maxRetries, threshold, cooldownSec int) {
+	s.breaker.configure(maxRetries, threshold, cooldownSec)
+}
+
+// SetWaitOnWrite 设置写入时是否等待Qdrant索引完成（wait=true）
+// 启用后AddDocuments改走原生API的upsert（langchaingo不暴露wait参数），
+// 返回时新增的点已可被检索，修复"刚上传就查不到"的时序问题
+func (s *QdrantStore) SetWaitOnWrite(enabled bool) {
+	s.waitOnWrite = enabled
+}
+
+// Optimize 触发集合的优化器运行，合并段并清理删除文档后累积的软删除点
+// Qdrant没有"立即优化"的专用端点，但更新optimizer配置会让优化器重新评估所有段并执行合并/清理
+func (s *QdrantStore) Optimize(ctx context.Context) error {
+	url := fmt.Sprintf("%s/collections/%s", s.qdrantURL, s.collectionName)
+
+	// deleted_threshold: 段内删除点占比超过该值时触发清理（0.1为较激进的阈值）
+	payload := map[string]interface{}{
+		"optimizers_config": map[string]interface{}{
+			"deleted_threshold": 0.1,
+		},
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("api-key", s.apiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to trigger optimizer (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	logger.Info("✅ 已触发Qdrant集合 %s 的优化器运行", s.collectionName)
+	return nil
+}
+
 // checkCollectionExists 检查集合是否存在
 func checkCollectionExists(ctx context.Context, qdrantURL, apiKey, collectionName string) (bool, error) {
 	url := fmt.Sprintf("%s/collections/%s", qdrantURL, collectionName)
@@ -245,16 +358,161 @@ func (s *QdrantStore) AddDocuments(ctx context.Context, docs []schema.Document,
 	cleanedDocs := make([]schema.Document, len(docs))
 	for i := range docs {
 		cleanedDocs[i] = docs[i]
-		cleanedDocs[i].PageContent = cleanTextEncoding(docs[i].PageContent)
+		cleanedDocs[i].PageContent = cleanDocContent(docs[i])
+	}
+
+	// 带重试和熔断执行写入（瞬时故障重试，连续失败后快速失败）
+	return s.breaker.execute(ctx, "向量写入", func() error {
+		// 启用写入等待时改走原生API（带wait=true），返回时新增的点已可被检索
+		if s.waitOnWrite {
+			return s.upsertPointsWithWait(ctx, cleanedDocs, embedder)
+		}
+
+		_, err := s.store.AddDocuments(ctx, cleanedDocs, vectorstores.WithEmbedder(embedder))
+		return err
+	})
+}
+
+// upsertPointsWithWait 通过Qdrant原生API写入文档，并等待索引完成（wait=true）
+// payload布局与langchaingo保持一致：文档内容在"content"字段，metadata平铺在payload中
+func (s *QdrantStore) upsertPointsWithWait(ctx context.Context, docs []schema.Document, embedder embeddings.Embedder) error {
+	texts := make([]string, len(docs))
+	for i := range docs {
+		texts[i] = docs[i].PageContent
+	}
+
+	vectors, err := embedder.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("failed to embed documents: %w", err)
+	}
+	if len(vectors) != len(docs) {
+		return fmt.Errorf("向量数量 (%d) 与文档数量 (%d) 不匹配", len(vectors), len(docs))
+	}
+
+	points := make([]map[string]interface{}, len(docs))
+	for i := range docs {
+		payload := make(map[string]interface{}, len(docs[i].Metadata)+1)
+		for k, v := range docs[i].Metadata {
+			payload[k] = v
+		}
+		payload[payloadContentKey] = docs[i].PageContent
+
+		points[i] = map[string]interface{}{
+			"id":      uuid.New().String(),
+			"vector":  vectors[i],
+			"payload": payload,
+		}
+	}
+
+	return s.putPoints(ctx, points)
+}
+
+// ImportDocuments 从导出记录恢复文档（POST /api/import 的灾备恢复路径）
+// 与AddDocuments的区别是point ID按file_id、chunk_index和内容确定性派生（UUIDv5），
+// 同一条记录重复导入会覆盖同一个点而不是产生副本，因此整个导入过程可安全重跑
+func (s *QdrantStore) ImportDocuments(ctx context.Context, docs []schema.Document, embedder embeddings.Embedder) error {
+	cleanedDocs := make([]schema.Document, len(docs))
+	for i := range docs {
+		cleanedDocs[i] = docs[i]
+		cleanedDocs[i].PageContent = cleanDocContent(docs[i])
+	}
+
+	return s.breaker.execute(ctx, "向量导入", func() error {
+		texts := make([]string, len(cleanedDocs))
+		for i := range cleanedDocs {
+			texts[i] = cleanedDocs[i].PageContent
+		}
+
+		vectors, err := embedder.EmbedDocuments(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed documents: %w", err)
+		}
+		if len(vectors) != len(cleanedDocs) {
+			return fmt.Errorf("向量数量 (%d) 与文档数量 (%d) 不匹配", len(vectors), len(cleanedDocs))
+		}
+
+		points := make([]map[string]interface{}, len(cleanedDocs))
+		for i := range cleanedDocs {
+			payload := make(map[string]interface{}, len(cleanedDocs[i].Metadata)+1)
+			for k, v := range cleanedDocs[i].Metadata {
+				payload[k] = v
+			}
+			payload[payloadContentKey] = cleanedDocs[i].PageContent
+
+			points[i] = map[string]interface{}{
+				"id":      deterministicPointID(cleanedDocs[i]),
+				"vector":  vectors[i],
+				"payload": payload,
+			}
+		}
+
+		return s.putPoints(ctx, points)
+	})
+}
+
+// deterministicPointID 由file_id、chunk_index和内容派生的UUIDv5
+// 同一条导出记录无论导入多少次都映射到同一个point ID，保证导入幂等
+func deterministicPointID(doc schema.Document) string {
+	key := fmt.Sprintf("%v|%v|%s", doc.Metadata["file_id"], doc.Metadata["chunk_index"], doc.PageContent)
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(key)).String()
+}
+
+// putPoints 通过Qdrant原生API批量写入points并等待索引完成（wait=true）
+func (s *QdrantStore) putPoints(ctx context.Context, points []map[string]interface{}) error {
+	jsonData, err := json.Marshal(map[string]interface{}{"points": points})
+	if err != nil {
+		return fmt.Errorf("failed to marshal upsert request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/collections/%s/points?wait=true", s.qdrantURL, s.collectionName)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create upsert request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("api-key", s.apiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send upsert request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read upsert response: %w", err)
 	}
 
-	_, err := s.store.AddDocuments(ctx, cleanedDocs, vectorstores.WithEmbedder(embedder))
-	return err
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upsert points (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	return nil
 }
 
 // cleanTextEncoding 清理和修复文本编码，确保是有效的UTF-8
 // 移除无效的UTF-8字符、控制字符和乱码字符，替换为空格或删除
 func cleanTextEncoding(text string) string {
+	text = stripInvalidChars(text)
+
+	// 清理多余的空白字符
+	for strings.Contains(text, "  ") {
+		text = strings.ReplaceAll(text, "  ", " ")
+	}
+	for strings.Contains(text, "\n\n\n") {
+		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+	}
+	text = strings.TrimSpace(text)
+
+	return text
+}
+
+// stripInvalidChars 编码级清理：剔除无效UTF-8、控制字符和乱码字符，不动空白布局
+func stripInvalidChars(text string) string {
 	if text == "" {
 		return text
 	}
@@ -307,16 +565,260 @@ func cleanTextEncoding(text string) string {
 	// 移除连续的无效字符序列
 	text = strings.ReplaceAll(text, "\uFFFD", " ")
 
-	// 清理多余的空白字符
-	for strings.Contains(text, "  ") {
-		text = strings.ReplaceAll(text, "  ", " ")
+	return text
+}
+
+// FetchChunksByIndex 根据file_id和chunk_index从Qdrant中取出指定的文本块
+// 用于邻近块扩展：命中某个块后，取它前后相邻的块补充上下文
+// 注意：langchaingo 将文档内容存储在 payload 的 "content" 字段，metadata 平铺在 payload 中
+func (s *QdrantStore) FetchChunksByIndex(ctx context.Context, qdrantURL, apiKey, collectionName, fileID string, indices []int) ([]schema.Document, error) {
+	if fileID == "" || len(indices) == 0 {
+		return nil, nil
 	}
-	for strings.Contains(text, "\n\n\n") {
-		text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
+
+	url := fmt.Sprintf("%s/collections/%s/points/scroll", qdrantURL, collectionName)
+
+	// 构建 filter：file_id 精确匹配 + chunk_index 匹配任意一个指定索引
+	indexValues := make([]interface{}, 0, len(indices))
+	for _, idx := range indices {
+		indexValues = append(indexValues, idx)
+	}
+	payload := map[string]interface{}{
+		"filter": map[string]interface{}{
+			"must": []map[string]interface{}{
+				{
+					"key": "file_id",
+					"match": map[string]interface{}{
+						"value": fileID,
+					},
+				},
+				{
+					"key": "chunk_index",
+					"match": map[string]interface{}{
+						"any": indexValues,
+					},
+				},
+			},
+		},
+		"limit":        len(indices),
+		"with_payload": true,
 	}
-	text = strings.TrimSpace(text)
 
-	return text
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal scroll request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scroll request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("api-key", apiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send scroll request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scroll response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to scroll points (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var scrollResult struct {
+		Result struct {
+			Points []struct {
+				ID      interface{}            `json:"id"`
+				Payload map[string]interface{} `json:"payload"`
+			} `json:"points"`
+		} `json:"result"`
+	}
+
+	if err := json.Unmarshal(body, &scrollResult); err != nil {
+		return nil, fmt.Errorf("failed to parse scroll response: %w", err)
+	}
+
+	docs := make([]schema.Document, 0, len(scrollResult.Result.Points))
+	for _, point := range scrollResult.Result.Points {
+		content, _ := point.Payload[payloadContentKey].(string)
+		if content == "" {
+			continue
+		}
+		metadata := make(map[string]interface{}, len(point.Payload))
+		for k, v := range point.Payload {
+			if k == payloadContentKey {
+				continue
+			}
+			metadata[k] = v
+		}
+		// 带上点ID（_point_id），供chunk级删除接口定位要移除的点
+		if point.ID != nil {
+			metadata["_point_id"] = point.ID
+		}
+		docs = append(docs, schema.Document{
+			PageContent: content,
+			Metadata:    metadata,
+		})
+	}
+
+	return docs, nil
+}
+
+// ScrollDocuments 不经过向量检索，用scroll接口分页取出存储的文档（最多limit个）
+// 用于嵌入服务故障时的关键词降级检索：payload里有完整的content和metadata，不需要向量
+func (s *QdrantStore) ScrollDocuments(ctx context.Context, qdrantURL, apiKey, collectionName string, limit int) ([]schema.Document, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	url := fmt.Sprintf("%s/collections/%s/points/scroll", qdrantURL, collectionName)
+	client := &http.Client{}
+
+	docs := make([]schema.Document, 0, limit)
+	var offset interface{}
+	for len(docs) < limit {
+		pageSize := 256
+		if remaining := limit - len(docs); remaining < pageSize {
+			pageSize = remaining
+		}
+		payload := map[string]interface{}{
+			"limit":        pageSize,
+			"with_payload": true,
+		}
+		if offset != nil {
+			payload["offset"] = offset
+		}
+
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal scroll request: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create scroll request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set("api-key", apiKey)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send scroll request: %w", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read scroll response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to scroll points (status %d): %s", resp.StatusCode, string(body))
+		}
+
+		var scrollResult struct {
+			Result struct {
+				Points []struct {
+					ID      interface{}            `json:"id"`
+					Payload map[string]interface{} `json:"payload"`
+				} `json:"points"`
+				NextPageOffset interface{} `json:"next_page_offset"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(body, &scrollResult); err != nil {
+			return nil, fmt.Errorf("failed to parse scroll response: %w", err)
+		}
+
+		for _, point := range scrollResult.Result.Points {
+			content, _ := point.Payload[payloadContentKey].(string)
+			if content == "" {
+				continue
+			}
+			metadata := make(map[string]interface{}, len(point.Payload))
+			for k, v := range point.Payload {
+				if k == payloadContentKey {
+					continue
+				}
+				metadata[k] = v
+			}
+			// 带上点ID（_point_id），供chunk级删除接口定位要移除的点
+			if point.ID != nil {
+				metadata["_point_id"] = point.ID
+			}
+			docs = append(docs, schema.Document{
+				PageContent: content,
+				Metadata:    metadata,
+			})
+		}
+
+		if scrollResult.Result.NextPageOffset == nil || len(scrollResult.Result.Points) == 0 {
+			break
+		}
+		offset = scrollResult.Result.NextPageOffset
+	}
+
+	return docs, nil
+}
+
+// scrollOnePayload 取集合中任意一个点的原始payload（用于payload形状校验）
+// 集合为空时返回nil
+func (s *QdrantStore) scrollOnePayload(ctx context.Context, qdrantURL, apiKey, collectionName string) (map[string]interface{}, error) {
+	url := fmt.Sprintf("%s/collections/%s/points/scroll", qdrantURL, collectionName)
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"limit":        1,
+		"with_payload": true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal scroll request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scroll request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("api-key", apiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send scroll request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scroll response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to scroll points (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var scrollResult struct {
+		Result struct {
+			Points []struct {
+				Payload map[string]interface{} `json:"payload"`
+			} `json:"points"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &scrollResult); err != nil {
+		return nil, fmt.Errorf("failed to parse scroll response: %w", err)
+	}
+	if len(scrollResult.Result.Points) == 0 {
+		return nil, nil
+	}
+	return scrollResult.Result.Points[0].Payload, nil
 }
 
 // SearchResult 搜索结果，包含文档和相似度分数
@@ -331,14 +833,57 @@ type SearchResult struct {
 // 2. 在Qdrant向量数据库中进行相似性搜索（余弦相似度）
 // 3. 返回最相似的topK个文档片段
 func (s *QdrantStore) Search(ctx context.Context, query string, embedder embeddings.Embedder, topK int) ([]schema.Document, error) {
-	// SimilaritySearch会自动使用embedder将query向量化，然后在向量数据库中搜索
-	results, err := s.store.SimilaritySearch(ctx, query, topK, vectorstores.WithEmbedder(embedder))
+	// 带重试和熔断执行检索（瞬时故障重试，连续失败后快速失败）
+	var results []schema.Document
+	err := s.breaker.execute(ctx, "向量检索", func() error {
+		// SimilaritySearch会自动使用embedder将query向量化，然后在向量数据库中搜索
+		var searchErr error
+		results, searchErr = s.store.SimilaritySearch(ctx, query, topK, vectorstores.WithEmbedder(embedder))
+		return searchErr
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to search: %w", err)
 	}
 	return results, nil
 }
 
+// SearchWithIngestedRange 带入库时间范围过滤的相似文档搜索（仅Qdrant）
+// afterUnix/beforeUnix为入库时间（Unix秒）的下限/上限，传0表示该侧不限制；
+// 通过Qdrant的range filter作用在chunk的ingested_at字段上，范围外的点不参与检索
+func (s *QdrantStore) SearchWithIngestedRange(ctx context.Context, query string, embedder embeddings.Embedder, topK int, afterUnix, beforeUnix int64) ([]schema.Document, error) {
+	rangeCond := map[string]interface{}{}
+	if afterUnix > 0 {
+		rangeCond["gte"] = afterUnix
+	}
+	if beforeUnix > 0 {
+		rangeCond["lte"] = beforeUnix
+	}
+	if len(rangeCond) == 0 {
+		return s.Search(ctx, query, embedder, topK)
+	}
+	filter := map[string]interface{}{
+		"must": []map[string]interface{}{
+			{
+				"key":   "ingested_at",
+				"range": rangeCond,
+			},
+		},
+	}
+
+	// 带重试和熔断执行检索（与Search一致）
+	var results []schema.Document
+	err := s.breaker.execute(ctx, "向量检索", func() error {
+		var searchErr error
+		results, searchErr = s.store.SimilaritySearch(ctx, query, topK,
+			vectorstores.WithEmbedder(embedder), vectorstores.WithFilters(filter))
+		return searchErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search with ingested range: %w", err)
+	}
+	return results, nil
+}
+
 // SearchWithScore 搜索相似文档并返回相似度分数
 func (s *QdrantStore) SearchWithScore(ctx context.Context, query string, embedder embeddings.Embedder, topK int, minScore float64) ([]SearchResult, error) {
 	// 先进行普通搜索
@@ -367,6 +912,116 @@ func (s *QdrantStore) SearchWithScore(ctx context.Context, query string, embedde
 	return searchResults, nil
 }
 
+// UpdatePayload 更新指定文件所有向量点的payload字段（set-payload，不触碰向量本身）
+// 用于标题、标签等元数据编辑，避免为了改元数据而重新嵌入整个文档
+func (s *QdrantStore) UpdatePayload(ctx context.Context, qdrantURL, apiKey, collectionName, fileID string, patch map[string]interface{}) error {
+	if fileID == "" || len(patch) == 0 {
+		return nil
+	}
+
+	url := fmt.Sprintf("%s/collections/%s/points/payload", qdrantURL, collectionName)
+
+	payload := map[string]interface{}{
+		"payload": patch,
+		"filter": map[string]interface{}{
+			"must": []map[string]interface{}{
+				{
+					"key": "file_id",
+					"match": map[string]interface{}{
+						"value": fileID,
+					},
+				},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal set-payload request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create set-payload request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("api-key", apiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send set-payload request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read set-payload response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to update payload (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	logger.Info("更新向量payload成功，file_id: %s, 字段: %d 个", fileID, len(patch))
+	return nil
+}
+
+// Count 返回集合中的向量数量（精确计数）
+func (s *QdrantStore) Count(ctx context.Context, qdrantURL, apiKey, collectionName string) (int64, error) {
+	url := fmt.Sprintf("%s/collections/%s/points/count", qdrantURL, collectionName)
+
+	payload := map[string]interface{}{
+		"exact": true,
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal count request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create count request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("api-key", apiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send count request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read count response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to count points (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var countResult struct {
+		Result struct {
+			Count int64 `json:"count"`
+		} `json:"result"`
+	}
+
+	if err := json.Unmarshal(body, &countResult); err != nil {
+		return 0, fmt.Errorf("failed to parse count response: %w", err)
+	}
+
+	return countResult.Result.Count, nil
+}
+
 // DeleteDocumentsBySource 根据source字段删除文档
 // sourcePath 可以是完整路径或部分路径，会匹配所有包含该路径的文档
 func (s *QdrantStore) DeleteDocumentsBySource(ctx context.Context, qdrantURL, apiKey, collectionName, sourcePath string) error {
@@ -378,13 +1033,16 @@ func (s *QdrantStore) DeleteDocumentsBySource(ctx context.Context, qdrantURL, ap
 	// Qdrant 支持通过 filter 删除匹配条件的 points
 	url := fmt.Sprintf("%s/collections/%s/points/delete", qdrantURL, collectionName)
 
-	// 构建 filter，匹配 source 字段
+	// 首次删除前对照实际存储的payload校验字段名，映射不一致时及时暴露
+	s.verifyPayloadShape(ctx, qdrantURL, apiKey, collectionName)
+
+	// 构建 filter，匹配来源字段（字段名可通过QDRANT_SOURCE_KEY配置）
 	// Qdrant 中 payload 字段的访问方式：使用 key 和 match
 	// 注意：langchaingo 将 metadata 存储在 payload 中
 	filter := map[string]interface{}{
 		"must": []map[string]interface{}{
 			{
-				"key": "source",
+				"key": payloadSourceKey,
 				"match": map[string]interface{}{
 					"value": sourcePath,
 				},
@@ -448,3 +1106,77 @@ func (s *QdrantStore) DeleteDocumentsBySource(ctx context.Context, qdrantURL, ap
 
 	return nil
 }
+
+// DeletePoint 按点ID删除单个point（chunk级删除）
+// 某个段落内容错误或涉敏时，可只移除对应的点而不删除整个文件；
+// 先retrieve确认点存在（给出明确的"不存在"错误），再调用points/delete删除
+func (s *QdrantStore) DeletePoint(ctx context.Context, qdrantURL, apiKey, collectionName, pointID string) error {
+	if pointID == "" {
+		return fmt.Errorf("point ID is required")
+	}
+
+	// Qdrant的点ID可以是无符号整数或UUID字符串，请求体按实际类型传递
+	var id interface{} = pointID
+	if n, err := strconv.ParseUint(pointID, 10, 64); err == nil {
+		id = n
+	}
+
+	client := &http.Client{}
+
+	// 先确认点存在，不存在时返回明确错误（points/delete对不存在的ID静默成功）
+	retrieveURL := fmt.Sprintf("%s/collections/%s/points/%s", qdrantURL, collectionName, pointID)
+	req, err := http.NewRequestWithContext(ctx, "GET", retrieveURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create retrieve request: %w", err)
+	}
+	if apiKey != "" {
+		req.Header.Set("api-key", apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send retrieve request: %w", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read retrieve response: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("点ID %s 不存在", pointID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to retrieve point (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	// 删除该点
+	deleteURL := fmt.Sprintf("%s/collections/%s/points/delete", qdrantURL, collectionName)
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"points": []interface{}{id},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal delete request: %w", err)
+	}
+	req, err = http.NewRequestWithContext(ctx, "POST", deleteURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create delete request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("api-key", apiKey)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send delete request: %w", err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read delete response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to delete point (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	logger.Info("✅ 已从向量数据库删除点: %s", pointID)
+	return nil
+}
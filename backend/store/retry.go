@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Codeyangyi/personal-ai-kb/logger"
+)
+
+// 向量存储的瞬时故障重试与熔断保护
+// Qdrant重启或网络抖动时先做有限次数的重试；连续失败达到阈值后打开熔断器，
+// 冷却期内所有请求快速失败并返回明确的"向量存储不可用"错误，避免每个请求都挂在超时上
+
+const (
+	defaultStoreMaxRetries  = 2                      // 失败后最多重试的次数
+	defaultBreakerThreshold = 5                      // 连续失败多少次后打开熔断器
+	defaultBreakerCooldown  = 30 * time.Second       // 熔断后的冷却时间
+	retryBaseDelay          = 500 * time.Millisecond // 重试基础延迟（按次数线性递增）
+)
+
+// circuitBreaker 简单的熔断器：按连续失败次数打开，冷却期结束后自动关闭重新放行
+type circuitBreaker struct {
+	mu         sync.Mutex
+	maxRetries int
+	threshold  int
+	cooldown   time.Duration
+
+	failures int       // 当前连续失败的操作次数
+	openedAt time.Time // 熔断器打开的时间（零值表示关闭）
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		maxRetries: defaultStoreMaxRetries,
+		threshold:  defaultBreakerThreshold,
+		cooldown:   defaultBreakerCooldown,
+	}
+}
+
+// configure 设置重试次数、熔断阈值和冷却时间（传0或负数表示保持默认值）
+func (b *circuitBreaker) configure(maxRetries, threshold, cooldownSec int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if maxRetries >= 0 {
+		b.maxRetries = maxRetries
+	}
+	if threshold > 0 {
+		b.threshold = threshold
+	}
+	if cooldownSec > 0 {
+		b.cooldown = time.Duration(cooldownSec) * time.Second
+	}
+}
+
+// allow 检查当前是否允许请求
+// 熔断打开且在冷却期内时返回快速失败错误；冷却期结束后自动关闭熔断器放行
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if elapsed := time.Since(b.openedAt); elapsed < b.cooldown {
+		return fmt.Errorf("vector store unavailable: 向量存储连续失败 %d 次已熔断，请 %v 后重试",
+			b.failures, (b.cooldown - elapsed).Round(time.Second))
+	}
+
+	// 冷却期结束，关闭熔断器放行（再次失败会立即重新打开）
+	logger.Info("向量存储熔断器冷却结束，恢复放行请求")
+	b.openedAt = time.Time{}
+	b.failures = b.threshold - 1
+	return nil
+}
+
+func (b *circuitBreaker) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+}
+
+func (b *circuitBreaker) recordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.threshold && b.openedAt.IsZero() {
+		b.openedAt = time.Now()
+		logger.Error("❌ 向量存储连续失败 %d 次，熔断器打开，%v 内的请求将快速失败", b.failures, b.cooldown)
+	}
+}
+
+// execute 带重试和熔断执行一次向量存储操作
+// 操作失败时按次数线性退避重试；重试耗尽计为一次失败，连续失败达到阈值后熔断
+func (b *circuitBreaker) execute(ctx context.Context, op string, fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil {
+			b.recordSuccess()
+			return nil
+		}
+		if attempt >= b.maxRetries || ctx.Err() != nil {
+			break
+		}
+		delay := retryBaseDelay * time.Duration(attempt+1)
+		logger.Warn("⚠️ %s失败 (第 %d 次尝试)，%v 后重试: %v", op, attempt+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+
+	b.recordFailure()
+	return err
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// VectorStore 向量存储后端接口，通过 VECTOR_STORE 环境变量选择实现（默认Qdrant）
+// 注意：部分方法带有 qdrantURL/apiKey/collectionName 参数（历史原因，Qdrant实现通过REST API直连），
+// 其他后端（如Milvus）自己持有连接，实现时忽略这些参数即可
+type VectorStore interface {
+	// CollectionName 返回实际使用的集合名称（维度迁移后可能与配置的名称不同）
+	CollectionName() string
+
+	// AddDocuments 添加文档到向量数据库
+	AddDocuments(ctx context.Context, docs []schema.Document, embedder embeddings.Embedder) error
+
+	// Search 搜索相似文档，返回最相似的topK个文档片段
+	Search(ctx context.Context, query string, embedder embeddings.Embedder, topK int) ([]schema.Document, error)
+
+	// SearchWithScore 搜索相似文档并返回相似度分数，过滤低于minScore的结果
+	SearchWithScore(ctx context.Context, query string, embedder embeddings.Embedder, topK int, minScore float64) ([]SearchResult, error)
+
+	// FetchChunksByIndex 根据file_id和chunk_index取出指定的文本块（用于邻近块扩展）
+	FetchChunksByIndex(ctx context.Context, qdrantURL, apiKey, collectionName, fileID string, indices []int) ([]schema.Document, error)
+
+	// ScrollDocuments 不经过向量检索直接取出存储的文档（最多limit个）
+	// 用于嵌入服务故障时的关键词降级检索
+	ScrollDocuments(ctx context.Context, qdrantURL, apiKey, collectionName string, limit int) ([]schema.Document, error)
+
+	// DeleteDocumentsBySource 根据source字段删除文档
+	DeleteDocumentsBySource(ctx context.Context, qdrantURL, apiKey, collectionName, sourcePath string) error
+
+	// Count 返回集合中的向量数量
+	Count(ctx context.Context, qdrantURL, apiKey, collectionName string) (int64, error)
+}
@@ -0,0 +1,13 @@
+// Package version 保存构建信息，通过 -ldflags 在构建时注入：
+//
+//	go build -ldflags "-X github.com/Codeyangyi/personal-ai-kb/version.Version=v1.2.3 \
+//	  -X github.com/Codeyangyi/personal-ai-kb/version.GitCommit=$(git rev-parse --short HEAD) \
+//	  -X github.com/Codeyangyi/personal-ai-kb/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+// 构建时通过 -ldflags -X 注入，未注入时保持默认值
+var (
+	Version   = "dev"     // 版本号
+	GitCommit = "unknown" // 构建时的git提交
+	BuildTime = "unknown" // 构建时间（UTC）
+)